@@ -0,0 +1,99 @@
+// teldrvr checks the telemetry configuration of a service before it is deployed.
+// It reads the same config file and environment variables as the service, so it has to be started
+// in the directory of the service.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+
+	// the external drivers have to be imported to validate their settings
+	_ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/amqpdrvr"
+	_ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/natsdrvr"
+	_ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/pgdrvr"
+)
+
+const usage = `usage: teldrvr <command>
+
+commands:
+  validate-config  checks the general settings and the settings of all drivers selected by telemetry.driver
+  smoke            sends a sample transaction through the drivers and prints every call
+                   -drivers  comma separated drivers, default are the drivers of telemetry.driver
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "validate-config":
+		os.Exit(validateConfig())
+	case "smoke":
+		os.Exit(smoke(os.Args[2:]))
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command »%s«\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+// validateConfig prints every problem of the config and returns the exit code
+func validateConfig() int {
+	cfg, err := teldrvr.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config could not be loaded: %v\n", err)
+		return 1
+	}
+
+	drivers := teldrvr.SelectedDrivers(cfg)
+	if len(drivers) == 0 {
+		fmt.Fprintln(os.Stderr, "no driver selected, set telemetry.driver or TELEMETRY_DRIVER")
+		return 1
+	}
+
+	err = teldrvr.ValidateConfig(cfg)
+	if err == nil {
+		fmt.Printf("config of the drivers %v is valid\n", drivers)
+		return 0
+	}
+
+	for _, problem := range problems(err) {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+
+	return 1
+}
+
+// problems splits the error of ValidateConfig into one line per offending setting
+func problems(err error) []string {
+	var lines []string
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	for _, err := range joined.Unwrap() {
+		var configError *teldrvr.ConfigError
+		if !errors.As(err, &configError) {
+			lines = append(lines, err.Error())
+			continue
+		}
+
+		for _, issue := range configError.Issues {
+			if len(configError.Driver) == 0 {
+				lines = append(lines, fmt.Sprintf("%s %s", issue.Key, issue.Reason))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("driver %s: %s %s", configError.Driver, issue.Key, issue.Reason))
+		}
+	}
+
+	return lines
+}
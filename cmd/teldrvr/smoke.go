@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// smoke sends a sample transaction through the drivers and prints every call with its result
+func smoke(args []string) int {
+	flags := flag.NewFlagSet("smoke", flag.ExitOnError)
+	driverNames := flags.String("drivers", "", "comma separated drivers, default are the drivers of telemetry.driver")
+	flags.Parse(args)
+
+	// errors of the asynchronous drivers are only reported to the error handler
+	var failed atomic.Bool
+	teldrvr.SetErrorHandler(func(err error) {
+		failed.Store(true)
+		fmt.Printf("  driver error: %v\n", err)
+	})
+
+	cfg, err := teldrvr.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config could not be loaded: %v\n", err)
+		return 1
+	}
+
+	drivers := teldrvr.SelectedDrivers(cfg)
+	if len(*driverNames) > 0 {
+		drivers = strings.Split(*driverNames, ",")
+	}
+
+	for _, name := range drivers {
+		driver, ok := teldrvr.RegisteredDriver(name)
+		if !ok {
+			fmt.Printf("driver %s is not registered, check telemetry.driver and validate-config\n", name)
+			failed.Store(true)
+			continue
+		}
+
+		fmt.Printf("driver %s (%T)\n", name, driver)
+		if !smokeTransaction(driver) {
+			failed.Store(true)
+		}
+	}
+
+	fmt.Println("closing drivers, queued events are sent")
+	err = teldrvr.CloseDrivers()
+	if err != nil {
+		fmt.Printf("  driver error: %v\n", err)
+		failed.Store(true)
+	}
+
+	if failed.Load() {
+		return 1
+	}
+
+	return 0
+}
+
+// smokeTransaction runs the sample transaction and reports whether all calls succeeded
+func smokeTransaction(driver telemetry.Driver) bool {
+	transaction, err := driver.InitializeTransaction("teldrvr.smoke")
+	if !step("InitializeTransaction teldrvr.smoke", err) {
+		return false
+	}
+
+	ok := true
+	call := func(description string, err error) {
+		ok = step(description, err) && ok
+	}
+
+	trace, err := transaction.CreateTrace()
+	call("CreateTrace", err)
+	call("SetTrace "+trace, transaction.SetTrace(trace))
+
+	processID, err := transaction.CreateProcessID()
+	call("CreateProcessID", err)
+	call("SetProcessID "+processID, transaction.SetProcessID(processID))
+
+	call("AddTransactionAttribute smoke=true", transaction.AddTransactionAttribute("smoke", true))
+
+	call("SegmentStart outer", transaction.SegmentStart("outer", "teldrvr.smoke.outer"))
+	call("AddSegmentAttribute outer step=1", transaction.AddSegmentAttribute("outer", "step", 1))
+	call("Info outer", transaction.Info("outer", message("smoke info message")))
+
+	call("SegmentStart inner", transaction.SegmentStart("inner", "teldrvr.smoke.inner"))
+	call("AddSegmentAttribute inner step=2", transaction.AddSegmentAttribute("inner", "step", 2))
+	call("Debug inner", transaction.Debug("inner", message("smoke debug message")))
+	call("Error inner", transaction.Error("inner", message("smoke error message")))
+	call("RecordMetric teldrvr.smoke.value=1", teldrvr.RecordMetric(transaction, "teldrvr.smoke.value", 1))
+	call("SegmentEnd inner", transaction.SegmentEnd("inner"))
+
+	call("SegmentEnd outer", transaction.SegmentEnd("outer"))
+	call("Done", transaction.Done())
+
+	return ok
+}
+
+// step prints the call with its result and reports whether it succeeded
+func step(description string, err error) bool {
+	if err != nil {
+		fmt.Printf("  %s: %v\n", description, err)
+		return false
+	}
+
+	fmt.Printf("  %s: ok\n", description)
+
+	return true
+}
+
+func message(text string) io.ReadCloser {
+	return io.NopCloser(strings.NewReader(text))
+}
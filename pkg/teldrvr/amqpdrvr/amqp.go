@@ -0,0 +1,241 @@
+// Package amqpdrvr provides a telemetry driver publishing all events to an AMQP (RabbitMQ) exchange.
+// Import it for its side effect and add "amqp" to telemetry.driver or telemetry.external:
+//
+//	import _ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/amqpdrvr"
+package amqpdrvr
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+/** DRIVER NAME **/
+const amqpDriver = "amqp"
+
+const defaultRoutingKey = "{{.Level}}.{{.Transaction}}"
+const defaultConfirmTimeout = 5 * time.Second
+
+// configKeys are the settings below telemetry.drivers.amqp
+var configKeys = []teldrvr.ConfigKey{
+	{Name: "url", Required: true},
+	{Name: "exchange"},
+	{Name: "routingKey", Default: defaultRoutingKey},
+	{Name: "confirmTimeout", Kind: teldrvr.ConfigKindDuration, Min: 0},
+	{Name: "queueSize", Kind: teldrvr.ConfigKindInt, Min: 1},
+}
+
+func init() {
+	teldrvr.RegisterDriverConfig(amqpDriver, configKeys...)
+	teldrvr.RegisterDriverConfig(amqpDriver, teldrvr.TLSConfigKeys...)
+	teldrvr.RegisterDriverConfig(amqpDriver, teldrvr.EmitPolicyConfigKeys...)
+	teldrvr.RegisterDriverConfig(amqpDriver, teldrvr.EventEncodingConfigKeys...)
+
+	err := teldrvr.RegisterExternal(amqpDriver, newDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newDriver(cfg teldrvr.Config) (telemetry.Driver, error) {
+	driverCfg := teldrvr.DriverConfig(cfg, amqpDriver)
+
+	routingKey := driverCfg.GetString("routingKey")
+	if len(routingKey) == 0 {
+		routingKey = defaultRoutingKey
+	}
+
+	routingKeyTemplate, err := template.New("routingKey").Parse(routingKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing key template: %w", err)
+	}
+
+	tlsConfig, err := teldrvr.TLSConfig(cfg, amqpDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := teldrvr.LoadEmitPolicy(cfg, amqpDriver, teldrvr.DefaultEmitPolicy)
+
+	sink := &Sink{
+		URL:            driverCfg.GetString("url"),
+		TLSConfig:      tlsConfig,
+		Exchange:       driverCfg.GetString("exchange"),
+		RoutingKey:     routingKeyTemplate,
+		ConfirmTimeout: driverCfg.GetDuration("confirmTimeout"),
+		Encoding:       driverCfg.GetString("encoding"),
+		Policy:         policy,
+	}
+
+	if sink.ConfirmTimeout <= 0 {
+		sink.ConfirmTimeout = policy.RequestTimeout
+	}
+
+	return teldrvr.EventDriver{
+		Sink: teldrvr.NewAsyncSink(amqpDriver, sink, driverCfg.GetInt("queueSize")),
+	}, nil
+}
+
+// Sink publishes every event as JSON, Protobuf or Avro in confirm mode. The connection is (re)established lazily,
+// so a broker restart only loses the events that were in flight.
+type Sink struct {
+	URL string
+	// TLSConfig is used for amqps urls, if nil the system defaults are used
+	TLSConfig  *tls.Config
+	Exchange   string
+	RoutingKey *template.Template
+	// ConfirmTimeout is the maximum time to wait for the broker to confirm a message
+	ConfirmTimeout time.Duration
+	// Encoding of the events, see teldrvr.EncodeEvent
+	Encoding string
+	// Policy defines the connect timeout and the retries of a failed publish
+	Policy teldrvr.EmitPolicy
+
+	mutex      sync.Mutex
+	connection *amqp.Connection
+	channel    *amqp.Channel
+}
+
+// Emit publishes the event and waits for the confirmation of the broker.
+// If the publish failed, the connection is recovered and the event is published again according to the policy.
+func (s *Sink) Emit(event teldrvr.Event) error {
+	body, err := teldrvr.EncodeEvent(s.Encoding, event)
+	if err != nil {
+		return err
+	}
+
+	routingKey := strings.Builder{}
+	err = s.RoutingKey.Execute(&routingKey, event)
+	if err != nil {
+		return fmt.Errorf("could not execute routing key template: %w", err)
+	}
+
+	publishing := amqp.Publishing{
+		ContentType:  teldrvr.EventContentType(s.Encoding),
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    event.Time,
+		Body:         body,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.Policy.Do(func() (bool, error) {
+		err := s.publish(routingKey.String(), publishing)
+		if err != nil {
+			// connection recovery with the next attempt
+			s.close()
+		}
+
+		return true, err
+	})
+}
+
+// publish sends the message and waits for the confirmation
+// - Expects the mutex to be locked -
+func (s *Sink) publish(routingKey string, publishing amqp.Publishing) error {
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := s.channel.PublishWithDeferredConfirm(s.Exchange, routingKey, false, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	timeout := s.ConfirmTimeout
+	if timeout <= 0 {
+		timeout = defaultConfirmTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("no publish confirmation received: %w", err)
+	}
+
+	if !acked {
+		return errors.New("broker rejected the message")
+	}
+
+	return nil
+}
+
+// connect opens the connection and a channel in confirm mode if they are not open
+// - Expects the mutex to be locked -
+func (s *Sink) connect() error {
+	if s.connection != nil && !s.connection.IsClosed() && s.channel != nil && !s.channel.IsClosed() {
+		return nil
+	}
+
+	s.close()
+
+	// same defaults as amqp.Dial
+	config := amqp.Config{
+		Heartbeat:       10 * time.Second,
+		Locale:          "en_US",
+		TLSClientConfig: s.TLSConfig,
+	}
+	if s.Policy.ConnectTimeout > 0 {
+		config.Dial = amqp.DefaultDial(s.Policy.ConnectTimeout)
+	}
+
+	connection, err := amqp.DialConfig(s.URL, config)
+	if err != nil {
+		return err
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	err = channel.Confirm(false)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	s.connection = connection
+	s.channel = channel
+
+	return nil
+}
+
+// close closes the connection, errors are ignored as the connection is probably already broken
+// - Expects the mutex to be locked -
+func (s *Sink) close() {
+	if s.channel != nil {
+		s.channel.Close()
+		s.channel = nil
+	}
+
+	if s.connection != nil {
+		s.connection.Close()
+		s.connection = nil
+	}
+}
+
+// Close closes the connection to the broker
+func (s *Sink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.close()
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// attributeCoercionConfigKey configures the coercion of attribute values, e.g. telemetry.attributeCoercion.mode: json
+const attributeCoercionConfigKey = "telemetry.attributeCoercion"
+
+// modes of the attribute coercion
+const (
+	// AttributeCoercionFlatten splits maps and slices into one attribute per value with dotted keys, e.g. order.id
+	AttributeCoercionFlatten = "flatten"
+	// AttributeCoercionJSON encodes maps, slices and structs as JSON string
+	AttributeCoercionJSON = "json"
+	// AttributeCoercionNone passes all values unchanged
+	AttributeCoercionNone = "none"
+)
+
+// attributeCoercionModes are the valid values of telemetry.attributeCoercion.mode
+var attributeCoercionModes = []string{AttributeCoercionFlatten, AttributeCoercionJSON, AttributeCoercionNone}
+
+// attributeCoercionDefaults holds the modes of drivers whose backend drops values that are no string, number or bool
+var attributeCoercionDefaults = struct {
+	modes map[string]string
+	mutex sync.RWMutex
+}{
+	modes: make(map[string]string),
+}
+
+// registerAttributeCoercion registers the default mode of the driver, it is overridden by telemetry.attributeCoercion.mode
+func registerAttributeCoercion(driver string, mode string) {
+	attributeCoercionDefaults.mutex.Lock()
+	defer attributeCoercionDefaults.mutex.Unlock()
+
+	attributeCoercionDefaults.modes[driver] = mode
+}
+
+// attributeCoercionFor returns the mode of the driver, the configured mode wins over the registered default
+func attributeCoercionFor(cfg Config, driver string) string {
+	mode := cfg.GetString(attributeCoercionConfigKey + ".mode")
+	if len(mode) > 0 {
+		return mode
+	}
+
+	attributeCoercionDefaults.mutex.RLock()
+	defer attributeCoercionDefaults.mutex.RUnlock()
+
+	return attributeCoercionDefaults.modes[driver]
+}
+
+// withAttributeCoercion wraps the driver in an AttributeCoercionDriver if a coercion is registered or configured for it
+func withAttributeCoercion(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(AttributeCoercionDriver); ok {
+		return driver
+	}
+
+	mode := attributeCoercionFor(viper.GetViper(), name)
+	switch mode {
+	case "", AttributeCoercionNone:
+		return driver
+	case AttributeCoercionFlatten, AttributeCoercionJSON:
+	default:
+		handleError(fmt.Errorf("%s%s.mode »%s« has to be one of %s, %s, %s, falling back to %s",
+			telemetry.TelemetryDriverError, attributeCoercionConfigKey, mode, AttributeCoercionFlatten, AttributeCoercionJSON,
+			AttributeCoercionNone, AttributeCoercionJSON))
+		mode = AttributeCoercionJSON
+	}
+
+	return AttributeCoercionDriver{
+		Driver: driver,
+		Mode:   mode,
+		name:   name,
+	}
+}
+
+// AttributeCoercionDriver coerces attribute values the backend would drop into strings, numbers and bools before they
+// reach the wrapped driver. Every coerced key is reported once to the error handler.
+type AttributeCoercionDriver struct {
+	Driver telemetry.Driver
+	// Mode is AttributeCoercionFlatten or AttributeCoercionJSON
+	Mode string
+	name string
+}
+
+// InitializeTransaction starts a transaction of the wrapped driver that coerces the attributes
+func (d AttributeCoercionDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	return &AttributeCoercionTransaction{
+		Transaction: transaction,
+		mode:        d.Mode,
+		driver:      d.name,
+	}, nil
+}
+
+// Unwrap returns the wrapped driver
+func (d AttributeCoercionDriver) Unwrap() telemetry.Driver {
+	return d.Driver
+}
+
+// coercedAttributes holds the keys already reported per driver, so every coercion is reported once
+var coercedAttributes = struct {
+	keys  map[string]struct{}
+	mutex sync.Mutex
+}{
+	keys: make(map[string]struct{}),
+}
+
+// reportCoercion passes the coercion of the key to the error handler, if it was not reported for the driver before
+func reportCoercion(driver string, key string, value any, coercion string) {
+	coercedAttributes.mutex.Lock()
+	_, reported := coercedAttributes.keys[driver+"\x00"+key]
+	coercedAttributes.keys[driver+"\x00"+key] = struct{}{}
+	coercedAttributes.mutex.Unlock()
+
+	if reported {
+		return
+	}
+
+	handleError(fmt.Errorf("%sattribute »%s« of type %T is %s for driver %s", telemetry.TelemetryDriverError, key, value, coercion, driver))
+}
+
+// AttributeCoercionTransaction coerces the values of the transaction and segment attributes
+type AttributeCoercionTransaction struct {
+	telemetry.Transaction
+	mode   string
+	driver string
+}
+
+// coerce returns the attributes to add for the key and the value. Strings, numbers and bools are kept, errors,
+// fmt.Stringer like time.Time and values that can not be encoded are formatted with fmt. In the flatten mode maps and
+// slices become one attribute per value, nil values become the string null. Other values are encoded as JSON.
+func (t *AttributeCoercionTransaction) coerce(key string, value any) map[string]any {
+	if isScalarAttribute(value) {
+		return map[string]any{key: value}
+	}
+
+	switch value.(type) {
+	case error, fmt.Stringer:
+		reportCoercion(t.driver, key, value, "formatted as string")
+		return map[string]any{key: fmt.Sprint(value)}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		reportCoercion(t.driver, key, value, "formatted as string")
+		return map[string]any{key: fmt.Sprint(value)}
+	}
+
+	if t.mode == AttributeCoercionFlatten {
+		var decoded any
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.UseNumber()
+		if decoder.Decode(&decoded) == nil {
+			attributes := make(map[string]any)
+			flattenAttribute(attributes, key, decoded)
+			if len(attributes) > 0 {
+				reportCoercion(t.driver, key, value, fmt.Sprintf("flattened into %d attributes", len(attributes)))
+				return attributes
+			}
+		}
+	}
+
+	reportCoercion(t.driver, key, value, "encoded as JSON")
+
+	return map[string]any{key: string(encoded)}
+}
+
+// isScalarAttribute reports whether the backends accept the value as it is
+func isScalarAttribute(value any) bool {
+	switch value.(type) {
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// flattenAttribute adds the decoded JSON value with dotted keys, e.g. order.items.0.id
+func flattenAttribute(attributes map[string]any, key string, value any) {
+	switch typed := value.(type) {
+	case map[string]any:
+		if len(typed) == 0 {
+			attributes[key] = "{}"
+		}
+		for subKey, subValue := range typed {
+			flattenAttribute(attributes, key+"."+subKey, subValue)
+		}
+	case []any:
+		if len(typed) == 0 {
+			attributes[key] = "[]"
+		}
+		for i, subValue := range typed {
+			flattenAttribute(attributes, key+"."+strconv.Itoa(i), subValue)
+		}
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			attributes[key] = number
+		} else if number, err := typed.Float64(); err == nil {
+			attributes[key] = number
+		} else {
+			attributes[key] = typed.String()
+		}
+	case nil:
+		attributes[key] = "null"
+	default:
+		attributes[key] = typed
+	}
+}
+
+// add adds the coerced attributes in the order of their keys
+func (t *AttributeCoercionTransaction) add(key string, value any, add func(key string, value any) error) error {
+	attributes := t.coerce(key, value)
+	keys := make([]string, 0, len(attributes))
+	for coercedKey := range attributes {
+		keys = append(keys, coercedKey)
+	}
+	sort.Strings(keys)
+
+	errs := make([]error, 0, len(keys))
+	for _, coercedKey := range keys {
+		errs = append(errs, add(coercedKey, attributes[coercedKey]))
+	}
+
+	return errors.Join(errs...)
+}
+
+// AddTransactionAttribute adds the attribute with the coerced value
+func (t *AttributeCoercionTransaction) AddTransactionAttribute(key string, value any) error {
+	return t.add(key, value, t.Transaction.AddTransactionAttribute)
+}
+
+// AddSegmentAttribute adds the attribute with the coerced value
+func (t *AttributeCoercionTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	return t.add(key, value, func(key string, value any) error {
+		return t.Transaction.AddSegmentAttribute(segmentID, key, value)
+	})
+}
+
+// Unwrap returns the wrapped transaction
+func (t *AttributeCoercionTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
@@ -0,0 +1,82 @@
+package teldrvr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// attributeRecorder records the attributes it receives
+type attributeRecorder struct {
+	NopTransaction
+	attributes map[string]any
+}
+
+func (t *attributeRecorder) AddTransactionAttribute(key string, value any) error {
+	t.attributes[key] = value
+	return nil
+}
+
+func TestAttributeCoercionTransactionCoercesNestedValues(t *testing.T) {
+	value := map[string]any{
+		"id":    7,
+		"tags":  []string{"new", "paid"},
+		"items": []map[string]any{{"sku": "A1", "price": 1.5}},
+		"empty": map[string]any{},
+		"note":  nil,
+	}
+
+	for mode, want := range map[string]map[string]any{
+		AttributeCoercionFlatten: {
+			"order.id":            int64(7),
+			"order.tags.0":        "new",
+			"order.tags.1":        "paid",
+			"order.items.0.sku":   "A1",
+			"order.items.0.price": 1.5,
+			"order.empty":         "{}",
+			"order.note":          "null",
+		},
+		AttributeCoercionJSON: {
+			"order": `{"empty":{},"id":7,"items":[{"price":1.5,"sku":"A1"}],"note":null,"tags":["new","paid"]}`,
+		},
+	} {
+		t.Run(mode, func(t *testing.T) {
+			recorder := &attributeRecorder{attributes: make(map[string]any)}
+			transaction := &AttributeCoercionTransaction{Transaction: recorder, mode: mode, driver: "coercion" + mode}
+
+			if err := transaction.AddTransactionAttribute("order", value); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(recorder.attributes, want) {
+				t.Errorf("attributes = %v, want %v", recorder.attributes, want)
+			}
+		})
+	}
+}
+
+func TestAttributeCoercionTransactionReportsEachKeyOnce(t *testing.T) {
+	// drops the errors kept from before and the coercions reported by earlier runs, e.g. with -count
+	SetErrorHandler(nil)
+	coercedAttributes.mutex.Lock()
+	coercedAttributes.keys = make(map[string]struct{})
+	coercedAttributes.mutex.Unlock()
+	var reported []error
+	SetErrorHandler(func(err error) {
+		reported = append(reported, err)
+	})
+	t.Cleanup(func() {
+		SetErrorHandler(nil)
+	})
+
+	recorder := &attributeRecorder{attributes: make(map[string]any)}
+	transaction := &AttributeCoercionTransaction{Transaction: recorder, mode: AttributeCoercionJSON, driver: "coercionOnce"}
+	for i := 0; i < 3; i++ {
+		_ = transaction.AddTransactionAttribute("items", []int{i})
+		_ = transaction.AddTransactionAttribute("cause", errors.New("timeout"))
+		_ = transaction.AddTransactionAttribute("count", i)
+	}
+
+	if len(reported) != 2 {
+		t.Errorf("reported %d coercions, want one for items and one for cause: %v", len(reported), reported)
+	}
+}
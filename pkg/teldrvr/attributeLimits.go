@@ -0,0 +1,192 @@
+package teldrvr
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// attributeLimitsConfigKey configures the attribute limits of all drivers, e.g. telemetry.attributeLimits.maxValueLength
+const attributeLimitsConfigKey = "telemetry.attributeLimits"
+
+// truncationMarker is appended to truncated attribute values
+const truncationMarker = "...[truncated]"
+
+// AttributeLimits restricts the attributes passed to a driver, 0 means unlimited
+type AttributeLimits struct {
+	MaxTransactionAttributes int
+	// MaxSegmentAttributes applies to every segment on its own
+	MaxSegmentAttributes int
+	// MaxValueLength is the maximum length of string values in bytes including the truncation marker
+	MaxValueLength int
+}
+
+// attributeLimitDefaults holds the limits of drivers whose backend drops attributes over its limits
+var attributeLimitDefaults = struct {
+	limits map[string]AttributeLimits
+	mutex  sync.RWMutex
+}{
+	limits: make(map[string]AttributeLimits),
+}
+
+// registerAttributeLimits registers the default limits of the driver, they are overridden by telemetry.attributeLimits.*
+func registerAttributeLimits(driver string, limits AttributeLimits) {
+	attributeLimitDefaults.mutex.Lock()
+	defer attributeLimitDefaults.mutex.Unlock()
+
+	attributeLimitDefaults.limits[driver] = limits
+}
+
+// attributeLimitsFor returns the limits of the driver, the configured limits win over the registered defaults
+func attributeLimitsFor(cfg Config, driver string) AttributeLimits {
+	attributeLimitDefaults.mutex.RLock()
+	limits := attributeLimitDefaults.limits[driver]
+	attributeLimitDefaults.mutex.RUnlock()
+
+	if cfg.IsSet(attributeLimitsConfigKey + ".maxTransactionAttributes") {
+		limits.MaxTransactionAttributes = cfg.GetInt(attributeLimitsConfigKey + ".maxTransactionAttributes")
+	}
+
+	if cfg.IsSet(attributeLimitsConfigKey + ".maxSegmentAttributes") {
+		limits.MaxSegmentAttributes = cfg.GetInt(attributeLimitsConfigKey + ".maxSegmentAttributes")
+	}
+
+	if cfg.IsSet(attributeLimitsConfigKey + ".maxValueLength") {
+		limits.MaxValueLength = cfg.GetInt(attributeLimitsConfigKey + ".maxValueLength")
+	}
+
+	return limits
+}
+
+// withAttributeLimits wraps the driver in an AttributeLimitDriver if limits are registered or configured for it
+func withAttributeLimits(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(AttributeLimitDriver); ok {
+		return driver
+	}
+
+	limits := attributeLimitsFor(viper.GetViper(), name)
+	if limits == (AttributeLimits{}) {
+		return driver
+	}
+
+	return AttributeLimitDriver{
+		Driver: driver,
+		Limits: limits,
+	}
+}
+
+// AttributeLimitDriver enforces the attribute limits before the attributes reach the wrapped driver.
+// Attributes over the count limit are dropped and reported to the caller, string values over the length limit
+// are truncated and marked with ...[truncated].
+type AttributeLimitDriver struct {
+	Driver telemetry.Driver
+	Limits AttributeLimits
+}
+
+// InitializeTransaction starts a transaction of the wrapped driver that enforces the limits
+func (d AttributeLimitDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	return &AttributeLimitTransaction{
+		Transaction:           transaction,
+		limits:                d.Limits,
+		transactionAttributes: make(map[string]struct{}),
+		segmentAttributes:     make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// AttributeLimitTransaction counts the attributes of the transaction and its segments
+type AttributeLimitTransaction struct {
+	telemetry.Transaction
+	limits                AttributeLimits
+	transactionAttributes map[string]struct{}
+	segmentAttributes     map[string]map[string]struct{}
+	mutex                 sync.Mutex
+}
+
+// AddTransactionAttribute adds the attribute if the transaction has not reached the count limit
+func (t *AttributeLimitTransaction) AddTransactionAttribute(key string, value any) error {
+	t.mutex.Lock()
+	ok := admitAttribute(t.transactionAttributes, key, t.limits.MaxTransactionAttributes)
+	t.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("transaction reached the limit of %d attributes, attribute »%s« is dropped", t.limits.MaxTransactionAttributes, key)
+	}
+
+	return t.Transaction.AddTransactionAttribute(key, truncateAttributeValue(value, t.limits.MaxValueLength))
+}
+
+// AddSegmentAttribute adds the attribute if the segment has not reached the count limit
+func (t *AttributeLimitTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	t.mutex.Lock()
+	attributes, ok := t.segmentAttributes[segmentID]
+	if !ok {
+		attributes = make(map[string]struct{})
+		t.segmentAttributes[segmentID] = attributes
+	}
+	ok = admitAttribute(attributes, key, t.limits.MaxSegmentAttributes)
+	t.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("segment »%s« reached the limit of %d attributes, attribute »%s« is dropped", segmentID, t.limits.MaxSegmentAttributes, key)
+	}
+
+	return t.Transaction.AddSegmentAttribute(segmentID, key, truncateAttributeValue(value, t.limits.MaxValueLength))
+}
+
+// SegmentEnd ends the segment and forgets its attributes
+func (t *AttributeLimitTransaction) SegmentEnd(segmentID string) error {
+	t.mutex.Lock()
+	delete(t.segmentAttributes, segmentID)
+	t.mutex.Unlock()
+
+	return t.Transaction.SegmentEnd(segmentID)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *AttributeLimitTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// admitAttribute reports whether the attribute fits into the limit and remembers its key.
+// Replacing the value of a known key is always admitted.
+// - Expects the mutex to be locked -
+func admitAttribute(attributes map[string]struct{}, key string, limit int) bool {
+	if _, ok := attributes[key]; ok {
+		return true
+	}
+
+	if limit > 0 && len(attributes) >= limit {
+		return false
+	}
+
+	attributes[key] = struct{}{}
+
+	return true
+}
+
+// truncateAttributeValue truncates string values longer than the limit at a rune boundary and marks them
+func truncateAttributeValue(value any, limit int) any {
+	stringValue, ok := value.(string)
+	if !ok || limit <= 0 || len(stringValue) <= limit {
+		return value
+	}
+
+	cut := limit - len(truncationMarker)
+	if cut <= 0 {
+		return truncationMarker[:limit]
+	}
+
+	for cut > 0 && !utf8.RuneStart(stringValue[cut]) {
+		cut--
+	}
+
+	return stringValue[:cut] + truncationMarker
+}
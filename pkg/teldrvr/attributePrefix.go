@@ -0,0 +1,96 @@
+package teldrvr
+
+import (
+	"strings"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// attributePrefixConfigKey configures the prefix of the attributes, e.g. telemetry.attributePrefix.prefix: "app."
+const attributePrefixConfigKey = "telemetry.attributePrefix"
+
+// attributePrefixFor returns the prefix of the driver, telemetry.attributePrefix.drivers.<driver> overrides
+// telemetry.attributePrefix.prefix, an empty override disables the prefix for the driver
+func attributePrefixFor(cfg Config, driver string) string {
+	// viper lowercases the keys of maps
+	for name, prefix := range cfg.GetStringMapString(attributePrefixConfigKey + ".drivers") {
+		if strings.EqualFold(name, driver) {
+			return prefix
+		}
+	}
+
+	return cfg.GetString(attributePrefixConfigKey + ".prefix")
+}
+
+// withAttributePrefix wraps the driver in an AttributePrefixDriver if a prefix is configured for it
+func withAttributePrefix(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(AttributePrefixDriver); ok {
+		return driver
+	}
+
+	prefix := attributePrefixFor(viper.GetViper(), name)
+	if len(prefix) == 0 {
+		return driver
+	}
+
+	return AttributePrefixDriver{
+		Driver: driver,
+		Prefix: prefix,
+	}
+}
+
+// AttributePrefixDriver prefixes the keys of the transaction and segment attributes, so they never collide with the
+// attributes reserved by the backend. The attributes the drivers add on their own, e.g. outcome, keep their names.
+type AttributePrefixDriver struct {
+	Driver telemetry.Driver
+	Prefix string
+}
+
+// InitializeTransaction starts a transaction of the wrapped driver that prefixes the attributes
+func (d AttributePrefixDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	return &AttributePrefixTransaction{
+		Transaction: transaction,
+		prefix:      d.Prefix,
+	}, nil
+}
+
+// Unwrap returns the wrapped driver
+func (d AttributePrefixDriver) Unwrap() telemetry.Driver {
+	return d.Driver
+}
+
+// AttributePrefixTransaction prefixes the keys of the attributes, keys starting with the prefix are kept
+type AttributePrefixTransaction struct {
+	telemetry.Transaction
+	prefix string
+}
+
+// prefixed returns the key with the prefix
+func (t *AttributePrefixTransaction) prefixed(key string) string {
+	if strings.HasPrefix(key, t.prefix) {
+		return key
+	}
+
+	return t.prefix + key
+}
+
+// AddTransactionAttribute adds the attribute with the prefixed key
+func (t *AttributePrefixTransaction) AddTransactionAttribute(key string, value any) error {
+	return t.Transaction.AddTransactionAttribute(t.prefixed(key), value)
+}
+
+// AddSegmentAttribute adds the attribute with the prefixed key
+func (t *AttributePrefixTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	return t.Transaction.AddSegmentAttribute(segmentID, t.prefixed(key), value)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *AttributePrefixTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
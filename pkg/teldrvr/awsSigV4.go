@@ -0,0 +1,201 @@
+package teldrvr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const awsSigningAlgorithm = "AWS4-HMAC-SHA256"
+const awsAmzDateFormat = "20060102T150405Z"
+const awsScopeDateFormat = "20060102"
+
+// awsContainerCredentialsHost is the credentials endpoint of ECS tasks
+const awsContainerCredentialsHost = "http://169.254.170.2"
+
+// awsCredentialsRefresh is the time before the expiration at which temporary credentials are refreshed
+const awsCredentialsRefresh = 5 * time.Minute
+
+// awsCredentials are the credentials used to sign a request
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// signAWSRequest adds the AWS signature version 4 to the request
+func signAWSRequest(request *http.Request, body []byte, now time.Time, region string, service string, credentials awsCredentials) error {
+	if len(credentials.AccessKeyID) == 0 || len(credentials.SecretAccessKey) == 0 {
+		return fmt.Errorf("%s credentials are missing", service)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format(awsAmzDateFormat)
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(awsScopeDateFormat), region, service)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if len(credentials.SessionToken) > 0 {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// host and all x-amz-* headers are signed
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := awsHMAC([]byte("AWS4"+credentials.SecretAccessKey), now.Format(awsScopeDateFormat))
+	key = awsHMAC(key, region)
+	key = awsHMAC(key, service)
+	key = awsHMAC(key, "aws4_request")
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+
+	return nil
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsCredentialsProvider returns static credentials or the temporary credentials of the ECS task role.
+// The temporary credentials are refreshed before they expire.
+type awsCredentialsProvider struct {
+	static awsCredentials
+	// containerURL and containerToken are set from AWS_CONTAINER_CREDENTIALS_* for ECS tasks
+	containerURL   string
+	containerToken string
+	client         *http.Client
+
+	credentials awsCredentials
+	mutex       sync.Mutex
+}
+
+// newAWSCredentialsProvider reads the static credentials from the prefix, e.g. telemetry.secrets.aws.accessKeyID.
+// Without static credentials the credentials endpoint of the ECS task is used.
+func newAWSCredentialsProvider(cfg Config, prefix string) (*awsCredentialsProvider, error) {
+	p := &awsCredentialsProvider{
+		static: awsCredentials{
+			AccessKeyID:     cfg.GetString(prefix + "accessKeyID"),
+			SecretAccessKey: cfg.GetString(prefix + "secretAccessKey"),
+			SessionToken:    cfg.GetString(prefix + "sessionToken"),
+		},
+		client: &http.Client{Timeout: DefaultEmitPolicy.RequestTimeout},
+	}
+
+	if len(p.static.AccessKeyID) > 0 {
+		return p, nil
+	}
+
+	relativeURI := cfg.GetString("telemetry.secrets.aws.containerCredentialsRelativeURI")
+	fullURI := cfg.GetString("telemetry.secrets.aws.containerCredentialsFullURI")
+	switch {
+	case len(relativeURI) > 0:
+		p.containerURL = awsContainerCredentialsHost + relativeURI
+	case len(fullURI) > 0:
+		p.containerURL = fullURI
+		p.containerToken = cfg.GetString("telemetry.secrets.aws.containerAuthorizationToken")
+	default:
+		return nil, errors.New(prefix + "accessKeyID is not set and no ECS container credentials are available")
+	}
+
+	return p, nil
+}
+
+// Credentials returns the current credentials
+func (p *awsCredentialsProvider) Credentials() (awsCredentials, error) {
+	if len(p.containerURL) == 0 {
+		return p.static, nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.credentials.AccessKeyID) > 0 && time.Until(p.credentials.Expiration) > awsCredentialsRefresh {
+		return p.credentials, nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, p.containerURL, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if len(p.containerToken) > 0 {
+		request.Header.Set("Authorization", p.containerToken)
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("container credentials could not be requested: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("container credentials endpoint responded with status %d", response.StatusCode)
+	}
+
+	credentials := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&credentials)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("container credentials could not be decoded: %w", err)
+	}
+
+	p.credentials = awsCredentials{
+		AccessKeyID:     credentials.AccessKeyID,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.Token,
+		Expiration:      credentials.Expiration,
+	}
+
+	return p.credentials, nil
+}
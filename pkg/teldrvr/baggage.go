@@ -0,0 +1,184 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// BaggageHeader carries the baggage of a transaction in the format of the W3C baggage header
+const BaggageHeader = "Baggage"
+
+// limits of the baggage header, entries over them are dropped
+const (
+	baggageMaxEntries = 64
+	baggageMaxBytes   = 8192
+)
+
+// Baggage are small key-value pairs that travel with the trace to other services, e.g. the tenant or a request ID
+type Baggage map[string]string
+
+// validateBaggageKey reports keys the header can not carry
+func validateBaggageKey(key string) error {
+	if len(key) == 0 || strings.ContainsAny(key, ",;= \t\"\\") {
+		return fmt.Errorf("baggage key »%s« has to be a non-empty token without separators", key)
+	}
+
+	return nil
+}
+
+// header returns the baggage in the format of the W3C baggage header, the values are percent-encoded.
+// The entries are sorted by key, entries over the limits are dropped.
+func (b Baggage) header() string {
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var header strings.Builder
+	for i, key := range keys {
+		if i >= baggageMaxEntries {
+			break
+		}
+
+		entry := key + "=" + url.PathEscape(b[key])
+		if header.Len() > 0 {
+			entry = "," + entry
+		}
+		if header.Len()+len(entry) > baggageMaxBytes {
+			break
+		}
+		header.WriteString(entry)
+	}
+
+	return header.String()
+}
+
+// parseBaggage reads the entries of the W3C baggage header, properties of the entries and invalid entries are skipped
+func parseBaggage(header string) Baggage {
+	baggage := Baggage{}
+	for _, member := range strings.Split(header, ",") {
+		if len(baggage) >= baggageMaxEntries {
+			break
+		}
+
+		member, _, _ = strings.Cut(member, ";")
+		key, value, ok := strings.Cut(member, "=")
+		key = strings.TrimSpace(key)
+		if !ok || validateBaggageKey(key) != nil {
+			continue
+		}
+
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		baggage[key] = value
+	}
+
+	return baggage
+}
+
+// WithBaggage attaches the baggage to the transaction. The entries are added as transaction attributes and passed to
+// other services by InjectTraceHeaders, ExtractTraceHeaders adds them on the receiving side. Baggage of a transaction
+// returned by WithBaggage before is merged, later values win.
+func WithBaggage(transaction telemetry.Transaction, baggage Baggage) (telemetry.Transaction, error) {
+	var errs []error
+	valid := make(Baggage, len(baggage))
+	for key, value := range baggage {
+		err := validateBaggageKey(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		valid[key] = value
+	}
+
+	if len(valid) == 0 {
+		return transaction, errors.Join(errs...)
+	}
+
+	baggageTransaction, ok := transaction.(*BaggageTransaction)
+	if !ok {
+		baggageTransaction = &BaggageTransaction{
+			Transaction: transaction,
+			baggage:     make(Baggage, len(valid)),
+		}
+	}
+
+	for key, value := range valid {
+		baggageTransaction.baggage[key] = value
+
+		err := baggageTransaction.Transaction.AddTransactionAttribute(key, value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return baggageTransaction, errors.Join(errs...)
+}
+
+// ExtractTraceHeaders continues the trace of the header of an incoming request and attaches its baggage with
+// WithBaggage. The distributed tracing header of New Relic is preferred over the TraceHeader.
+func ExtractTraceHeaders(transaction telemetry.Transaction, header http.Header) (telemetry.Transaction, error) {
+	var errs []error
+
+	trace := header.Get(newRelicTraceHeader)
+	if len(trace) == 0 {
+		trace = header.Get(TraceHeader)
+	}
+	if len(trace) > 0 {
+		err := transaction.SetTrace(trace)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	baggageHeader := strings.Join(header.Values(BaggageHeader), ",")
+	if len(baggageHeader) > 0 {
+		var err error
+		transaction, err = WithBaggage(transaction, parseBaggage(baggageHeader))
+		errs = append(errs, err)
+	}
+
+	return transaction, errors.Join(errs...)
+}
+
+// BaggageTransaction carries the baggage of the transaction to the headers of outgoing requests
+type BaggageTransaction struct {
+	telemetry.Transaction
+	baggage Baggage
+}
+
+// Baggage returns a copy of the baggage of the transaction
+func (t *BaggageTransaction) Baggage() Baggage {
+	baggage := make(Baggage, len(t.baggage))
+	for key, value := range t.baggage {
+		baggage[key] = value
+	}
+
+	return baggage
+}
+
+// InjectTraceHeaders adds the trace headers of the wrapped transaction and the baggage header
+func (t *BaggageTransaction) InjectTraceHeaders(header http.Header) error {
+	err := InjectTraceHeaders(t.Transaction, header)
+
+	baggageHeader := t.baggage.header()
+	if len(baggageHeader) > 0 {
+		header.Set(BaggageHeader, baggageHeader)
+	}
+
+	return err
+}
+
+// Unwrap returns the wrapped transaction
+func (t *BaggageTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
@@ -0,0 +1,66 @@
+package teldrvr
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// callerEnabled adds the caller and goroutine of every Info/Error/Debug call to the log drivers output
+var callerEnabled = false
+
+// ignoredCallerPackages are skipped while looking for the caller
+var ignoredCallerPackages = []string{
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr.",
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry.",
+	"runtime.",
+}
+
+// callerInfo returns file:line of the first frame outside of the telemetry packages
+func callerInfo() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		frame, more := frames.Next()
+		if !isIgnoredCaller(frame.Function) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+func isIgnoredCaller(function string) bool {
+	for _, pkg := range ignoredCallerPackages {
+		if strings.HasPrefix(function, pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// goroutineID parses the ID of the current goroutine from its stack header ("goroutine 42 [running]:")
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+
+	end := bytes.IndexByte(buf, ' ')
+	if end < 0 {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(string(buf[:end]), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
@@ -0,0 +1,414 @@
+package teldrvr
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// canaryConfigKey configures the canary of a driver, e.g. telemetry.canary.newrelicAPM.driver: webhook
+const canaryConfigKey = "telemetry.canary"
+
+// canaryBuckets is the resolution of the percentage, 10000 allows steps of 0.01%
+const canaryBuckets = 10000
+
+// canaryFor returns the name of the canary driver and the percentage of transactions routed to it
+func canaryFor(cfg Config, name string) (string, float64, error) {
+	canary := strings.TrimSpace(cfg.GetString(canaryConfigKey + "." + name + ".driver"))
+	if len(canary) == 0 || canary == name {
+		return "", 0, nil
+	}
+
+	percentKey := canaryConfigKey + "." + name + ".percent"
+	percent, err := cast.ToFloat64E(cfg.Get(percentKey))
+	if err != nil || percent < 0 || percent > 100 {
+		return "", 0, fmt.Errorf("%s »%v« has to be a number between 0 and 100", percentKey, cfg.Get(percentKey))
+	}
+
+	return canary, percent, nil
+}
+
+// isCanaryDriver reports whether the driver is configured as canary of another driver
+func isCanaryDriver(cfg Config, name string) bool {
+	for _, canary := range cast.ToStringMap(cfg.Get(canaryConfigKey)) {
+		if strings.TrimSpace(cast.ToString(cast.ToStringMap(canary)["driver"])) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withCanary wraps the driver in a CanaryDriver if a canary is configured for it
+func withCanary(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(CanaryDriver); ok {
+		return driver
+	}
+
+	canary, percent, err := canaryFor(viper.GetViper(), name)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has no canary: %w", telemetry.TelemetryDriverError, name, err))
+		return driver
+	}
+
+	if len(canary) == 0 {
+		return driver
+	}
+
+	return CanaryDriver{
+		Stable:  driver,
+		Canary:  canary,
+		Percent: percent,
+	}
+}
+
+// CanaryDriver routes a percentage of the transactions to the canary driver, the rest keeps using the stable driver.
+// The decision is based on the hash of the trace, so all services with the same percentage route a trace the same way.
+// Calls before the trace is set are buffered and replayed to the transaction of the selected driver.
+type CanaryDriver struct {
+	Stable telemetry.Driver
+	// Canary is the name of a registered driver, it is resolved when a transaction is routed to it
+	Canary string
+	// Percent of the transactions routed to the canary driver, between 0 and 100
+	Percent float64
+}
+
+// InitializeTransaction starts a transaction, the driver is selected once the trace is known
+func (d CanaryDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	return &CanaryTransaction{
+		driver: d,
+		name:   name,
+	}, nil
+}
+
+// routeToCanary reports whether the trace is routed to the canary driver
+func (d CanaryDriver) routeToCanary(trace string) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte(trace))
+
+	return float64(hash.Sum32()%canaryBuckets) < d.Percent*canaryBuckets/100
+}
+
+// selectDriver returns the driver for the trace
+func (d CanaryDriver) selectDriver(trace string) telemetry.Driver {
+	if !d.routeToCanary(trace) {
+		return d.Stable
+	}
+
+	registry.mutex.RLock()
+	canary, ok := registry.drivers[d.Canary]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return d.Stable
+	}
+
+	// a canary of the canary is not used, this prevents cycles
+	if canaryDriver, ok := canary.(CanaryDriver); ok {
+		return canaryDriver.Stable
+	}
+
+	return canary
+}
+
+// canaryCall is a buffered call of a transaction
+type canaryCall func(transaction telemetry.Transaction) error
+
+// CanaryTransaction forwards all calls to the transaction of the selected driver
+type CanaryTransaction struct {
+	driver      CanaryDriver
+	name        string
+	transaction telemetry.Transaction
+	pending     []canaryCall
+	// processID is kept until the driver is selected, so creating the process ID does not select the driver
+	processID string
+	mutex     sync.Mutex
+}
+
+// selected returns the transaction of the selected driver, the driver is selected by the trace.
+// Without trace a random one is used, which is the case for the root of a trace.
+// - Expects the mutex to be locked -
+func (t *CanaryTransaction) selected(trace string) (telemetry.Transaction, error) {
+	if t.transaction != nil {
+		return t.transaction, nil
+	}
+
+	if len(trace) == 0 {
+		trace = newIDString()
+	}
+
+	transaction, err := t.driver.selectDriver(trace).InitializeTransaction(t.name)
+	if err != nil {
+		return nil, err
+	}
+	t.transaction = transaction
+
+	for _, call := range t.pending {
+		err = call(transaction)
+		if err != nil {
+			handleError(fmt.Errorf("%sbuffered call of canary transaction %s failed: %w", telemetry.TelemetryDriverError, t.name, err))
+		}
+	}
+	t.pending = nil
+
+	return transaction, nil
+}
+
+// do forwards the call to the selected transaction or buffers it until the trace is known
+func (t *CanaryTransaction) do(call canaryCall) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.transaction == nil {
+		t.pending = append(t.pending, call)
+		return nil
+	}
+
+	return call(t.transaction)
+}
+
+// current returns the transaction of the selected driver, nil if the driver is not selected yet
+func (t *CanaryTransaction) current() telemetry.Transaction {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.transaction
+}
+
+// withSelected forwards the call to the transaction, the driver is selected first if necessary
+func (t *CanaryTransaction) withSelected(trace string, call canaryCall) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	transaction, err := t.selected(trace)
+	if err != nil {
+		return err
+	}
+
+	return call(transaction)
+}
+
+// Start starts the transaction
+func (t *CanaryTransaction) Start(name string) {
+	t.do(func(transaction telemetry.Transaction) error {
+		transaction.Start(name)
+		return nil
+	})
+}
+
+// AddTransactionAttribute adds an attribute to the transaction
+// - Not thread safe -
+func (t *CanaryTransaction) AddTransactionAttribute(key string, value any) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.AddTransactionAttribute(key, value)
+	})
+}
+
+// SegmentStart starts a segment
+func (t *CanaryTransaction) SegmentStart(segmentID string, name string) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.SegmentStart(segmentID, name)
+	})
+}
+
+// AddSegmentAttribute adds an attribute to the currently open segment
+// - Thread safe if the transaction of the selected driver is -
+func (t *CanaryTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.AddSegmentAttribute(segmentID, key, value)
+	})
+}
+
+// SegmentEnd ends the segment
+func (t *CanaryTransaction) SegmentEnd(segmentID string) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.SegmentEnd(segmentID)
+	})
+}
+
+// Error logs errors in the transaction/segment
+func (t *CanaryTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.ErrorBytesSize)
+	if err != nil {
+		return err
+	}
+
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.Error(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// Info logs information in the transaction
+func (t *CanaryTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.Info(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// Debug logs information in the transaction
+func (t *CanaryTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.Debug(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// RecordMetric records a custom metric, if the transaction of the selected driver supports metrics
+func (t *CanaryTransaction) RecordMetric(name string, value float64) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return RecordMetric(transaction, name, value)
+	})
+}
+
+// InjectTraceHeaders adds the trace headers of the transaction of the selected driver
+func (t *CanaryTransaction) InjectTraceHeaders(header http.Header) error {
+	return t.withSelected("", func(transaction telemetry.Transaction) error {
+		return InjectTraceHeaders(transaction, header)
+	})
+}
+
+// SetOutcome sets the outcome of the transaction of the selected driver
+func (t *CanaryTransaction) SetOutcome(outcome string) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return SetOutcome(transaction, outcome)
+	})
+}
+
+// SetQueueStart records the queue start in the transaction of the selected driver
+func (t *CanaryTransaction) SetQueueStart(start time.Time) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return SetQueueStart(transaction, start)
+	})
+}
+
+// OverrideSampling overrides the sampling of the transaction of the selected driver
+func (t *CanaryTransaction) OverrideSampling(decision string) error {
+	return t.do(func(transaction telemetry.Transaction) error {
+		return OverrideSampling(transaction, decision)
+	})
+}
+
+// IsLevelEnabled reports whether the transaction of the selected driver logs messages of the level in the segment.
+// Before the driver is selected all levels are enabled, the messages are buffered.
+func (t *CanaryTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	transaction := t.current()
+
+	return transaction == nil || IsLevelEnabled(transaction, segmentID, level)
+}
+
+// Done ends the transaction, a transaction without trace is routed randomly
+func (t *CanaryTransaction) Done() error {
+	return t.withSelected("", func(transaction telemetry.Transaction) error {
+		return transaction.Done()
+	})
+}
+
+// CreateTrace starts a new trace, the driver is selected randomly
+func (t *CanaryTransaction) CreateTrace() (string, error) {
+	var trace string
+	err := t.withSelected("", func(transaction telemetry.Transaction) error {
+		var err error
+		trace, err = transaction.CreateTrace()
+		return err
+	})
+
+	return trace, err
+}
+
+// SetTrace sets the trace and selects the driver by its hash
+func (t *CanaryTransaction) SetTrace(trace string) error {
+	return t.withSelected(trace, func(transaction telemetry.Transaction) error {
+		return transaction.SetTrace(trace)
+	})
+}
+
+// Trace returns the current trace for the transaction, it is empty until the driver is selected
+func (t *CanaryTransaction) Trace() (string, error) {
+	transaction := t.current()
+	if transaction == nil {
+		return "", nil
+	}
+
+	return transaction.Trace()
+}
+
+// TraceID returns the current trace ID for the transaction, it is empty until the driver is selected
+func (t *CanaryTransaction) TraceID() (string, error) {
+	transaction := t.current()
+	if transaction == nil {
+		return "", nil
+	}
+
+	return transaction.TraceID()
+}
+
+// SetTraceID sets the trace ID and selects the driver by its hash
+func (t *CanaryTransaction) SetTraceID(traceID string) error {
+	return t.withSelected(traceID, func(transaction telemetry.Transaction) error {
+		return transaction.SetTraceID(traceID)
+	})
+}
+
+// CreateProcessID creates a ProcessID for the transaction. Until the driver is selected it is created like the drivers
+// do, telemetry.Start creates it before the trace is set.
+func (t *CanaryTransaction) CreateProcessID() (string, error) {
+	transaction := t.current()
+	if transaction == nil {
+		return newID()
+	}
+
+	return transaction.CreateProcessID()
+}
+
+// SetProcessID sets a ProcessID for the transaction
+func (t *CanaryTransaction) SetProcessID(processID string) error {
+	t.mutex.Lock()
+	t.processID = processID
+	t.mutex.Unlock()
+
+	return t.do(func(transaction telemetry.Transaction) error {
+		return transaction.SetProcessID(processID)
+	})
+}
+
+// ProcessID returns the current ProcessID for the transaction
+func (t *CanaryTransaction) ProcessID() (string, error) {
+	t.mutex.Lock()
+	transaction, processID := t.transaction, t.processID
+	t.mutex.Unlock()
+
+	if transaction == nil {
+		return processID, nil
+	}
+
+	return transaction.ProcessID()
+}
+
+// Erase any memory the transaction allocated
+func (t *CanaryTransaction) Erase() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.pending = nil
+	if t.transaction != nil {
+		t.transaction.Erase()
+	}
+}
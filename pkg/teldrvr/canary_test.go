@@ -0,0 +1,66 @@
+package teldrvr
+
+import (
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestCanaryTransactionSelectsDriverBySetTrace(t *testing.T) {
+	registry.mutex.Lock()
+	registry.drivers["canaryTest"] = LocalDriver{Format: localFormatPretty, Writer: io.Discard}
+	registry.mutex.Unlock()
+	t.Cleanup(func() {
+		registry.mutex.Lock()
+		delete(registry.drivers, "canaryTest")
+		registry.mutex.Unlock()
+	})
+
+	driver := CanaryDriver{
+		Stable:  LocalDriver{Format: localFormatPlain, Writer: io.Discard},
+		Canary:  "canaryTest",
+		Percent: 50,
+	}
+
+	for _, toCanary := range []bool{true, false} {
+		trace := ""
+		for i := 0; len(trace) == 0; i++ {
+			if driver.routeToCanary(strconv.Itoa(i)) == toCanary {
+				trace = strconv.Itoa(i)
+			}
+		}
+
+		transaction, _ := driver.InitializeTransaction("test")
+		canary := transaction.(*CanaryTransaction)
+
+		// the calls of telemetry.Start must not select the driver before the trace is set
+		processID, err := canary.CreateProcessID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = canary.SetProcessID(processID); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := canary.ProcessID(); got != processID {
+			t.Errorf("ProcessID() = %q, want %q", got, processID)
+		}
+		if _, err = canary.Trace(); err != nil {
+			t.Fatal(err)
+		}
+		if canary.current() != nil {
+			t.Fatal("driver is selected before the trace is set")
+		}
+
+		if err = canary.SetTrace(trace); err != nil {
+			t.Fatal(err)
+		}
+
+		selected := canary.current().(*LocalTransaction).format == localFormatPretty
+		if selected != toCanary {
+			t.Errorf("trace %s routed to canary = %t, want %t", trace, selected, toCanary)
+		}
+		if got, _ := canary.ProcessID(); got != processID {
+			t.Errorf("ProcessID() after the selection = %q, want %q", got, processID)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package teldrvr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const chatDriver = "chat"
+
+const chatPlatformSlack = "slack"
+const chatPlatformTeams = "teams"
+
+// chat messages should stay readable, longer error messages are cut
+const chatMessageLimit = 2000
+
+// chatConfigKeys are the settings below telemetry.drivers.chat
+var chatConfigKeys = []ConfigKey{
+	{Name: "url", Required: true, Secret: true},
+	{Name: "platform", Default: chatPlatformSlack, Values: []string{chatPlatformSlack, chatPlatformTeams}},
+	{Name: "traceURL"},
+	{Name: "ratePerMinute", Kind: ConfigKindInt, Default: 10, Min: 1},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(chatDriver, chatConfigKeys...)
+	RegisterDriverConfig(chatDriver, TLSConfigKeys...)
+	RegisterDriverConfig(chatDriver, EmitPolicyConfigKeys...)
+
+	if !driverEnabled(cfg, chatDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, chatDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no messages will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(chatDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, chatDriver)
+
+	policy := LoadEmitPolicy(cfg, chatDriver, DefaultEmitPolicy)
+	client, err := newHTTPClient(cfg, chatDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no messages will be sent: %w", telemetry.TelemetryDriverError, chatDriver, err))
+		registerDriver(chatDriver, NopDriver{})
+		return
+	}
+
+	sink := &ChatSink{
+		URL:           driverCfg.GetString("url"),
+		Platform:      driverCfg.GetString("platform"),
+		TraceURL:      driverCfg.GetString("traceURL"),
+		RatePerMinute: driverCfg.GetInt("ratePerMinute"),
+		Policy:        policy,
+		Client:        client,
+	}
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(chatDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(chatDriver, driver)
+}
+
+// ChatSink posts error summaries to a Slack or MS Teams incoming webhook
+type ChatSink struct {
+	URL      string
+	Platform string
+	// TraceURL links to the trace, {traceID} is replaced with the trace of the transaction
+	TraceURL string
+	// RatePerMinute limits the posted messages, suppressed messages are counted and reported with the next message
+	RatePerMinute int
+	Policy        EmitPolicy
+	Client        *http.Client
+
+	mutex      sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	suppressed int
+}
+
+// SelfTest is skipped, a synthetic event would notify people
+func (s *ChatSink) SelfTest(ctx context.Context) error {
+	return ErrSelfTestSkipped
+}
+
+// Emit posts error events, all other events are ignored
+func (s *ChatSink) Emit(event Event) error {
+	if event.Type != eventTypeLog || event.Level != logLevelError {
+		return nil
+	}
+
+	suppressed, allowed := s.allow()
+	if !allowed {
+		return nil
+	}
+
+	title, text := s.summary(event, suppressed)
+
+	var payload any
+	switch s.Platform {
+	case chatPlatformTeams:
+		payload = map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"themeColor": "D70000",
+			"summary":    title,
+			"title":      title,
+			"text":       strings.ReplaceAll(text, "\n", "<br>"),
+		}
+	default:
+		payload = map[string]any{
+			"text": fmt.Sprintf("*%s*\n%s", title, text),
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return s.Policy.Do(func() (bool, error) {
+		return s.post(client, body)
+	})
+}
+
+// post sends the message once and reports whether a failed request should be retried
+func (s *ChatSink) post(client *http.Client, body []byte) (bool, error) {
+	response, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("%s webhook responded with status %d: %s", s.Platform, response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
+
+// allow is a token bucket refilled with RatePerMinute tokens per minute.
+// It returns the number of messages suppressed since the last allowed message.
+func (s *ChatSink) allow() (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.RatePerMinute <= 0 {
+		return 0, true
+	}
+
+	now := clockNow()
+	if s.lastRefill.IsZero() {
+		s.tokens = float64(s.RatePerMinute)
+	} else {
+		s.tokens += now.Sub(s.lastRefill).Minutes() * float64(s.RatePerMinute)
+		if s.tokens > float64(s.RatePerMinute) {
+			s.tokens = float64(s.RatePerMinute)
+		}
+	}
+	s.lastRefill = now
+
+	if s.tokens < 1 {
+		s.suppressed++
+		return 0, false
+	}
+
+	s.tokens--
+	suppressed := s.suppressed
+	s.suppressed = 0
+
+	return suppressed, true
+}
+
+func (s *ChatSink) summary(event Event, suppressed int) (string, string) {
+	title := fmt.Sprintf("Error in %s", event.Transaction)
+
+	message := event.Message
+	if len(message) > chatMessageLimit {
+		message = message[:chatMessageLimit] + "…"
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("Transaction: ")
+	builder.WriteString(event.Transaction)
+	builder.WriteString("\n")
+	if len(event.Segment) > 0 {
+		builder.WriteString("Segment: ")
+		builder.WriteString(event.Segment)
+		builder.WriteString("\n")
+	}
+	if len(event.TraceID) > 0 {
+		builder.WriteString("Trace: ")
+		if len(s.TraceURL) > 0 {
+			builder.WriteString(strings.ReplaceAll(s.TraceURL, "{traceID}", event.TraceID))
+		} else {
+			builder.WriteString(event.TraceID)
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("Message: ")
+	builder.WriteString(message)
+	if suppressed > 0 {
+		builder.WriteString("\n")
+		builder.WriteString(fmt.Sprintf("%d further errors were suppressed by the rate limit", suppressed))
+	}
+
+	return title, builder.String()
+}
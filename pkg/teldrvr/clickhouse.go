@@ -0,0 +1,452 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const clickhouseDriver = "clickhouse"
+
+const clickhouseDefaultTable = "telemetry_events"
+const clickhouseDefaultBatchSize = 1000
+const clickhouseDefaultFlushInterval = 5 * time.Second
+const clickhouseBufferFilePrefix = "clickhouse-"
+const clickhouseBufferFileSuffix = ".ndjson"
+
+// async inserts wait for the flush of the server side buffer, so the requests take longer than usual
+var clickhouseDefaultPolicy = EmitPolicy{
+	ConnectTimeout: DefaultEmitPolicy.ConnectTimeout,
+	RequestTimeout: 30 * time.Second,
+	MaxRetries:     DefaultEmitPolicy.MaxRetries,
+	InitialBackoff: DefaultEmitPolicy.InitialBackoff,
+	MaxBackoff:     DefaultEmitPolicy.MaxBackoff,
+}
+
+// clickhouse expects DateTime64 values without time zone
+const clickhouseTimeFormat = "2006-01-02 15:04:05.000"
+
+// clickhouseConfigKeys are the settings below telemetry.drivers.clickhouse
+var clickhouseConfigKeys = []ConfigKey{
+	{Name: "url", Required: true},
+	{Name: "table", Default: clickhouseDefaultTable},
+	{Name: "user"},
+	{Name: "password", Secret: true},
+	{Name: "batchSize", Kind: ConfigKindInt, Default: clickhouseDefaultBatchSize, Min: 1},
+	{Name: "flushInterval", Kind: ConfigKindDuration, Default: clickhouseDefaultFlushInterval, Min: time.Millisecond},
+	{Name: "bufferDir"},
+	{Name: "bufferMaxBytes", Kind: ConfigKindInt, Min: 0},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(clickhouseDriver, clickhouseConfigKeys...)
+	RegisterDriverConfig(clickhouseDriver, TLSConfigKeys...)
+	RegisterDriverConfig(clickhouseDriver, EmitPolicyConfigKeys...)
+
+	if !driverEnabled(cfg, clickhouseDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, clickhouseDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no events will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(clickhouseDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, clickhouseDriver)
+
+	policy := LoadEmitPolicy(cfg, clickhouseDriver, clickhouseDefaultPolicy)
+	client, err := newHTTPClient(cfg, clickhouseDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no events will be sent: %w", telemetry.TelemetryDriverError, clickhouseDriver, err))
+		registerDriver(clickhouseDriver, NopDriver{})
+		return
+	}
+
+	sink := NewClickHouseSink(driverCfg.GetString("url"), driverCfg.GetString("table"), driverCfg.GetInt("batchSize"),
+		driverCfg.GetDuration("flushInterval"))
+	sink.User = driverCfg.GetString("user")
+	sink.Password = driverCfg.GetString("password")
+	sink.BufferDir = driverCfg.GetString("bufferDir")
+	sink.BufferMaxBytes = driverCfg.GetInt64("bufferMaxBytes")
+	sink.Policy = policy
+	sink.Client = client
+	sink.Start()
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(clickhouseDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(clickhouseDriver, driver)
+}
+
+// ClickHouseSink inserts the events batch wise with async inserts into one wide table using the HTTP interface.
+// If the cluster is unavailable, the batches are written to BufferDir and inserted with the next successful flush.
+type ClickHouseSink struct {
+	URL      string
+	Table    string
+	User     string
+	Password string
+	// BatchSize is the maximum number of events per insert
+	BatchSize int
+	// FlushInterval is the maximum time an event is buffered in memory
+	FlushInterval time.Duration
+	// BufferDir enables the on-disk buffering, if empty failed batches are dropped
+	BufferDir string
+	// BufferMaxBytes limits the size of the on-disk buffer, 0 means unlimited
+	BufferMaxBytes int64
+	Policy         EmitPolicy
+	Client         *http.Client
+
+	mutex  sync.Mutex
+	events []Event
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// clickhouseRow is the JSONEachRow representation of an event
+type clickhouseRow struct {
+	Time         string  `json:"time"`
+	Type         string  `json:"type"`
+	Level        string  `json:"level"`
+	Transaction  string  `json:"transaction"`
+	TraceID      string  `json:"trace_id"`
+	ProcessID    string  `json:"process_id"`
+	SegmentID    string  `json:"segment_id"`
+	Segment      string  `json:"segment"`
+	Message      string  `json:"message"`
+	ErrorGroup   string  `json:"error_group"`
+	MetricName   string  `json:"metric_name"`
+	MetricValue  float64 `json:"metric_value"`
+	DurationMs   int64   `json:"duration_ms"`
+	SegmentCount int     `json:"segment_count"`
+	ErrorCount   int     `json:"error_count"`
+	Attributes   string  `json:"attributes"`
+}
+
+// NewClickHouseSink creates a ClickHouseSink, Start has to be called to enable the periodic flush
+func NewClickHouseSink(url string, table string, batchSize int, flushInterval time.Duration) *ClickHouseSink {
+	if len(table) == 0 {
+		table = clickhouseDefaultTable
+	}
+
+	if batchSize < 1 {
+		batchSize = clickhouseDefaultBatchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = clickhouseDefaultFlushInterval
+	}
+
+	return &ClickHouseSink{
+		URL:           url,
+		Table:         table,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		Policy:        clickhouseDefaultPolicy,
+		Client:        &http.Client{Timeout: clickhouseDefaultPolicy.RequestTimeout},
+		events:        make([]Event, 0, batchSize),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush in a background goroutine, the flush timer is taken from the clock of the drivers
+func (s *ClickHouseSink) Start() {
+	ticker := CurrentClock().NewTicker(s.FlushInterval)
+
+	s.wg.Add(1)
+	go s.run(ticker)
+}
+
+func (s *ClickHouseSink) run(ticker Ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			err := s.Flush()
+			if err != nil {
+				handleError(fmt.Errorf("%s%s could not flush events: %w", telemetry.TelemetryDriverError, clickhouseDriver, err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Emit buffers the event and flushes the buffer if the batch is full
+func (s *ClickHouseSink) Emit(event Event) error {
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= s.BatchSize
+	s.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return s.Flush()
+}
+
+// Flush inserts the buffered events after all batches of the on-disk buffer
+func (s *ClickHouseSink) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var body []byte
+	if len(s.events) > 0 {
+		var err error
+		body, err = encodeClickHouseRows(s.events)
+		s.events = make([]Event, 0, s.BatchSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.replayBuffer()
+	if err != nil {
+		return s.bufferOrDrop(body, err)
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	err = s.insert(body)
+	if err != nil {
+		return s.bufferOrDrop(body, err)
+	}
+
+	return nil
+}
+
+// encodeClickHouseRows converts the events into newline delimited JSON
+func encodeClickHouseRows(events []Event) ([]byte, error) {
+	body := bytes.Buffer{}
+	encoder := json.NewEncoder(&body)
+
+	for _, event := range events {
+		// the outcome has no column, it is stored with the attributes of the transaction end
+		eventAttributes := event.Attributes
+		if len(event.Outcome) > 0 {
+			eventAttributes = make(map[string]any, len(event.Attributes)+1)
+			for key, value := range event.Attributes {
+				eventAttributes[key] = value
+			}
+			eventAttributes[OutcomeAttribute] = event.Outcome
+		}
+
+		attributes, err := json.Marshal(eventAttributes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = encoder.Encode(clickhouseRow{
+			Time:         event.Time.UTC().Format(clickhouseTimeFormat),
+			Type:         event.Type,
+			Level:        event.Level,
+			Transaction:  event.Transaction,
+			TraceID:      event.TraceID,
+			ProcessID:    event.ProcessID,
+			SegmentID:    event.SegmentID,
+			Segment:      event.Segment,
+			Message:      event.Message,
+			ErrorGroup:   event.ErrorGroup,
+			MetricName:   event.MetricName,
+			MetricValue:  event.MetricValue,
+			DurationMs:   event.Duration.Milliseconds(),
+			SegmentCount: event.SegmentCount,
+			ErrorCount:   event.ErrorCount,
+			Attributes:   string(attributes),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body.Bytes(), nil
+}
+
+// insert sends one batch as async insert and retries according to the policy
+func (s *ClickHouseSink) insert(body []byte) error {
+	return s.Policy.Do(func() (bool, error) {
+		return s.insertOnce(body)
+	})
+}
+
+// insertOnce sends the batch once and reports whether a failed insert should be retried
+func (s *ClickHouseSink) insertOnce(body []byte) (bool, error) {
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.Table))
+	query.Set("async_insert", "1")
+	query.Set("wait_for_async_insert", "1")
+
+	separator := "?"
+	if strings.Contains(s.URL, "?") {
+		separator = "&"
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.URL+separator+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+
+	if len(s.User) > 0 {
+		request.SetBasicAuth(s.User, s.Password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("clickhouse responded with status %d: %s", response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
+
+// bufferOrDrop writes the batch to the on-disk buffer, if the buffer is disabled or full the batch is dropped
+// - Expects the mutex to be locked -
+func (s *ClickHouseSink) bufferOrDrop(body []byte, insertErr error) error {
+	if len(body) == 0 {
+		return insertErr
+	}
+
+	if len(s.BufferDir) == 0 {
+		return fmt.Errorf("batch dropped: %w", insertErr)
+	}
+
+	if s.BufferMaxBytes > 0 {
+		size, err := s.bufferSize()
+		if err != nil {
+			return errors.Join(insertErr, err)
+		}
+
+		if size+int64(len(body)) > s.BufferMaxBytes {
+			return fmt.Errorf("on-disk buffer is full, batch dropped: %w", insertErr)
+		}
+	}
+
+	err := os.MkdirAll(s.BufferDir, 0o750)
+	if err != nil {
+		return errors.Join(insertErr, err)
+	}
+
+	name := fmt.Sprintf("%s%d%s", clickhouseBufferFilePrefix, clockNow().UnixNano(), clickhouseBufferFileSuffix)
+	err = os.WriteFile(filepath.Join(s.BufferDir, name), body, 0o640)
+	if err != nil {
+		return errors.Join(insertErr, err)
+	}
+
+	log.Printf("%s%s is unavailable, batch buffered on disk: %v", telemetry.TelemetryDriverError, clickhouseDriver, insertErr)
+
+	return nil
+}
+
+// bufferFiles returns the buffered batches, oldest first
+// - Expects the mutex to be locked -
+func (s *ClickHouseSink) bufferFiles() ([]string, error) {
+	if len(s.BufferDir) == 0 {
+		return nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.BufferDir, clickhouseBufferFilePrefix+"*"+clickhouseBufferFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// bufferSize returns the size of all buffered batches
+// - Expects the mutex to be locked -
+func (s *ClickHouseSink) bufferSize() (int64, error) {
+	files, err := s.bufferFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// replayBuffer inserts the buffered batches and removes them, it stops at the first failed insert
+// - Expects the mutex to be locked -
+func (s *ClickHouseSink) replayBuffer() error {
+	files, err := s.bufferFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		err = s.insert(body)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close stops the background goroutine and flushes the buffered events
+func (s *ClickHouseSink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	return s.Flush()
+}
@@ -0,0 +1,185 @@
+package teldrvr
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of the timestamps, durations and flush timers of all drivers
+type Clock interface {
+	Now() time.Time
+	NewTicker(interval time.Duration) Ticker
+}
+
+// Ticker delivers ticks in the interval of the ticker like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the default clock based on the time package
+type SystemClock struct{}
+
+// Now returns the current local time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a time.Ticker
+func (SystemClock) NewTicker(interval time.Duration) Ticker {
+	return systemTicker{time.NewTicker(interval)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+var clock = struct {
+	clock Clock
+	mutex sync.RWMutex
+}{
+	clock: SystemClock{},
+}
+
+// SetClock replaces the clock of all drivers, e.g. with a FakeClock for golden file tests. nil restores the SystemClock.
+// Sinks started before read their flush timers from the previous clock, so they have to be created afterwards.
+func SetClock(c Clock) {
+	if c == nil {
+		c = SystemClock{}
+	}
+
+	clock.mutex.Lock()
+	clock.clock = c
+	clock.mutex.Unlock()
+}
+
+// CurrentClock returns the clock set by SetClock, for drivers outside of this package
+func CurrentClock() Clock {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+
+	return clock.clock
+}
+
+// clockNow returns the current time of the clock
+func clockNow() time.Time {
+	return CurrentClock().Now()
+}
+
+// clockSince returns the time elapsed since start on the clock
+func clockSince(start time.Time) time.Duration {
+	return clockNow().Sub(start)
+}
+
+// FakeClock is a Clock that only moves on Advance or Set, so the output of the drivers is deterministic
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock creates a FakeClock starting at the given time
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// NewTicker returns a ticker that ticks when the clock is advanced past its next tick
+func (c *FakeClock) NewTicker(interval time.Duration) Ticker {
+	if interval <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ticker := &fakeTicker{
+		clock:    c,
+		interval: interval,
+		next:     c.now.Add(interval),
+		channel:  make(chan time.Time, 1),
+	}
+	c.tickers = append(c.tickers, ticker)
+
+	return ticker
+}
+
+// Advance moves the clock forward and delivers the due ticks in order.
+// Like time.Ticker a ticker drops ticks if the previous tick was not received yet.
+func (c *FakeClock) Advance(duration time.Duration) {
+	c.Set(c.Now().Add(duration))
+}
+
+// Set moves the clock to the given time, see Advance
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for {
+		var due []*fakeTicker
+		for _, ticker := range c.tickers {
+			if !ticker.next.After(now) {
+				due = append(due, ticker)
+			}
+		}
+
+		if len(due) == 0 {
+			break
+		}
+
+		sort.SliceStable(due, func(i, j int) bool {
+			return due[i].next.Before(due[j].next)
+		})
+
+		ticker := due[0]
+		c.now = ticker.next
+		ticker.next = ticker.next.Add(ticker.interval)
+
+		select {
+		case ticker.channel <- c.now:
+		default:
+		}
+	}
+
+	c.now = now
+}
+
+type fakeTicker struct {
+	clock    *FakeClock
+	interval time.Duration
+	next     time.Time
+	channel  chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.channel
+}
+
+// Stop removes the ticker from the clock, the channel is not closed like the one of time.Ticker
+func (t *fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	for i, ticker := range t.clock.tickers {
+		if ticker == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
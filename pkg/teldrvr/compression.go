@@ -0,0 +1,141 @@
+package teldrvr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionNone = "none"
+const compressionGzip = "gzip"
+const compressionZstd = "zstd"
+
+// compressionValues are the allowed values of the compression settings
+var compressionValues = []string{compressionNone, compressionGzip, compressionZstd}
+
+// validateCompression checks that the compression is supported, an empty value is the same as none
+func validateCompression(compression string) error {
+	switch compression {
+	case "", compressionNone, compressionGzip, compressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("unknown compression »%s«, supported are %s, %s and %s", compression, compressionNone, compressionGzip, compressionZstd)
+	}
+}
+
+// compressionEnabled reports whether the data has to be compressed
+func compressionEnabled(compression string) bool {
+	return compression == compressionGzip || compression == compressionZstd
+}
+
+// compressionExtension returns the file extension of the compression, e.g. ".gz"
+func compressionExtension(compression string) string {
+	switch compression {
+	case compressionGzip:
+		return ".gz"
+	case compressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// newCompressionWriter wraps the writer with the compression, Close has to be called to write the trailer
+func newCompressionWriter(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	case compressionZstd:
+		return zstd.NewWriter(w)
+	case "", compressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, validateCompression(compression)
+	}
+}
+
+// compress returns the compressed data, without compression the data is returned unchanged
+func compress(compression string, data []byte) ([]byte, error) {
+	if !compressionEnabled(compression) {
+		return data, validateCompression(compression)
+	}
+
+	compressed := bytes.Buffer{}
+	writer, err := newCompressionWriter(compression, &compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// flushWriter is implemented by the gzip and zstd writers
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// streamCompressionWriter compresses a long living stream, e.g. a log file.
+// Every write is flushed, so the written data can be decompressed even if the process ends without Close.
+type streamCompressionWriter struct {
+	writer flushWriter
+	mutex  sync.Mutex
+}
+
+// newStreamCompressionWriter wraps the writer with the compression
+func newStreamCompressionWriter(compression string, w io.Writer) (io.WriteCloser, error) {
+	writer, err := newCompressionWriter(compression, w)
+	if err != nil {
+		return nil, err
+	}
+
+	flusher, ok := writer.(flushWriter)
+	if !ok {
+		return writer, nil
+	}
+
+	return &streamCompressionWriter{writer: flusher}, nil
+}
+
+// Write compresses and flushes the data
+func (w *streamCompressionWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	n, err := w.writer.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.writer.Flush()
+}
+
+// Close writes the trailer of the compression
+func (w *streamCompressionWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.writer.Close()
+}
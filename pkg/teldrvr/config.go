@@ -1,8 +1,16 @@
 package teldrvr
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
@@ -12,43 +20,285 @@ const logLevelInfo = "info"
 
 var logLevel = logLevelError
 
+// configName is the name of the config file without extension, e.g. config.yaml
+const configName = "config"
+
+// configPathEnv lists additional directories searched for the config file, separated by the OS path list separator.
+// They are searched before the working directory.
+const configPathEnv = "TELEMETRY_CONFIG_PATH"
+
+// configEnvEnv is the environment whose file, e.g. config.production.yaml, overlays the config file
+const configEnvEnv = "TELEMETRY_ENV"
+
+// envPrefixEnv is the prefix of the env variables of the service, e.g. MYSERVICE for MYSERVICE_TELEMETRY_DRIVER
+const envPrefixEnv = "TELEMETRY_ENV_PREFIX"
+
+// telemetryEnvPrefix is the prefix of the env variables of this package that are replaced by the prefixed variables
+const telemetryEnvPrefix = "TELEMETRY_"
+
+// settingsConfigKeys are the settings outside of the driver namespaces, the names are relative to telemetry
+var settingsConfigKeys = []ConfigKey{
+	{Name: "logLevel", Values: []string{logLevelDebug, logLevelInfo, logLevelError}},
+	{Name: "levelBoost.level", Values: []string{logLevelDebug, logLevelInfo, logLevelError}},
+	{Name: "levelBoost.from", Validate: validateLevelBoostTime},
+	{Name: "levelBoost.until", Validate: validateLevelBoostTime},
+	{Name: "caller.enabled", Kind: ConfigKindBool},
+	{Name: "diagnostics.log", Kind: ConfigKindBool},
+	{Name: "secrets.cacheTTL", Kind: ConfigKindDuration, Min: 0},
+	{Name: "runtimeMetrics.interval", Kind: ConfigKindDuration, Min: time.Millisecond},
+	{Name: "tailSampling.threshold", Kind: ConfigKindDuration, Min: 0},
+	{Name: "tailSampling.maxMessages", Kind: ConfigKindInt, Min: 0},
+	{Name: "attributeCoercion.mode", Values: attributeCoercionModes},
+	{Name: "attributeLimits.maxTransactionAttributes", Kind: ConfigKindInt, Min: 0},
+	{Name: "attributeLimits.maxSegmentAttributes", Kind: ConfigKindInt, Min: 0},
+	{Name: "attributeLimits.maxValueLength", Kind: ConfigKindInt, Min: 0},
+	{Name: "attributePrefix.prefix"},
+	{Name: "nameNormalization.lowercase", Kind: ConfigKindBool},
+	{Name: "nameNormalization.maxLength", Kind: ConfigKindInt, Min: 0},
+	{Name: "segmentTemplates.enabled", Kind: ConfigKindBool},
+	{Name: "errorBudget.errorRate", Validate: validateErrorRate},
+	{Name: "errorBudget.window", Kind: ConfigKindDuration, Min: time.Second},
+	{Name: "errorBudget.minTransactions", Kind: ConfigKindInt, Min: 1},
+	{Name: "segmentLeaks.detect", Kind: ConfigKindBool},
+	{Name: "segmentLeaks.autoClose", Kind: ConfigKindBool},
+	{Name: "segmentLifecycle", Values: []string{segmentLifecycleStrict, segmentLifecycleLenient}},
+	{Name: "idFormat", Values: idFormats},
+	{Name: "middlewares"},
+	{Name: "timestamp.field"},
+	{Name: "timestamp.format", Values: timestampFormats},
+	{Name: "timestamp.timezone", Validate: validateTimezone},
+}
+
 // Config contains and provides the configuration that is required at runtime
 type Config interface {
+	Get(string) any
 	GetString(string) string
 	GetInt(string) int
 	GetInt64(string) int64
 	GetBool(string) bool
+	GetStringSlice(string) []string
+	GetStringMapString(string) map[string]string
+	GetDuration(string) time.Duration
+	IsSet(string) bool
 }
 
-// GetConfig returns the configuration
+// GetConfig returns the configuration.
+// The config file is searched in the directories of TELEMETRY_CONFIG_PATH and the working directory,
+// the file of the environment in TELEMETRY_ENV, e.g. config.production.yaml, is merged into it.
 func GetConfig() (Config, error) {
+	envPrefix := os.Getenv(envPrefixEnv)
 
-	// defining that we want to read config from the file named "app" in the provided directory
-	viper.SetConfigName("config")
+	for _, path := range filepath.SplitList(getEnv(envPrefix, configPathEnv)) {
+		if len(path) > 0 {
+			viper.AddConfigPath(path)
+		}
+	}
 	viper.AddConfigPath(".")
 
+	// all keys are read from env variables named like the key, e.g. MYSERVICE_TELEMETRY_DRIVERS_WEBHOOK_URL.
+	// They win over the variables bound below.
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
 	// settigs
-	viper.BindEnv("telemetry.driver", "TELEMETRY_DRIVER")
-	viper.BindEnv("telemetry.app", "TELEMETRY_APP")
-	viper.BindEnv("telemetry.logLevel", "TELEMETRY_LOGLEVEL")
+	bindEnv(envPrefix, "telemetry.driver", "TELEMETRY_DRIVER")
+	bindEnv(envPrefix, "telemetry.app", "TELEMETRY_APP")
+	bindEnv(envPrefix, "telemetry.logLevel", "TELEMETRY_LOGLEVEL")
+	bindEnv(envPrefix, "telemetry.levelBoost.level", "TELEMETRY_LEVELBOOST_LEVEL")
+	bindEnv(envPrefix, "telemetry.levelBoost.from", "TELEMETRY_LEVELBOOST_FROM")
+	bindEnv(envPrefix, "telemetry.levelBoost.until", "TELEMETRY_LEVELBOOST_UNTIL")
+	bindEnv(envPrefix, "telemetry.external", "TELEMETRY_EXTERNAL")
+	bindEnv(envPrefix, "telemetry.caller.enabled", "TELEMETRY_CALLER_ENABLED")
+	bindEnv(envPrefix, "telemetry.idFormat", "TELEMETRY_IDFORMAT")
+	bindEnv(envPrefix, "telemetry.middlewares", "TELEMETRY_MIDDLEWARES")
+	bindEnv(envPrefix, "telemetry.attributePrefix.prefix", "TELEMETRY_ATTRIBUTEPREFIX_PREFIX")
+	bindEnv(envPrefix, "telemetry.drivers.local.format", "TELEMETRY_LOCAL_FORMAT")
+	bindEnv(envPrefix, "telemetry.drivers.local.output", "TELEMETRY_LOCAL_OUTPUT")
+	bindEnv(envPrefix, "telemetry.drivers.zerolog.fieldProfile", "TELEMETRY_ZEROLOG_FIELDPROFILE")
+	bindEnv(envPrefix, "telemetry.datadog.env", "DD_ENV")
+	bindEnv(envPrefix, "telemetry.datadog.version", "DD_VERSION")
 
 	// specifics
-	viper.BindEnv("telemetry.newrelic.licenceKey", "NEW_RELIC_LICENSE_KEY")
+	bindEnv(envPrefix, "telemetry.drivers.pagerduty.routingKey", "PAGERDUTY_ROUTING_KEY")
+	bindEnv(envPrefix, "telemetry.drivers.chat.url", "TELEMETRY_CHAT_URL")
+	bindEnv(envPrefix, "telemetry.drivers.webhook.url", "TELEMETRY_WEBHOOK_URL")
+	bindEnv(envPrefix, "telemetry.drivers.webhook.secret", "TELEMETRY_WEBHOOK_SECRET")
+	bindEnv(envPrefix, "telemetry.drivers.clickhouse.url", "TELEMETRY_CLICKHOUSE_URL")
+	bindEnv(envPrefix, "telemetry.drivers.loki.url", "TELEMETRY_LOKI_URL")
+	bindEnv(envPrefix, "telemetry.drivers.loki.password", "TELEMETRY_LOKI_PASSWORD")
+	bindEnv(envPrefix, "telemetry.drivers.sentry.dsn", "SENTRY_DSN")
+	bindEnv(envPrefix, "telemetry.drivers.sentry.environment", "SENTRY_ENVIRONMENT")
+	bindEnv(envPrefix, "telemetry.drivers.sentry.release", "SENTRY_RELEASE")
+	bindEnv(envPrefix, "telemetry.drivers.clickhouse.password", "TELEMETRY_CLICKHOUSE_PASSWORD")
+	bindEnv(envPrefix, "telemetry.drivers.s3.bucket", "TELEMETRY_S3_BUCKET")
+	bindEnv(envPrefix, "telemetry.drivers.s3.region", "AWS_REGION")
+	bindEnv(envPrefix, "telemetry.drivers.s3.endpoint", "AWS_ENDPOINT_URL_S3")
+	bindEnv(envPrefix, "telemetry.drivers.s3.accessKeyID", "AWS_ACCESS_KEY_ID")
+	bindEnv(envPrefix, "telemetry.drivers.s3.secretAccessKey", "AWS_SECRET_ACCESS_KEY")
+	bindEnv(envPrefix, "telemetry.drivers.s3.sessionToken", "AWS_SESSION_TOKEN")
+	bindEnv(envPrefix, "telemetry.drivers.nats.url", "NATS_URL")
+	bindEnv(envPrefix, "telemetry.drivers.amqp.url", "TELEMETRY_AMQP_URL")
+	bindEnv(envPrefix, "telemetry.drivers.postgres.dsn", "TELEMETRY_POSTGRES_DSN")
+	bindEnv(envPrefix, "telemetry.drivers.newrelic.licenceKey", "NEW_RELIC_LICENSE_KEY")
+	bindEnv(envPrefix, "telemetry.drivers.newrelic.region", "NEW_RELIC_REGION")
+	bindEnv(envPrefix, "telemetry.drivers.newrelic.host", "NEW_RELIC_HOST")
+	bindEnv(envPrefix, "telemetry.drivers.newrelic.hostDisplayName", "NEW_RELIC_PROCESS_HOST_DISPLAY_NAME")
+	bindEnv(envPrefix, "telemetry.drivers.newrelic.infiniteTracing.host", "NEW_RELIC_INFINITE_TRACING_TRACE_OBSERVER_HOST")
+	bindEnv(envPrefix, "telemetry.secrets.vault.address", "VAULT_ADDR")
+	bindEnv(envPrefix, "telemetry.secrets.vault.token", "VAULT_TOKEN")
+	bindEnv(envPrefix, "telemetry.secrets.vault.namespace", "VAULT_NAMESPACE")
+	bindEnv(envPrefix, "telemetry.secrets.vault.caFile", "VAULT_CACERT")
+	bindEnv(envPrefix, "telemetry.secrets.aws.region", "AWS_REGION")
+	bindEnv(envPrefix, "telemetry.secrets.aws.accessKeyID", "AWS_ACCESS_KEY_ID")
+	bindEnv(envPrefix, "telemetry.secrets.aws.secretAccessKey", "AWS_SECRET_ACCESS_KEY")
+	bindEnv(envPrefix, "telemetry.secrets.aws.sessionToken", "AWS_SESSION_TOKEN")
+	bindEnv(envPrefix, "telemetry.secrets.aws.containerCredentialsRelativeURI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	bindEnv(envPrefix, "telemetry.secrets.aws.containerCredentialsFullURI", "AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	bindEnv(envPrefix, "telemetry.secrets.aws.containerAuthorizationToken", "AWS_CONTAINER_AUTHORIZATION_TOKEN")
 
-	// Defaults
+	// Defaults, the defaults of the driver settings are registered with RegisterDriverConfig
 	viper.SetDefault("telemetry.logLevel", "error")
 
 	viper.AutomaticEnv()
 
 	// read in a config file if one exists
-	viper.ReadInConfig()
+	viper.SetConfigName(configName)
+	err := viper.ReadInConfig()
 
 	configFileUsed := viper.ConfigFileUsed()
-	if len(configFileUsed) == 0 {
+	switch {
+	case errors.As(err, &viper.ConfigFileNotFoundError{}):
 		log.Println("no configuration file found")
-	} else {
+	case err != nil:
+		log.Printf("configuration file could not be read: %s\n", err.Error())
+	default:
 		log.Printf("configuration file »%s« used\n", configFileUsed)
 	}
 
+	env := getEnv(envPrefix, configEnvEnv)
+	if len(env) == 0 {
+		return viper.GetViper(), nil
+	}
+
+	// maps are merged key by key, all other values of the environment file replace the values of the config file
+	viper.SetConfigName(configName + "." + env)
+	err = viper.MergeInConfig()
+	switch {
+	case errors.As(err, &viper.ConfigFileNotFoundError{}):
+		log.Printf("no configuration file for environment »%s« found\n", env)
+	case err != nil:
+		log.Printf("configuration file of environment »%s« could not be read: %s\n", env, err.Error())
+	default:
+		log.Printf("configuration file »%s« of environment »%s« used\n", viper.ConfigFileUsed(), env)
+	}
+
 	return viper.GetViper(), nil
 }
+
+// validateSettings checks the settings outside of the driver namespaces and returns every problem.
+// Invalid values are reported as *ConfigError without driver, unknown shadow and canary drivers as *UnknownDriverError.
+func validateSettings(cfg Config) []error {
+	var errs []error
+	configError := &ConfigError{}
+
+	for _, key := range settingsConfigKeys {
+		fullKey := legacyConfigPrefix + key.Name
+		if !cfg.IsSet(fullKey) {
+			continue
+		}
+
+		reason := validateConfigValue(key, cfg.Get(fullKey))
+		if len(reason) > 0 {
+			configError.Issues = append(configError.Issues, ConfigIssue{Key: fullKey, Reason: reason})
+		}
+	}
+
+	_, levelOverrideIssues := readLevelOverrides(cfg)
+	configError.Issues = append(configError.Issues, levelOverrideIssues...)
+
+	_, nameNormalizationIssues := readNameNormalization(cfg)
+	configError.Issues = append(configError.Issues, nameNormalizationIssues...)
+
+	_, logMetricIssues := readLogMetricRules(cfg)
+	configError.Issues = append(configError.Issues, logMetricIssues...)
+
+	for _, middleware := range middlewareNames(cfg) {
+		if _, ok := middlewareFactory(middleware); !ok {
+			configError.Issues = append(configError.Issues, ConfigIssue{Key: middlewaresConfigKey, Reason: fmt.Sprintf("middleware »%s« is not registered", middleware)})
+		}
+	}
+
+	for primary, shadow := range cfg.GetStringMapString(shadowConfigKey) {
+		shadow = strings.TrimSpace(shadow)
+		if len(shadow) > 0 && !knownDriver(shadow) {
+			errs = append(errs, &UnknownDriverError{Key: shadowConfigKey + "." + primary, Driver: shadow})
+		}
+	}
+
+	for _, driver := range splitDriverList(cfg.GetString(tailSamplingConfigKey + ".drivers")) {
+		if !knownDriver(driver) {
+			errs = append(errs, &UnknownDriverError{Key: tailSamplingConfigKey + ".drivers", Driver: driver})
+		}
+	}
+
+	for primary := range cast.ToStringMap(cfg.Get(canaryConfigKey)) {
+		canary, _, err := canaryFor(cfg, primary)
+		if err != nil {
+			configError.Issues = append(configError.Issues, ConfigIssue{Key: canaryConfigKey + "." + primary + ".percent", Reason: "has to be a number between 0 and 100"})
+			continue
+		}
+
+		if len(canary) > 0 && !knownDriver(canary) {
+			errs = append(errs, &UnknownDriverError{Key: canaryConfigKey + "." + primary + ".driver", Driver: canary})
+		}
+	}
+
+	if len(configError.Issues) > 0 {
+		errs = append([]error{configError}, errs...)
+	}
+
+	return errs
+}
+
+// configuredLogLevel returns telemetry.logLevel, an unknown level is reported and replaced by the error level
+func configuredLogLevel(cfg Config) string {
+	configLogLevel := cfg.GetString("telemetry.logLevel")
+	switch configLogLevel {
+	case logLevelDebug, logLevelInfo, logLevelError:
+		return configLogLevel
+	}
+
+	handleError(fmt.Errorf("%stelemetry.logLevel »%s« has to be one of %s, %s, %s, falling back to %s",
+		telemetry.TelemetryDriverError, configLogLevel, logLevelDebug, logLevelInfo, logLevelError, logLevelError))
+
+	return logLevelError
+}
+
+// bindEnv binds the key to the env variables. With an env prefix the TELEMETRY_* variables are replaced by the prefixed
+// variable of the key, so a service is not affected by the variables of another service on the same host.
+// Variables of vendors like AWS_REGION are still bound.
+func bindEnv(envPrefix string, key string, envs ...string) {
+	names := []string{key}
+	for _, env := range envs {
+		if len(envPrefix) > 0 && strings.HasPrefix(env, telemetryEnvPrefix) {
+			env = prefixedEnv(envPrefix, key)
+		}
+		names = append(names, env)
+	}
+
+	viper.BindEnv(names...)
+}
+
+// getEnv returns the env variable, with an env prefix the prefixed variable, e.g. MYSERVICE_TELEMETRY_ENV
+func getEnv(envPrefix string, name string) string {
+	if len(envPrefix) > 0 {
+		name = strings.ToUpper(envPrefix) + "_" + name
+	}
+
+	return os.Getenv(name)
+}
+
+// prefixedEnv returns the env variable of the key, e.g. MYSERVICE_TELEMETRY_DRIVERS_WEBHOOK_URL
+func prefixedEnv(envPrefix string, key string) string {
+	return strings.ToUpper(envPrefix + "_" + strings.ReplaceAll(key, ".", "_"))
+}
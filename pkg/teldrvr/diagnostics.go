@@ -0,0 +1,252 @@
+package teldrvr
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// diagnosticsLogConfigKey enables logging the diagnostics report when the first transaction is started
+const diagnosticsLogConfigKey = "telemetry.diagnostics.log"
+
+const maskedValue = "********"
+
+// DiagnosticsReport describes the state of the telemetry drivers to make misconfiguration obvious
+type DiagnosticsReport struct {
+	LogLevel string
+	// LogLevelBoost is the level of the active level boost, empty without boost
+	LogLevelBoost string
+	Drivers       []DriverDiagnostics
+	// Config holds the resolved telemetry settings including the driver defaults, secrets are masked
+	Config map[string]any
+}
+
+// DriverDiagnostics describes a registered driver
+type DriverDiagnostics struct {
+	Name string
+	// Active drivers are selected by telemetry.driver
+	Active bool
+	// Type is the go type of the driver, e.g. teldrvr.NopDriver for a driver disabled because of an invalid config
+	Type string
+	// Status is reported by drivers with a backend connection, e.g. the New Relic drivers
+	Status        string
+	QueueLength   int
+	QueueCapacity int
+	Shadow        string
+	Canary        string
+	CanaryPercent float64
+	TailSampling  bool
+	// AttributeLimits are the enforced limits, nil if the attributes are not limited
+	AttributeLimits *AttributeLimits
+}
+
+// statusReporter is implemented by drivers that are able to report the state of their backend connection
+type statusReporter interface {
+	diagnosticsStatus() string
+}
+
+// Diagnostics returns the report of all registered drivers and the resolved configuration
+func Diagnostics() DiagnosticsReport {
+	cfg := viper.GetViper()
+
+	report := DiagnosticsReport{
+		LogLevel:      logLevel,
+		LogLevelBoost: boostedLogLevel(),
+		Config:        diagnosticsConfig(cfg),
+	}
+
+	for _, name := range RegisteredDrivers() {
+		registry.mutex.RLock()
+		driver, ok := registry.drivers[name]
+		registry.mutex.RUnlock()
+
+		if !ok {
+			continue
+		}
+
+		diagnostics := DriverDiagnostics{
+			Name:   name,
+			Active: IsDriverActive(name),
+		}
+		diagnoseDriver(driver, &diagnostics)
+
+		report.Drivers = append(report.Drivers, diagnostics)
+	}
+
+	return report
+}
+
+// diagnoseDriver fills the diagnostics of the driver, wrapping drivers are resolved to the wrapped driver
+func diagnoseDriver(driver telemetry.Driver, diagnostics *DriverDiagnostics) {
+	switch d := driver.(type) {
+	case ShadowDriver:
+		diagnostics.Shadow = d.Shadow
+		diagnoseDriver(d.Primary, diagnostics)
+		return
+	case CanaryDriver:
+		diagnostics.Canary = d.Canary
+		diagnostics.CanaryPercent = d.Percent
+		diagnoseDriver(d.Stable, diagnostics)
+		return
+	case TailSamplingDriver:
+		diagnostics.TailSampling = true
+		diagnoseDriver(d.Driver, diagnostics)
+		return
+	case AttributeLimitDriver:
+		limits := d.Limits
+		diagnostics.AttributeLimits = &limits
+		diagnoseDriver(d.Driver, diagnostics)
+		return
+	case DriverUnwrapper:
+		diagnoseDriver(d.Unwrap(), diagnostics)
+		return
+	case EventDriver:
+		if sink, ok := d.Sink.(*AsyncSink); ok {
+			diagnostics.QueueLength, diagnostics.QueueCapacity = sink.Queue()
+		}
+	case NopDriver:
+		diagnostics.Status = "disabled"
+	}
+
+	diagnostics.Type = fmt.Sprintf("%T", driver)
+
+	if reporter, ok := driver.(statusReporter); ok {
+		diagnostics.Status = reporter.diagnosticsStatus()
+	}
+}
+
+// diagnosticsConfig returns the telemetry settings, the driver settings are resolved with their registered defaults
+func diagnosticsConfig(cfg Config) map[string]any {
+	settings := make(map[string]any)
+
+	driverSchemas.mutex.RLock()
+	schemas := make(map[string][]ConfigKey, len(driverSchemas.schemas))
+	for name, schema := range driverSchemas.schemas {
+		for _, key := range schema {
+			schemas[name] = append(schemas[name], key)
+		}
+	}
+	driverSchemas.mutex.RUnlock()
+
+	// viper lowercases all keys, the driver namespaces are reported with the schema below
+	namespaces := map[string]bool{"drivers": true}
+	for name := range schemas {
+		namespaces[strings.ToLower(name)] = true
+	}
+
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, legacyConfigPrefix) {
+			continue
+		}
+
+		namespace, _, _ := strings.Cut(strings.TrimPrefix(key, legacyConfigPrefix), ".")
+		if namespaces[namespace] {
+			continue
+		}
+
+		settings[key] = maskConfigValue(cfg.Get(key), false)
+	}
+
+	for name, keys := range schemas {
+		config := driverConfig{root: cfg, name: name}
+
+		configured := IsDriverRegistered(name)
+		for _, key := range keys {
+			if _, ok := config.resolve(key.Name); ok {
+				configured = true
+				break
+			}
+		}
+
+		// settings of drivers that are neither registered nor configured are only noise
+		if !configured {
+			continue
+		}
+
+		for _, key := range keys {
+			settings[driverConfigPrefix+name+"."+key.Name] = maskConfigValue(config.Get(key.Name), key.Secret)
+		}
+	}
+
+	return settings
+}
+
+// maskConfigValue masks secrets and the passwords of URLs
+func maskConfigValue(value any, secret bool) any {
+	if secret {
+		if value == nil || (len(cast.ToString(value)) == 0 && len(cast.ToStringMap(value)) == 0) {
+			return value
+		}
+
+		return maskedValue
+	}
+
+	stringValue, ok := value.(string)
+	if !ok || !strings.Contains(stringValue, "@") {
+		return value
+	}
+
+	parsed, err := url.Parse(stringValue)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+
+	return parsed.Redacted()
+}
+
+// String formats the report for the log
+func (r DiagnosticsReport) String() string {
+	lines := []string{fmt.Sprintf("telemetry diagnostics, log level %s", r.LogLevel)}
+
+	for _, driver := range r.Drivers {
+		line := fmt.Sprintf("driver %s: active=%t type=%s", driver.Name, driver.Active, driver.Type)
+		if len(driver.Status) > 0 {
+			line += " status=" + driver.Status
+		}
+		if driver.QueueCapacity > 0 {
+			line += fmt.Sprintf(" queue=%d/%d", driver.QueueLength, driver.QueueCapacity)
+		}
+		if len(driver.Shadow) > 0 {
+			line += " shadow=" + driver.Shadow
+		}
+		if len(driver.Canary) > 0 {
+			line += fmt.Sprintf(" canary=%s(%g%%)", driver.Canary, driver.CanaryPercent)
+		}
+		lines = append(lines, line)
+	}
+
+	keys := make([]string, 0, len(r.Config))
+	for key := range r.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("config %s=%v", key, r.Config[key]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var diagnosticsLogOnce sync.Once
+
+// logDiagnostics logs the report once if telemetry.diagnostics.log is enabled.
+// It is called with the first transaction, at this point all drivers including the external ones are registered.
+func logDiagnostics() {
+	diagnosticsLogOnce.Do(func() {
+		if !viper.GetBool(diagnosticsLogConfigKey) {
+			return
+		}
+
+		for _, line := range strings.Split(Diagnostics().String(), "\n") {
+			log.Println(line)
+		}
+	})
+}
@@ -0,0 +1,494 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+)
+
+// driverConfigPrefix is the namespace of the driver specific settings, e.g. telemetry.drivers.webhook.url
+const driverConfigPrefix = "telemetry.drivers."
+
+// legacyConfigPrefix is the namespace of the flat driver settings, e.g. telemetry.webhook.url.
+// They are still read if the setting is missing in the driver namespace.
+const legacyConfigPrefix = "telemetry."
+
+// kinds of a ConfigKey
+const ConfigKindString = "string"
+const ConfigKindInt = "int"
+const ConfigKindBool = "bool"
+const ConfigKindDuration = "duration"
+const ConfigKindStringSlice = "stringSlice"
+const ConfigKindStringMap = "stringMap"
+
+// ConfigKey describes a single setting of a driver, the name is relative to telemetry.drivers.<driver>
+type ConfigKey struct {
+	Name string
+	// Kind is used to validate the configured value, empty is the same as ConfigKindString
+	Kind     string
+	Default  any
+	Required bool
+	// Values restricts the setting to the listed values
+	Values []string
+	// Min and Max restrict int and duration settings to a range, nil means unrestricted
+	Min any
+	Max any
+	// Secret settings are masked in the diagnostics report
+	Secret bool
+	// Validate checks the format of a configured value, e.g. of a licence key
+	Validate func(value string) error
+}
+
+// ConfigIssue describes a setting that does not match the schema of its driver
+type ConfigIssue struct {
+	Key    string
+	Reason string
+}
+
+// ConfigError lists all settings of a driver that do not match its schema
+type ConfigError struct {
+	Driver string
+	Issues []ConfigIssue
+}
+
+// Error lists all offending settings with their reason
+func (e *ConfigError) Error() string {
+	issues := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		issues = append(issues, issue.Key+" "+issue.Reason)
+	}
+
+	if len(e.Driver) == 0 {
+		return fmt.Sprintf("invalid config: %s", strings.Join(issues, ", "))
+	}
+
+	return fmt.Sprintf("invalid config of driver %s: %s", e.Driver, strings.Join(issues, ", "))
+}
+
+// Keys returns the full names of the offending settings
+func (e *ConfigError) Keys() []string {
+	keys := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		keys = append(keys, issue.Key)
+	}
+
+	return keys
+}
+
+// UnknownDriverError reports a driver name that is neither built in nor registered as external driver
+type UnknownDriverError struct {
+	// Key is the setting that contains the driver, e.g. telemetry.driver
+	Key    string
+	Driver string
+}
+
+func (e *UnknownDriverError) Error() string {
+	return fmt.Sprintf("%s contains the unknown driver »%s«", e.Key, e.Driver)
+}
+
+// driverConfigCheck validates settings of a driver namespace that depend on each other, e.g. the region and the licence key.
+// The passed config is the driver config of the namespace.
+type driverConfigCheck func(driverCfg Config) []ConfigIssue
+
+// driverSchemas holds the settings of all drivers, the key is the name of the driver namespace.
+// Drivers that read other namespaces than their name are listed in namespaces.
+var driverSchemas = struct {
+	schemas    map[string]map[string]ConfigKey
+	namespaces map[string][]string
+	checks     map[string]driverConfigCheck
+	mutex      sync.RWMutex
+}{
+	schemas:    make(map[string]map[string]ConfigKey),
+	namespaces: make(map[string][]string),
+	checks:     make(map[string]driverConfigCheck),
+}
+
+// RegisterDriverConfig registers the settings of a driver namespace to provide their defaults and validation.
+// External drivers call it in their init function before RegisterExternal.
+func RegisterDriverConfig(name string, keys ...ConfigKey) {
+	driverSchemas.mutex.Lock()
+	defer driverSchemas.mutex.Unlock()
+
+	schema, ok := driverSchemas.schemas[name]
+	if !ok {
+		schema = make(map[string]ConfigKey, len(keys))
+		driverSchemas.schemas[name] = schema
+	}
+
+	for _, key := range keys {
+		schema[key.Name] = key
+	}
+}
+
+// registerDriverConfigNamespaces registers the namespaces of a driver that are not named like the driver,
+// e.g. the newrelicAPM driver reads telemetry.drivers.newrelic
+func registerDriverConfigNamespaces(driver string, namespaces ...string) {
+	driverSchemas.mutex.Lock()
+	defer driverSchemas.mutex.Unlock()
+
+	driverSchemas.namespaces[driver] = namespaces
+}
+
+// registerDriverConfigCheck registers the check of the settings of a driver namespace that depend on each other.
+// It runs after all settings of the namespace are valid on their own.
+func registerDriverConfigCheck(name string, check driverConfigCheck) {
+	driverSchemas.mutex.Lock()
+	defer driverSchemas.mutex.Unlock()
+
+	driverSchemas.checks[name] = check
+}
+
+func driverConfigKey(name string, key string) (ConfigKey, bool) {
+	driverSchemas.mutex.RLock()
+	defer driverSchemas.mutex.RUnlock()
+
+	configKey, ok := driverSchemas.schemas[name][key]
+
+	return configKey, ok
+}
+
+// DriverConfig returns the settings of the driver namespace, all keys are relative to telemetry.drivers.<name>.
+// If a setting is not configured there, the flat key telemetry.<name>.<key> and after that the registered default is used.
+func DriverConfig(cfg Config, name string) Config {
+	return driverConfig{
+		root: cfg,
+		name: name,
+	}
+}
+
+// driverConfig implements Config for a single driver namespace
+type driverConfig struct {
+	root Config
+	name string
+}
+
+// resolve returns the full name of the configured setting
+func (c driverConfig) resolve(key string) (string, bool) {
+	fullKey := driverConfigPrefix + c.name + "." + key
+	if c.root.IsSet(fullKey) {
+		return fullKey, true
+	}
+
+	legacyKey := legacyConfigPrefix + c.name + "." + key
+	if c.root.IsSet(legacyKey) {
+		return legacyKey, true
+	}
+
+	return fullKey, false
+}
+
+// resolveDriverConfigKey returns the full name of the setting that is read for the key of the driver namespace
+func resolveDriverConfigKey(cfg Config, name string, key string) string {
+	fullKey, _ := driverConfig{root: cfg, name: name}.resolve(key)
+
+	return fullKey
+}
+
+// fallback returns the registered default of the setting
+func (c driverConfig) fallback(key string) any {
+	configKey, _ := driverConfigKey(c.name, key)
+
+	return configKey.Default
+}
+
+func (c driverConfig) Get(key string) any {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return c.fallback(key)
+	}
+
+	return c.root.Get(fullKey)
+}
+
+// GetString returns the setting, references to a secrets provider are resolved
+func (c driverConfig) GetString(key string) string {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToString(c.fallback(key))
+	}
+
+	value, err := ResolveSecret(c.root.GetString(fullKey))
+	if err != nil {
+		handleError(fmt.Errorf("%s%s %w", telemetry.TelemetryDriverError, fullKey, err))
+		return ""
+	}
+
+	return value
+}
+
+func (c driverConfig) GetInt(key string) int {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToInt(c.fallback(key))
+	}
+
+	return c.root.GetInt(fullKey)
+}
+
+func (c driverConfig) GetInt64(key string) int64 {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToInt64(c.fallback(key))
+	}
+
+	return c.root.GetInt64(fullKey)
+}
+
+func (c driverConfig) GetBool(key string) bool {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToBool(c.fallback(key))
+	}
+
+	return c.root.GetBool(fullKey)
+}
+
+func (c driverConfig) GetStringSlice(key string) []string {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToStringSlice(c.fallback(key))
+	}
+
+	return c.root.GetStringSlice(fullKey)
+}
+
+func (c driverConfig) GetStringMapString(key string) map[string]string {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToStringMapString(c.fallback(key))
+	}
+
+	return c.root.GetStringMapString(fullKey)
+}
+
+func (c driverConfig) GetDuration(key string) time.Duration {
+	fullKey, ok := c.resolve(key)
+	if !ok {
+		return cast.ToDuration(c.fallback(key))
+	}
+
+	return c.root.GetDuration(fullKey)
+}
+
+// IsSet reports whether the setting is configured or has a default
+func (c driverConfig) IsSet(key string) bool {
+	_, ok := c.resolve(key)
+
+	return ok || c.fallback(key) != nil
+}
+
+// ValidateDriverConfig checks the settings of the driver namespace against the registered schema.
+// All offending settings are reported at once in a *ConfigError.
+func ValidateDriverConfig(cfg Config, name string) error {
+	driverSchemas.mutex.RLock()
+	keys := make([]ConfigKey, 0, len(driverSchemas.schemas[name]))
+	for _, key := range driverSchemas.schemas[name] {
+		keys = append(keys, key)
+	}
+	check := driverSchemas.checks[name]
+	driverSchemas.mutex.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+
+	config := driverConfig{root: cfg, name: name}
+	configError := &ConfigError{Driver: name}
+
+	for _, key := range keys {
+		fullKey, ok := config.resolve(key.Name)
+		if !ok {
+			if key.Required && key.Default == nil {
+				configError.Issues = append(configError.Issues, ConfigIssue{Key: fullKey, Reason: "is required"})
+			}
+			continue
+		}
+
+		value := cfg.Get(fullKey)
+		if stringValue, ok := value.(string); ok {
+			secret, err := ResolveSecret(stringValue)
+			if err != nil {
+				configError.Issues = append(configError.Issues, ConfigIssue{Key: fullKey, Reason: err.Error()})
+				continue
+			}
+			value = secret
+		}
+
+		reason := validateConfigValue(key, value)
+		if len(reason) > 0 {
+			configError.Issues = append(configError.Issues, ConfigIssue{Key: fullKey, Reason: reason})
+		}
+	}
+
+	if check != nil && len(configError.Issues) == 0 {
+		configError.Issues = check(config)
+	}
+
+	if len(configError.Issues) > 0 {
+		return configError
+	}
+
+	return nil
+}
+
+// validateConfigValue returns the reason why the value does not match the key, an empty reason means valid
+func validateConfigValue(key ConfigKey, value any) string {
+	kind := key.Kind
+	if len(kind) == 0 {
+		kind = ConfigKindString
+	}
+
+	var err error
+	switch kind {
+	case ConfigKindInt:
+		_, err = cast.ToInt64E(value)
+	case ConfigKindBool:
+		_, err = cast.ToBoolE(value)
+	case ConfigKindDuration:
+		_, err = cast.ToDurationE(value)
+	case ConfigKindStringSlice:
+		_, err = cast.ToStringSliceE(value)
+	case ConfigKindStringMap:
+		_, err = cast.ToStringMapStringE(value)
+	default:
+		var stringValue string
+		stringValue, err = cast.ToStringE(value)
+		if err == nil && key.Required && len(stringValue) == 0 {
+			return "is required"
+		}
+	}
+
+	if err != nil {
+		return fmt.Sprintf("is not a valid %s", kind)
+	}
+
+	reason := validateConfigRange(key, kind, value)
+	if len(reason) > 0 {
+		return reason
+	}
+
+	stringValue := cast.ToString(value)
+	if len(stringValue) == 0 && !key.Required {
+		return ""
+	}
+
+	if len(key.Values) > 0 && !containsString(key.Values, stringValue) {
+		return fmt.Sprintf("has to be one of %s", strings.Join(key.Values, ", "))
+	}
+
+	if key.Validate != nil {
+		err = key.Validate(stringValue)
+		if err != nil {
+			return err.Error()
+		}
+	}
+
+	return ""
+}
+
+// validateConfigRange returns the reason why an int or duration value is out of the range of the key
+func validateConfigRange(key ConfigKey, kind string, value any) string {
+	if kind != ConfigKindInt && kind != ConfigKindDuration {
+		return ""
+	}
+
+	// durations are compared in nanoseconds
+	toInt64 := func(value any) int64 {
+		if kind == ConfigKindDuration {
+			return int64(cast.ToDuration(value))
+		}
+		return cast.ToInt64(value)
+	}
+
+	format := func(limit any) string {
+		if kind == ConfigKindDuration {
+			return cast.ToDuration(limit).String()
+		}
+		return cast.ToString(limit)
+	}
+
+	number := toInt64(value)
+
+	if key.Min != nil && number < toInt64(key.Min) {
+		return fmt.Sprintf("has to be at least %s", format(key.Min))
+	}
+
+	if key.Max != nil && number > toInt64(key.Max) {
+		return fmt.Sprintf("has to be at most %s", format(key.Max))
+	}
+
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SelectedDrivers returns the names of the drivers listed in telemetry.driver, separated by comma or whitespace
+func SelectedDrivers(cfg Config) []string {
+	return splitDriverList(cfg.GetString("telemetry.driver"))
+}
+
+// splitDriverList splits a list of driver names separated by comma or whitespace
+func splitDriverList(drivers string) []string {
+	return strings.FieldsFunc(drivers, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// knownDriver reports whether the driver is built in or registered as external driver
+func knownDriver(driver string) bool {
+	driverSchemas.mutex.RLock()
+	_, hasNamespaces := driverSchemas.namespaces[driver]
+	_, hasSchema := driverSchemas.schemas[driver]
+	driverSchemas.mutex.RUnlock()
+
+	return hasNamespaces || hasSchema || IsDriverRegistered(driver) || containsString(ExternalDrivers(), driver)
+}
+
+// ValidateConfig checks the settings outside of the driver namespaces, that all drivers selected by telemetry.driver
+// are known and that their settings are valid. All problems are returned at once: the settings outside of the driver
+// namespaces and of every driver are reported as *ConfigError, unknown drivers as *UnknownDriverError.
+// External drivers are only known if their package is imported.
+func ValidateConfig(cfg Config) error {
+	var errs []error
+
+	errs = append(errs, validateSettings(cfg)...)
+
+	for _, driver := range SelectedDrivers(cfg) {
+		if !knownDriver(driver) {
+			errs = append(errs, &UnknownDriverError{Key: "telemetry.driver", Driver: driver})
+			continue
+		}
+
+		driverSchemas.mutex.RLock()
+		namespaces, ok := driverSchemas.namespaces[driver]
+		driverSchemas.mutex.RUnlock()
+
+		if !ok {
+			namespaces = []string{driver}
+		}
+
+		for _, namespace := range namespaces {
+			err := ValidateDriverConfig(cfg, namespace)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
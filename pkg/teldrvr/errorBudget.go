@@ -0,0 +1,284 @@
+package teldrvr
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// errorBudgetConfigKey configures the evaluation of the error rates, e.g. telemetry.errorBudget.errorRate: 5
+const errorBudgetConfigKey = "telemetry.errorBudget"
+
+// errorBudgetDefaultWindow is the sliding window if telemetry.errorBudget.window is not set
+const errorBudgetDefaultWindow = 5 * time.Minute
+
+// errorBudgetDefaultMinTransactions is the minimum of transactions in the window if
+// telemetry.errorBudget.minTransactions is not set, so a single failure does not alert
+const errorBudgetDefaultMinTransactions = 10
+
+// errorBudgetBuckets is the number of buckets the window is split into, the window slides bucket by bucket
+const errorBudgetBuckets = 60
+
+// errorBudgetTransaction is the name of the transactions that report an exceeded error budget
+const errorBudgetTransaction = "telemetry.errorBudget"
+
+// attributes of the errorBudgetTransaction
+const (
+	errorBudgetTransactionAttribute = "errorBudget.transaction"
+	errorBudgetErrorRateAttribute   = "errorBudget.errorRate"
+	errorBudgetThresholdAttribute   = "errorBudget.threshold"
+	errorBudgetWindowAttribute      = "errorBudget.window"
+	errorBudgetFailedAttribute      = "errorBudget.failed"
+	errorBudgetTotalAttribute       = "errorBudget.total"
+)
+
+// errorBudgetSettings are the settings of telemetry.errorBudget
+type errorBudgetSettings struct {
+	// errorRate is the threshold in percent of failed transactions, 0 disables the evaluation
+	errorRate       float64
+	window          time.Duration
+	minTransactions int
+}
+
+// errorBudgetBucket counts the transactions that ended in a part of the window
+type errorBudgetBucket struct {
+	start  time.Time
+	total  int
+	failed int
+}
+
+// errorBudgetState is the sliding window of a transaction name
+type errorBudgetState struct {
+	buckets []errorBudgetBucket
+	// alerting is set after the threshold was crossed, it is reset once the error rate is below the threshold again
+	alerting bool
+}
+
+// errorBudget holds the settings read from the config on the first transaction and the windows by transaction name
+var errorBudget = struct {
+	settings errorBudgetSettings
+	states   map[string]*errorBudgetState
+	once     sync.Once
+	mutex    sync.Mutex
+}{
+	states: make(map[string]*errorBudgetState),
+}
+
+// configuredErrorBudget reads telemetry.errorBudget once, an invalid error rate is reported and disables the evaluation
+func configuredErrorBudget() errorBudgetSettings {
+	errorBudget.once.Do(func() {
+		cfg := viper.GetViper()
+
+		errorRate := cfg.GetString(errorBudgetConfigKey + ".errorRate")
+		if len(errorRate) == 0 {
+			return
+		}
+
+		err := validateErrorRate(errorRate)
+		if err != nil {
+			handleError(fmt.Errorf("%s%s.errorRate is ignored: %w", telemetry.TelemetryDriverError, errorBudgetConfigKey, err))
+			return
+		}
+
+		settings := errorBudgetSettings{
+			errorRate:       cfg.GetFloat64(errorBudgetConfigKey + ".errorRate"),
+			window:          errorBudgetDefaultWindow,
+			minTransactions: errorBudgetDefaultMinTransactions,
+		}
+		if cfg.IsSet(errorBudgetConfigKey + ".window") {
+			settings.window = cfg.GetDuration(errorBudgetConfigKey + ".window")
+		}
+		if cfg.IsSet(errorBudgetConfigKey + ".minTransactions") {
+			settings.minTransactions = cfg.GetInt(errorBudgetConfigKey + ".minTransactions")
+		}
+		if settings.window <= 0 {
+			handleError(fmt.Errorf("%s%s.window has to be positive, falling back to %s", telemetry.TelemetryDriverError,
+				errorBudgetConfigKey, errorBudgetDefaultWindow))
+			settings.window = errorBudgetDefaultWindow
+		}
+
+		errorBudget.settings = settings
+	})
+
+	return errorBudget.settings
+}
+
+// validateErrorRate checks the error rate is a percentage above 0 and up to 100, e.g. 2.5
+func validateErrorRate(value string) error {
+	errorRate, err := strconv.ParseFloat(value, 64)
+	if err != nil || errorRate <= 0 || errorRate > 100 {
+		return fmt.Errorf("»%s« has to be a percentage above 0 and up to 100", value)
+	}
+
+	return nil
+}
+
+// record counts the ended transaction in the window of its name. It returns the failed and total transactions of the
+// window and whether the threshold was crossed with this transaction.
+func (s errorBudgetSettings) record(name string, failed bool, now time.Time) (int, int, bool) {
+	errorBudget.mutex.Lock()
+	defer errorBudget.mutex.Unlock()
+
+	state, ok := errorBudget.states[name]
+	if !ok {
+		state = &errorBudgetState{}
+		errorBudget.states[name] = state
+	}
+
+	expired := 0
+	for expired < len(state.buckets) && !state.buckets[expired].start.After(now.Add(-s.window)) {
+		expired++
+	}
+	state.buckets = state.buckets[expired:]
+
+	last := len(state.buckets) - 1
+	if last < 0 || !now.Before(state.buckets[last].start.Add(s.window/errorBudgetBuckets)) {
+		state.buckets = append(state.buckets, errorBudgetBucket{start: now})
+		last++
+	}
+	state.buckets[last].total++
+	if failed {
+		state.buckets[last].failed++
+	}
+
+	failedCount, total := 0, 0
+	for _, bucket := range state.buckets {
+		failedCount += bucket.failed
+		total += bucket.total
+	}
+
+	exceeded := total >= s.minTransactions && float64(failedCount)*100 >= s.errorRate*float64(total)
+	crossed := exceeded && !state.alerting
+	state.alerting = exceeded
+
+	return failedCount, total, crossed
+}
+
+// recordErrorBudget reports the exceeded error budget of the transaction name as critical error in every active driver
+func recordErrorBudget(settings errorBudgetSettings, name string, failed int, total int) {
+	for _, driverName := range RegisteredDrivers() {
+		if !IsDriverActive(driverName) {
+			continue
+		}
+
+		driver, ok := RegisteredDriver(driverName)
+		if !ok {
+			continue
+		}
+
+		err := recordDriverErrorBudget(driver, settings, name, failed, total)
+		if err != nil {
+			handleError(fmt.Errorf("%serror budget could not be recorded by driver %s: %w", telemetry.TelemetryDriverError, driverName, err))
+		}
+	}
+}
+
+func recordDriverErrorBudget(driver telemetry.Driver, settings errorBudgetSettings, name string, failed int, total int) error {
+	transaction, err := driver.InitializeTransaction(errorBudgetTransaction)
+	if err != nil {
+		return err
+	}
+
+	errorRate := float64(failed) * 100 / float64(total)
+	attributes := map[string]any{
+		errorBudgetTransactionAttribute: name,
+		errorBudgetErrorRateAttribute:   errorRate,
+		errorBudgetThresholdAttribute:   settings.errorRate,
+		errorBudgetWindowAttribute:      settings.window.String(),
+		errorBudgetFailedAttribute:      failed,
+		errorBudgetTotalAttribute:       total,
+		CriticalAttribute:               true,
+	}
+	for key, value := range attributes {
+		err = transaction.AddTransactionAttribute(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	message := fmt.Sprintf("error rate of transaction %s is %.1f%% (%d of %d) in the last %s, the threshold is %g%%",
+		name, errorRate, failed, total, settings.window, settings.errorRate)
+	err = transaction.Error("", messageReader(message))
+	if err != nil {
+		return err
+	}
+
+	return transaction.Done()
+}
+
+// withErrorBudget wraps the transaction in an ErrorBudgetTransaction if the evaluation is enabled and the driver is the
+// first configured driver, so every transaction is counted once
+func withErrorBudget(driver string, name string, transaction telemetry.Transaction) telemetry.Transaction {
+	settings := configuredErrorBudget()
+	if settings.errorRate == 0 || driver != firstConfiguredDriver() {
+		return transaction
+	}
+
+	return &ErrorBudgetTransaction{
+		Transaction: transaction,
+		name:        name,
+		settings:    settings,
+	}
+}
+
+// ErrorBudgetTransaction counts its outcome in the error rate of its name at Done. When the error rate crosses the
+// threshold a critical error is reported in the transaction telemetry.errorBudget of every active driver.
+type ErrorBudgetTransaction struct {
+	telemetry.Transaction
+	name       string
+	settings   errorBudgetSettings
+	outcome    string
+	errorCount int
+	mutex      sync.Mutex
+}
+
+// Error counts the error and logs it
+func (t *ErrorBudgetTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	t.mutex.Lock()
+	t.errorCount++
+	t.mutex.Unlock()
+
+	return t.Transaction.Error(segmentID, readCloser)
+}
+
+// Done ends the transaction and counts its outcome, transactions with the outcome unknown are not counted
+func (t *ErrorBudgetTransaction) Done() error {
+	err := t.Transaction.Done()
+
+	t.mutex.Lock()
+	outcome := deriveOutcome(t.outcome, t.errorCount)
+	t.mutex.Unlock()
+
+	if outcome == OutcomeUnknown {
+		return err
+	}
+
+	failed, total, crossed := t.settings.record(t.name, outcome == OutcomeFailure, clockNow())
+	if crossed {
+		recordErrorBudget(t.settings, t.name, failed, total)
+	}
+
+	return err
+}
+
+// Unwrap returns the wrapped transaction
+func (t *ErrorBudgetTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// SetOutcome sets the outcome of the wrapped transaction and keeps it for the error rate
+func (t *ErrorBudgetTransaction) SetOutcome(outcome string) error {
+	err := SetOutcome(t.Transaction, outcome)
+	if err == nil {
+		t.mutex.Lock()
+		t.outcome = outcome
+		t.mutex.Unlock()
+	}
+
+	return err
+}
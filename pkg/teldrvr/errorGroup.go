@@ -0,0 +1,53 @@
+package teldrvr
+
+import (
+	"sync"
+)
+
+// ErrorGroupAttribute is the attribute holding the fingerprint used to group errors.
+// If it is set as transaction or segment attribute, it takes precedence over the error group callback.
+const ErrorGroupAttribute = "error.group"
+
+// ErrorCountAttribute holds the number of errors logged in the transaction, it is added at Done for error rate alerts
+// per transaction name
+const ErrorCountAttribute = "error.count"
+
+// ErrorGroupCallback returns the group (fingerprint) of an error. An empty group keeps the default grouping.
+type ErrorGroupCallback func(transaction string, message string) string
+
+var errorGroupCallback = struct {
+	callback ErrorGroupCallback
+	mutex    sync.RWMutex
+}{}
+
+// SetErrorGroupCallback sets the callback used by all drivers to group errors
+func SetErrorGroupCallback(callback ErrorGroupCallback) {
+	errorGroupCallback.mutex.Lock()
+	defer errorGroupCallback.mutex.Unlock()
+
+	errorGroupCallback.callback = callback
+}
+
+// errorGroup determines the group of an error based on the attributes (first match wins) or the error group callback
+func errorGroup(transaction string, message string, attributes ...map[string]any) string {
+	for _, attributeMap := range attributes {
+		group, ok := attributeMap[ErrorGroupAttribute]
+		if !ok {
+			continue
+		}
+
+		if groupString, ok := group.(string); ok && len(groupString) > 0 {
+			return groupString
+		}
+	}
+
+	errorGroupCallback.mutex.RLock()
+	callback := errorGroupCallback.callback
+	errorGroupCallback.mutex.RUnlock()
+
+	if callback == nil {
+		return ""
+	}
+
+	return callback(transaction, message)
+}
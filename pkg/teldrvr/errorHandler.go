@@ -0,0 +1,75 @@
+package teldrvr
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// ErrorHandler is called for errors which can not be returned to the caller, e.g. during driver initialization
+type ErrorHandler func(error)
+
+// maxPendingErrors limits the errors kept until a handler is set, the oldest are dropped first. Services that never
+// set a handler would otherwise keep every failed emit in memory.
+const maxPendingErrors = 100
+
+var errorHandler = struct {
+	handler ErrorHandler
+	pending []error
+	// dropped counts the pending errors dropped over maxPendingErrors
+	dropped int
+	mutex   sync.Mutex
+}{}
+
+// SetErrorHandler sets the handler for driver errors.
+// The last errors that occurred before a handler was set are passed to the handler immediately, preceded by the
+// number of older errors that were dropped.
+func SetErrorHandler(handler ErrorHandler) {
+	errorHandler.mutex.Lock()
+	errorHandler.handler = handler
+	pending := errorHandler.pending
+	dropped := errorHandler.dropped
+	errorHandler.pending = nil
+	errorHandler.dropped = 0
+	errorHandler.mutex.Unlock()
+
+	if handler == nil {
+		return
+	}
+
+	if dropped > 0 {
+		handler(fmt.Errorf("%s%d errors before the error handler was set are dropped", telemetry.TelemetryDriverError, dropped))
+	}
+
+	for _, err := range pending {
+		handler(err)
+	}
+}
+
+// handleError passes the error to the error handler, if none is set the error is logged and the last
+// maxPendingErrors are kept until one is set
+func handleError(err error) {
+	if err == nil {
+		return
+	}
+
+	errorHandler.mutex.Lock()
+	handler := errorHandler.handler
+	if handler == nil {
+		if len(errorHandler.pending) >= maxPendingErrors {
+			errorHandler.pending = errorHandler.pending[1:]
+			errorHandler.dropped++
+		}
+		errorHandler.pending = append(errorHandler.pending, err)
+	}
+	errorHandler.mutex.Unlock()
+
+	if handler == nil {
+		log.Printf("Telemetry driver error: %v", err)
+		return
+	}
+
+	handler(err)
+}
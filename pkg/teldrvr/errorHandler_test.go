@@ -0,0 +1,38 @@
+package teldrvr
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHandleErrorKeepsLastPendingErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		SetErrorHandler(nil)
+		log.SetOutput(os.Stderr)
+	})
+	SetErrorHandler(nil)
+
+	for i := 0; i < maxPendingErrors+5; i++ {
+		handleError(fmt.Errorf("error %d", i))
+	}
+
+	var received []string
+	SetErrorHandler(func(err error) {
+		received = append(received, err.Error())
+	})
+
+	if len(received) != maxPendingErrors+1 {
+		t.Fatalf("handler received %d errors, want %d", len(received), maxPendingErrors+1)
+	}
+	if !strings.Contains(received[0], "5 errors") {
+		t.Errorf("first error = %q, want the number of dropped errors", received[0])
+	}
+	if received[1] != "error 5" || received[len(received)-1] != fmt.Sprintf("error %d", maxPendingErrors+4) {
+		t.Errorf("pending errors = %q ... %q, want error 5 ... error %d", received[1], received[len(received)-1], maxPendingErrors+4)
+	}
+}
@@ -0,0 +1,458 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+const eventTypeTransactionStart = "transaction.start"
+const eventTypeTransactionEnd = "transaction.end"
+const eventTypeSegmentStart = "segment.start"
+const eventTypeSegmentEnd = "segment.end"
+const eventTypeLog = "log"
+const eventTypeMetric = "metric"
+
+// Event is a single telemetry record passed to an EventSink
+type Event struct {
+	Time         time.Time      `json:"time"`
+	Type         string         `json:"type"`
+	Level        string         `json:"level,omitempty"`
+	Transaction  string         `json:"transaction"`
+	TraceID      string         `json:"traceID,omitempty"`
+	ProcessID    string         `json:"processID,omitempty"`
+	SegmentID    string         `json:"segmentID,omitempty"`
+	Segment      string         `json:"segment,omitempty"`
+	Message      string         `json:"message,omitempty"`
+	ErrorGroup   string         `json:"error.group,omitempty"`
+	MetricName   string         `json:"metric.name,omitempty"`
+	MetricValue  float64        `json:"metric.value,omitempty"`
+	Duration     time.Duration  `json:"duration,omitempty"`
+	SegmentCount int            `json:"segmentCount,omitempty"`
+	ErrorCount   int            `json:"errorCount,omitempty"`
+	InfoCount    int            `json:"infoCount,omitempty"`
+	DebugCount   int            `json:"debugCount,omitempty"`
+	Outcome      string         `json:"outcome,omitempty"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Breadcrumbs  []Breadcrumb   `json:"breadcrumbs,omitempty"`
+}
+
+// Breadcrumb is an info message logged in the transaction before an error, see EventDriver.Breadcrumbs
+type Breadcrumb struct {
+	Time      time.Time `json:"time"`
+	SegmentID string    `json:"segmentID,omitempty"`
+	Segment   string    `json:"segment,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// EventSink receives the events of an EventDriver, e.g. to send them to a remote system
+type EventSink interface {
+	Emit(Event) error
+}
+
+// EventDriver is the base for all drivers that only need to forward events to a sink
+type EventDriver struct {
+	Sink EventSink
+	// Breadcrumbs is the number of recent info messages every transaction attaches to its error events, 0 disables them
+	Breadcrumbs int
+}
+
+// InitializeTransaction starts a transaction
+func (d EventDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	if d.Sink == nil {
+		return nil, errors.New("event driver has no sink")
+	}
+
+	transaction := newEventTransaction(name, d.Sink)
+	transaction.maxBreadcrumbs = d.Breadcrumbs
+
+	return transaction, nil
+}
+
+// EventSegmentContainer used for segment handling
+type EventSegmentContainer struct {
+	segments   map[string]string         // key = segment ID | value = name of the segment
+	attributes map[string]map[string]any // {"segmentID":  {"attributeName": "attribute value"}}
+	mutex      sync.RWMutex
+}
+
+// EventTransaction converts all transaction calls into events
+type EventTransaction struct {
+	name             string
+	sink             EventSink
+	segmentContainer EventSegmentContainer
+	attributes       map[string]any
+	trace            string
+	processID        string
+	startTime        time.Time
+	segmentCount     int
+	errorCount       int
+	infoCount        int
+	debugCount       int
+	outcome          string
+	// breadcrumbs holds the latest maxBreadcrumbs info messages, the oldest first
+	breadcrumbs    []Breadcrumb
+	maxBreadcrumbs int
+}
+
+func newEventTransaction(name string, sink EventSink) *EventTransaction {
+	t := EventTransaction{
+		name:       name,
+		sink:       sink,
+		attributes: make(map[string]any),
+		startTime:  clockNow(),
+	}
+	t.segmentContainer.segments = make(map[string]string)
+	t.segmentContainer.attributes = make(map[string]map[string]any)
+	return &t
+}
+
+// newEvent creates an event with the transaction information and the merged transaction and segment attributes
+// - Expects the segment mutex to be locked -
+func (t *EventTransaction) newEvent(eventType string, segmentID string) Event {
+	attributes := make(map[string]any, len(t.attributes)+len(t.segmentContainer.attributes[segmentID]))
+	for key, value := range t.attributes {
+		attributes[key] = value
+	}
+	for key, value := range t.segmentContainer.attributes[segmentID] {
+		attributes[key] = value
+	}
+
+	return Event{
+		Time:        clockNow(),
+		Type:        eventType,
+		Transaction: t.name,
+		TraceID:     t.trace,
+		ProcessID:   t.processID,
+		SegmentID:   segmentID,
+		Segment:     t.segmentContainer.segments[segmentID],
+		Attributes:  attributes,
+	}
+}
+
+func (t *EventTransaction) emit(event Event) error {
+	err := t.sink.Emit(event)
+	if err != nil {
+		return fmt.Errorf("could not emit %s event: %w", event.Type, err)
+	}
+
+	return nil
+}
+
+// Start emits the transaction start event
+func (t *EventTransaction) Start(name string) {
+	t.segmentContainer.mutex.RLock()
+	event := t.newEvent(eventTypeTransactionStart, "")
+	t.segmentContainer.mutex.RUnlock()
+
+	err := t.emit(event)
+	if err != nil {
+		log.Printf("%s%v", telemetry.TelemetryDriverError, err)
+	}
+}
+
+// AddTransactionAttribute adds an attribute to the transaction
+// - Not thread safe -
+func (t *EventTransaction) AddTransactionAttribute(key string, value any) error {
+	val, ok := t.attributes[key]
+	if ok {
+		return fmt.Errorf("transaction attribute '%s' already set with value '%v'", key, val)
+	}
+
+	t.attributes[key] = value
+
+	return nil
+}
+
+// SegmentStart starts a segment and emits the segment start event on debug level
+func (t *EventTransaction) SegmentStart(segmentID string, name string) error {
+	t.segmentContainer.mutex.Lock()
+	if t.segmentContainer.segments == nil {
+		t.segmentContainer.segments = make(map[string]string)
+	}
+	t.segmentContainer.segments[segmentID] = name
+	t.segmentCount++
+	event := t.newEvent(eventTypeSegmentStart, segmentID)
+	t.segmentContainer.mutex.Unlock()
+
+	if !messageEnabled(logLevelDebug, name) {
+		return nil
+	}
+
+	return t.emit(event)
+}
+
+// AddSegmentAttribute adds an attribute to the currently open segment
+// - Thread safe -
+func (t *EventTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	t.segmentContainer.mutex.Lock()
+	defer t.segmentContainer.mutex.Unlock()
+
+	segmentName, segmentExist := t.segmentContainer.segments[segmentID]
+	if !segmentExist {
+		return fmt.Errorf("can not add attribute to not existing segment. SegmentID: %s | Key: %s | Value: %s", segmentID, key, value)
+	}
+
+	if t.segmentContainer.attributes == nil {
+		t.segmentContainer.attributes = make(map[string]map[string]any)
+	}
+
+	if t.segmentContainer.attributes[segmentID] == nil {
+		t.segmentContainer.attributes[segmentID] = make(map[string]any)
+	}
+
+	attribute, attributeExist := t.segmentContainer.attributes[segmentID][key]
+	if attributeExist {
+		return fmt.Errorf("segment attribute already exist. Segment: %s | SegmentID: %s | Key: %s | Already set value: %v", segmentName, segmentID, key, attribute)
+	}
+
+	t.segmentContainer.attributes[segmentID][key] = value
+
+	return nil
+}
+
+// SegmentEnd ends the segment and emits the segment end event on debug level
+func (t *EventTransaction) SegmentEnd(segmentID string) error {
+	t.segmentContainer.mutex.Lock()
+	name, ok := t.segmentContainer.segments[segmentID]
+	if !ok {
+		t.segmentContainer.mutex.Unlock()
+		return fmt.Errorf("Error trying to end segment. Segment is not open. SegmentID: %s", segmentID)
+	}
+
+	event := t.newEvent(eventTypeSegmentEnd, segmentID)
+	delete(t.segmentContainer.segments, segmentID)
+	delete(t.segmentContainer.attributes, segmentID)
+	t.segmentContainer.mutex.Unlock()
+
+	if !messageEnabled(logLevelDebug, name) {
+		return nil
+	}
+
+	return t.emit(event)
+}
+
+// readLogMessage reads the complete message up to the limit, so multi-line messages like stack traces are not cut
+// after the first chunk of the reader. A limit of 0 reads the whole message.
+func readLogMessage(reader io.Reader, limit int) ([]byte, error) {
+	if limit > 0 {
+		reader = io.LimitReader(reader, int64(limit))
+	}
+
+	return io.ReadAll(reader)
+}
+
+// logMessage reads the message and emits it as log event, info messages are kept as breadcrumb as well.
+// A disabled message is only kept as breadcrumb.
+func (t *EventTransaction) logMessage(level string, segmentID string, readCloser io.ReadCloser, enabled bool) error {
+	defer func() {
+		closeErr := readCloser.Close()
+		if closeErr != nil {
+			log.Printf("Telemetry driver event could not close reader while logging. Potential resource leak!")
+		}
+	}()
+
+	// max bytes available for the message
+	msgByteSize := telemetry.ErrorBytesSize
+	if level != logLevelError {
+		msgByteSize = telemetry.DebugByteSize
+	}
+
+	msg, err := readLogMessage(readCloser, msgByteSize)
+	if err != nil {
+		return errors.New("error while reading message")
+	}
+
+	t.segmentContainer.mutex.Lock()
+	event := t.newEvent(eventTypeLog, segmentID)
+	event.Level = level
+	event.Message = string(msg)
+	switch level {
+	case logLevelError:
+		t.errorCount++
+		event.ErrorGroup = errorGroup(t.name, event.Message, t.segmentContainer.attributes[segmentID], t.attributes)
+		event.Breadcrumbs = append([]Breadcrumb(nil), t.breadcrumbs...)
+	case logLevelInfo:
+		if enabled {
+			t.infoCount++
+		}
+		t.addBreadcrumb(event)
+	case logLevelDebug:
+		t.debugCount++
+	}
+	t.segmentContainer.mutex.Unlock()
+
+	if !enabled {
+		return nil
+	}
+
+	return t.emit(event)
+}
+
+// addBreadcrumb keeps the info event as breadcrumb, the oldest breadcrumb is dropped if the buffer is full
+// - Expects the segment mutex to be locked -
+func (t *EventTransaction) addBreadcrumb(event Event) {
+	if t.maxBreadcrumbs <= 0 {
+		return
+	}
+
+	if len(t.breadcrumbs) >= t.maxBreadcrumbs {
+		copy(t.breadcrumbs, t.breadcrumbs[len(t.breadcrumbs)-t.maxBreadcrumbs+1:])
+		t.breadcrumbs = t.breadcrumbs[:t.maxBreadcrumbs-1]
+	}
+
+	t.breadcrumbs = append(t.breadcrumbs, Breadcrumb{
+		Time:      event.Time,
+		SegmentID: event.SegmentID,
+		Segment:   event.Segment,
+		Message:   event.Message,
+	})
+}
+
+// Error emits an error event
+func (t *EventTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	return t.logMessage(logLevelError, segmentID, readCloser, true)
+}
+
+// Info emits an info event, with breadcrumbs it is kept for the next error event even if info messages are disabled
+func (t *EventTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	enabled := t.messageEnabled(logLevelInfo, segmentID)
+	if !enabled && t.maxBreadcrumbs <= 0 {
+		return nil
+	}
+	return t.logMessage(logLevelInfo, segmentID, readCloser, enabled)
+}
+
+// Debug emits a debug event
+func (t *EventTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	if !t.messageEnabled(logLevelDebug, segmentID) {
+		return nil
+	}
+	return t.logMessage(logLevelDebug, segmentID, readCloser, true)
+}
+
+// messageEnabled reports whether a message of the level is emitted in the segment, see telemetry.levelOverrides
+func (t *EventTransaction) messageEnabled(level string, segmentID string) bool {
+	t.segmentContainer.mutex.RLock()
+	name := t.segmentContainer.segments[segmentID]
+	t.segmentContainer.mutex.RUnlock()
+
+	return messageEnabled(level, name)
+}
+
+// IsLevelEnabled reports whether a message of the level is emitted in the segment. With breadcrumbs info messages are
+// always enabled, they are kept for the next error event.
+func (t *EventTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	if level == logLevelInfo && t.maxBreadcrumbs > 0 {
+		return true
+	}
+
+	return t.messageEnabled(level, segmentID)
+}
+
+// RecordMetric emits a metric event
+func (t *EventTransaction) RecordMetric(name string, value float64) error {
+	t.segmentContainer.mutex.RLock()
+	event := t.newEvent(eventTypeMetric, "")
+	t.segmentContainer.mutex.RUnlock()
+
+	event.MetricName = name
+	event.MetricValue = value
+
+	return t.emit(event)
+}
+
+// SetOutcome sets the outcome reported at Done, without one it is derived from the logged errors
+// - Not thread safe -
+func (t *EventTransaction) SetOutcome(outcome string) error {
+	err := validateOutcome(outcome)
+	if err != nil {
+		return err
+	}
+
+	t.outcome = outcome
+
+	return nil
+}
+
+// Done emits the transaction end event including a summary of the transaction: the duration, the number of segments
+// and the number of logged messages per level
+func (t *EventTransaction) Done() error {
+	t.segmentContainer.mutex.RLock()
+	event := t.newEvent(eventTypeTransactionEnd, "")
+	event.Duration = clockSince(t.startTime)
+	event.SegmentCount = t.segmentCount
+	event.ErrorCount = t.errorCount
+	event.InfoCount = t.infoCount
+	event.DebugCount = t.debugCount
+	event.Outcome = deriveOutcome(t.outcome, t.errorCount)
+	event.Attributes[ErrorCountAttribute] = t.errorCount
+	t.segmentContainer.mutex.RUnlock()
+
+	return t.emit(event)
+}
+
+// CreateTrace creates a trace for the transaction
+func (t *EventTransaction) CreateTrace() (string, error) {
+	return newID()
+}
+
+// SetTrace sets a trace for the transaction
+func (t *EventTransaction) SetTrace(trace string) error {
+	t.trace = trace
+
+	return nil
+}
+
+// Trace returns the current trace for the transaction
+func (t *EventTransaction) Trace() (string, error) {
+	return t.trace, nil
+}
+
+// TraceID returns the current trace for the transaction, this is the same as trace for every instance but apm
+func (t *EventTransaction) TraceID() (string, error) {
+	return t.trace, nil
+}
+
+// SetTraceID sets a trace for the transaction
+func (t *EventTransaction) SetTraceID(traceID string) error {
+	t.trace = traceID
+	return nil
+}
+
+// CreateProcessID creates a ProcessID for the transaction
+func (t *EventTransaction) CreateProcessID() (string, error) {
+	return newID()
+}
+
+// SetProcessID sets a ProcessID for the transaction
+func (t *EventTransaction) SetProcessID(processID string) error {
+	t.processID = processID
+
+	return nil
+}
+
+// ProcessID returns the current ProcessID for the transaction
+func (t *EventTransaction) ProcessID() (string, error) {
+	return t.processID, nil
+}
+
+// Erase any memory the transaction allocated
+func (t *EventTransaction) Erase() {
+	t.attributes = nil
+	t.segmentContainer.segments = nil
+	t.segmentContainer.attributes = nil
+	t.breadcrumbs = nil
+
+	// we need to collect the garbage manually here because maps in go do have some problems with the garbage collection
+	// the runtime.GC method is used to manually free the memory
+	// this problem is already known since 2017
+	// https://github.com/golang/go/issues/20135
+	runtime.GC()
+}
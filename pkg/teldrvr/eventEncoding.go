@@ -0,0 +1,273 @@
+package teldrvr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// encodings of the events published by the network drivers, selected with telemetry.drivers.<driver>.encoding
+const (
+	EventEncodingJSON     = "json"
+	EventEncodingProtobuf = "protobuf"
+	EventEncodingAvro     = "avro"
+)
+
+// EventEncodingConfigKeys are the settings read by the drivers that support all encodings, they register them with
+// their own settings
+var EventEncodingConfigKeys = []ConfigKey{
+	{Name: "encoding", Default: EventEncodingJSON, Values: []string{EventEncodingJSON, EventEncodingProtobuf, EventEncodingAvro}},
+}
+
+// EventAvroSchema is the schema of the Avro encoded events in parsing canonical form, every message starts with the
+// single object header carrying its fingerprint. The Protobuf messages are described by event.proto.
+const EventAvroSchema = `{"name":"plentymarkets.telemetry.Event","type":"record","fields":[` +
+	`{"name":"timeUnixNano","type":"long"},` +
+	`{"name":"type","type":"string"},` +
+	`{"name":"level","type":"string"},` +
+	`{"name":"transaction","type":"string"},` +
+	`{"name":"traceID","type":"string"},` +
+	`{"name":"processID","type":"string"},` +
+	`{"name":"segmentID","type":"string"},` +
+	`{"name":"segment","type":"string"},` +
+	`{"name":"message","type":"string"},` +
+	`{"name":"errorGroup","type":"string"},` +
+	`{"name":"metricName","type":"string"},` +
+	`{"name":"metricValue","type":"double"},` +
+	`{"name":"durationNano","type":"long"},` +
+	`{"name":"segmentCount","type":"int"},` +
+	`{"name":"errorCount","type":"int"},` +
+	`{"name":"infoCount","type":"int"},` +
+	`{"name":"debugCount","type":"int"},` +
+	`{"name":"outcome","type":"string"},` +
+	`{"name":"attributes","type":{"type":"map","values":"string"}},` +
+	`{"name":"breadcrumbs","type":{"type":"array","items":{"name":"plentymarkets.telemetry.Breadcrumb","type":"record","fields":[` +
+	`{"name":"timeUnixNano","type":"long"},` +
+	`{"name":"segmentID","type":"string"},` +
+	`{"name":"segment","type":"string"},` +
+	`{"name":"message","type":"string"}]}}}]}`
+
+// avroEmptyFingerprint is the initial value of the CRC-64-AVRO fingerprint
+const avroEmptyFingerprint uint64 = 0xc15d213aa4d7a795
+
+// avroSingleObjectHeader prefixes every Avro message: the marker C3 01 and the fingerprint of EventAvroSchema
+var avroSingleObjectHeader = func() []byte {
+	var table [256]uint64
+	for i := range table {
+		fingerprint := uint64(i)
+		for j := 0; j < 8; j++ {
+			fingerprint = (fingerprint >> 1) ^ (avroEmptyFingerprint & -(fingerprint & 1))
+		}
+		table[i] = fingerprint
+	}
+
+	fingerprint := avroEmptyFingerprint
+	for _, b := range []byte(EventAvroSchema) {
+		fingerprint = (fingerprint >> 8) ^ table[byte(fingerprint)^b]
+	}
+
+	return binary.LittleEndian.AppendUint64([]byte{0xc3, 0x01}, fingerprint)
+}()
+
+// EventContentType returns the content type of the encoding, e.g. for the message properties
+func EventContentType(encoding string) string {
+	switch encoding {
+	case EventEncodingProtobuf:
+		return "application/x-protobuf"
+	case EventEncodingAvro:
+		return "avro/binary"
+	default:
+		return "application/json"
+	}
+}
+
+// EncodeEvent encodes the event as JSON, Protobuf or Avro. An empty encoding is JSON.
+// Protobuf and Avro carry the attributes as strings, all values but strings are JSON encoded.
+func EncodeEvent(encoding string, event Event) ([]byte, error) {
+	switch encoding {
+	case "", EventEncodingJSON:
+		return json.Marshal(event)
+	case EventEncodingProtobuf:
+		return encodeEventProtobuf(event), nil
+	case EventEncodingAvro:
+		return encodeEventAvro(event), nil
+	default:
+		return nil, fmt.Errorf("unknown event encoding »%s«, supported are %s, %s and %s", encoding,
+			EventEncodingJSON, EventEncodingProtobuf, EventEncodingAvro)
+	}
+}
+
+// eventAttributeStrings converts the attribute values to strings and returns the sorted keys
+func eventAttributeStrings(attributes map[string]any) ([]string, map[string]string) {
+	keys := make([]string, 0, len(attributes))
+	values := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		keys = append(keys, key)
+
+		if text, ok := value.(string); ok {
+			values[key] = text
+			continue
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			values[key] = fmt.Sprint(value)
+			continue
+		}
+		values[key] = string(encoded)
+	}
+	sort.Strings(keys)
+
+	return keys, values
+}
+
+// protobuf wire types
+const (
+	protobufVarint  = 0
+	protobufFixed64 = 1
+	protobufBytes   = 2
+)
+
+// protobufWriter appends the fields of a message, fields with the zero value are skipped like proto3 does
+type protobufWriter []byte
+
+func (w *protobufWriter) tag(field int, wireType int) {
+	*w = binary.AppendUvarint(*w, uint64(field)<<3|uint64(wireType))
+}
+
+func (w *protobufWriter) int64(field int, value int64) {
+	if value == 0 {
+		return
+	}
+	w.tag(field, protobufVarint)
+	*w = binary.AppendUvarint(*w, uint64(value))
+}
+
+func (w *protobufWriter) double(field int, value float64) {
+	if value == 0 {
+		return
+	}
+	w.tag(field, protobufFixed64)
+	*w = binary.LittleEndian.AppendUint64(*w, math.Float64bits(value))
+}
+
+func (w *protobufWriter) string(field int, value string) {
+	if len(value) == 0 {
+		return
+	}
+	w.bytes(field, []byte(value))
+}
+
+func (w *protobufWriter) bytes(field int, value []byte) {
+	w.tag(field, protobufBytes)
+	*w = binary.AppendUvarint(*w, uint64(len(value)))
+	*w = append(*w, value...)
+}
+
+// encodeEventProtobuf encodes the event as message plentymarkets.telemetry.Event of event.proto
+func encodeEventProtobuf(event Event) []byte {
+	w := protobufWriter{}
+	w.int64(1, event.Time.UnixNano())
+	w.string(2, event.Type)
+	w.string(3, event.Level)
+	w.string(4, event.Transaction)
+	w.string(5, event.TraceID)
+	w.string(6, event.ProcessID)
+	w.string(7, event.SegmentID)
+	w.string(8, event.Segment)
+	w.string(9, event.Message)
+	w.string(10, event.ErrorGroup)
+	w.string(11, event.MetricName)
+	w.double(12, event.MetricValue)
+	w.int64(13, int64(event.Duration))
+	w.int64(14, int64(event.SegmentCount))
+	w.int64(15, int64(event.ErrorCount))
+	w.string(16, event.Outcome)
+	w.int64(19, int64(event.InfoCount))
+	w.int64(20, int64(event.DebugCount))
+
+	keys, values := eventAttributeStrings(event.Attributes)
+	for _, key := range keys {
+		entry := protobufWriter{}
+		// map entries always carry the key
+		entry.bytes(1, []byte(key))
+		entry.string(2, values[key])
+		w.bytes(17, entry)
+	}
+
+	for _, breadcrumb := range event.Breadcrumbs {
+		entry := protobufWriter{}
+		entry.int64(1, breadcrumb.Time.UnixNano())
+		entry.string(2, breadcrumb.SegmentID)
+		entry.string(3, breadcrumb.Segment)
+		entry.string(4, breadcrumb.Message)
+		w.bytes(18, entry)
+	}
+
+	return w
+}
+
+// avroWriter appends the values of a record in the order of the schema
+type avroWriter []byte
+
+func (w *avroWriter) long(value int64) {
+	*w = binary.AppendVarint(*w, value)
+}
+
+func (w *avroWriter) double(value float64) {
+	*w = binary.LittleEndian.AppendUint64(*w, math.Float64bits(value))
+}
+
+func (w *avroWriter) string(value string) {
+	w.long(int64(len(value)))
+	*w = append(*w, value...)
+}
+
+// encodeEventAvro encodes the event with EventAvroSchema as single object
+func encodeEventAvro(event Event) []byte {
+	w := avroWriter(append([]byte(nil), avroSingleObjectHeader...))
+	w.long(event.Time.UnixNano())
+	w.string(event.Type)
+	w.string(event.Level)
+	w.string(event.Transaction)
+	w.string(event.TraceID)
+	w.string(event.ProcessID)
+	w.string(event.SegmentID)
+	w.string(event.Segment)
+	w.string(event.Message)
+	w.string(event.ErrorGroup)
+	w.string(event.MetricName)
+	w.double(event.MetricValue)
+	w.long(int64(event.Duration))
+	w.long(int64(event.SegmentCount))
+	w.long(int64(event.ErrorCount))
+	w.long(int64(event.InfoCount))
+	w.long(int64(event.DebugCount))
+	w.string(event.Outcome)
+
+	// maps and arrays are written as one block terminated by an empty block
+	keys, values := eventAttributeStrings(event.Attributes)
+	if len(keys) > 0 {
+		w.long(int64(len(keys)))
+		for _, key := range keys {
+			w.string(key)
+			w.string(values[key])
+		}
+	}
+	w.long(0)
+
+	if len(event.Breadcrumbs) > 0 {
+		w.long(int64(len(event.Breadcrumbs)))
+		for _, breadcrumb := range event.Breadcrumbs {
+			w.long(breadcrumb.Time.UnixNano())
+			w.string(breadcrumb.SegmentID)
+			w.string(breadcrumb.Segment)
+			w.string(breadcrumb.Message)
+		}
+	}
+	w.long(0)
+
+	return w
+}
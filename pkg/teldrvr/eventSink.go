@@ -0,0 +1,131 @@
+package teldrvr
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+const defaultEventQueueSize = 1000
+
+// FlushingSink is implemented by all sinks that buffer events, e.g. to insert them batch wise
+type FlushingSink interface {
+	Flush() error
+}
+
+// AsyncSink decouples slow (remote) sinks from the application by emitting the events in a background goroutine.
+// If the queue is full, events are dropped instead of blocking the application.
+type AsyncSink struct {
+	driver string
+	next   EventSink
+	events chan Event
+	// flushes receives the flush requests, the result is sent back on the passed channel
+	flushes chan chan error
+	stopped chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewAsyncSink creates an AsyncSink and starts its background goroutine
+func NewAsyncSink(driver string, next EventSink, queueSize int) *AsyncSink {
+	if queueSize < 1 {
+		queueSize = defaultEventQueueSize
+	}
+
+	s := &AsyncSink{
+		driver:  driver,
+		next:    next,
+		events:  make(chan Event, queueSize),
+		flushes: make(chan chan error),
+		stopped: make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *AsyncSink) run() {
+	defer s.wg.Done()
+	defer close(s.stopped)
+
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return
+			}
+			s.emit(event)
+		case result := <-s.flushes:
+			for queued := len(s.events); queued > 0; queued-- {
+				event, ok := <-s.events
+				if !ok {
+					break
+				}
+				s.emit(event)
+			}
+			result <- s.flushNext()
+		}
+	}
+}
+
+func (s *AsyncSink) emit(event Event) {
+	err := s.next.Emit(event)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s could not emit event: %w", telemetry.TelemetryDriverError, s.driver, err))
+	}
+}
+
+// flushNext flushes the next sink, if it buffers events
+func (s *AsyncSink) flushNext() error {
+	flusher, ok := s.next.(FlushingSink)
+	if !ok {
+		return nil
+	}
+
+	return flusher.Flush()
+}
+
+// Emit queues the event
+func (s *AsyncSink) Emit(event Event) error {
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("event queue of driver %s is full, event dropped", s.driver)
+	}
+}
+
+// Flush emits all events queued so far and flushes the next sink, e.g. a batch. The sink can be used afterwards.
+func (s *AsyncSink) Flush() error {
+	result := make(chan error, 1)
+	select {
+	case s.flushes <- result:
+		return <-result
+	case <-s.stopped:
+		return nil
+	}
+}
+
+// Close emits all queued events, stops the background goroutine and closes the next sink, e.g. to flush a batch
+func (s *AsyncSink) Close() error {
+	s.once.Do(func() {
+		close(s.events)
+	})
+	s.wg.Wait()
+
+	closer, ok := s.next.(io.Closer)
+	if !ok {
+		return nil
+	}
+
+	return closer.Close()
+}
+
+// Queue returns the number of queued events and the capacity of the queue
+func (s *AsyncSink) Queue() (length int, capacity int) {
+	return len(s.events), cap(s.events)
+}
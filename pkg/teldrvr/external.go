@@ -0,0 +1,94 @@
+package teldrvr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// DriverFactory creates a driver based on the provided configuration
+type DriverFactory func(Config) (telemetry.Driver, error)
+
+// externalFactories holds the factories of all external drivers
+var externalFactories = struct {
+	factories map[string]DriverFactory
+	mutex     sync.Mutex
+}{
+	factories: make(map[string]DriverFactory),
+}
+
+// RegisterExternal registers a driver that is shipped outside of this repository.
+// It is meant to be called from the init function of the external driver package.
+// The factory is only called if the driver is enabled, which is the case if its name
+// is listed in telemetry.external or telemetry.driver, and its settings registered with
+// RegisterDriverConfig are valid.
+func RegisterExternal(name string, factory DriverFactory) error {
+	if len(name) == 0 {
+		return fmt.Errorf("can not register external driver without name")
+	}
+
+	if factory == nil {
+		return fmt.Errorf("can not register external driver '%s' without factory", name)
+	}
+
+	externalFactories.mutex.Lock()
+	defer externalFactories.mutex.Unlock()
+
+	if _, ok := externalFactories.factories[name]; ok {
+		return fmt.Errorf("external driver '%s' is already registered", name)
+	}
+
+	if IsDriverRegistered(name) {
+		return fmt.Errorf("external driver '%s' conflicts with an already registered driver", name)
+	}
+
+	externalFactories.factories[name] = factory
+
+	cfg, err := GetConfig()
+	if err != nil {
+		return err
+	}
+
+	if !externalDriverEnabled(cfg, name) {
+		return nil
+	}
+
+	err = ValidateDriverConfig(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	driver, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("external driver '%s' could not be created: %w", name, err)
+	}
+
+	registerDriver(name, driver)
+
+	return nil
+}
+
+// ExternalDrivers returns the names of all registered external driver factories
+func ExternalDrivers() []string {
+	externalFactories.mutex.Lock()
+	defer externalFactories.mutex.Unlock()
+
+	names := make([]string, 0, len(externalFactories.factories))
+	for name := range externalFactories.factories {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func externalDriverEnabled(cfg Config, name string) bool {
+	for _, external := range strings.Split(cfg.GetString("telemetry.external"), ",") {
+		if strings.TrimSpace(external) == name {
+			return true
+		}
+	}
+
+	return driverEnabled(cfg, name)
+}
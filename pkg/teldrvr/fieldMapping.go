@@ -0,0 +1,173 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// FieldMapper renames, adds or removes fields of a single JSON log record
+type FieldMapper func(fields map[string]any)
+
+// FieldProfileFactory creates the field mapper of an output profile based on the configuration
+type FieldProfileFactory func(cfg Config) FieldMapper
+
+// fieldProfiles holds all available output profiles for JSON based log drivers
+var fieldProfiles = struct {
+	factories map[string]FieldProfileFactory
+	mutex     sync.RWMutex
+}{
+	factories: map[string]FieldProfileFactory{
+		fieldProfileECS:     ecsFieldMapper,
+		fieldProfileDatadog: datadogFieldMapper,
+	},
+}
+
+// RegisterFieldProfile makes a vendor specific field profile available for the JSON based log drivers.
+// Profiles are resolved when the first transaction of a driver is initialized.
+func RegisterFieldProfile(name string, factory FieldProfileFactory) error {
+	if factory == nil {
+		return fmt.Errorf("can not register field profile '%s' without factory", name)
+	}
+
+	fieldProfiles.mutex.Lock()
+	defer fieldProfiles.mutex.Unlock()
+
+	if _, ok := fieldProfiles.factories[name]; ok {
+		return fmt.Errorf("field profile '%s' is already registered", name)
+	}
+
+	fieldProfiles.factories[name] = factory
+
+	return nil
+}
+
+// FieldMappersFromConfig creates the mappers for the comma separated list of profiles. They are applied in order.
+func FieldMappersFromConfig(cfg Config, key string) ([]FieldMapper, error) {
+	var mappers []FieldMapper
+
+	fieldProfiles.mutex.RLock()
+	defer fieldProfiles.mutex.RUnlock()
+
+	for _, profile := range strings.Split(cfg.GetString(key), ",") {
+		profile = strings.TrimSpace(profile)
+		if len(profile) == 0 {
+			continue
+		}
+
+		factory, ok := fieldProfiles.factories[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown field profile »%s« in %s", profile, key)
+		}
+
+		mappers = append(mappers, factory(cfg))
+	}
+
+	return mappers, nil
+}
+
+// fieldMapping resolves the field profiles of a driver once and wraps its output
+type fieldMapping struct {
+	driver    string
+	configKey string
+	once      sync.Once
+	mappers   []FieldMapper
+}
+
+func newFieldMapping(driver string, configKey string) *fieldMapping {
+	return &fieldMapping{
+		driver:    driver,
+		configKey: configKey,
+	}
+}
+
+// writer returns the output wrapped by the configured field profiles
+func (m *fieldMapping) writer(output io.Writer) io.Writer {
+	m.once.Do(func() {
+		cfg, err := GetConfig()
+		if err != nil {
+			handleError(err)
+			return
+		}
+
+		m.mappers, err = FieldMappersFromConfig(cfg, m.configKey)
+		if err != nil {
+			handleError(fmt.Errorf("%s%s ignores the field profile: %w", telemetry.TelemetryDriverError, m.driver, err))
+		}
+	})
+
+	if len(m.mappers) == 0 {
+		return output
+	}
+
+	return fieldMappingWriter{
+		next:    output,
+		mappers: m.mappers,
+	}
+}
+
+// renameFields returns a mapper that renames the fields according to the mapping (old name => new name)
+func renameFields(mapping map[string]string) FieldMapper {
+	return func(fields map[string]any) {
+		for oldName, newName := range mapping {
+			value, ok := fields[oldName]
+			if !ok {
+				continue
+			}
+
+			delete(fields, oldName)
+			fields[newName] = value
+		}
+	}
+}
+
+// firstStringField returns the first non empty string value of the given field names.
+// This allows profiles to work on top of profiles that renamed the fields before.
+func firstStringField(fields map[string]any, names ...string) string {
+	for _, name := range names {
+		if value, ok := fields[name].(string); ok && len(value) > 0 {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// fieldMappingWriter applies the field mappers to every JSON record before it is written to the next writer.
+// Every call of Write is expected to contain exactly one JSON object, which is how zerolog writes its events.
+type fieldMappingWriter struct {
+	next    io.Writer
+	mappers []FieldMapper
+}
+
+// Write maps the fields of the record. Records that are no JSON objects are passed through unchanged.
+func (w fieldMappingWriter) Write(p []byte) (int, error) {
+	fields := make(map[string]any)
+
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return w.next.Write(p)
+	}
+
+	for _, mapper := range w.mappers {
+		mapper(fields)
+	}
+
+	mapped, err := json.Marshal(fields)
+	if err != nil {
+		return w.next.Write(p)
+	}
+
+	_, err = w.next.Write(append(mapped, '\n'))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,58 @@
+package teldrvr
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const fieldProfileDatadog = "datadog"
+
+// datadogFieldMapper adds the fields datadog uses to correlate logs with APM traces
+// (https://docs.datadoghq.com/tracing/other_telemetry/connect_logs_and_traces/)
+func datadogFieldMapper(cfg Config) FieldMapper {
+	service := cfg.GetString("telemetry.app")
+	env := cfg.GetString("telemetry.datadog.env")
+	version := cfg.GetString("telemetry.datadog.version")
+
+	return func(fields map[string]any) {
+		if traceID := firstStringField(fields, "traceID", "trace.id"); len(traceID) > 0 {
+			fields["dd.trace_id"] = datadogID(traceID)
+		}
+
+		if segmentID := firstStringField(fields, "segmentID", "span.id"); len(segmentID) > 0 {
+			fields["dd.span_id"] = datadogID(segmentID)
+		}
+
+		if len(service) > 0 {
+			fields["dd.service"] = service
+		}
+
+		if len(env) > 0 {
+			fields["dd.env"] = env
+		}
+
+		if len(version) > 0 {
+			fields["dd.version"] = version
+		}
+	}
+}
+
+// datadogID converts an ID into the unsigned 64 bit decimal representation datadog expects.
+// Hex IDs (trace IDs, UUIDs) use their lower 64 bits, any other ID is hashed.
+func datadogID(id string) string {
+	hexID := strings.ReplaceAll(id, "-", "")
+	if len(hexID) >= 16 {
+		decoded, err := hex.DecodeString(hexID[len(hexID)-16:])
+		if err == nil {
+			return strconv.FormatUint(binary.BigEndian.Uint64(decoded), 10)
+		}
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+
+	return strconv.FormatUint(hash.Sum64(), 10)
+}
@@ -0,0 +1,68 @@
+package teldrvr
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fieldProfileECS = "ecs"
+
+// ecsFieldNames maps the fields of the log drivers to the Elastic Common Schema
+var ecsFieldNames = map[string]string{
+	"time":         "@timestamp",
+	"level":        "log.level",
+	"traceID":      "trace.id",
+	"processID":    "transaction.id",
+	"segmentID":    "span.id",
+	"action":       "event.action",
+	"goroutine":    "process.thread.id",
+	"duration":     "event.duration",
+	"segmentCount": "transaction.span_count",
+	"errorCount":   "transaction.error_count",
+	"outcome":      "event.outcome",
+}
+
+// ecsFieldMapper renames the fields to the Elastic Common Schema (https://www.elastic.co/guide/en/ecs/current/index.html)
+func ecsFieldMapper(cfg Config) FieldMapper {
+	serviceName := cfg.GetString("telemetry.app")
+	rename := renameFields(ecsFieldNames)
+
+	return func(fields map[string]any) {
+		// zerolog writes durations in milliseconds, ECS expects nanoseconds
+		if duration, ok := fields["duration"].(json.Number); ok {
+			milliseconds, err := duration.Float64()
+			if err == nil {
+				fields["duration"] = int64(milliseconds * float64(time.Millisecond))
+			}
+		}
+
+		rename(fields)
+
+		fields["ecs.version"] = "8.11.0"
+		if len(serviceName) > 0 {
+			fields["service.name"] = serviceName
+		}
+
+		if fields["log.level"] == "error" {
+			fields["error.message"] = fields["message"]
+		}
+
+		// file:line is split into the two ECS fields
+		if caller, ok := fields["caller"].(string); ok {
+			delete(fields, "caller")
+			separator := strings.LastIndex(caller, ":")
+			if separator < 0 {
+				fields["log.origin.file.name"] = caller
+				return
+			}
+
+			fields["log.origin.file.name"] = caller[:separator]
+			line, err := strconv.Atoi(caller[separator+1:])
+			if err == nil {
+				fields["log.origin.file.line"] = line
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package teldrvr
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// idFormatConfigKey selects the generator of the trace, process and segment IDs created by the drivers
+const idFormatConfigKey = "telemetry.idFormat"
+
+// ID formats of telemetry.idFormat
+const (
+	IDFormatUUIDv1   = "uuidv1"
+	IDFormatUUIDv4   = "uuidv4"
+	IDFormatUUIDv7   = "uuidv7"
+	IDFormatSequence = "sequence"
+)
+
+// idFormats are the allowed values of telemetry.idFormat
+var idFormats = []string{IDFormatUUIDv1, IDFormatUUIDv4, IDFormatUUIDv7, IDFormatSequence}
+
+// IDGenerator returns a new unique ID
+type IDGenerator func() (string, error)
+
+// NewUUIDv1 returns a time based UUID including the node ID, it is the default
+func NewUUIDv1() (string, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// NewUUIDv4 returns a random UUID
+func NewUUIDv4() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// NewUUIDv7 returns a UUID starting with the unix milliseconds of the clock, so IDs created later sort after earlier
+// ones, e.g. for the indexes of database sinks
+func NewUUIDv7() (string, error) {
+	var id uuid.UUID
+	_, err := rand.Read(id[6:])
+	if err != nil {
+		return "", err
+	}
+
+	var milliseconds [8]byte
+	binary.BigEndian.PutUint64(milliseconds[:], uint64(clockNow().UnixMilli()))
+	copy(id[:6], milliseconds[2:])
+
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+
+	return id.String(), nil
+}
+
+// NewSequenceIDGenerator returns a generator of deterministic IDs for tests. The IDs count up from 1 and are formatted
+// as UUID, e.g. 00000000-0000-0000-0000-000000000001, so they pass the validation of the backends.
+func NewSequenceIDGenerator() IDGenerator {
+	var sequence atomic.Uint64
+
+	return func() (string, error) {
+		var id uuid.UUID
+		binary.BigEndian.PutUint64(id[8:], sequence.Add(1))
+
+		return id.String(), nil
+	}
+}
+
+var idGenerator = struct {
+	generator IDGenerator
+	// configured is set on the first ID, SetIDGenerator overrides it
+	configured bool
+	mutex      sync.RWMutex
+}{}
+
+// SetIDGenerator sets the generator of the trace, process and segment IDs, e.g. NewSequenceIDGenerator in tests.
+// nil restores the generator of telemetry.idFormat.
+func SetIDGenerator(generator IDGenerator) {
+	idGenerator.mutex.Lock()
+	idGenerator.generator = generator
+	idGenerator.configured = generator != nil
+	idGenerator.mutex.Unlock()
+}
+
+// currentIDGenerator returns the generator set by SetIDGenerator, without one the generator of telemetry.idFormat
+func currentIDGenerator() IDGenerator {
+	idGenerator.mutex.RLock()
+	generator, configured := idGenerator.generator, idGenerator.configured
+	idGenerator.mutex.RUnlock()
+
+	if configured {
+		return generator
+	}
+
+	generator = configuredIDGenerator(viper.GetViper())
+
+	idGenerator.mutex.Lock()
+	defer idGenerator.mutex.Unlock()
+
+	// a generator set in the meantime wins
+	if idGenerator.configured {
+		return idGenerator.generator
+	}
+	idGenerator.generator = generator
+	idGenerator.configured = true
+
+	return generator
+}
+
+// configuredIDGenerator returns the generator of telemetry.idFormat, uuidv1 by default
+func configuredIDGenerator(cfg Config) IDGenerator {
+	switch format := cfg.GetString(idFormatConfigKey); format {
+	case "", IDFormatUUIDv1:
+		return NewUUIDv1
+	case IDFormatUUIDv4:
+		return NewUUIDv4
+	case IDFormatUUIDv7:
+		return NewUUIDv7
+	case IDFormatSequence:
+		return NewSequenceIDGenerator()
+	default:
+		handleError(fmt.Errorf("%s%s »%s« has to be one of %s, %s, %s, %s, falling back to %s", telemetry.TelemetryDriverError,
+			idFormatConfigKey, format, IDFormatUUIDv1, IDFormatUUIDv4, IDFormatUUIDv7, IDFormatSequence, IDFormatUUIDv1))
+		return NewUUIDv1
+	}
+}
+
+// newID returns a new ID of the configured generator
+func newID() (string, error) {
+	return currentIDGenerator()()
+}
+
+// newIDString returns a new ID of the configured generator, if it fails a random UUID is returned instead
+func newIDString() string {
+	id, err := newID()
+	if err != nil {
+		handleError(fmt.Errorf("%sid could not be generated, using a random UUID: %w", telemetry.TelemetryDriverError, err))
+		return uuid.NewString()
+	}
+
+	return id
+}
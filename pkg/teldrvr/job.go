@@ -0,0 +1,70 @@
+package teldrvr
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// job outcomes recorded in the job.outcome attribute
+const (
+	jobOutcomeSuccess = "success"
+	jobOutcomeError   = "error"
+	jobOutcomePanic   = "panic"
+)
+
+// JobFunc is one execution of a job, it logs into the transaction of the execution
+type JobFunc func(transaction telemetry.Transaction) error
+
+// RunJob runs the job in a new transaction of all active drivers, e.g. for scheduled sync jobs. The transaction gets
+// the attributes job.duration.ms and job.outcome (success, error or panic). A returned error is logged in the
+// transaction, a panic is recovered, logged with its stack and returned as error. Afterwards the queued events of all
+// drivers are flushed, so short-lived jobs do not lose them.
+func RunJob(name string, job JobFunc) (err error) {
+	transaction, startErr := StartActiveTransaction(name)
+	if startErr != nil {
+		handleError(fmt.Errorf("%sjob %s: %w", telemetry.TelemetryDriverError, name, startErr))
+	}
+
+	trace, traceErr := transaction.CreateTrace()
+	if traceErr == nil {
+		traceErr = transaction.SetTrace(trace)
+	}
+	reportJobError(name, traceErr)
+
+	start := clockNow()
+	outcome := jobOutcomeSuccess
+
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			outcome = jobOutcomePanic
+			err = fmt.Errorf("job %s panicked: %v", name, recovered)
+			reportJobError(name, Errorf(transaction, "", "%s\n%s", err, debug.Stack()))
+		}
+
+		reportJobError(name, transaction.AddTransactionAttribute("job.duration.ms", float64(clockSince(start))/float64(time.Millisecond)))
+		reportJobError(name, transaction.AddTransactionAttribute("job.outcome", outcome))
+		reportJobError(name, transaction.Done())
+		transaction.Erase()
+
+		reportJobError(name, FlushDrivers())
+	}()
+
+	err = job(transaction)
+	if err != nil {
+		outcome = jobOutcomeError
+		reportJobError(name, ErrorMsg(transaction, "", err))
+	}
+
+	return err
+}
+
+// reportJobError passes telemetry errors of the job to the error handler, they do not fail the job
+func reportJobError(name string, err error) {
+	if err != nil {
+		handleError(fmt.Errorf("%sjob %s: %w", telemetry.TelemetryDriverError, name, err))
+	}
+}
@@ -0,0 +1,44 @@
+package teldrvr
+
+import "github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+
+// LevelChecker is implemented by all transactions that drop messages below the log level of their segments
+type LevelChecker interface {
+	IsLevelEnabled(segmentID string, level string) bool
+}
+
+// IsLevelEnabled reports whether the transaction logs a message of the level in the segment, e.g. to skip building a
+// debug dump of a large payload. Transactions that do not report their levels are enabled for all levels.
+func IsLevelEnabled(transaction telemetry.Transaction, segmentID string, level string) bool {
+	checker, ok := findTransaction[LevelChecker](transaction)
+	if !ok {
+		return true
+	}
+
+	return checker.IsLevelEnabled(segmentID, level)
+}
+
+// InfoLazy logs the info message returned by the function, the function is only called if info messages are logged
+// in the segment:
+//
+//	err := teldrvr.InfoLazy(transaction, segmentID, func() string {
+//		payload, _ := json.Marshal(order)
+//		return string(payload)
+//	})
+func InfoLazy(transaction telemetry.Transaction, segmentID string, message func() string) error {
+	if !IsLevelEnabled(transaction, segmentID, LevelInfo) {
+		return nil
+	}
+
+	return InfoMsg(transaction, segmentID, message())
+}
+
+// DebugLazy logs the debug message returned by the function, the function is only called if debug messages are
+// logged in the segment
+func DebugLazy(transaction telemetry.Transaction, segmentID string, message func() string) error {
+	if !IsLevelEnabled(transaction, segmentID, LevelDebug) {
+		return nil
+	}
+
+	return DebugMsg(transaction, segmentID, message())
+}
@@ -0,0 +1,250 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// levelBoostConfigKey schedules a level boost window, e.g.
+// telemetry.levelBoost: {level: debug, from: "2026-10-16T08:00:00Z", until: "2026-10-16T08:15:00Z"}
+const levelBoostConfigKey = "telemetry.levelBoost"
+
+// levelBoostTransaction is the name of the transactions that record the start and the end of a boost window
+const levelBoostTransaction = "telemetry.levelBoost"
+
+// attributes of the levelBoostTransaction
+const (
+	levelBoostLevelAttribute = "levelBoost.level"
+	levelBoostFromAttribute  = "levelBoost.from"
+	levelBoostUntilAttribute = "levelBoost.until"
+	levelBoostStateAttribute = "levelBoost.state"
+)
+
+// states of the boost window recorded by the levelBoostTransaction
+const (
+	levelBoostStarted = "started"
+	levelBoostEnded   = "ended"
+)
+
+// levelBoostWindow raises the log level of all segments to its level between from and until
+type levelBoostWindow struct {
+	level string
+	from  time.Time
+	until time.Time
+	// stop ends the goroutine recording the start and the end when the window is replaced
+	stop chan struct{}
+}
+
+// active reports whether the window covers the time
+func (w *levelBoostWindow) active(now time.Time) bool {
+	return !now.Before(w.from) && now.Before(w.until)
+}
+
+// levelBoost holds the current boost window, nil if no boost is scheduled
+var levelBoost = struct {
+	window *levelBoostWindow
+	once   sync.Once
+	mutex  sync.RWMutex
+}{}
+
+// BoostLogLevel raises the log level of all drivers to the level for the duration, e.g. to debug for 15 minutes while
+// investigating an incident. The level reverts automatically, the start and the end are recorded as transaction
+// telemetry.levelBoost in every active driver. A boost replaces the previous one.
+func BoostLogLevel(level string, duration time.Duration) error {
+	now := clockNow()
+
+	return ScheduleLogLevelBoost(level, now, now.Add(duration))
+}
+
+// ScheduleLogLevelBoost raises the log level of all drivers to the level between from and until, see BoostLogLevel
+func ScheduleLogLevelBoost(level string, from time.Time, until time.Time) error {
+	if _, ok := logLevelRanks[level]; !ok {
+		return fmt.Errorf("log level »%s« has to be one of %s, %s, %s", level, logLevelDebug, logLevelInfo, logLevelError)
+	}
+
+	if !until.After(from) {
+		return errors.New("the end of the level boost has to be after its start")
+	}
+
+	loadLevelBoost()
+	scheduleLevelBoost(&levelBoostWindow{
+		level: level,
+		from:  from,
+		until: until,
+		stop:  make(chan struct{}),
+	})
+
+	return nil
+}
+
+// EndLogLevelBoost reverts the log level immediately, an active boost records its end
+func EndLogLevelBoost() {
+	loadLevelBoost()
+	scheduleLevelBoost(nil)
+}
+
+// scheduleLevelBoost replaces the current window, the end of an active window is recorded
+func scheduleLevelBoost(window *levelBoostWindow) {
+	levelBoost.mutex.Lock()
+	previous := levelBoost.window
+	levelBoost.window = window
+	levelBoost.mutex.Unlock()
+
+	if previous != nil {
+		close(previous.stop)
+		if previous.active(clockNow()) {
+			go recordLevelBoost(previous, levelBoostEnded)
+		}
+	}
+
+	if window != nil {
+		go runLevelBoost(window)
+	}
+}
+
+// runLevelBoost records the start and the end of the window and removes it after its end
+func runLevelBoost(window *levelBoostWindow) {
+	if !waitForLevelBoost(window.from, window.stop) {
+		return
+	}
+	recordLevelBoost(window, levelBoostStarted)
+
+	if !waitForLevelBoost(window.until, window.stop) {
+		return
+	}
+
+	levelBoost.mutex.Lock()
+	current := levelBoost.window == window
+	if current {
+		levelBoost.window = nil
+	}
+	levelBoost.mutex.Unlock()
+
+	if current {
+		recordLevelBoost(window, levelBoostEnded)
+	}
+}
+
+// waitForLevelBoost waits on the clock of the drivers until the time, false if the window was replaced before
+func waitForLevelBoost(at time.Time, stop chan struct{}) bool {
+	wait := at.Sub(clockNow())
+	if wait <= 0 {
+		return true
+	}
+
+	ticker := CurrentClock().NewTicker(wait)
+	defer ticker.Stop()
+
+	select {
+	case <-stop:
+		return false
+	case <-ticker.C():
+		return true
+	}
+}
+
+// recordLevelBoost records the state of the window in a transaction of every active driver
+func recordLevelBoost(window *levelBoostWindow, state string) {
+	for _, name := range RegisteredDrivers() {
+		if !IsDriverActive(name) {
+			continue
+		}
+
+		driver, ok := RegisteredDriver(name)
+		if !ok {
+			continue
+		}
+
+		err := recordDriverLevelBoost(driver, window, state)
+		if err != nil {
+			handleError(fmt.Errorf("%slevel boost could not be recorded by driver %s: %w", telemetry.TelemetryDriverError, name, err))
+		}
+	}
+}
+
+func recordDriverLevelBoost(driver telemetry.Driver, window *levelBoostWindow, state string) error {
+	transaction, err := driver.InitializeTransaction(levelBoostTransaction)
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]any{
+		levelBoostLevelAttribute: window.level,
+		levelBoostFromAttribute:  window.from.Format(time.RFC3339),
+		levelBoostUntilAttribute: window.until.Format(time.RFC3339),
+		levelBoostStateAttribute: state,
+	}
+	for key, value := range attributes {
+		err = transaction.AddTransactionAttribute(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return transaction.Done()
+}
+
+// loadLevelBoost schedules the window of telemetry.levelBoost once, an invalid window is reported and ignored
+func loadLevelBoost() {
+	levelBoost.once.Do(func() {
+		cfg := viper.GetViper()
+		level := cfg.GetString(levelBoostConfigKey + ".level")
+		if len(level) == 0 {
+			return
+		}
+
+		from := clockNow()
+		if len(cfg.GetString(levelBoostConfigKey+".from")) > 0 {
+			from = cfg.GetTime(levelBoostConfigKey + ".from")
+		}
+		until := cfg.GetTime(levelBoostConfigKey + ".until")
+
+		if _, ok := logLevelRanks[level]; !ok || !until.After(from) {
+			handleError(fmt.Errorf("%s%s is ignored, it needs a level of %s, %s, %s and an until after from",
+				telemetry.TelemetryDriverError, levelBoostConfigKey, logLevelDebug, logLevelInfo, logLevelError))
+			return
+		}
+
+		// the window has passed already, e.g. after a restart
+		if !until.After(clockNow()) {
+			return
+		}
+
+		scheduleLevelBoost(&levelBoostWindow{
+			level: level,
+			from:  from,
+			until: until,
+			stop:  make(chan struct{}),
+		})
+	})
+}
+
+// validateLevelBoostTime checks the start or the end of the configured window is a time, e.g. 2026-10-16T08:00:00Z
+func validateLevelBoostTime(value string) error {
+	_, err := cast.ToTimeE(value)
+	if err != nil {
+		return fmt.Errorf("»%s« is no valid time", value)
+	}
+
+	return nil
+}
+
+// boostedLogLevel returns the level of the active boost window, empty without boost
+func boostedLogLevel() string {
+	loadLevelBoost()
+
+	levelBoost.mutex.RLock()
+	defer levelBoost.mutex.RUnlock()
+
+	if levelBoost.window == nil || !levelBoost.window.active(clockNow()) {
+		return ""
+	}
+
+	return levelBoost.window.level
+}
@@ -0,0 +1,149 @@
+package teldrvr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// levelOverridesConfigKey maps segment name patterns to a log level, e.g. telemetry.levelOverrides: {"db.*": debug}
+const levelOverridesConfigKey = "telemetry.levelOverrides"
+
+// logLevelRanks orders the log levels, messages below the level of their segment are dropped
+var logLevelRanks = map[string]int{
+	logLevelDebug: 0,
+	logLevelInfo:  1,
+	logLevelError: 2,
+}
+
+// levelOverride sets the log level of all segments whose name matches the pattern
+type levelOverride struct {
+	pattern    string
+	expression *regexp.Regexp
+	level      string
+}
+
+// levelOverrides holds the overrides sorted by specificity and the resolved level of every segment name seen so far
+var levelOverrides = struct {
+	overrides []levelOverride
+	levels    map[string]string
+	once      sync.Once
+	mutex     sync.RWMutex
+}{
+	levels: make(map[string]string),
+}
+
+// readLevelOverrides reads the overrides from the config, viper splits the patterns at their dots into nested maps.
+// The patterns are matched case-insensitive because viper lowercases the keys. Overrides with an unknown level are
+// skipped and returned as issues.
+func readLevelOverrides(cfg Config) (map[string]string, []ConfigIssue) {
+	overrides := make(map[string]string)
+	var issues []ConfigIssue
+
+	var walk func(pattern string, value any)
+	walk = func(pattern string, value any) {
+		nested, err := cast.ToStringMapE(value)
+		if err == nil {
+			for key, nestedValue := range nested {
+				if len(pattern) > 0 {
+					key = pattern + "." + key
+				}
+				walk(key, nestedValue)
+			}
+			return
+		}
+
+		level := strings.ToLower(strings.TrimSpace(cast.ToString(value)))
+		if _, ok := logLevelRanks[level]; !ok {
+			issues = append(issues, ConfigIssue{
+				Key:    levelOverridesConfigKey + "." + pattern,
+				Reason: fmt.Sprintf("»%v« has to be one of %s, %s, %s", value, logLevelDebug, logLevelInfo, logLevelError),
+			})
+			return
+		}
+		overrides[pattern] = level
+	}
+
+	if cfg.IsSet(levelOverridesConfigKey) {
+		walk("", cfg.Get(levelOverridesConfigKey))
+	}
+
+	return overrides, issues
+}
+
+// loadLevelOverrides compiles the overrides once, the longest pattern is the most specific and wins
+func loadLevelOverrides() {
+	levelOverrides.once.Do(func() {
+		overrides, issues := readLevelOverrides(viper.GetViper())
+		if len(issues) > 0 {
+			handleError(fmt.Errorf("%sinvalid level overrides are ignored: %w", telemetry.TelemetryDriverError, &ConfigError{Issues: issues}))
+		}
+
+		compiled := make([]levelOverride, 0, len(overrides))
+		for pattern, level := range overrides {
+			expression := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+			compiled = append(compiled, levelOverride{
+				pattern:    pattern,
+				expression: regexp.MustCompile(expression),
+				level:      level,
+			})
+		}
+
+		sort.Slice(compiled, func(i, j int) bool {
+			if len(compiled[i].pattern) != len(compiled[j].pattern) {
+				return len(compiled[i].pattern) > len(compiled[j].pattern)
+			}
+			return compiled[i].pattern < compiled[j].pattern
+		})
+
+		levelOverrides.mutex.Lock()
+		levelOverrides.overrides = compiled
+		levelOverrides.mutex.Unlock()
+	})
+}
+
+// segmentLogLevel returns the log level of the segment, the global log level applies if no override matches.
+// The level of a segment name is resolved when the first segment with the name starts and kept afterwards.
+func segmentLogLevel(segmentName string) string {
+	if len(segmentName) == 0 {
+		return logLevel
+	}
+
+	loadLevelOverrides()
+
+	levelOverrides.mutex.RLock()
+	level, ok := levelOverrides.levels[segmentName]
+	levelOverrides.mutex.RUnlock()
+	if ok {
+		return level
+	}
+
+	level = logLevel
+	levelOverrides.mutex.Lock()
+	for _, override := range levelOverrides.overrides {
+		if override.expression.MatchString(segmentName) {
+			level = override.level
+			break
+		}
+	}
+	levelOverrides.levels[segmentName] = level
+	levelOverrides.mutex.Unlock()
+
+	return level
+}
+
+// messageEnabled reports whether a message of the level is logged in the segment, an empty name means no segment.
+// An active level boost enables the messages of its level in all segments, see BoostLogLevel.
+func messageEnabled(level string, segmentName string) bool {
+	if boost := boostedLogLevel(); len(boost) > 0 && logLevelRanks[level] >= logLevelRanks[boost] {
+		return true
+	}
+
+	return logLevelRanks[level] >= logLevelRanks[segmentLogLevel(segmentName)]
+}
@@ -5,87 +5,244 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
 )
 
 /** DRIVER NAME **/
 const localDriver = "local"
 
+const localOutputStdout = "stdout"
+const localOutputStderr = "stderr"
+
+// localConfigKeys are the settings below telemetry.drivers.local
+var localConfigKeys = []ConfigKey{
+	{Name: "format", Default: localFormatPlain, Values: []string{localFormatPlain, localFormatPretty}},
+	{Name: "output", Default: localOutputStdout},
+	{Name: "errorOutput", Values: []string{localOutputStdout, localOutputStderr}},
+	{Name: "compression", Default: compressionNone, Values: compressionValues},
+}
+
 func init() {
 	cfg, err := GetConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	configLogLevel := cfg.GetString("telemetry.logLevel")
-	switch configLogLevel {
-	case logLevelDebug:
-		logLevel = logLevelDebug
-		break
-	case logLevelError:
-		logLevel = logLevelError
-		break
-	case logLevelInfo:
-		logLevel = logLevelInfo
+	RegisterDriverConfig(localDriver, localConfigKeys...)
+
+	logLevel = configuredLogLevel(cfg)
+
+	callerEnabled = cfg.GetBool("telemetry.caller.enabled")
+
+	registerDriver(localDriver, configuredLocalDriver(cfg))
+}
+
+// localDriverSetup holds the driver built from telemetry.drivers.local, the New Relic drivers fall back to it
+var localDriverSetup = struct {
+	driver LocalDriver
+	once   sync.Once
+}{}
+
+// configuredLocalDriver returns the driver of the telemetry.drivers.local settings. It is built once, so the output file
+// is only opened once, also if a New Relic driver falls back to it.
+func configuredLocalDriver(cfg Config) LocalDriver {
+	localDriverSetup.once.Do(func() {
+		localDriverSetup.driver = newConfiguredLocalDriver(cfg)
+	})
+
+	return localDriverSetup.driver
+}
+
+// newConfiguredLocalDriver builds the driver of the telemetry.drivers.local settings
+func newConfiguredLocalDriver(cfg Config) LocalDriver {
+	// the local driver is the fallback of all other drivers, so invalid settings are only reported
+	err := ValidateDriverConfig(cfg, localDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w", telemetry.TelemetryDriverError, err))
+	}
+
+	driverCfg := DriverConfig(cfg, localDriver)
+
+	format := driverCfg.GetString("format")
+	if format != localFormatPlain && format != localFormatPretty {
+		log.Printf("Got unknown local format »%s« from config. Fallback to plain format", format)
+		format = localFormatPlain
+	}
+
+	driver := LocalDriver{
+		Format: format,
+	}
+
+	output := driverCfg.GetString("output")
+	switch output {
+	case "", localOutputStdout:
+		// default output of the std logger and stdout
 		break
+	case localOutputStderr:
+		driver.Writer = os.Stderr
 	default:
-		log.Println("Got unknown log level from config. Fallback to error level")
-		logLevel = logLevelError
+		file, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			handleError(fmt.Errorf("%s%s could not open output file »%s«, fallback to default output: %w", telemetry.TelemetryDriverError, localDriver, output, err))
+			break
+		}
+		driver.Writer = file
+
+		// only files are compressed, the console output has to stay readable
+		compression := driverCfg.GetString("compression")
+		if !compressionEnabled(compression) {
+			if err := validateCompression(compression); err != nil {
+				handleError(fmt.Errorf("%s%s %w, output is not compressed", telemetry.TelemetryDriverError, localDriver, err))
+			}
+			break
+		}
+
+		writer, err := newStreamCompressionWriter(compression, file)
+		if err != nil {
+			handleError(fmt.Errorf("%s%s output is not compressed: %w", telemetry.TelemetryDriverError, localDriver, err))
+			break
+		}
+		driver.Writer = writer
 	}
 
-	driver := LocalDriver{}
+	driver.ErrorWriter = consoleOutput(driverCfg.GetString("errorOutput"))
 
-	telemetry.RegisterDriver(localDriver, driver)
+	return driver
+}
+
+// consoleOutput returns the stream of the output setting, nil if the output is not set
+func consoleOutput(output string) io.Writer {
+	switch output {
+	case localOutputStdout:
+		return os.Stdout
+	case localOutputStderr:
+		return os.Stderr
+	}
+
+	return nil
 }
 
 // LocalDriver holds all information the driver needs for telemetry
-type LocalDriver struct{}
+type LocalDriver struct {
+	// Format is either plain (default) or pretty for a colored console output
+	Format string
+	// Writer receives all output of the driver. If nil, the std logger and stdout are used
+	Writer io.Writer
+	// ErrorWriter receives the errors instead of Writer, e.g. stderr while the other messages go to stdout
+	ErrorWriter io.Writer
+}
 
 // InitializeTransaction starts a transaction
 func (d LocalDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
-	transaction := newLocalTransaction(name)
+	transaction := newLocalTransaction(name, d.Format)
+	if d.Writer != nil {
+		writer := localWriter{writer: d.Writer}
+		transaction.logger = log.New(writer, "", log.LstdFlags)
+		transaction.out = writer
+	}
+
+	// the std logger is shared by the whole process, so only the logger of the transaction gets the timestamp.
+	// It replaces the log.LstdFlags in any case, so the time is read from the clock of the drivers.
+	transaction.logger = log.New(timestampWriter{writer: transaction.logger.Writer(), timestamp: configuredTimestamp()}, "", 0)
+
+	transaction.errorLogger = transaction.logger
+	transaction.errorOut = transaction.out
+	if d.ErrorWriter != nil {
+		writer := localWriter{writer: d.ErrorWriter}
+		transaction.errorLogger = log.New(timestampWriter{writer: writer, timestamp: configuredTimestamp()}, "", 0)
+		transaction.errorOut = writer
+	}
+
 	return transaction, nil
 }
 
-// LocalSegmentContainer used for segment handling
+// localWriterMutex serializes the writes of all local transactions to a custom writer
+var localWriterMutex sync.Mutex
+
+// localWriter writes every message of the local driver with a single locked write, so multi-line messages like
+// stack traces are not interleaved with the output of other goroutines. Writers like files or compression writers
+// are not safe for concurrent use, the std logger and stdout already serialize their writes.
+type localWriter struct {
+	writer io.Writer
+}
+
+func (w localWriter) Write(p []byte) (int, error) {
+	localWriterMutex.Lock()
+	defer localWriterMutex.Unlock()
+
+	return w.writer.Write(p)
+}
+
+// LocalSegmentContainer used for segment handling, the segments are sharded by their ID
 type LocalSegmentContainer struct {
+	shards [segmentShardCount]localSegmentShard
+	open   atomic.Int64 // number of open segments
+}
+
+// localSegmentShard holds the segments whose ID belongs to the shard, the maps are created on first use
+type localSegmentShard struct {
 	segments               map[string]string
 	attributes             map[string]map[string]any
 	mutex                  sync.RWMutex
 	segmentsStartWasLogged map[string]struct{}
+	depths                 map[string]int // number of segments that were open when the segment started
+}
+
+// shard returns the shard of the segment
+func (c *LocalSegmentContainer) shard(segmentID string) *localSegmentShard {
+	return &c.shards[segmentShardIndex(segmentID)]
 }
 
 // LocalTransaction used for local transactions
 type LocalTransaction struct {
 	transaction      string
+	format           string
+	logger           *log.Logger
+	out              io.Writer
+	errorLogger      *log.Logger
+	errorOut         io.Writer
+	startTime        time.Time
+	segmentCount     atomic.Int64
+	errorCount       atomic.Int64
+	infoCount        atomic.Int64
+	debugCount       atomic.Int64
+	outcome          string
 	segmentContainer LocalSegmentContainer
 	attributes       map[string]any
 	trace            string
 	processID        string
 }
 
-func newLocalTransaction(name string) *LocalTransaction {
+func newLocalTransaction(name string, format string) *LocalTransaction {
 	t := LocalTransaction{
 		transaction: name,
+		format:      format,
+		startTime:   clockNow(),
+		logger:      log.Default(),
+		out:         os.Stdout,
 		attributes:  make(map[string]any),
 	}
-	t.segmentContainer.segments = make(map[string]string)
-	t.segmentContainer.attributes = make(map[string]map[string]any)
-	t.segmentContainer.segmentsStartWasLogged = make(map[string]struct{})
 	return &t
 }
 
 // Start writes the start message for the transaction
 func (t *LocalTransaction) Start(name string) {
+	if t.format == localFormatPretty {
+		t.writePretty("start", "", fmt.Sprintf("Transaction %s", name))
+		return
+	}
 	if t.trace != "" {
-		log.Printf("Transaction %s start: %s \n", t.trace, name)
+		t.logger.Printf("Transaction %s start: %s \n", t.trace, name)
 	}
-	log.Printf("Transaction processID %s start: %s \n", t.processID, name)
+	t.logger.Printf("Transaction processID %s start: %s \n", t.processID, name)
 }
 
 // AddTransactionAttribute adds an attribute to the transaction
@@ -103,14 +260,22 @@ func (t *LocalTransaction) AddTransactionAttribute(key string, value any) error
 
 // SegmentStart starts a local segment and keeps track of all opened segments
 func (t *LocalTransaction) SegmentStart(segmentID string, name string) error {
+	shard := t.segmentContainer.shard(segmentID)
 	var err error
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
-	if t.segmentContainer.segments == nil {
-		t.segmentContainer.segments = make(map[string]string)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.segments == nil {
+		shard.segments = make(map[string]string)
 	}
-	t.segmentContainer.segments[segmentID] = name
-	if logLevel == logLevelDebug {
+	if shard.depths == nil {
+		shard.depths = make(map[string]int)
+	}
+	if _, ok := shard.segments[segmentID]; !ok {
+		shard.depths[segmentID] = int(t.segmentContainer.open.Add(1)) - 1
+	}
+	shard.segments[segmentID] = name
+	t.segmentCount.Add(1)
+	if messageEnabled(logLevelDebug, name) {
 		err = t.segmentWriteStart(segmentID)
 	}
 
@@ -122,16 +287,24 @@ func (t *LocalTransaction) SegmentStart(segmentID string, name string) error {
 }
 
 func (t *LocalTransaction) segmentWriteStart(segmentID string) error {
-	if _, ok := t.segmentContainer.segmentsStartWasLogged[segmentID]; ok {
+	shard := t.segmentContainer.shard(segmentID)
+	if _, ok := shard.segmentsStartWasLogged[segmentID]; ok {
 		return nil
 	}
 	var name string
 	ok := false
-	if name, ok = t.segmentContainer.segments[segmentID]; !ok {
+	if name, ok = shard.segments[segmentID]; !ok {
 		return fmt.Errorf("segment name not found for segmentID: %s", segmentID)
 	}
-	log.Printf("Segment start[%s]: %s \n", segmentID, name)
-	t.segmentContainer.segmentsStartWasLogged[segmentID] = struct{}{}
+	if t.format == localFormatPretty {
+		t.writePretty("start", segmentID, "")
+	} else {
+		t.logger.Printf("Segment start[%s]: %s \n", segmentID, name)
+	}
+	if shard.segmentsStartWasLogged == nil {
+		shard.segmentsStartWasLogged = make(map[string]struct{})
+	}
+	shard.segmentsStartWasLogged[segmentID] = struct{}{}
 
 	return nil
 }
@@ -139,91 +312,114 @@ func (t *LocalTransaction) segmentWriteStart(segmentID string) error {
 // AddSegmentAttribute adds an attribute to the currently open segment
 // - Thread safe -
 func (t *LocalTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	segmentName, segmentExist := t.segmentContainer.segments[segmentID]
+	segmentName, segmentExist := shard.segments[segmentID]
 	if !segmentExist {
 		return fmt.Errorf("can not add attribute to not existing segment.\nSegmentID: %s\nKey: %s\nValue: %s", segmentID, key, value)
 	}
 
-	if t.segmentContainer.attributes == nil {
-		t.segmentContainer.attributes = make(map[string]map[string]any)
+	if shard.attributes == nil {
+		shard.attributes = make(map[string]map[string]any)
 	}
 
-	if t.segmentContainer.attributes[segmentID] == nil {
-		t.segmentContainer.attributes[segmentID] = make(map[string]any)
+	if shard.attributes[segmentID] == nil {
+		shard.attributes[segmentID] = make(map[string]any)
 	}
 
-	attribute, attributeExist := t.segmentContainer.attributes[segmentID][key]
+	attribute, attributeExist := shard.attributes[segmentID][key]
 	if attributeExist {
 		return fmt.Errorf("segment attribute already exist.\nSegment: %s\nSegmentID: %s\nKey: %s\nAlready set value: %v", segmentName, segmentID, key, attribute)
 	}
 
-	t.segmentContainer.attributes[segmentID][key] = value
+	shard.attributes[segmentID][key] = value
 
 	return nil
 }
 
 // SegmentEnd ends the current open segment (LIFO) and keeps track of all opened segments
 func (t *LocalTransaction) SegmentEnd(segmentID string) error {
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
-	_, ok := t.segmentContainer.segments[segmentID]
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	_, ok := shard.segments[segmentID]
 	if !ok {
 		return fmt.Errorf("Error trying to end segment. Segment is not open.\nSegmentID: %s", segmentID)
 	}
 
 	t.segmentWriteEnd(segmentID)
+	t.segmentContainer.open.Add(-1)
 
 	return nil
 }
 
 func (t *LocalTransaction) segmentWriteEnd(segmentID string) error {
-	if _, ok := t.segmentContainer.segmentsStartWasLogged[segmentID]; !ok {
-		delete(t.segmentContainer.segments, segmentID)
-		delete(t.segmentContainer.attributes, segmentID)
+	shard := t.segmentContainer.shard(segmentID)
+	if _, ok := shard.segmentsStartWasLogged[segmentID]; !ok {
+		delete(shard.segments, segmentID)
+		delete(shard.attributes, segmentID)
+		delete(shard.depths, segmentID)
 		return nil
 	}
 
-	name, ok := t.segmentContainer.segments[segmentID]
+	name, ok := shard.segments[segmentID]
 	if !ok {
 		return fmt.Errorf("Error trying to end segment. Segment is not open.\nSegmentID: %s", segmentID)
 	}
 	// todo add the attributes
-	log.Printf("Segment end[%s]: %s\n", segmentID, name)
+	if t.format == localFormatPretty {
+		t.writePretty("end", segmentID, "")
+	} else {
+		t.logger.Printf("Segment end[%s]: %s\n", segmentID, name)
+	}
 
-	delete(t.segmentContainer.segments, segmentID)
-	delete(t.segmentContainer.attributes, segmentID)
-	delete(t.segmentContainer.segmentsStartWasLogged, segmentID)
+	delete(shard.segments, segmentID)
+	delete(shard.attributes, segmentID)
+	delete(shard.depths, segmentID)
+	delete(shard.segmentsStartWasLogged, segmentID)
 
 	return nil
 }
 
-// Error logs errors in the transaction/segment
-func (t *LocalTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
-	t.segmentContainer.mutex.Lock()
+// readLocalMessage reads the message up to the limit and closes the reader. It is called before the shard is locked,
+// so a slow reader does not block the other segments of the shard. A limit of 0 reads the whole message.
+func readLocalMessage(readCloser io.ReadCloser, limit int, severity string) ([]byte, error) {
 	defer func() {
-		t.segmentContainer.mutex.Unlock()
 		closeErr := readCloser.Close()
 		if closeErr != nil {
-			log.Printf("Telemetry driver local could not close reader while logging Info. Potential resource leak!")
+			log.Printf("Telemetry driver local could not close reader while logging %s. Potential resource leak!", severity)
 		}
 	}()
-	t.segmentWriteStart(segmentID)
-	// max bytes available for the error message
-	errMsg := make([]byte, telemetry.ErrorBytesSize)
 
-	bytesRead, err := readCloser.Read(errMsg)
+	return readLogMessage(readCloser, limit)
+}
+
+// Error logs errors in the transaction/segment
+func (t *LocalTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	// max bytes available for the error message
+	errMsg, err := readLocalMessage(readCloser, telemetry.ErrorBytesSize, "Error")
 	if err != nil {
 		return errors.New("error while reading err message")
 	}
 
-	errLog := string(errMsg[:bytesRead])
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	t.segmentWriteStart(segmentID)
+
+	errLog := string(errMsg)
+	t.errorCount.Add(1)
+
+	if t.format == localFormatPretty {
+		t.writePretty(logLevelError, segmentID, errLog)
+		return nil
+	}
 
 	inSegment := false
 	if len(segmentID) > 0 {
-		_, ok := t.segmentContainer.segments[segmentID]
+		_, ok := shard.segments[segmentID]
 		if ok {
 			inSegment = true
 		}
@@ -243,13 +439,27 @@ func (t *LocalTransaction) Error(segmentID string, readCloser io.ReadCloser) err
 	builder.WriteString("\n")
 	if inSegment {
 		builder.WriteString("Segment: ")
-		builder.WriteString(t.segmentContainer.segments[segmentID])
+		builder.WriteString(shard.segments[segmentID])
 		builder.WriteString("\n")
 		builder.WriteString("SegmentID: ")
 		builder.WriteString(segmentID)
 		builder.WriteString("\n")
 		builder.WriteString("Segment-Attributes: ")
-		builder.WriteString(fmt.Sprintf("%+v", t.segmentContainer.attributes[segmentID]))
+		builder.WriteString(fmt.Sprintf("%+v", shard.attributes[segmentID]))
+		builder.WriteString("\n")
+	}
+	group := errorGroup(t.transaction, errLog, shard.attributes[segmentID], t.attributes)
+	if len(group) > 0 {
+		builder.WriteString("Error-Group: ")
+		builder.WriteString(group)
+		builder.WriteString("\n")
+	}
+	if callerEnabled {
+		builder.WriteString("Caller: ")
+		builder.WriteString(callerInfo())
+		builder.WriteString("\n")
+		builder.WriteString("Goroutine: ")
+		builder.WriteString(strconv.FormatUint(goroutineID(), 10))
 		builder.WriteString("\n")
 	}
 	builder.WriteString("Error: ")
@@ -257,35 +467,38 @@ func (t *LocalTransaction) Error(segmentID string, readCloser io.ReadCloser) err
 	builder.WriteString("\n")
 	builder.WriteString("- ERROR END -")
 
-	log.Println(builder.String())
+	t.errorLogger.Println(builder.String())
 
 	return nil
 }
 
 // Info logs information in the transaction
 func (t *LocalTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
-	if logLevel == logLevelError {
+	if !t.IsLevelEnabled(segmentID, logLevelInfo) {
+		_ = readCloser.Close()
 		return nil
 	}
-	t.segmentContainer.mutex.Lock()
-	defer func() {
-		t.segmentContainer.mutex.Unlock()
-		closeErr := readCloser.Close()
-		if closeErr != nil {
-			log.Printf("Telemetry driver local could not close reader while logging Info. Potential resource leak!")
-		}
-	}()
-	t.segmentWriteStart(segmentID)
-	infoMsg, err := io.ReadAll(readCloser)
+	infoMsg, err := readLocalMessage(readCloser, 0, "Info")
 	if err != nil {
 		return errors.New("error while reading info message")
 	}
 
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	t.infoCount.Add(1)
+	t.segmentWriteStart(segmentID)
+
 	infoLog := string(infoMsg)
 
+	if t.format == localFormatPretty {
+		t.writePretty(logLevelInfo, segmentID, infoLog)
+		return nil
+	}
+
 	inSegment := false
 	if len(segmentID) > 0 {
-		_, ok := t.segmentContainer.segments[segmentID]
+		_, ok := shard.segments[segmentID]
 		if ok {
 			inSegment = true
 		}
@@ -305,13 +518,21 @@ func (t *LocalTransaction) Info(segmentID string, readCloser io.ReadCloser) erro
 	builder.WriteString("\n")
 	if inSegment {
 		builder.WriteString("Segment: ")
-		builder.WriteString(t.segmentContainer.segments[segmentID])
+		builder.WriteString(shard.segments[segmentID])
 		builder.WriteString("\n")
 		builder.WriteString("SegmentID: ")
 		builder.WriteString(segmentID)
 		builder.WriteString("\n")
 		builder.WriteString("Segment-Attributes: ")
-		builder.WriteString(fmt.Sprintf("%+v", t.segmentContainer.attributes[segmentID]))
+		builder.WriteString(fmt.Sprintf("%+v", shard.attributes[segmentID]))
+		builder.WriteString("\n")
+	}
+	if callerEnabled {
+		builder.WriteString("Caller: ")
+		builder.WriteString(callerInfo())
+		builder.WriteString("\n")
+		builder.WriteString("Goroutine: ")
+		builder.WriteString(strconv.FormatUint(goroutineID(), 10))
 		builder.WriteString("\n")
 	}
 	builder.WriteString("Message: ")
@@ -319,35 +540,38 @@ func (t *LocalTransaction) Info(segmentID string, readCloser io.ReadCloser) erro
 	builder.WriteString("\n")
 	builder.WriteString("- INFO END -")
 
-	fmt.Println(builder.String())
+	fmt.Fprintln(t.out, builder.String())
 
 	return nil
 }
 
 // Debug logs information in the transaction
 func (t *LocalTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
-	if logLevel != logLevelDebug {
+	if !t.IsLevelEnabled(segmentID, logLevelDebug) {
+		_ = readCloser.Close()
 		return nil
 	}
-	t.segmentContainer.mutex.Lock()
-	defer func() {
-		t.segmentContainer.mutex.Unlock()
-		closeErr := readCloser.Close()
-		if closeErr != nil {
-			log.Printf("Telemetry driver local could not close reader while logging Debug. Potential resource leak!")
-		}
-	}()
-	t.segmentWriteStart(segmentID) // TODO - Discusses the situation in which this returns an error
-	debugMsg, err := io.ReadAll(readCloser)
+	debugMsg, err := readLocalMessage(readCloser, 0, "Debug")
 	if err != nil {
 		return errors.New("error while reading debug message")
 	}
 
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	t.debugCount.Add(1)
+	t.segmentWriteStart(segmentID) // TODO - Discusses the situation in which this returns an error
+
 	debugLog := string(debugMsg)
 
+	if t.format == localFormatPretty {
+		t.writePretty(logLevelDebug, segmentID, debugLog)
+		return nil
+	}
+
 	inSegment := false
 	if len(segmentID) > 0 {
-		_, ok := t.segmentContainer.segments[segmentID]
+		_, ok := shard.segments[segmentID]
 		if ok {
 			inSegment = true
 		}
@@ -367,13 +591,21 @@ func (t *LocalTransaction) Debug(segmentID string, readCloser io.ReadCloser) err
 	builder.WriteString("\n")
 	if inSegment {
 		builder.WriteString("Segment: ")
-		builder.WriteString(t.segmentContainer.segments[segmentID])
+		builder.WriteString(shard.segments[segmentID])
 		builder.WriteString("\n")
 		builder.WriteString("SegmentID: ")
 		builder.WriteString(segmentID)
 		builder.WriteString("\n")
 		builder.WriteString("Segment-Attributes: ")
-		builder.WriteString(fmt.Sprintf("%+v", t.segmentContainer.attributes[segmentID]))
+		builder.WriteString(fmt.Sprintf("%+v", shard.attributes[segmentID]))
+		builder.WriteString("\n")
+	}
+	if callerEnabled {
+		builder.WriteString("Caller: ")
+		builder.WriteString(callerInfo())
+		builder.WriteString("\n")
+		builder.WriteString("Goroutine: ")
+		builder.WriteString(strconv.FormatUint(goroutineID(), 10))
 		builder.WriteString("\n")
 	}
 	builder.WriteString("Message: ")
@@ -381,27 +613,94 @@ func (t *LocalTransaction) Debug(segmentID string, readCloser io.ReadCloser) err
 	builder.WriteString("\n")
 	builder.WriteString("- DEBUG END -")
 
-	fmt.Println(builder.String())
+	fmt.Fprintln(t.out, builder.String())
 
 	return nil
 }
 
-// Done ends the transaction
+// IsLevelEnabled reports whether a message of the level is logged in the segment
+func (t *LocalTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.RLock()
+	name := shard.segments[segmentID]
+	shard.mutex.RUnlock()
+
+	return messageEnabled(level, name)
+}
+
+// SetOutcome sets the outcome reported at Done, without one it is derived from the logged errors
+// - Not thread safe -
+func (t *LocalTransaction) SetOutcome(outcome string) error {
+	err := validateOutcome(outcome)
+	if err != nil {
+		return err
+	}
+
+	t.outcome = outcome
+
+	return nil
+}
+
+// RecordMetric writes the metric to the log
+func (t *LocalTransaction) RecordMetric(name string, value float64) error {
+	t.logger.Printf("Metric[%s] %s: %v \n", t.trace, name, value)
+
+	return nil
+}
+
+// Done ends the transaction and logs the duration, the number of segments and the number of messages per level
 func (t *LocalTransaction) Done() error {
-	// todo print transaction attributes
-	log.Printf("Transaction end: %s \n", t.transaction)
+	// the transaction messages are logged in the shard without segment
+	shard := t.segmentContainer.shard("")
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	duration := clockSince(t.startTime)
+	segmentCount := int(t.segmentCount.Load())
+	errorCount := int(t.errorCount.Load())
+	infoCount := int(t.infoCount.Load())
+	debugCount := int(t.debugCount.Load())
+	outcome := deriveOutcome(t.outcome, errorCount)
+	t.attributes[ErrorCountAttribute] = errorCount
+
+	if t.format == localFormatPretty {
+		t.writePretty("end", "", fmt.Sprintf("Transaction %s duration=%s segments=%d errors=%d infos=%d debugs=%d outcome=%s", t.transaction, duration, segmentCount, errorCount, infoCount, debugCount, outcome))
+		return nil
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("Transaction end: ")
+	builder.WriteString(t.transaction)
+	builder.WriteString("\n")
+	builder.WriteString("Duration: ")
+	builder.WriteString(duration.String())
+	builder.WriteString("\n")
+	builder.WriteString("Segments: ")
+	builder.WriteString(strconv.Itoa(segmentCount))
+	builder.WriteString("\n")
+	builder.WriteString("Errors: ")
+	builder.WriteString(strconv.Itoa(errorCount))
+	builder.WriteString("\n")
+	builder.WriteString("Infos: ")
+	builder.WriteString(strconv.Itoa(infoCount))
+	builder.WriteString("\n")
+	builder.WriteString("Debugs: ")
+	builder.WriteString(strconv.Itoa(debugCount))
+	builder.WriteString("\n")
+	builder.WriteString("Outcome: ")
+	builder.WriteString(outcome)
+	builder.WriteString("\n")
+	builder.WriteString("Transaction-Attributes: ")
+	builder.WriteString(fmt.Sprintf("%+v", t.attributes))
+
+	t.logger.Println(builder.String())
 
 	return nil
 }
 
 // CreateTrace creates a trace for the transaction
 func (t *LocalTransaction) CreateTrace() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetTrace sets a trace for the transaction
@@ -429,12 +728,7 @@ func (t *LocalTransaction) SetTraceID(traceID string) error {
 
 // CreateProcessID creates a ProcessID for the transaction
 func (t *LocalTransaction) CreateProcessID() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetProcessID sets a ProcessID for the transaction
@@ -452,8 +746,15 @@ func (t *LocalTransaction) ProcessID() (string, error) {
 // Erase any memory the transaction allocated
 func (t *LocalTransaction) Erase() {
 	t.attributes = nil
-	t.segmentContainer.segments = nil
-	t.segmentContainer.attributes = nil
+	for i := range t.segmentContainer.shards {
+		shard := &t.segmentContainer.shards[i]
+		shard.mutex.Lock()
+		shard.segments = nil
+		shard.attributes = nil
+		shard.depths = nil
+		shard.segmentsStartWasLogged = nil
+		shard.mutex.Unlock()
+	}
 
 	// we need to collect the garbage manually here because maps in go do have some problems with the garbage collection
 	// the runtime.GC method is used to manually free the memory
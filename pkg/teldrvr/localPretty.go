@@ -0,0 +1,109 @@
+package teldrvr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const localFormatPlain = "plain"
+const localFormatPretty = "pretty"
+
+const colorReset = "\033[0m"
+const colorRed = "\033[31m"
+const colorGreen = "\033[32m"
+const colorYellow = "\033[33m"
+const colorCyan = "\033[36m"
+const colorGray = "\033[90m"
+
+// prettyLevelColors maps the log levels to their console color
+var prettyLevelColors = map[string]string{
+	logLevelError: colorRed,
+	logLevelInfo:  colorGreen,
+	logLevelDebug: colorGray,
+}
+
+// writePretty writes a single colored line, indented by the depth of the segment
+// - Expects the shard of the segment to be locked -
+func (t *LocalTransaction) writePretty(level string, segmentID string, msg string) {
+	shard := t.segmentContainer.shard(segmentID)
+	builder := strings.Builder{}
+	builder.WriteString(colorGray)
+	builder.WriteString(configuredTimestamp().formatTime(clockNow(), "15:04:05.000"))
+	builder.WriteString(colorReset)
+	builder.WriteString(" ")
+
+	color, ok := prettyLevelColors[level]
+	if !ok {
+		color = colorCyan
+	}
+	builder.WriteString(color)
+	builder.WriteString(fmt.Sprintf("%-5s", strings.ToUpper(level)))
+	builder.WriteString(colorReset)
+	builder.WriteString(" ")
+
+	name, inSegment := shard.segments[segmentID]
+	if inSegment {
+		builder.WriteString(strings.Repeat("  ", shard.depths[segmentID]+1))
+		builder.WriteString(colorYellow)
+		builder.WriteString(name)
+		builder.WriteString(colorReset)
+		builder.WriteString(" ")
+	}
+
+	builder.WriteString(msg)
+
+	if inSegment {
+		builder.WriteString(compactAttributes(shard.attributes[segmentID]))
+	} else {
+		builder.WriteString(compactAttributes(t.attributes))
+	}
+
+	if callerEnabled && level != "start" && level != "end" {
+		builder.WriteString(colorGray)
+		builder.WriteString(fmt.Sprintf(" caller=%s goroutine=%d", callerInfo(), goroutineID()))
+		builder.WriteString(colorReset)
+	}
+
+	if len(t.trace) > 0 {
+		builder.WriteString(colorGray)
+		builder.WriteString(" trace=")
+		builder.WriteString(t.trace)
+		builder.WriteString(colorReset)
+	}
+
+	out := t.out
+	if level == logLevelError {
+		out = t.errorOut
+	}
+
+	fmt.Fprintln(out, builder.String())
+}
+
+// sortedAttributeKeys returns the keys of the attributes in ascending order, so the output does not depend on the
+// iteration order of the map
+func sortedAttributeKeys(attributes map[string]any) []string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// compactAttributes formats attributes as sorted key=value pairs
+func compactAttributes(attributes map[string]any) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(colorCyan)
+	for _, key := range sortedAttributeKeys(attributes) {
+		builder.WriteString(fmt.Sprintf(" %s=%v", key, attributes[key]))
+	}
+	builder.WriteString(colorReset)
+
+	return builder.String()
+}
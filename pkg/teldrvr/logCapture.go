@@ -0,0 +1,129 @@
+package teldrvr
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// logCaptureQueueSize is the number of log lines waiting for the transaction, further lines go to the original output
+const logCaptureQueueSize = 1000
+
+// logLineLevelFields is the number of leading fields of a log line searched for a level, e.g. date, time, file and level
+const logLineLevelFields = 4
+
+// logLineLevels maps the level markers of log lines to the log level, e.g. "ERROR:" or "[warn]"
+var logLineLevels = map[string]string{
+	"error":    logLevelError,
+	"err":      logLevelError,
+	"fatal":    logLevelError,
+	"panic":    logLevelError,
+	"critical": logLevelError,
+	"crit":     logLevelError,
+	"debug":    logLevelDebug,
+	"trace":    logLevelDebug,
+}
+
+// logCapture receives the output of a log.Logger and logs every line in the transaction.
+// The lines are passed by a separate goroutine: a driver writing to the same logger, like the local driver with the std
+// logger, would otherwise deadlock on the mutex of the logger. The output of that goroutine goes to the original writer.
+type logCapture struct {
+	transaction telemetry.Transaction
+	segmentID   string
+	original    io.Writer
+	lines       chan string
+	done        chan struct{}
+	routerID    atomic.Uint64
+}
+
+// CaptureLog routes the output of the std logger into the segment of the transaction, or into the transaction if the
+// segment ID is empty, until the returned function is called. It restores the former output and waits until all
+// captured lines are logged. Lines containing a level marker like ERROR or FATAL in their leading fields are logged as
+// errors, DEBUG or TRACE as debug messages and all others as info messages.
+// The std logger is shared by the whole process, so lines of concurrent transactions end up in this transaction as well.
+func CaptureLog(transaction telemetry.Transaction, segmentID string) func() {
+	return CaptureLogger(log.Default(), transaction, segmentID)
+}
+
+// CaptureLogger routes the output of the logger into the segment of the transaction, see CaptureLog
+func CaptureLogger(logger *log.Logger, transaction telemetry.Transaction, segmentID string) func() {
+	capture := &logCapture{
+		transaction: transaction,
+		segmentID:   segmentID,
+		original:    logger.Writer(),
+		lines:       make(chan string, logCaptureQueueSize),
+		done:        make(chan struct{}),
+	}
+
+	started := make(chan struct{})
+	go capture.route(started)
+	<-started
+
+	logger.SetOutput(capture)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			logger.SetOutput(capture.original)
+			close(capture.lines)
+			<-capture.done
+		})
+	}
+}
+
+// Write queues the line for the transaction, it is written to the original output if the queue is full or
+// if it is written by the routing goroutine itself
+func (c *logCapture) Write(p []byte) (int, error) {
+	if goroutineID() == c.routerID.Load() {
+		return c.original.Write(p)
+	}
+
+	select {
+	case c.lines <- string(p):
+		return len(p), nil
+	default:
+		return c.original.Write(p)
+	}
+}
+
+// route logs the queued lines in the transaction until the queue is closed
+func (c *logCapture) route(started chan struct{}) {
+	defer close(c.done)
+
+	c.routerID.Store(goroutineID())
+	close(started)
+
+	for line := range c.lines {
+		line = strings.TrimSuffix(line, "\n")
+
+		err := logLevelMessage(c.transaction, c.segmentID, logLineLevel(line), line)
+		if err != nil {
+			_, _ = io.WriteString(c.original, line+"\n")
+		}
+	}
+}
+
+// logLineLevel returns the level of the first level marker in the leading fields of the line, info if there is none
+func logLineLevel(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) > logLineLevelFields {
+		fields = fields[:logLineLevelFields]
+	}
+
+	for _, field := range fields {
+		marker := strings.ToLower(strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}))
+
+		if level, ok := logLineLevels[marker]; ok {
+			return level
+		}
+	}
+
+	return logLevelInfo
+}
@@ -0,0 +1,337 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// logMetricsConfigKey configures the metrics derived from log messages, e.g.
+// telemetry.logMetrics.rules: [{name: "payment.errors", level: "error", segment: "^payment\\."}]
+const logMetricsConfigKey = "telemetry.logMetrics"
+
+// logMetricRule records the metric for every message matching the level, the segment name and the message
+type logMetricRule struct {
+	name string
+	// level is empty for messages of all levels
+	level   string
+	segment *regexp.Regexp
+	message *regexp.Regexp
+	// attribute records the numeric value of the segment attribute instead of 1, e.g. for a distribution
+	attribute string
+}
+
+// matchesSegment reports whether the rule applies to messages of the level in the segment
+func (r logMetricRule) matchesSegment(level string, segmentName string) bool {
+	if len(r.level) > 0 && r.level != level {
+		return false
+	}
+
+	return r.segment == nil || r.segment.MatchString(segmentName)
+}
+
+// logMetricRules holds the rules read from the config on the first registered driver
+var logMetricRules = struct {
+	rules []logMetricRule
+	once  sync.Once
+}{}
+
+// readLogMetricRules reads the rules from the config, invalid rules are skipped and returned as issues
+func readLogMetricRules(cfg Config) ([]logMetricRule, []ConfigIssue) {
+	var rules []logMetricRule
+	var issues []ConfigIssue
+	for i, rule := range cast.ToSlice(cfg.Get(logMetricsConfigKey + ".rules")) {
+		key := fmt.Sprintf("%s.rules.%d", logMetricsConfigKey, i)
+		ruleMap := cast.ToStringMapString(rule)
+
+		metricRule := logMetricRule{
+			name:      ruleMap["name"],
+			level:     ruleMap["level"],
+			attribute: ruleMap["attribute"],
+		}
+
+		if len(metricRule.name) == 0 {
+			issues = append(issues, ConfigIssue{Key: key + ".name", Reason: "is required"})
+			continue
+		}
+
+		switch metricRule.level {
+		case "", logLevelError, logLevelInfo, logLevelDebug:
+		default:
+			issues = append(issues, ConfigIssue{Key: key + ".level", Reason: fmt.Sprintf("»%s« has to be one of %s, %s, %s or empty",
+				metricRule.level, logLevelError, logLevelInfo, logLevelDebug)})
+			continue
+		}
+
+		var err error
+		metricRule.segment, err = compileLogMetricPattern(ruleMap["segment"])
+		if err != nil {
+			issues = append(issues, ConfigIssue{Key: key + ".segment", Reason: err.Error()})
+			continue
+		}
+
+		metricRule.message, err = compileLogMetricPattern(ruleMap["message"])
+		if err != nil {
+			issues = append(issues, ConfigIssue{Key: key + ".message", Reason: err.Error()})
+			continue
+		}
+
+		rules = append(rules, metricRule)
+	}
+
+	return rules, issues
+}
+
+// compileLogMetricPattern compiles the pattern of a rule, an empty pattern matches everything
+func compileLogMetricPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("»%s« is no valid regular expression: %s", pattern, err)
+	}
+
+	return expression, nil
+}
+
+// configuredLogMetricRules returns the rules of telemetry.logMetrics.rules, they are read once
+func configuredLogMetricRules() []logMetricRule {
+	logMetricRules.once.Do(func() {
+		rules, issues := readLogMetricRules(viper.GetViper())
+		if len(issues) > 0 {
+			handleError(fmt.Errorf("%sinvalid log metric rules are ignored: %w", telemetry.TelemetryDriverError, &ConfigError{Issues: issues}))
+		}
+
+		logMetricRules.rules = rules
+	})
+
+	return logMetricRules.rules
+}
+
+// withLogMetrics wraps the driver in a LogMetricsDriver if log metric rules are configured
+func withLogMetrics(_ string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(LogMetricsDriver); ok {
+		return driver
+	}
+
+	rules := configuredLogMetricRules()
+	if len(rules) == 0 {
+		return driver
+	}
+
+	return LogMetricsDriver{
+		Driver: driver,
+		rules:  rules,
+	}
+}
+
+// LogMetricsDriver derives metrics from the messages of its transactions and records them in the same transaction,
+// so dashboards need no separate instrumentation. The metrics are recorded for all messages, also those below the log
+// level or dropped by the tail sampling.
+type LogMetricsDriver struct {
+	Driver telemetry.Driver
+	rules  []logMetricRule
+}
+
+// InitializeTransaction starts a transaction of the wrapped driver that derives the metrics
+func (d LogMetricsDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	return &LogMetricsTransaction{
+		Transaction: transaction,
+		rules:       d.rules,
+		segments:    make(map[string]*logMetricSegment),
+	}, nil
+}
+
+// Unwrap returns the wrapped driver
+func (d LogMetricsDriver) Unwrap() telemetry.Driver {
+	return d.Driver
+}
+
+// logMetricSegment is the name and the attributes of an open segment that the rules read
+type logMetricSegment struct {
+	name       string
+	attributes map[string]any
+}
+
+// LogMetricsTransaction records the metrics of the rules matching its messages
+type LogMetricsTransaction struct {
+	telemetry.Transaction
+	rules    []logMetricRule
+	segments map[string]*logMetricSegment
+	mutex    sync.Mutex
+}
+
+// SegmentStart starts the segment and keeps its name for the rules
+func (t *LogMetricsTransaction) SegmentStart(segmentID string, name string) error {
+	t.mutex.Lock()
+	t.segments[segmentID] = &logMetricSegment{name: name}
+	t.mutex.Unlock()
+
+	return t.Transaction.SegmentStart(segmentID, name)
+}
+
+// AddSegmentAttribute adds the attribute and keeps it for the rules
+func (t *LogMetricsTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	t.mutex.Lock()
+	if segment, ok := t.segments[segmentID]; ok {
+		if segment.attributes == nil {
+			segment.attributes = make(map[string]any)
+		}
+		segment.attributes[key] = value
+	}
+	t.mutex.Unlock()
+
+	return t.Transaction.AddSegmentAttribute(segmentID, key, value)
+}
+
+// SegmentEnd ends the segment and forgets it
+func (t *LogMetricsTransaction) SegmentEnd(segmentID string) error {
+	t.mutex.Lock()
+	delete(t.segments, segmentID)
+	t.mutex.Unlock()
+
+	return t.Transaction.SegmentEnd(segmentID)
+}
+
+// Error records the metrics of the message and logs it
+func (t *LogMetricsTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	readCloser, metricErr := t.recordMetrics(logLevelError, segmentID, readCloser)
+
+	return errors.Join(t.Transaction.Error(segmentID, readCloser), metricErr)
+}
+
+// Info records the metrics of the message and logs it
+func (t *LogMetricsTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	readCloser, metricErr := t.recordMetrics(logLevelInfo, segmentID, readCloser)
+
+	return errors.Join(t.Transaction.Info(segmentID, readCloser), metricErr)
+}
+
+// Debug records the metrics of the message and logs it
+func (t *LogMetricsTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	readCloser, metricErr := t.recordMetrics(logLevelDebug, segmentID, readCloser)
+
+	return errors.Join(t.Transaction.Debug(segmentID, readCloser), metricErr)
+}
+
+// matchingRules returns the rules of the level and the segment with the values of their attributes
+func (t *LogMetricsTransaction) matchingRules(level string, segmentID string) ([]logMetricRule, []any) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	segment := t.segments[segmentID]
+	segmentName := ""
+	if segment != nil {
+		segmentName = segment.name
+	}
+
+	var rules []logMetricRule
+	var values []any
+	for _, rule := range t.rules {
+		if !rule.matchesSegment(level, segmentName) {
+			continue
+		}
+
+		var value any = 1
+		if len(rule.attribute) > 0 {
+			if segment == nil {
+				continue
+			}
+			var ok bool
+			value, ok = segment.attributes[rule.attribute]
+			if !ok {
+				continue
+			}
+		}
+
+		rules = append(rules, rule)
+		values = append(values, value)
+	}
+
+	return rules, values
+}
+
+// recordMetrics records the metrics of the matching rules. The message is only read if a rule matches on it, the
+// returned reader replaces the consumed one.
+func (t *LogMetricsTransaction) recordMetrics(level string, segmentID string, readCloser io.ReadCloser) (io.ReadCloser, error) {
+	rules, values := t.matchingRules(level, segmentID)
+	if len(rules) == 0 {
+		return readCloser, nil
+	}
+
+	var message string
+	if logMetricRulesReadMessage(rules) {
+		limit := telemetry.DebugByteSize
+		if level == logLevelError {
+			limit = telemetry.ErrorBytesSize
+		}
+
+		content, err := readMessage(readCloser, limit)
+		if err != nil {
+			return messageReader(""), err
+		}
+		message = string(content)
+		readCloser = messageReader(message)
+	}
+
+	var errs []error
+	for i, rule := range rules {
+		if rule.message != nil && !rule.message.MatchString(message) {
+			continue
+		}
+
+		value, err := cast.ToFloat64E(values[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("log metric %s: attribute »%s« is not numeric: %w", rule.name, rule.attribute, err))
+			continue
+		}
+
+		err = RecordMetric(t.Transaction, rule.name, value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return readCloser, errors.Join(errs...)
+}
+
+// logMetricRulesReadMessage reports whether any of the rules matches on the message
+func logMetricRulesReadMessage(rules []logMetricRule) bool {
+	for _, rule := range rules {
+		if rule.message != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unwrap returns the wrapped transaction
+func (t *LogMetricsTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// IsLevelEnabled reports whether the wrapped transaction logs messages of the level in the segment, or a rule derives
+// a metric from them, so lazy messages are counted as well
+func (t *LogMetricsTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	if IsLevelEnabled(t.Transaction, segmentID, level) {
+		return true
+	}
+
+	rules, _ := t.matchingRules(level, segmentID)
+
+	return len(rules) > 0
+}
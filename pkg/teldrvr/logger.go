@@ -0,0 +1,71 @@
+package teldrvr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// Logger is the minimal logging API of a transaction. Shared packages can depend on it instead of the transaction.
+// The fields are key-value pairs like the arguments of slog, e.g. logger.Info("order imported", "orderID", 4711).
+type Logger interface {
+	Debug(msg string, fields ...any)
+	Info(msg string, fields ...any)
+	Warn(msg string, fields ...any)
+	Error(msg string, fields ...any)
+}
+
+// transactionLogger logs the messages in a segment of the transaction through its slog handler
+type transactionLogger struct {
+	transaction telemetry.Transaction
+	segmentID   string
+	handler     slog.Handler
+}
+
+// LoggerFor returns a Logger that logs in the segment of the transaction, or in the transaction if the segment ID is
+// empty. The drivers have no warn level, warnings are logged as info messages. Errors of the transaction are passed to
+// the error handler, so the callers do not have to handle them.
+func LoggerFor(transaction telemetry.Transaction, segmentID string) Logger {
+	return &transactionLogger{
+		transaction: transaction,
+		segmentID:   segmentID,
+		handler:     SlogHandler(transaction, segmentID),
+	}
+}
+
+// Debug logs a debug message, the fields are only formatted if debug messages are logged in the segment
+func (l *transactionLogger) Debug(msg string, fields ...any) {
+	l.log(slog.LevelDebug, logLevelDebug, msg, fields)
+}
+
+// Info logs an info message, the fields are only formatted if info messages are logged in the segment
+func (l *transactionLogger) Info(msg string, fields ...any) {
+	l.log(slog.LevelInfo, logLevelInfo, msg, fields)
+}
+
+// Warn logs an info message, see Info
+func (l *transactionLogger) Warn(msg string, fields ...any) {
+	l.log(slog.LevelWarn, logLevelInfo, msg, fields)
+}
+
+// Error logs an error message
+func (l *transactionLogger) Error(msg string, fields ...any) {
+	l.log(slog.LevelError, logLevelError, msg, fields)
+}
+
+// log passes the message to the slog handler if the level is logged in the segment
+func (l *transactionLogger) log(level slog.Level, driverLevel string, msg string, fields []any) {
+	if driverLevel != logLevelError && !IsLevelEnabled(l.transaction, l.segmentID, driverLevel) {
+		return
+	}
+
+	record := slog.NewRecord(clockNow(), level, msg, 0)
+	record.Add(fields...)
+
+	err := l.handler.Handle(context.Background(), record)
+	if err != nil {
+		handleError(fmt.Errorf("%slogger could not log the %s message: %w", telemetry.TelemetryDriverError, driverLevel, err))
+	}
+}
@@ -0,0 +1,475 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const lokiDriver = "loki"
+
+const lokiPushPath = "/loki/api/v1/push"
+const lokiDefaultBatchSize = 1000
+const lokiDefaultFlushInterval = 5 * time.Second
+const lokiDefaultMaxLabelValues = 100
+
+// label sources besides the event fields, attributes are referenced as attributes.<name>
+const lokiLabelSourceApp = "app"
+const lokiLabelSourceAttributePrefix = "attributes."
+
+// lokiLabelSourceFields are the event fields that can be promoted to labels
+var lokiLabelSourceFields = []string{"type", "level", "transaction", "segment"}
+
+// lokiDefaultLabels are promoted if telemetry.drivers.loki.labels is not configured
+var lokiDefaultLabels = map[string]string{
+	"app":   lokiLabelSourceApp,
+	"level": "level",
+}
+
+// lokiLabelName is the format of a Prometheus label name, which Loki uses as well
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiConfigKeys are the settings below telemetry.drivers.loki
+var lokiConfigKeys = []ConfigKey{
+	{Name: "url", Required: true},
+	{Name: "tenant"},
+	{Name: "user"},
+	{Name: "password", Secret: true},
+	{Name: "labels", Kind: ConfigKindStringMap},
+	{Name: "maxLabelValues", Kind: ConfigKindInt, Default: lokiDefaultMaxLabelValues, Min: 0},
+	{Name: "batchSize", Kind: ConfigKindInt, Default: lokiDefaultBatchSize, Min: 1},
+	{Name: "flushInterval", Kind: ConfigKindDuration, Default: lokiDefaultFlushInterval, Min: time.Millisecond},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(lokiDriver, lokiConfigKeys...)
+	RegisterDriverConfig(lokiDriver, TLSConfigKeys...)
+	RegisterDriverConfig(lokiDriver, EmitPolicyConfigKeys...)
+	RegisterDriverConfig(lokiDriver, SeverityConfigKeys...)
+	registerDriverConfigCheck(lokiDriver, checkLokiLabels)
+
+	if !driverEnabled(cfg, lokiDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, lokiDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no events will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(lokiDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, lokiDriver)
+
+	policy := LoadEmitPolicy(cfg, lokiDriver, DefaultEmitPolicy)
+	client, err := newHTTPClient(cfg, lokiDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no events will be sent: %w", telemetry.TelemetryDriverError, lokiDriver, err))
+		registerDriver(lokiDriver, NopDriver{})
+		return
+	}
+
+	labels := driverCfg.GetStringMapString("labels")
+	if len(labels) == 0 {
+		labels = lokiDefaultLabels
+	}
+
+	sink := NewLokiSink(driverCfg.GetString("url"), driverCfg.GetInt("batchSize"), driverCfg.GetDuration("flushInterval"))
+	sink.Tenant = driverCfg.GetString("tenant")
+	sink.User = driverCfg.GetString("user")
+	sink.Password = driverCfg.GetString("password")
+	sink.Labels = NewLokiLabelMapping(labels, cfg.GetString("telemetry.app"), driverCfg.GetInt("maxLabelValues"))
+	sink.Policy = policy
+	sink.Client = client
+	sink.Severities = LoadSeverityMapping(cfg, lokiDriver, nil)
+	sink.Start()
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(lokiDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(lokiDriver, driver)
+}
+
+// checkLokiLabels checks the label names and their sources
+func checkLokiLabels(driverCfg Config) []ConfigIssue {
+	var issues []ConfigIssue
+	for label, source := range driverCfg.GetStringMapString("labels") {
+		key := driverConfigPrefix + lokiDriver + ".labels." + label
+		if !lokiLabelName.MatchString(label) {
+			issues = append(issues, ConfigIssue{Key: key, Reason: "is no valid label name"})
+			continue
+		}
+
+		if !validLokiLabelSource(source) {
+			issues = append(issues, ConfigIssue{Key: key, Reason: fmt.Sprintf("»%s« has to be %s, one of %s or %s<name>",
+				source, lokiLabelSourceApp, strings.Join(lokiLabelSourceFields, ", "), lokiLabelSourceAttributePrefix)})
+		}
+	}
+
+	return issues
+}
+
+// validLokiLabelSource reports whether the source is the app, an event field or an attribute
+func validLokiLabelSource(source string) bool {
+	if source == lokiLabelSourceApp || containsString(lokiLabelSourceFields, source) {
+		return true
+	}
+
+	return strings.HasPrefix(source, lokiLabelSourceAttributePrefix) && len(source) > len(lokiLabelSourceAttributePrefix)
+}
+
+// LokiLabelMapping promotes event fields and attributes to the labels of the Loki streams.
+// Every label gets at most MaxLabelValues distinct values, further values are kept in the JSON payload only, because
+// every label combination is a stream of its own and too many streams overload Loki.
+type LokiLabelMapping struct {
+	// Labels maps the label names to their source: app, type, level, transaction, segment or attributes.<name>
+	Labels map[string]string
+	// App is the value of the app source
+	App string
+	// MaxLabelValues limits the distinct values per label, 0 is unlimited
+	MaxLabelValues int
+
+	mutex    sync.Mutex
+	values   map[string]map[string]struct{}
+	reported map[string]struct{}
+}
+
+// NewLokiLabelMapping creates a LokiLabelMapping
+func NewLokiLabelMapping(labels map[string]string, app string, maxLabelValues int) *LokiLabelMapping {
+	return &LokiLabelMapping{
+		Labels:         labels,
+		App:            app,
+		MaxLabelValues: maxLabelValues,
+		values:         make(map[string]map[string]struct{}),
+		reported:       make(map[string]struct{}),
+	}
+}
+
+// streamLabels returns the labels of the event and the attributes that were promoted to labels
+func (m *LokiLabelMapping) streamLabels(event Event) (map[string]string, []string) {
+	labels := make(map[string]string, len(m.Labels))
+	var promoted []string
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for label, source := range m.Labels {
+		value := m.sourceValue(event, source)
+		if len(value) == 0 || !m.admit(label, value) {
+			continue
+		}
+
+		labels[label] = value
+		if strings.HasPrefix(source, lokiLabelSourceAttributePrefix) {
+			promoted = append(promoted, strings.TrimPrefix(source, lokiLabelSourceAttributePrefix))
+		}
+	}
+
+	return labels, promoted
+}
+
+// sourceValue returns the value of the label source in the event
+func (m *LokiLabelMapping) sourceValue(event Event, source string) string {
+	switch source {
+	case lokiLabelSourceApp:
+		return m.App
+	case "type":
+		return event.Type
+	case "level":
+		return event.Level
+	case "transaction":
+		return event.Transaction
+	case "segment":
+		return event.Segment
+	}
+
+	value, ok := event.Attributes[strings.TrimPrefix(source, lokiLabelSourceAttributePrefix)]
+	if !ok || value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// admit reports whether the value fits into the distinct values of the label, exceeding labels are reported once
+// - Expects the mutex to be locked -
+func (m *LokiLabelMapping) admit(label string, value string) bool {
+	if m.values == nil {
+		m.values = make(map[string]map[string]struct{})
+		m.reported = make(map[string]struct{})
+	}
+
+	values, ok := m.values[label]
+	if !ok {
+		values = make(map[string]struct{})
+		m.values[label] = values
+	}
+
+	if _, ok := values[value]; ok {
+		return true
+	}
+
+	if m.MaxLabelValues > 0 && len(values) >= m.MaxLabelValues {
+		if _, ok := m.reported[label]; !ok {
+			m.reported[label] = struct{}{}
+			handleError(fmt.Errorf("%s%s label %s exceeds %d values, further values are only kept in the payload",
+				telemetry.TelemetryDriverError, lokiDriver, label, m.MaxLabelValues))
+		}
+		return false
+	}
+
+	values[value] = struct{}{}
+
+	return true
+}
+
+// LokiSink pushes the events batch wise into Loki. Every event is a JSON line of the stream of its labels.
+type LokiSink struct {
+	URL string
+	// Tenant is sent as X-Scope-OrgID for multi-tenant Loki installations
+	Tenant   string
+	User     string
+	Password string
+	Labels   *LokiLabelMapping
+	// BatchSize is the maximum number of events per push
+	BatchSize int
+	// FlushInterval is the maximum time an event is buffered in memory
+	FlushInterval time.Duration
+	Policy        EmitPolicy
+	Client        *http.Client
+	// Severities replaces the level of the events in the label and the line, levels without entry keep their name
+	Severities SeverityMapping
+
+	mutex  sync.Mutex
+	events []Event
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// lokiPush is the JSON body of the push API
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLokiSink creates a LokiSink, Start has to be called to enable the periodic flush
+func NewLokiSink(url string, batchSize int, flushInterval time.Duration) *LokiSink {
+	if batchSize < 1 {
+		batchSize = lokiDefaultBatchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = lokiDefaultFlushInterval
+	}
+
+	return &LokiSink{
+		URL:           strings.TrimSuffix(url, "/"),
+		Labels:        NewLokiLabelMapping(lokiDefaultLabels, "", lokiDefaultMaxLabelValues),
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		Policy:        DefaultEmitPolicy,
+		Client:        &http.Client{Timeout: DefaultEmitPolicy.RequestTimeout},
+		events:        make([]Event, 0, batchSize),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush in a background goroutine, the flush timer is taken from the clock of the drivers
+func (s *LokiSink) Start() {
+	ticker := CurrentClock().NewTicker(s.FlushInterval)
+
+	s.wg.Add(1)
+	go s.run(ticker)
+}
+
+func (s *LokiSink) run(ticker Ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			err := s.Flush()
+			if err != nil {
+				handleError(fmt.Errorf("%s%s could not flush events: %w", telemetry.TelemetryDriverError, lokiDriver, err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Emit buffers the event and flushes the buffer if the batch is full
+func (s *LokiSink) Emit(event Event) error {
+	if s.Severities != nil {
+		event.Level = s.Severities.EventSeverity(event)
+	}
+
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= s.BatchSize
+	s.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return s.Flush()
+}
+
+// Flush pushes the buffered events, a failed batch is dropped
+func (s *LokiSink) Flush() error {
+	s.mutex.Lock()
+	events := s.events
+	s.events = make([]Event, 0, s.BatchSize)
+	s.mutex.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	body, err := s.encode(events)
+	if err != nil {
+		return err
+	}
+
+	err = s.Policy.Do(func() (bool, error) {
+		return s.pushOnce(body)
+	})
+	if err != nil {
+		return fmt.Errorf("batch of %d events dropped: %w", len(events), err)
+	}
+
+	return nil
+}
+
+// encode groups the events by their labels into streams
+func (s *LokiSink) encode(events []Event) ([]byte, error) {
+	streams := make(map[string]*lokiStream)
+	var keys []string
+
+	for _, event := range events {
+		labels, promoted := s.Labels.streamLabels(event)
+
+		// promoted attributes are only kept as label
+		if len(promoted) > 0 {
+			attributes := make(map[string]any, len(event.Attributes))
+			for key, value := range event.Attributes {
+				attributes[key] = value
+			}
+			for _, key := range promoted {
+				delete(attributes, key)
+			}
+			event.Attributes = attributes
+		}
+
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		key := lokiStreamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(event.Time.UnixNano(), 10), string(line)})
+	}
+
+	push := lokiPush{Streams: make([]lokiStream, 0, len(keys))}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, *streams[key])
+	}
+
+	return json.Marshal(push)
+}
+
+// lokiStreamKey identifies the stream of the labels
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := strings.Builder{}
+	for _, name := range names {
+		builder.WriteString(name)
+		builder.WriteString("=")
+		builder.WriteString(strconv.Quote(labels[name]))
+		builder.WriteString(",")
+	}
+
+	return builder.String()
+}
+
+// pushOnce sends the batch once and reports whether a failed push should be retried
+func (s *LokiSink) pushOnce(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, s.URL+lokiPushPath, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if len(s.Tenant) > 0 {
+		request.Header.Set("X-Scope-OrgID", s.Tenant)
+	}
+
+	if len(s.User) > 0 {
+		request.SetBasicAuth(s.User, s.Password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("loki responded with status %d: %s", response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
+
+// Close stops the periodic flush and pushes the buffered events
+func (s *LokiSink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	return s.Flush()
+}
@@ -0,0 +1,67 @@
+package teldrvr
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// ErrorMsg logs the error in the segment, or in the transaction if the segment ID is empty. A nil error is not logged.
+// The Error method of the transaction stays available for large messages that should be streamed.
+func ErrorMsg(transaction telemetry.Transaction, segmentID string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return transaction.Error(segmentID, messageReader(err.Error()))
+}
+
+// InfoMsg logs the info message in the segment, or in the transaction if the segment ID is empty
+func InfoMsg(transaction telemetry.Transaction, segmentID string, message string) error {
+	return transaction.Info(segmentID, messageReader(message))
+}
+
+// DebugMsg logs the debug message in the segment, or in the transaction if the segment ID is empty
+func DebugMsg(transaction telemetry.Transaction, segmentID string, message string) error {
+	return transaction.Debug(segmentID, messageReader(message))
+}
+
+// Errorf formats and logs the error message, see ErrorMsg
+func Errorf(transaction telemetry.Transaction, segmentID string, format string, args ...any) error {
+	return transaction.Error(segmentID, messageReader(fmt.Sprintf(format, args...)))
+}
+
+// Infof formats and logs the info message, see InfoMsg. The arguments are only formatted if info messages are logged
+// in the segment.
+func Infof(transaction telemetry.Transaction, segmentID string, format string, args ...any) error {
+	return InfoLazy(transaction, segmentID, func() string {
+		return fmt.Sprintf(format, args...)
+	})
+}
+
+// Debugf formats and logs the debug message, see DebugMsg. The arguments are only formatted if debug messages are
+// logged in the segment.
+func Debugf(transaction telemetry.Transaction, segmentID string, format string, args ...any) error {
+	return DebugLazy(transaction, segmentID, func() string {
+		return fmt.Sprintf(format, args...)
+	})
+}
+
+// logLevelMessage logs the message with the level, an unknown level is logged as info
+func logLevelMessage(transaction telemetry.Transaction, segmentID string, level string, message string) error {
+	switch level {
+	case logLevelError:
+		return transaction.Error(segmentID, messageReader(message))
+	case logLevelDebug:
+		return DebugMsg(transaction, segmentID, message)
+	default:
+		return InfoMsg(transaction, segmentID, message)
+	}
+}
+
+// messageReader returns the message as reader for the streaming API of the transactions
+func messageReader(message string) io.ReadCloser {
+	return io.NopCloser(strings.NewReader(message))
+}
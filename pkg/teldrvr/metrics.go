@@ -0,0 +1,21 @@
+package teldrvr
+
+import (
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// MetricRecorder is implemented by all transactions that are able to record custom metrics
+type MetricRecorder interface {
+	RecordMetric(name string, value float64) error
+}
+
+// RecordMetric records a custom metric on the transaction.
+// Transactions that do not support metrics are silently ignored.
+func RecordMetric(transaction telemetry.Transaction, name string, value float64) error {
+	recorder, ok := findTransaction[MetricRecorder](transaction)
+	if !ok {
+		return nil
+	}
+
+	return recorder.RecordMetric(name, value)
+}
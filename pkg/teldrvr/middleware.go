@@ -0,0 +1,195 @@
+package teldrvr
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// middlewaresConfigKey orders the middlewares of all drivers, e.g. telemetry.middlewares: "canary, shadow, tailSampling"
+const middlewaresConfigKey = "telemetry.middlewares"
+
+// names of the built in middlewares
+const (
+	MiddlewareCanary            = "canary"
+	MiddlewareShadow            = "shadow"
+	MiddlewareTailSampling      = "tailSampling"
+	MiddlewareAttributeCoercion = "attributeCoercion"
+	MiddlewareAttributeLimits   = "attributeLimits"
+	MiddlewareAttributePrefix   = "attributePrefix"
+	MiddlewareLogMetrics        = "logMetrics"
+)
+
+// defaultMiddlewares is the chain without telemetry.middlewares, the first middleware is the outermost
+var defaultMiddlewares = []string{MiddlewareLogMetrics, MiddlewareCanary, MiddlewareShadow, MiddlewareTailSampling,
+	MiddlewareAttributeCoercion, MiddlewareAttributeLimits, MiddlewareAttributePrefix}
+
+// Middleware wraps a driver, e.g. to sample, redact, rate limit or enrich the calls of its transactions before they
+// reach the driver. The wrapped driver has to pass the calls it does not handle through to the driver.
+type Middleware func(driver telemetry.Driver) telemetry.Driver
+
+// DriverUnwrapper is implemented by the drivers of middlewares to return the wrapped driver, so CloseDrivers,
+// FlushDrivers, the self-test and the diagnostics reach it
+type DriverUnwrapper interface {
+	Unwrap() telemetry.Driver
+}
+
+// TransactionUnwrapper is implemented by the transactions of middlewares to return the wrapped transaction, so the
+// optional APIs like RecordMetric, SetOutcome or InjectTraceHeaders reach the transaction of the driver
+type TransactionUnwrapper interface {
+	Unwrap() telemetry.Transaction
+}
+
+// findTransaction returns the outermost transaction of the chain that implements T, e.g. a MetricRecorder
+func findTransaction[T any](transaction telemetry.Transaction) (T, bool) {
+	for transaction != nil {
+		found, ok := transaction.(T)
+		if ok {
+			return found, true
+		}
+
+		unwrapper, ok := transaction.(TransactionUnwrapper)
+		if !ok {
+			break
+		}
+		transaction = unwrapper.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// innermostTransaction returns the transaction of the driver at the end of the chain, the fallbacks of the optional
+// APIs go there and not through the middlewares, e.g. the outcome attribute is not prefixed
+func innermostTransaction(transaction telemetry.Transaction) telemetry.Transaction {
+	for {
+		unwrapper, ok := transaction.(TransactionUnwrapper)
+		if !ok {
+			return transaction
+		}
+		transaction = unwrapper.Unwrap()
+	}
+}
+
+// MiddlewareFactory returns the middleware for the driver registered with the given name.
+// nil leaves the driver unwrapped, e.g. if the middleware is not configured for the driver.
+type MiddlewareFactory func(driverName string) Middleware
+
+// Wrap wraps the driver in the middlewares. The first middleware is the outermost, it sees the calls of the
+// application first and passes them on to the next one. nil middlewares are skipped.
+func Wrap(driver telemetry.Driver, middlewares ...Middleware) telemetry.Driver {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			driver = middlewares[i](driver)
+		}
+	}
+
+	return driver
+}
+
+// driverMiddleware turns a with function of the built in wrappers into a MiddlewareFactory
+func driverMiddleware(with func(name string, driver telemetry.Driver) telemetry.Driver) MiddlewareFactory {
+	return func(driverName string) Middleware {
+		return func(driver telemetry.Driver) telemetry.Driver {
+			return with(driverName, driver)
+		}
+	}
+}
+
+// middlewareFactories holds the built in and registered middlewares by name
+var middlewareFactories = struct {
+	factories map[string]MiddlewareFactory
+	mutex     sync.RWMutex
+}{
+	factories: map[string]MiddlewareFactory{
+		MiddlewareCanary:            driverMiddleware(withCanary),
+		MiddlewareShadow:            driverMiddleware(withShadow),
+		MiddlewareTailSampling:      driverMiddleware(withTailSampling),
+		MiddlewareAttributeCoercion: driverMiddleware(withAttributeCoercion),
+		MiddlewareAttributeLimits:   driverMiddleware(withAttributeLimits),
+		MiddlewareAttributePrefix:   driverMiddleware(withAttributePrefix),
+		MiddlewareLogMetrics:        driverMiddleware(withLogMetrics),
+	},
+}
+
+// RegisterMiddleware makes a middleware available to telemetry.middlewares under the given name.
+// It is meant to be called from an init function, drivers registered before are not wrapped.
+func RegisterMiddleware(name string, factory MiddlewareFactory) error {
+	if len(name) == 0 {
+		return fmt.Errorf("can not register middleware without name")
+	}
+
+	if factory == nil {
+		return fmt.Errorf("can not register middleware '%s' without factory", name)
+	}
+
+	middlewareFactories.mutex.Lock()
+	defer middlewareFactories.mutex.Unlock()
+
+	if _, ok := middlewareFactories.factories[name]; ok {
+		return fmt.Errorf("middleware '%s' is already registered", name)
+	}
+
+	middlewareFactories.factories[name] = factory
+
+	return nil
+}
+
+// RegisteredMiddlewares returns the sorted names of the built in and registered middlewares
+func RegisteredMiddlewares() []string {
+	middlewareFactories.mutex.RLock()
+	defer middlewareFactories.mutex.RUnlock()
+
+	names := make([]string, 0, len(middlewareFactories.factories))
+	for name := range middlewareFactories.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// middlewareFactory returns the middleware registered with the given name
+func middlewareFactory(name string) (MiddlewareFactory, bool) {
+	middlewareFactories.mutex.RLock()
+	defer middlewareFactories.mutex.RUnlock()
+
+	factory, ok := middlewareFactories.factories[name]
+
+	return factory, ok
+}
+
+// middlewareNames returns the chain of telemetry.middlewares, without the setting the default chain
+func middlewareNames(cfg Config) []string {
+	if !cfg.IsSet(middlewaresConfigKey) {
+		return defaultMiddlewares
+	}
+
+	return splitDriverList(cfg.GetString(middlewaresConfigKey))
+}
+
+// configuredMiddlewares returns the middlewares of the driver in the order of telemetry.middlewares,
+// unknown middlewares are reported and skipped
+func configuredMiddlewares(cfg Config, driverName string) []Middleware {
+	var middlewares []Middleware
+	for _, name := range middlewareNames(cfg) {
+		factory, ok := middlewareFactory(name)
+		if !ok {
+			handleError(fmt.Errorf("%s%s »%s« is not a registered middleware, skipping it", telemetry.TelemetryDriverError,
+				middlewaresConfigKey, name))
+			continue
+		}
+
+		middlewares = append(middlewares, factory(driverName))
+	}
+
+	return middlewares
+}
+
+// withMiddlewares wraps the driver in the middlewares of telemetry.middlewares
+func withMiddlewares(name string, driver telemetry.Driver) telemetry.Driver {
+	return Wrap(driver, configuredMiddlewares(viper.GetViper(), name)...)
+}
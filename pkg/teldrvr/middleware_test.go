@@ -0,0 +1,41 @@
+package teldrvr
+
+import (
+	"testing"
+)
+
+// metricRecorder records the metrics it receives
+type metricRecorder struct {
+	attributeRecorder
+	metrics map[string]float64
+}
+
+func (t *metricRecorder) RecordMetric(name string, value float64) error {
+	t.metrics[name] = value
+	return nil
+}
+
+func TestOptionalAPIsWalkTheUnwrapChain(t *testing.T) {
+	driverTransaction := &metricRecorder{
+		attributeRecorder: attributeRecorder{attributes: map[string]any{}},
+		metrics:           map[string]float64{},
+	}
+	transaction := &TenantTransaction{
+		Transaction: &AttributePrefixTransaction{Transaction: driverTransaction, prefix: "app."},
+	}
+
+	if err := RecordMetric(transaction, "orders", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := driverTransaction.metrics["orders"]; got != 3 {
+		t.Errorf("metric orders = %v, want 3", got)
+	}
+
+	// the fallback goes to the transaction of the driver, the outcome attribute is not prefixed
+	if err := SetOutcome(transaction, OutcomeFailure); err != nil {
+		t.Fatal(err)
+	}
+	if got := driverTransaction.attributes[OutcomeAttribute]; got != OutcomeFailure {
+		t.Errorf("attribute %s = %v, want %s", OutcomeAttribute, got, OutcomeFailure)
+	}
+}
@@ -0,0 +1,268 @@
+package teldrvr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// StartActiveTransaction starts a transaction in every active driver and returns a transaction forwarding all calls to
+// them. Without an active driver a nop transaction is returned. Drivers that can not start a transaction are skipped
+// and reported in the error, the returned transaction can be used nevertheless.
+// The process ID is created once and set in all transactions, so the records of all backends correlate.
+func StartActiveTransaction(name string) (telemetry.Transaction, error) {
+	transaction := &MultiTransaction{}
+
+	var errs []error
+	for _, driver := range RegisteredDrivers() {
+		if !IsDriverActive(driver) {
+			continue
+		}
+
+		driverTransaction, err := StartTransaction(driver, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("driver %s could not start transaction »%s«: %w", driver, name, err))
+			continue
+		}
+		transaction.transactions = append(transaction.transactions, driverTransaction)
+	}
+
+	if len(transaction.transactions) == 0 {
+		nop, _ := NopDriver{}.InitializeTransaction(name)
+		return nop, errors.Join(errs...)
+	}
+
+	errs = append(errs, transaction.shareProcessID())
+
+	return transaction, errors.Join(errs...)
+}
+
+// MultiTransaction forwards all calls to the transactions of several drivers.
+// The trace and the process ID are created by the first transaction and passed to the others, the segment IDs are
+// passed by the caller, so all backends use the same IDs.
+type MultiTransaction struct {
+	transactions []telemetry.Transaction
+}
+
+// shareProcessID creates the process ID with the first transaction and sets it in all transactions, like the telemetry
+// package does for the transactions of its drivers
+func (t *MultiTransaction) shareProcessID() error {
+	processID, err := t.CreateProcessID()
+	if err != nil {
+		return fmt.Errorf("process ID could not be created: %w", err)
+	}
+
+	return t.SetProcessID(processID)
+}
+
+// each calls the function for every transaction and joins the errors
+func (t *MultiTransaction) each(call func(transaction telemetry.Transaction) error) error {
+	var errs []error
+	for _, transaction := range t.transactions {
+		err := call(transaction)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Start starts all transactions
+func (t *MultiTransaction) Start(name string) {
+	for _, transaction := range t.transactions {
+		transaction.Start(name)
+	}
+}
+
+// AddTransactionAttribute adds an attribute to all transactions
+func (t *MultiTransaction) AddTransactionAttribute(key string, value any) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.AddTransactionAttribute(key, value)
+	})
+}
+
+// SegmentStart starts the segment in all transactions
+func (t *MultiTransaction) SegmentStart(segmentID string, name string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.SegmentStart(segmentID, name)
+	})
+}
+
+// AddSegmentAttribute adds an attribute to the segment of all transactions
+func (t *MultiTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.AddSegmentAttribute(segmentID, key, value)
+	})
+}
+
+// SegmentEnd ends the segment in all transactions
+func (t *MultiTransaction) SegmentEnd(segmentID string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.SegmentEnd(segmentID)
+	})
+}
+
+// Error logs the error in all transactions
+func (t *MultiTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.ErrorBytesSize)
+	if err != nil {
+		return err
+	}
+
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.Error(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// Info logs the information in all transactions
+func (t *MultiTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.Info(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// Debug logs the information in all transactions
+func (t *MultiTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.Debug(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+}
+
+// RecordMetric records a custom metric in all transactions that support metrics
+func (t *MultiTransaction) RecordMetric(name string, value float64) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return RecordMetric(transaction, name, value)
+	})
+}
+
+// InjectTraceHeaders adds the trace headers of all transactions
+func (t *MultiTransaction) InjectTraceHeaders(header http.Header) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return InjectTraceHeaders(transaction, header)
+	})
+}
+
+// SetOutcome sets the outcome of all transactions
+func (t *MultiTransaction) SetOutcome(outcome string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return SetOutcome(transaction, outcome)
+	})
+}
+
+// SetQueueStart records the queue start in all transactions
+func (t *MultiTransaction) SetQueueStart(start time.Time) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return SetQueueStart(transaction, start)
+	})
+}
+
+// OverrideSampling overrides the sampling of all transactions
+func (t *MultiTransaction) OverrideSampling(decision string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return OverrideSampling(transaction, decision)
+	})
+}
+
+// IsLevelEnabled reports whether any of the transactions logs messages of the level in the segment
+func (t *MultiTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	for _, transaction := range t.transactions {
+		if IsLevelEnabled(transaction, segmentID, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Done ends all transactions
+func (t *MultiTransaction) Done() error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.Done()
+	})
+}
+
+// CreateTrace creates a trace with the first transaction
+func (t *MultiTransaction) CreateTrace() (string, error) {
+	return t.transactions[0].CreateTrace()
+}
+
+// SetTrace sets the trace in the first transaction and its trace ID in the others, like the telemetry package does with
+// the trace driver. The trace is created by the first transaction, e.g. as New Relic header the other drivers can not read.
+func (t *MultiTransaction) SetTrace(trace string) error {
+	err := t.transactions[0].SetTrace(trace)
+	if err != nil {
+		return err
+	}
+
+	traceID, err := t.transactions[0].TraceID()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, transaction := range t.transactions[1:] {
+		err = transaction.SetTraceID(traceID)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Trace returns the trace of the first transaction
+func (t *MultiTransaction) Trace() (string, error) {
+	return t.transactions[0].Trace()
+}
+
+// TraceID returns the trace ID of the first transaction
+func (t *MultiTransaction) TraceID() (string, error) {
+	return t.transactions[0].TraceID()
+}
+
+// SetTraceID sets the trace ID in all transactions
+func (t *MultiTransaction) SetTraceID(traceID string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.SetTraceID(traceID)
+	})
+}
+
+// CreateProcessID creates a ProcessID with the first transaction
+func (t *MultiTransaction) CreateProcessID() (string, error) {
+	return t.transactions[0].CreateProcessID()
+}
+
+// SetProcessID sets the ProcessID in all transactions
+func (t *MultiTransaction) SetProcessID(processID string) error {
+	return t.each(func(transaction telemetry.Transaction) error {
+		return transaction.SetProcessID(processID)
+	})
+}
+
+// ProcessID returns the ProcessID of the first transaction
+func (t *MultiTransaction) ProcessID() (string, error) {
+	return t.transactions[0].ProcessID()
+}
+
+// Erase any memory the transactions allocated
+func (t *MultiTransaction) Erase() {
+	for _, transaction := range t.transactions {
+		transaction.Erase()
+	}
+}
@@ -0,0 +1,140 @@
+package teldrvr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// nameNormalizationConfigKey configures the normalization of transaction and segment names, e.g.
+// telemetry.nameNormalization.replace: [{pattern: "[0-9]+", replacement: "{id}"}]
+const nameNormalizationConfigKey = "telemetry.nameNormalization"
+
+// nameReplacement replaces all matches of the expression in a name
+type nameReplacement struct {
+	expression  *regexp.Regexp
+	replacement string
+}
+
+// nameNormalizer rewrites transaction and segment names before they reach a driver, so IDs in names do not
+// create an unbounded number of metrics. The replacements are applied in order, then the name is lowercased and cut.
+type nameNormalizer struct {
+	replacements []nameReplacement
+	lowercase    bool
+	// maxLength is the maximum length of a name in bytes, 0 means unlimited
+	maxLength int
+}
+
+// nameNormalization holds the normalization read from the config on the first transaction
+var nameNormalization = struct {
+	normalization nameNormalizer
+	once          sync.Once
+}{}
+
+// readNameNormalization reads the normalization from the config.
+// Replacements with an invalid pattern are skipped and returned as issues.
+func readNameNormalization(cfg Config) (nameNormalizer, []ConfigIssue) {
+	normalization := nameNormalizer{
+		lowercase: cfg.GetBool(nameNormalizationConfigKey + ".lowercase"),
+		maxLength: cfg.GetInt(nameNormalizationConfigKey + ".maxLength"),
+	}
+
+	var issues []ConfigIssue
+	for i, rule := range cast.ToSlice(cfg.Get(nameNormalizationConfigKey + ".replace")) {
+		key := fmt.Sprintf("%s.replace.%d.pattern", nameNormalizationConfigKey, i)
+
+		ruleMap := cast.ToStringMapString(rule)
+		pattern, ok := ruleMap["pattern"]
+		if !ok || len(pattern) == 0 {
+			issues = append(issues, ConfigIssue{Key: key, Reason: "is required"})
+			continue
+		}
+
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			issues = append(issues, ConfigIssue{Key: key, Reason: fmt.Sprintf("»%s« is no valid regular expression: %s", pattern, err)})
+			continue
+		}
+
+		normalization.replacements = append(normalization.replacements, nameReplacement{
+			expression:  expression,
+			replacement: ruleMap["replacement"],
+		})
+	}
+
+	return normalization, issues
+}
+
+// enabled reports whether the normalization changes any name
+func (n nameNormalizer) enabled() bool {
+	return len(n.replacements) > 0 || n.lowercase || n.maxLength > 0
+}
+
+// normalize returns the normalized name
+func (n nameNormalizer) normalize(name string) string {
+	for _, replacement := range n.replacements {
+		name = replacement.expression.ReplaceAllString(name, replacement.replacement)
+	}
+
+	if n.lowercase {
+		name = strings.ToLower(name)
+	}
+
+	if n.maxLength > 0 && len(name) > n.maxLength {
+		cut := n.maxLength
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+
+	return name
+}
+
+// configuredNameNormalization returns the normalization of telemetry.nameNormalization, it is read once
+func configuredNameNormalization() nameNormalizer {
+	nameNormalization.once.Do(func() {
+		normalization, issues := readNameNormalization(viper.GetViper())
+		if len(issues) > 0 {
+			handleError(fmt.Errorf("%sinvalid name normalizations are ignored: %w", telemetry.TelemetryDriverError, &ConfigError{Issues: issues}))
+		}
+
+		nameNormalization.normalization = normalization
+	})
+
+	return nameNormalization.normalization
+}
+
+// withNameNormalization wraps the transaction so the names of its segments are normalized, if a normalization is configured
+func withNameNormalization(normalization nameNormalizer, transaction telemetry.Transaction) telemetry.Transaction {
+	if !normalization.enabled() {
+		return transaction
+	}
+
+	return &NameNormalizingTransaction{
+		Transaction:   transaction,
+		normalization: normalization,
+	}
+}
+
+// NameNormalizingTransaction normalizes the segment names before they reach the wrapped transaction
+type NameNormalizingTransaction struct {
+	telemetry.Transaction
+	normalization nameNormalizer
+}
+
+// SegmentStart starts the segment with the normalized name
+func (t *NameNormalizingTransaction) SegmentStart(segmentID string, name string) error {
+	return t.Transaction.SegmentStart(segmentID, t.normalization.normalize(name))
+}
+
+// Unwrap returns the wrapped transaction
+func (t *NameNormalizingTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
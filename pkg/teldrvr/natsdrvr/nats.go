@@ -0,0 +1,193 @@
+// Package natsdrvr provides a telemetry driver publishing all events to NATS.
+// Import it for its side effect and add "nats" to telemetry.driver or telemetry.external:
+//
+//	import _ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/natsdrvr"
+package natsdrvr
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const natsDriver = "nats"
+
+const defaultSubject = "telemetry.{{.Type}}.{{.Level}}"
+
+// configKeys are the settings below telemetry.drivers.nats
+var configKeys = []teldrvr.ConfigKey{
+	{Name: "url", Default: nats.DefaultURL},
+	{Name: "subject", Default: defaultSubject},
+	{Name: "jetstream", Kind: teldrvr.ConfigKindBool},
+	{Name: "ackTimeout", Kind: teldrvr.ConfigKindDuration, Min: 0},
+	{Name: "queueSize", Kind: teldrvr.ConfigKindInt, Min: 1},
+}
+
+func init() {
+	teldrvr.RegisterDriverConfig(natsDriver, configKeys...)
+	teldrvr.RegisterDriverConfig(natsDriver, teldrvr.TLSConfigKeys...)
+	teldrvr.RegisterDriverConfig(natsDriver, teldrvr.EmitPolicyConfigKeys...)
+	teldrvr.RegisterDriverConfig(natsDriver, teldrvr.EventEncodingConfigKeys...)
+
+	err := teldrvr.RegisterExternal(natsDriver, newDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newDriver(cfg teldrvr.Config) (telemetry.Driver, error) {
+	driverCfg := teldrvr.DriverConfig(cfg, natsDriver)
+
+	subject := driverCfg.GetString("subject")
+	if len(subject) == 0 {
+		subject = defaultSubject
+	}
+
+	subjectTemplate, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+
+	url := driverCfg.GetString("url")
+	if len(url) == 0 {
+		url = nats.DefaultURL
+	}
+
+	tlsConfig, err := teldrvr.TLSConfig(cfg, natsDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := teldrvr.LoadEmitPolicy(cfg, natsDriver, teldrvr.DefaultEmitPolicy)
+
+	options := []nats.Option{
+		nats.Name(cfg.GetString("telemetry.app")),
+		nats.Timeout(policy.ConnectTimeout),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("%s%s disconnected: %v", telemetry.TelemetryDriverError, natsDriver, err)
+			}
+		}),
+		nats.ReconnectHandler(func(connection *nats.Conn) {
+			log.Printf("Telemetry driver %s reconnected to %s", natsDriver, connection.ConnectedUrl())
+		}),
+	}
+	if tlsConfig != nil {
+		options = append(options, nats.Secure(tlsConfig))
+	}
+
+	// the connection is established in the background, so a missing server does not block the start of the application
+	connection, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &Sink{
+		Connection: connection,
+		Subject:    subjectTemplate,
+		AckTimeout: driverCfg.GetDuration("ackTimeout"),
+		Encoding:   driverCfg.GetString("encoding"),
+		Policy:     policy,
+	}
+
+	if sink.AckTimeout <= 0 {
+		sink.AckTimeout = policy.RequestTimeout
+	}
+
+	if driverCfg.GetBool("jetstream") {
+		sink.JetStream, err = connection.JetStream()
+		if err != nil {
+			return nil, fmt.Errorf("jetstream not available: %w", err)
+		}
+	}
+
+	return teldrvr.EventDriver{
+		Sink: teldrvr.NewAsyncSink(natsDriver, sink, driverCfg.GetInt("queueSize")),
+	}, nil
+}
+
+// Sink publishes every event as JSON, Protobuf or Avro to the subject rendered from the Subject template.
+// Events that are not encoded as JSON carry their content type in the Content-Type header.
+type Sink struct {
+	Connection *nats.Conn
+	Subject    *template.Template
+	// JetStream enables publishing with acknowledgement, if nil a plain core NATS publish is used
+	JetStream  nats.JetStreamContext
+	AckTimeout time.Duration
+	// Encoding of the events, see teldrvr.EncodeEvent
+	Encoding string
+	// Policy defines the retries of a failed publish
+	Policy teldrvr.EmitPolicy
+}
+
+// Emit publishes the event and retries a failed publish according to the policy
+func (s *Sink) Emit(event teldrvr.Event) error {
+	body, err := teldrvr.EncodeEvent(s.Encoding, event)
+	if err != nil {
+		return err
+	}
+
+	subject := strings.Builder{}
+	err = s.Subject.Execute(&subject, event)
+	if err != nil {
+		return fmt.Errorf("could not execute subject template: %w", err)
+	}
+
+	return s.Policy.Do(func() (bool, error) {
+		return true, s.publish(s.message(sanitizeSubject(subject.String()), body))
+	})
+}
+
+// message creates the message, headers are only set for encodings other than JSON to support servers without headers
+func (s *Sink) message(subject string, body []byte) *nats.Msg {
+	message := nats.NewMsg(subject)
+	message.Data = body
+
+	if len(s.Encoding) > 0 && s.Encoding != teldrvr.EventEncodingJSON {
+		message.Header.Set("Content-Type", teldrvr.EventContentType(s.Encoding))
+	}
+
+	return message
+}
+
+// publish sends the message once, with JetStream it waits for the acknowledgement
+func (s *Sink) publish(message *nats.Msg) error {
+	if s.JetStream == nil {
+		return s.Connection.PublishMsg(message)
+	}
+
+	var options []nats.PubOpt
+	if s.AckTimeout > 0 {
+		options = append(options, nats.AckWait(s.AckTimeout))
+	}
+
+	_, err := s.JetStream.PublishMsg(message, options...)
+
+	return err
+}
+
+// sanitizeSubject replaces characters that are not allowed in subject tokens and removes empty tokens,
+// e.g. for events without level
+func sanitizeSubject(subject string) string {
+	replacer := strings.NewReplacer(" ", "_", "*", "_", ">", "_", "\t", "_")
+
+	tokens := strings.Split(replacer.Replace(subject), ".")
+	sanitized := tokens[:0]
+	for _, token := range tokens {
+		if len(token) > 0 {
+			sanitized = append(sanitized, token)
+		}
+	}
+
+	return strings.Join(sanitized, ".")
+}
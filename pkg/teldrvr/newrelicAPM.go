@@ -1,3 +1,5 @@
+//go:build !nonewrelic
+
 package teldrvr
 
 import (
@@ -7,10 +9,13 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
 )
@@ -24,29 +29,53 @@ func init() {
 		log.Fatal(err)
 	}
 
-	if !strings.Contains(cfg.GetString("telemetry.driver"), newrelicDriver) {
+	RegisterDriverConfig(newRelicConfigName, newRelicConfigKeys...)
+	RegisterDriverConfig(newRelicConfigName, SeverityConfigKeys...)
+	registerDriverConfigCheck(newRelicConfigName, checkNewRelicRegion)
+	registerDriverConfigNamespaces(newrelicDriver, newRelicConfigName)
+	registerAttributeLimits(newrelicDriver, newRelicAttributeLimits)
+	registerAttributeCoercion(newrelicDriver, AttributeCoercionFlatten)
+
+	if !driverEnabled(cfg, newrelicDriver) {
 		return
 	}
 
-	newRelicApplication, err := newrelic.NewApplication(
-		newrelic.ConfigAppName(cfg.GetString("telemetry.app")),
-		newrelic.ConfigLicense(cfg.GetString("telemetry.newrelic.licenceKey")),
-		newrelic.ConfigAppLogForwardingEnabled(true),
-	)
+	newRelicApplication, err := createNewRelicApplication(cfg)
 	if err != nil {
-		log.Fatalf("newrelic app could not be created, error: %s", err.Error())
+		// degraded mode, telemetry is still written by the local driver
+		handleError(fmt.Errorf("%s%s falls back to the local driver: %w", telemetry.TelemetryDriverError, newrelicDriver, err))
+		registerDriver(newrelicDriver, configuredLocalDriver(cfg))
+		return
 	}
 
 	driver := NewRelicAPMDriver{
-		NewRelicApp: newRelicApplication,
+		NewRelicApp:             newRelicApplication,
+		Severities:              LoadSeverityMapping(cfg, newRelicConfigName, newRelicSeverities),
+		FollowLogLevel:          DriverConfig(cfg, newRelicConfigName).GetBool("logForwarding.followLogLevel"),
+		QueueTimeWebTransaction: DriverConfig(cfg, newRelicConfigName).GetBool("queueTime.webTransaction"),
 	}
 
-	telemetry.RegisterDriver(newrelicDriver, driver)
+	registerDriver(newrelicDriver, driver)
+	watchNewRelicConnection(cfg, newrelicDriver, newRelicApplication, driver)
+}
+
+// newRelicSeverities are the default severities of the forwarded logs
+var newRelicSeverities = SeverityMapping{
+	logLevelInfo:  "Info",
+	logLevelDebug: "Debug",
 }
 
 // NewRelicAPMDriver holds all information the driver needs for telemetry
 type NewRelicAPMDriver struct {
 	NewRelicApp *newrelic.Application
+	// Severities maps the levels of the forwarded logs to their severity, nil uses Info and Debug
+	Severities SeverityMapping
+	// FollowLogLevel drops the info and debug messages below the log level, by default all are forwarded and the log
+	// level is left to New Relic
+	FollowLogLevel bool
+	// QueueTimeWebTransaction reports the queue time of New Relic, the agent only supports it for web transactions, so
+	// SetQueueStart turns the transaction into a web transaction. By default only the queue.duration attribute is added.
+	QueueTimeWebTransaction bool
 }
 
 // InitializeTransaction starts a transaction
@@ -57,7 +86,14 @@ func (d NewRelicAPMDriver) InitializeTransaction(name string) (telemetry.Transac
 		return nil, errors.New("could not start transaction")
 	}
 
-	transaction := newAPMTransaction(transactionStart)
+	transaction := newAPMTransaction(name, transactionStart)
+	if d.Severities != nil {
+		transaction.severities = d.Severities
+	}
+	// the spans are only needed to link the records of nrZerolog to them
+	transaction.linkSpans = IsDriverActive(zerologDriver)
+	transaction.followLogLevel = d.FollowLogLevel
+	transaction.queueTimeWebTransaction = d.QueueTimeWebTransaction
 
 	return transaction, nil
 }
@@ -71,18 +107,31 @@ type NewRelicSegmentContainer struct {
 
 // APMTransaction used for new relic transactions
 type APMTransaction struct {
+	name             string
 	transaction      *newrelic.Transaction
 	segmentContainer NewRelicSegmentContainer
 	attributes       map[string]any
 	trace            string
 	traceID          string
 	processID        string
+	errorCount       atomic.Int64
+	outcome          string
+	// linkSpans registers the spans of the segments for nrZerolog, see newRelicSpans
+	linkSpans bool
+	// severities maps the levels of the forwarded logs to their severity
+	severities SeverityMapping
+	// followLogLevel drops the messages below the log level of the segment
+	followLogLevel bool
+	// queueTimeWebTransaction turns the transaction into a web transaction at SetQueueStart, see NewRelicAPMDriver
+	queueTimeWebTransaction bool
 }
 
-func newAPMTransaction(transaction *newrelic.Transaction) *APMTransaction {
+func newAPMTransaction(name string, transaction *newrelic.Transaction) *APMTransaction {
 	t := APMTransaction{
+		name:        name,
 		transaction: transaction,
 		attributes:  make(map[string]any),
+		severities:  newRelicSeverities,
 	}
 	t.segmentContainer.segments = make(map[string]*newrelic.Segment)
 	t.segmentContainer.attributes = make(map[string]map[string]any)
@@ -119,10 +168,16 @@ func (t *APMTransaction) SegmentStart(segmentID string, name string) error {
 
 	t.segmentContainer.segments[segmentID] = segment
 
+	if t.linkSpans {
+		// the started segment is the current span of the transaction
+		registerNewRelicSpan(t.processID, segmentID, t.transaction.GetLinkingMetadata())
+	}
+
 	return nil
 }
 
-// AddSegmentAttribute adds an attribute to the currently open segment
+// AddSegmentAttribute adds an attribute to the currently open segment.
+// The attribute is kept in the container and applied to the New Relic segment at SegmentEnd.
 // - Thread safe -
 func (t *APMTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
 	t.segmentContainer.mutex.Lock()
@@ -148,12 +203,12 @@ func (t *APMTransaction) AddSegmentAttribute(segmentID string, key string, value
 
 	t.segmentContainer.attributes[segmentID][key] = value
 
-	segment.AddAttribute(key, value)
-
 	return nil
 }
 
-// SegmentEnd ends the current open segment (LIFO) and keeps track of all opened segments
+// SegmentEnd applies the attributes of the segment, ends it (LIFO) and keeps track of all opened segments.
+// Attributes and end are applied under the lock, so attributes added concurrently are either part of the segment or
+// rejected because the segment is not open anymore.
 func (t *APMTransaction) SegmentEnd(segmentID string) error {
 	t.segmentContainer.mutex.Lock()
 	defer t.segmentContainer.mutex.Unlock()
@@ -162,16 +217,43 @@ func (t *APMTransaction) SegmentEnd(segmentID string) error {
 		return fmt.Errorf("Error trying to end segment. Segment is not open. SegmentID: %s", segmentID)
 	}
 
+	applySegmentAttributes(segment, t.segmentContainer.attributes[segmentID])
+
 	segment.End()
 
 	delete(t.segmentContainer.segments, segmentID)
 	delete(t.segmentContainer.attributes, segmentID)
 
+	if t.linkSpans {
+		deregisterNewRelicSpan(t.processID, segmentID)
+	}
+
 	return nil
 }
 
+// applySegmentAttributes adds the attributes to the segment in a stable order. The agent silently drops values that
+// are no string, bool or number, so they are added in their string form.
+func applySegmentAttributes(segment *newrelic.Segment, attributes map[string]any) {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := attributes[key]
+		switch value.(type) {
+		case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		default:
+			value = fmt.Sprint(value)
+		}
+
+		segment.AddAttribute(key, value)
+	}
+}
+
 // Error logs errors in the transaction
-func (t *APMTransaction) Error(_ string, readCloser io.ReadCloser) error {
+func (t *APMTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
 	// max bytes available for the error message
 	errMsg := make([]byte, telemetry.ErrorBytesSize)
 	defer func() {
@@ -186,67 +268,174 @@ func (t *APMTransaction) Error(_ string, readCloser io.ReadCloser) error {
 		return errors.New("error while reading err message")
 	}
 
-	errLog := errors.New(string(errMsg[:bytesRead]))
+	message := string(errMsg[:bytesRead])
+	t.errorCount.Add(1)
+
+	t.segmentContainer.mutex.RLock()
+	group := errorGroup(t.name, message, t.segmentContainer.attributes[segmentID], t.attributes)
+	t.segmentContainer.mutex.RUnlock()
 
-	t.transaction.NoticeError(errLog)
+	if len(group) == 0 {
+		t.transaction.NoticeError(errors.New(message))
+		return nil
+	}
+
+	t.transaction.NoticeError(newrelic.Error{
+		Message:    message,
+		Attributes: map[string]any{ErrorGroupAttribute: group},
+	})
 
 	return nil
 }
 
-// Info logs info element in the transaction
-func (t *APMTransaction) Info(_ string, readCloser io.ReadCloser) error {
-	// max bytes available for the error message
-	infoMsg := make([]byte, telemetry.DebugByteSize)
+// Info forwards the info message as log of the transaction, see logMessage
+func (t *APMTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	return t.logMessage(logLevelInfo, segmentID, readCloser)
+}
+
+// Debug forwards the debug message as log of the transaction, see logMessage
+func (t *APMTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	return t.logMessage(logLevelDebug, segmentID, readCloser)
+}
+
+// IsLevelEnabled reports whether a message of the level is forwarded in the segment, all are unless the driver follows
+// the log level
+func (t *APMTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	if !t.followLogLevel {
+		return true
+	}
+
+	t.segmentContainer.mutex.RLock()
+	segmentName := ""
+	if segment, ok := t.segmentContainer.segments[segmentID]; ok && segment != nil {
+		segmentName = segment.Name
+	}
+	t.segmentContainer.mutex.RUnlock()
+
+	return messageEnabled(level, segmentName)
+}
+
+// logMessage forwards the message with the logs in context API of New Relic, the agent adds the trace and span ID.
+// The agent does not take attributes for logs, so the segment, the process ID and the segment attributes are appended
+// to the message as key=value pairs. Messages below the log level of the segment are only dropped if the driver follows
+// the log level.
+func (t *APMTransaction) logMessage(level string, segmentID string, readCloser io.ReadCloser) error {
+	severity := t.severities.Severity(level, false)
 	defer func() {
 		closeErr := readCloser.Close()
 		if closeErr != nil {
-			log.Printf("Telemetry driver newRelicAPM could not close reader while logging Info. Potential resource leak!")
+			log.Printf("Telemetry driver newRelicAPM could not close reader while logging %s. Potential resource leak!", severity)
 		}
 	}()
 
-	bytesRead, err := readCloser.Read(infoMsg)
+	t.segmentContainer.mutex.RLock()
+	segmentName := ""
+	if segment, ok := t.segmentContainer.segments[segmentID]; ok && segment != nil {
+		segmentName = segment.Name
+	}
+	segmentAttributes := t.segmentContainer.attributes[segmentID]
+	keys := make([]string, 0, len(segmentAttributes))
+	for key := range segmentAttributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	builder := strings.Builder{}
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf(" %s=%v", key, segmentAttributes[key]))
+	}
+	attributes := builder.String()
+	t.segmentContainer.mutex.RUnlock()
+
+	if t.followLogLevel && !messageEnabled(level, segmentName) {
+		return nil
+	}
+
+	// max bytes available for the message
+	message, err := readLogMessage(readCloser, telemetry.DebugByteSize)
 	if err != nil {
-		return errors.New("error while reading Debug message")
+		return fmt.Errorf("error while reading %s message: %w", severity, err)
 	}
 
-	recordLog := newrelic.LogData{
-		Severity: "Info",
-		Message:  string(infoMsg[:bytesRead]),
+	builder.Reset()
+	builder.Write(message)
+	if len(segmentName) > 0 {
+		builder.WriteString(" segment=")
+		builder.WriteString(segmentName)
+		builder.WriteString(" segmentID=")
+		builder.WriteString(segmentID)
+	}
+	if len(t.processID) > 0 {
+		builder.WriteString(" processID=")
+		builder.WriteString(t.processID)
 	}
+	builder.WriteString(attributes)
+
+	t.transaction.RecordLog(newrelic.LogData{
+		Timestamp: clockNow().UnixMilli(),
+		Severity:  severity,
+		Message:   builder.String(),
+	})
 
-	t.transaction.RecordLog(recordLog)
 	return nil
 }
 
-// Debug logs debug element in the transaction
-func (t *APMTransaction) Debug(_ string, readCloser io.ReadCloser) error {
-	// max bytes available for the error message
-	debugMsg := make([]byte, telemetry.DebugByteSize)
-	defer func() {
-		closeErr := readCloser.Close()
-		if closeErr != nil {
-			log.Printf("Telemetry driver newRelicAPM could not close reader while logging Debug. Potential resource leak!")
-		}
-	}()
+// RecordMetric records a custom metric in new relic
+func (t *APMTransaction) RecordMetric(name string, value float64) error {
+	application := t.transaction.Application()
+	if application == nil {
+		return errors.New("could not record metric, transaction has no application")
+	}
+
+	application.RecordCustomMetric(name, value)
+
+	return nil
+}
 
-	bytesRead, err := readCloser.Read(debugMsg)
+// SetOutcome sets the outcome reported at Done, without one it is derived from the logged errors
+// - Not thread safe -
+func (t *APMTransaction) SetOutcome(outcome string) error {
+	err := validateOutcome(outcome)
 	if err != nil {
-		return errors.New("error while reading Debug message")
+		return err
 	}
 
-	recordLog := newrelic.LogData{
-		Severity: "Debug",
-		Message:  string(debugMsg[:bytesRead]),
-	}
+	t.outcome = outcome
 
-	t.transaction.RecordLog(recordLog)
 	return nil
 }
 
-// Done ends a transaction in new relic
+// SetQueueStart adds the queue.duration attribute. If the driver reports the queue time of New Relic, the queue start is
+// passed as request header, the agent only takes it from a web transaction, so the transaction becomes one.
+func (t *APMTransaction) SetQueueStart(start time.Time) error {
+	if t.queueTimeWebTransaction {
+		header := http.Header{}
+		header.Set(QueueStartHeader, "t="+strconv.FormatInt(start.UnixMicro(), 10))
+
+		t.transaction.SetWebRequest(newrelic.WebRequest{Header: header, Transport: newrelic.TransportQueue})
+	}
+
+	return t.AddTransactionAttribute(QueueDurationAttribute, queueDurationMs(start))
+}
+
+// Done ends a transaction in new relic. The outcome and the number of logged errors are added as attributes. Logged
+// errors were noticed already, a failure set by SetOutcome without a logged error is noticed as error, so the error
+// rate of New Relic matches the outcome.
 func (t *APMTransaction) Done() error {
+	errorCount := int(t.errorCount.Load())
+	outcome := deriveOutcome(t.outcome, errorCount)
+	t.transaction.AddAttribute(OutcomeAttribute, outcome)
+	t.transaction.AddAttribute(ErrorCountAttribute, errorCount)
+	if outcome == OutcomeFailure && errorCount == 0 {
+		t.transaction.NoticeError(fmt.Errorf("transaction %s failed", t.name))
+	}
+
 	t.transaction.End()
 
+	if t.linkSpans {
+		deregisterNewRelicSpans(t.processID)
+	}
+
 	return nil
 }
 
@@ -258,6 +447,13 @@ func (t *APMTransaction) CreateTrace() (string, error) {
 	return t.trace, nil
 }
 
+// InjectTraceHeaders adds the New Relic distributed tracing headers to the header of an outgoing request
+func (t *APMTransaction) InjectTraceHeaders(header http.Header) error {
+	t.transaction.InsertDistributedTraceHeaders(header)
+
+	return nil
+}
+
 // SetTrace sets a trace for the transaction
 func (t *APMTransaction) SetTrace(trace string) error {
 	header := http.Header{}
@@ -286,16 +482,21 @@ func (t *APMTransaction) SetTraceID(traceID string) error {
 
 // CreateProcessID creates a ProcessID for the transaction
 func (t *APMTransaction) CreateProcessID() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetProcessID sets a ProcessID for the transaction
 func (t *APMTransaction) SetProcessID(processID string) error {
+	if t.linkSpans {
+		moveNewRelicSpans(t.processID, processID)
+
+		// without open segments the current span is the one of the transaction
+		t.segmentContainer.mutex.RLock()
+		if len(t.segmentContainer.segments) == 0 {
+			registerNewRelicSpan(processID, "", t.transaction.GetLinkingMetadata())
+		}
+		t.segmentContainer.mutex.RUnlock()
+	}
 	t.processID = processID
 
 	return nil
@@ -0,0 +1,85 @@
+//go:build !nonewrelic
+
+package teldrvr
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// newTestAPMTransaction returns a transaction of an agent that never connects
+func newTestAPMTransaction(t testing.TB) *APMTransaction {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("teldrvr test"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := NewRelicAPMDriver{NewRelicApp: app}.InitializeTransaction("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transaction.(*APMTransaction)
+}
+
+func TestAPMTransactionCountsConcurrentErrors(t *testing.T) {
+	transaction := newTestAPMTransaction(t)
+
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			_ = transaction.Error("", messageReader("failed"))
+		}()
+	}
+	wait.Wait()
+
+	if count := transaction.errorCount.Load(); count != 50 {
+		t.Errorf("errorCount = %d, want 50", count)
+	}
+	if err := transaction.Done(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAPMTransactionAddsSegmentAttributesWhileEnding interleaves AddSegmentAttribute and SegmentEnd, run it with -race
+func TestAPMTransactionAddsSegmentAttributesWhileEnding(t *testing.T) {
+	transaction := newTestAPMTransaction(t)
+
+	for i := 0; i < 20; i++ {
+		segmentID := strconv.Itoa(i)
+		if err := transaction.SegmentStart(segmentID, "segment"); err != nil {
+			t.Fatal(err)
+		}
+
+		var wait sync.WaitGroup
+		for j := 0; j < 10; j++ {
+			wait.Add(1)
+			go func(j int) {
+				defer wait.Done()
+				err := transaction.AddSegmentAttribute(segmentID, "key"+strconv.Itoa(j), j)
+				if err != nil && !strings.Contains(err.Error(), "not existing segment") {
+					t.Errorf("AddSegmentAttribute() = %v, want nil or a not existing segment", err)
+				}
+			}(j)
+		}
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if err := transaction.SegmentEnd(segmentID); err != nil {
+				t.Errorf("SegmentEnd() = %v", err)
+			}
+		}()
+		wait.Wait()
+	}
+
+	if len(transaction.segmentContainer.segments) != 0 || len(transaction.segmentContainer.attributes) != 0 {
+		t.Errorf("%d segments and %d attribute sets are left after the segment end", len(transaction.segmentContainer.segments),
+			len(transaction.segmentContainer.attributes))
+	}
+}
@@ -0,0 +1,470 @@
+//go:build !nonewrelic
+
+package teldrvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// newRelicConfigName is the settings namespace shared by the newrelicAPM and nrZerolog drivers
+const newRelicConfigName = "newrelic"
+
+const newRelicRegionEU = "eu"
+const newRelicRegionUS = "us"
+const newRelicHostEU = "collector.eu01.nr-data.net"
+
+// newRelicConfigKeys are the settings below telemetry.drivers.newrelic
+var newRelicConfigKeys = []ConfigKey{
+	{Name: "licenceKey", Required: true, Secret: true, Validate: validateNewRelicLicenceKey},
+	{Name: "region", Validate: validateNewRelicRegion},
+	{Name: "host"},
+	{Name: "hostDisplayName"},
+	{Name: "labels", Kind: ConfigKindStringMap},
+	{Name: "connectTimeout", Kind: ConfigKindDuration, Default: newRelicConnectTimeout, Min: 0},
+	{Name: "retry.maxAttempts", Kind: ConfigKindInt, Default: 5, Min: 1},
+	{Name: "retry.initialBackoff", Kind: ConfigKindDuration, Default: time.Second, Min: 0},
+	{Name: "logForwarding.enabled", Kind: ConfigKindBool, Default: true},
+	{Name: "logForwarding.maxSamples", Kind: ConfigKindInt, Min: 0},
+	{Name: "logForwarding.followLogLevel", Kind: ConfigKindBool, Default: false},
+	{Name: "queueTime.webTransaction", Kind: ConfigKindBool, Default: false},
+	{Name: "distributedTracing.enabled", Kind: ConfigKindBool},
+	{Name: "spanEvents.enabled", Kind: ConfigKindBool},
+	{Name: "spanEvents.maxSamples", Kind: ConfigKindInt, Min: 0},
+	{Name: "infiniteTracing.host"},
+	{Name: "infiniteTracing.port", Kind: ConfigKindInt, Min: 1, Max: 65535},
+	{Name: "infiniteTracing.queueSize", Kind: ConfigKindInt, Min: 1},
+	{Name: "attributes.include", Kind: ConfigKindStringSlice},
+	{Name: "attributes.exclude", Kind: ConfigKindStringSlice},
+}
+
+// newRelicAttributeLimits are the limits of custom attributes of new relic, attributes over them are dropped silently
+var newRelicAttributeLimits = AttributeLimits{
+	MaxTransactionAttributes: 64,
+	MaxSegmentAttributes:     64,
+	MaxValueLength:           255,
+}
+
+// newRelicLicenceKeyLength is the length of all new relic licence keys
+const newRelicLicenceKeyLength = 40
+
+// newRelicUserKeyPrefix is the prefix of user API keys, they are often mistaken for licence keys
+const newRelicUserKeyPrefix = "NRAK"
+
+// validateNewRelicLicenceKey checks the format of the licence key, so a wrong key is reported before the agent connects
+func validateNewRelicLicenceKey(licenceKey string) error {
+	if strings.HasPrefix(licenceKey, newRelicUserKeyPrefix) {
+		return errors.New("is a user API key, the licence (ingest) key of the account is required")
+	}
+
+	if len(licenceKey) != newRelicLicenceKeyLength {
+		return fmt.Errorf("has to be %d characters long, got %d", newRelicLicenceKeyLength, len(licenceKey))
+	}
+
+	for _, r := range licenceKey {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("contains the invalid character %q, only letters and digits are allowed", r)
+		}
+	}
+
+	prefix, ok := newRelicLicenceKeyPrefix(licenceKey)
+	if ok && !validNewRelicRegionPrefix(prefix) {
+		return fmt.Errorf("has the invalid region prefix »%s«, expected e.g. eu01", prefix)
+	}
+
+	return nil
+}
+
+// newRelicLicenceKeyPrefix returns the region prefix of the licence key, e.g. eu01 of eu01xx...
+// Keys of the US data center have no prefix, they never contain an x.
+func newRelicLicenceKeyPrefix(licenceKey string) (string, bool) {
+	prefix, _, ok := strings.Cut(licenceKey, "x")
+
+	return prefix, ok
+}
+
+// validNewRelicRegionPrefix checks that the prefix consists of lower case letters followed by digits, e.g. eu01
+func validNewRelicRegionPrefix(prefix string) bool {
+	letters := strings.TrimRightFunc(prefix, func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+	if len(letters) == 0 || len(letters) == len(prefix) {
+		return false
+	}
+
+	for _, r := range letters {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRelicLicenceKeyRegion returns the region of the data center the licence key belongs to, e.g. eu for eu01xx...
+func newRelicLicenceKeyRegion(licenceKey string) string {
+	prefix, ok := newRelicLicenceKeyPrefix(licenceKey)
+	if !ok {
+		return newRelicRegionUS
+	}
+
+	return strings.TrimRightFunc(prefix, func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+}
+
+// validateNewRelicRegion checks the region, an empty region is detected from the licence key
+func validateNewRelicRegion(region string) error {
+	if strings.EqualFold(region, newRelicRegionUS) || strings.EqualFold(region, newRelicRegionEU) {
+		return nil
+	}
+
+	return fmt.Errorf("has to be one of %s, %s", newRelicRegionUS, newRelicRegionEU)
+}
+
+// checkNewRelicRegion reports a configured region that does not match the region of the licence key.
+// The agent would connect to the wrong data center and only log that the key is rejected.
+func checkNewRelicRegion(driverCfg Config) []ConfigIssue {
+	region := driverCfg.GetString("region")
+	if len(region) == 0 || len(driverCfg.GetString("host")) > 0 {
+		return nil
+	}
+
+	keyRegion := newRelicLicenceKeyRegion(driverCfg.GetString("licenceKey"))
+	if strings.EqualFold(region, keyRegion) {
+		return nil
+	}
+
+	return []ConfigIssue{{
+		Key:    driverConfigPrefix + newRelicConfigName + ".region",
+		Reason: fmt.Sprintf("is »%s« but the licence key belongs to the region »%s«", region, keyRegion),
+	}}
+}
+
+// newRelicHost returns the collector host: an explicit host, the host of the configured region or the host
+// of the region prefix of the licence key. An empty host is the default US collector.
+func newRelicHost(driverCfg Config) string {
+	host := driverCfg.GetString("host")
+	if len(host) > 0 {
+		return host
+	}
+
+	region := driverCfg.GetString("region")
+	if strings.EqualFold(region, newRelicRegionEU) {
+		return newRelicHostEU
+	}
+
+	if len(region) > 0 {
+		return ""
+	}
+
+	prefix, ok := newRelicLicenceKeyPrefix(driverCfg.GetString("licenceKey"))
+	if !ok || !validNewRelicRegionPrefix(prefix) {
+		return ""
+	}
+
+	return fmt.Sprintf("collector.%s.nr-data.net", prefix)
+}
+
+// newRelicConfigOptions translates the telemetry.drivers.newrelic.* config keys into new relic config options
+func newRelicConfigOptions(cfg Config) []newrelic.ConfigOption {
+	driverCfg := DriverConfig(cfg, newRelicConfigName)
+
+	options := []newrelic.ConfigOption{
+		newrelic.ConfigAppName(cfg.GetString("telemetry.app")),
+		newrelic.ConfigLicense(driverCfg.GetString("licenceKey")),
+		newrelic.ConfigAppLogForwardingEnabled(driverCfg.GetBool("logForwarding.enabled")),
+	}
+
+	if driverCfg.IsSet("logForwarding.maxSamples") {
+		options = append(options, newrelic.ConfigAppLogForwardingMaxSamplesStored(driverCfg.GetInt("logForwarding.maxSamples")))
+	}
+
+	if driverCfg.IsSet("distributedTracing.enabled") {
+		options = append(options, newrelic.ConfigDistributedTracerEnabled(driverCfg.GetBool("distributedTracing.enabled")))
+	}
+
+	if driverCfg.IsSet("spanEvents.maxSamples") {
+		options = append(options, newrelic.ConfigDistributedTracerReservoirLimit(driverCfg.GetInt("spanEvents.maxSamples")))
+	}
+
+	// errors that carry a group are grouped by it in the errors inbox
+	options = append(options, newrelic.ConfigSetErrorGroupCallbackFunction(func(errorInfo newrelic.ErrorInfo) string {
+		group, ok := errorInfo.GetErrorAttribute(ErrorGroupAttribute)
+		if !ok {
+			return ""
+		}
+
+		groupString, _ := group.(string)
+
+		return groupString
+	}))
+
+	options = append(options, func(config *newrelic.Config) {
+		if driverCfg.IsSet("spanEvents.enabled") {
+			config.SpanEvents.Enabled = driverCfg.GetBool("spanEvents.enabled")
+		}
+
+		include := driverCfg.GetStringSlice("attributes.include")
+		if len(include) > 0 {
+			config.Attributes.Include = append(config.Attributes.Include, include...)
+		}
+
+		exclude := driverCfg.GetStringSlice("attributes.exclude")
+		if len(exclude) > 0 {
+			config.Attributes.Exclude = append(config.Attributes.Exclude, exclude...)
+		}
+
+		hostDisplayName := driverCfg.GetString("hostDisplayName")
+		if len(hostDisplayName) > 0 {
+			config.HostDisplayName = hostDisplayName
+		}
+
+		for key, value := range driverCfg.GetStringMapString("labels") {
+			config.Labels[key] = value
+		}
+
+		// infinite tracing requires distributed tracing and span events
+		traceObserverHost := driverCfg.GetString("infiniteTracing.host")
+		if len(traceObserverHost) > 0 {
+			config.DistributedTracer.Enabled = true
+			config.SpanEvents.Enabled = true
+			config.InfiniteTracing.TraceObserver.Host = traceObserverHost
+
+			if driverCfg.IsSet("infiniteTracing.port") {
+				config.InfiniteTracing.TraceObserver.Port = driverCfg.GetInt("infiniteTracing.port")
+			}
+
+			if driverCfg.IsSet("infiniteTracing.queueSize") {
+				config.InfiniteTracing.SpanEvents.QueueSize = driverCfg.GetInt("infiniteTracing.queueSize")
+			}
+		}
+
+		// an explicit host always wins over the region
+		host := newRelicHost(driverCfg)
+		if len(host) > 0 {
+			config.Host = host
+		}
+	})
+
+	return options
+}
+
+// createNewRelicApplication creates the new relic application. The agent connects in the background, so only invalid
+// settings fail here, a missing connection is detected by watchNewRelicConnection.
+func createNewRelicApplication(cfg Config) (*newrelic.Application, error) {
+	err := ValidateDriverConfig(cfg, newRelicConfigName)
+	if err != nil {
+		return nil, err
+	}
+
+	application, err := newrelic.NewApplication(newRelicConfigOptions(cfg)...)
+	if err != nil {
+		return nil, fmt.Errorf("newrelic app could not be created: %w", err)
+	}
+
+	return application, nil
+}
+
+// newRelicConnectTimeout is the time to wait for the connection if telemetry.drivers.newrelic.connectTimeout is not set
+const newRelicConnectTimeout = 10 * time.Second
+
+// newRelicWatches holds the stop channels of the connection watches by application, they are closed with the driver
+var newRelicWatches = struct {
+	stop  map[*newrelic.Application]chan struct{}
+	mutex sync.Mutex
+}{
+	stop: make(map[*newrelic.Application]chan struct{}),
+}
+
+// stopNewRelicWatch ends the connection watch of the application
+func stopNewRelicWatch(app *newrelic.Application) {
+	newRelicWatches.mutex.Lock()
+	defer newRelicWatches.mutex.Unlock()
+
+	stop, ok := newRelicWatches.stop[app]
+	if ok {
+		close(stop)
+		delete(newRelicWatches.stop, app)
+	}
+}
+
+// waitForNewRelicConnection waits up to the timeout for the connection. The agent returns before the timeout only for
+// a terminal error, e.g. a rejected licence key or a shut down application, which is reported as terminal.
+func waitForNewRelicConnection(app *newrelic.Application, timeout time.Duration) (bool, error) {
+	started := time.Now()
+	err := app.WaitForConnection(timeout)
+
+	return err != nil && time.Since(started) < timeout, err
+}
+
+// watchNewRelicConnection waits in the background for the connection of the application. If it is not connected within
+// telemetry.drivers.newrelic.connectTimeout, the driver falls back to the local driver and waits again with exponential
+// backoff up to telemetry.drivers.newrelic.retry.maxAttempts times. It stays on the local driver after a terminal error,
+// the last attempt or when the driver is closed. A connectTimeout of 0 disables the fallback.
+func watchNewRelicConnection(cfg Config, name string, app *newrelic.Application, driver telemetry.Driver) {
+	driverCfg := DriverConfig(cfg, newRelicConfigName)
+	timeout := driverCfg.GetDuration("connectTimeout")
+	if timeout <= 0 {
+		return
+	}
+	maxAttempts := driverCfg.GetInt("retry.maxAttempts")
+	backoff := driverCfg.GetDuration("retry.initialBackoff")
+
+	stop := make(chan struct{})
+	newRelicWatches.mutex.Lock()
+	newRelicWatches.stop[app] = stop
+	newRelicWatches.mutex.Unlock()
+
+	go func() {
+		terminal, err := waitForNewRelicConnection(app, timeout)
+		if err == nil || isStopped(stop) {
+			return
+		}
+
+		// degraded mode, telemetry is still written by the local driver
+		replaceErr := ReplaceDriver(name, newRelicFallbackDriver{LocalDriver: configuredLocalDriver(cfg), app: app})
+		if replaceErr != nil {
+			handleError(fmt.Errorf("%s%w", telemetry.TelemetryDriverError, replaceErr))
+			return
+		}
+		handleError(fmt.Errorf("%s%s is not connected, falling back to the local driver: %w", telemetry.TelemetryDriverError, name, err))
+
+		for attempt := 1; attempt <= maxAttempts && !terminal; attempt++ {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-stop:
+				timer.Stop()
+			case <-timer.C:
+				terminal, err = waitForNewRelicConnection(app, timeout)
+			}
+			// closing the driver shuts the application down, which ends the wait with a terminal error
+			if isStopped(stop) {
+				handleError(fmt.Errorf("%s%s is closed before it connected to new relic", telemetry.TelemetryDriverError, name))
+				return
+			}
+
+			if err == nil {
+				err = ReplaceDriver(name, driver)
+				if err != nil {
+					handleError(fmt.Errorf("%s%w", telemetry.TelemetryDriverError, err))
+					return
+				}
+				log.Printf("%s is connected to new relic after %d attempts, the local driver fallback ends", name, attempt+1)
+				return
+			}
+			backoff *= 2
+		}
+
+		if terminal {
+			handleError(fmt.Errorf("%s%s can not connect to new relic and stays on the local driver: %w", telemetry.TelemetryDriverError, name, err))
+			return
+		}
+		handleError(fmt.Errorf("%s%s is not connected after %d attempts and stays on the local driver: %w", telemetry.TelemetryDriverError,
+			name, maxAttempts+1, err))
+	}()
+}
+
+// isStopped reports whether the channel is closed
+func isStopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// newRelicFallbackDriver is the local driver used while the application is not connected. Closing it ends the watch
+// of the connection and shuts the application down.
+type newRelicFallbackDriver struct {
+	LocalDriver
+	app *newrelic.Application
+}
+
+func (d newRelicFallbackDriver) shutdown() error {
+	stopNewRelicWatch(d.app)
+	d.app.Shutdown(newRelicShutdownTimeout)
+
+	return nil
+}
+
+// newRelicStatus reports whether the application is connected to new relic without waiting for the connection
+func newRelicStatus(app *newrelic.Application) string {
+	if app == nil {
+		return "no application"
+	}
+
+	err := app.WaitForConnection(0)
+	if err != nil {
+		return "not connected"
+	}
+
+	return "connected"
+}
+
+func (d NewRelicAPMDriver) diagnosticsStatus() string {
+	return newRelicStatus(d.NewRelicApp)
+}
+
+func (d ZeroLogDriver) diagnosticsStatus() string {
+	return newRelicStatus(d.NewRelicApp)
+}
+
+// newRelicSelfTestTimeout is the time to wait for the connection if the context has no deadline
+const newRelicSelfTestTimeout = 10 * time.Second
+
+// newRelicSelfTest waits for the connection and sends a synthetic transaction.
+// A rejected licence key is reported as missing connection, the agent only logs the reason.
+func newRelicSelfTest(ctx context.Context, app *newrelic.Application) error {
+	if app == nil {
+		return errors.New("no new relic application")
+	}
+
+	timeout := newRelicSelfTestTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	err := app.WaitForConnection(timeout)
+	if err != nil {
+		return fmt.Errorf("not connected to new relic, check the licence key and region: %w", err)
+	}
+
+	transaction := app.StartTransaction(selfTestTransaction)
+	transaction.AddAttribute(SelfTestAttribute, true)
+	transaction.End()
+
+	return nil
+}
+
+func (d NewRelicAPMDriver) selfTest(ctx context.Context) error {
+	return newRelicSelfTest(ctx, d.NewRelicApp)
+}
+
+func (d ZeroLogDriver) selfTest(ctx context.Context) error {
+	return newRelicSelfTest(ctx, d.NewRelicApp)
+}
+
+// newRelicShutdownTimeout limits sending the harvested data when the drivers are closed
+const newRelicShutdownTimeout = 10 * time.Second
+
+func (d NewRelicAPMDriver) shutdown() error {
+	stopNewRelicWatch(d.NewRelicApp)
+	d.NewRelicApp.Shutdown(newRelicShutdownTimeout)
+	return nil
+}
+
+func (d ZeroLogDriver) shutdown() error {
+	stopNewRelicWatch(d.NewRelicApp)
+	d.NewRelicApp.Shutdown(newRelicShutdownTimeout)
+	return nil
+}
@@ -0,0 +1,107 @@
+//go:build !nonewrelic
+
+package teldrvr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/spf13/viper"
+)
+
+// newUnreachableNewRelicApplication returns an application whose collector refuses the connection
+func newUnreachableNewRelicApplication(t *testing.T) *newrelic.Application {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("teldrvr test"),
+		newrelic.ConfigLicense(strings.Repeat("0", 40)),
+		func(config *newrelic.Config) {
+			config.Host = "127.0.0.1:1"
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+// watchTestErrors registers a driver for the watch and returns the errors passed to the error handler
+func watchTestErrors(t *testing.T, name string) chan error {
+	SetErrorHandler(nil)
+	errs := make(chan error, 10)
+	SetErrorHandler(func(err error) {
+		errs <- err
+	})
+	registerDriver(name, NopDriver{})
+	t.Cleanup(func() {
+		SetErrorHandler(nil)
+		registry.mutex.Lock()
+		delete(registry.drivers, name)
+		registry.mutex.Unlock()
+	})
+
+	return errs
+}
+
+// nextError returns the next error passed to the error handler
+func nextError(t *testing.T, errs chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error was reported")
+		return nil
+	}
+}
+
+func TestWatchNewRelicConnectionGivesUpAfterMaxAttempts(t *testing.T) {
+	errs := watchTestErrors(t, "nrWatchAttempts")
+	app := newUnreachableNewRelicApplication(t)
+	t.Cleanup(func() {
+		app.Shutdown(time.Second)
+	})
+
+	cfg := viper.New()
+	cfg.Set("telemetry.drivers.newrelic.connectTimeout", "50ms")
+	cfg.Set("telemetry.drivers.newrelic.retry.maxAttempts", 2)
+	cfg.Set("telemetry.drivers.newrelic.retry.initialBackoff", "10ms")
+	watchNewRelicConnection(cfg, "nrWatchAttempts", app, NopDriver{})
+
+	if err := nextError(t, errs); !strings.Contains(err.Error(), "falling back to the local driver") {
+		t.Fatalf("first error = %v, want the fallback", err)
+	}
+	driver, _ := RegisteredDriver("nrWatchAttempts")
+	for unwrapper, ok := driver.(DriverUnwrapper); ok; unwrapper, ok = driver.(DriverUnwrapper) {
+		driver = unwrapper.Unwrap()
+	}
+	if _, ok := driver.(newRelicFallbackDriver); !ok {
+		t.Errorf("registered driver = %T, want the fallback", driver)
+	}
+	if err := nextError(t, errs); !strings.Contains(err.Error(), "not connected after 3 attempts") {
+		t.Errorf("last error = %v, want the give up after 3 attempts", err)
+	}
+}
+
+func TestWatchNewRelicConnectionEndsWhenTheDriverIsClosed(t *testing.T) {
+	errs := watchTestErrors(t, "nrWatchClose")
+	app := newUnreachableNewRelicApplication(t)
+
+	cfg := viper.New()
+	cfg.Set("telemetry.drivers.newrelic.connectTimeout", "50ms")
+	cfg.Set("telemetry.drivers.newrelic.retry.maxAttempts", 100)
+	cfg.Set("telemetry.drivers.newrelic.retry.initialBackoff", "10ms")
+	watchNewRelicConnection(cfg, "nrWatchClose", app, NopDriver{})
+
+	if err := nextError(t, errs); !strings.Contains(err.Error(), "falling back to the local driver") {
+		t.Fatalf("first error = %v, want the fallback", err)
+	}
+	driver, _ := RegisteredDriver("nrWatchClose")
+	if err := closeDriver(driver); err != nil {
+		t.Fatal(err)
+	}
+	if err := nextError(t, errs); !strings.Contains(err.Error(), "is closed before it connected") {
+		t.Errorf("last error = %v, want the end of the watch", err)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build !nonewrelic
+
+package teldrvr
+
+import (
+	"sync"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// New Relic logs in context links a log record to the span with these fields
+const (
+	newRelicTraceIDField = "trace.id"
+	newRelicSpanIDField  = "span.id"
+)
+
+// newRelicSpan is the span of a segment of the newrelicAPM driver
+type newRelicSpan struct {
+	traceID string
+	spanID  string
+}
+
+// newRelicSpans links the records of nrZerolog to the spans of newrelicAPM. The telemetry package passes the same
+// process ID and segment IDs to the transactions of all drivers, so the APM transaction registers its spans under them
+// and the zerolog transaction looks them up. The empty segment ID holds the span of the transaction.
+var newRelicSpans = struct {
+	spans map[string]map[string]newRelicSpan // process ID => segment ID => span
+	mutex sync.RWMutex
+}{
+	spans: make(map[string]map[string]newRelicSpan),
+}
+
+// registerNewRelicSpan keeps the current span of the APM transaction for the segment, spans without ID are skipped,
+// e.g. if distributed tracing is disabled
+func registerNewRelicSpan(processID string, segmentID string, metadata newrelic.LinkingMetadata) {
+	if len(processID) == 0 || len(metadata.SpanID) == 0 {
+		return
+	}
+
+	newRelicSpans.mutex.Lock()
+	defer newRelicSpans.mutex.Unlock()
+
+	spans, ok := newRelicSpans.spans[processID]
+	if !ok {
+		spans = make(map[string]newRelicSpan)
+		newRelicSpans.spans[processID] = spans
+	}
+	spans[segmentID] = newRelicSpan{traceID: metadata.TraceID, spanID: metadata.SpanID}
+}
+
+// deregisterNewRelicSpan removes the span of the ended segment
+func deregisterNewRelicSpan(processID string, segmentID string) {
+	newRelicSpans.mutex.Lock()
+	defer newRelicSpans.mutex.Unlock()
+
+	delete(newRelicSpans.spans[processID], segmentID)
+}
+
+// deregisterNewRelicSpans removes all spans of the transaction
+func deregisterNewRelicSpans(processID string) {
+	newRelicSpans.mutex.Lock()
+	defer newRelicSpans.mutex.Unlock()
+
+	delete(newRelicSpans.spans, processID)
+}
+
+// moveNewRelicSpans registers the spans of the transaction under its new process ID
+func moveNewRelicSpans(oldProcessID string, newProcessID string) {
+	newRelicSpans.mutex.Lock()
+	defer newRelicSpans.mutex.Unlock()
+
+	spans, ok := newRelicSpans.spans[oldProcessID]
+	if !ok {
+		return
+	}
+
+	delete(newRelicSpans.spans, oldProcessID)
+	if len(newProcessID) > 0 {
+		newRelicSpans.spans[newProcessID] = spans
+	}
+}
+
+// newRelicSpanOf returns the span of the segment, the empty segment ID returns the span of the transaction
+func newRelicSpanOf(processID string, segmentID string) (newRelicSpan, bool) {
+	newRelicSpans.mutex.RLock()
+	defer newRelicSpans.mutex.RUnlock()
+
+	span, ok := newRelicSpans.spans[processID][segmentID]
+
+	return span, ok
+}
@@ -1,3 +1,5 @@
+//go:build !nonewrelic
+
 package teldrvr
 
 import (
@@ -7,14 +9,13 @@ import (
 	"log"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/newrelic/go-agent/v3/integrations/logcontext-v2/zerologWriter"
 	"github.com/newrelic/go-agent/v3/newrelic"
 
-	"github.com/google/uuid"
-
 	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
 	"github.com/rs/zerolog"
 )
@@ -26,84 +27,257 @@ const newRelicZerologDebug = "debug"
 const newRelicZerologError = "error"
 const newRelicZerologInfo = "info"
 
+// zerologConfigName is the settings namespace of the nrZerolog driver
+const zerologConfigName = "zerolog"
+
+// zerologConfigKeys are the settings below telemetry.drivers.zerolog
+var zerologConfigKeys = []ConfigKey{
+	{Name: "fieldProfile"},
+	{Name: "largeMessage", Kind: ConfigKindBool},
+	{Name: "console", Kind: ConfigKindBool},
+	{Name: "errorOutput", Values: []string{localOutputStdout, localOutputStderr}},
+}
+
 func init() {
 	cfg, err := GetConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if !strings.Contains(cfg.GetString("telemetry.driver"), zerologDriver) {
+	RegisterDriverConfig(newRelicConfigName, newRelicConfigKeys...)
+	registerDriverConfigCheck(newRelicConfigName, checkNewRelicRegion)
+	RegisterDriverConfig(zerologConfigName, zerologConfigKeys...)
+	registerDriverConfigNamespaces(zerologDriver, newRelicConfigName, zerologConfigName)
+	registerAttributeLimits(zerologDriver, newRelicAttributeLimits)
+
+	if !driverEnabled(cfg, zerologDriver) {
 		return
 	}
 
-	newRelicApplication, err := newrelic.NewApplication(
-		newrelic.ConfigAppName(cfg.GetString("telemetry.app")),
-		newrelic.ConfigLicense(cfg.GetString("telemetry.newrelic.licenceKey")),
-		newrelic.ConfigAppLogForwardingEnabled(true),
-	)
+	newRelicApplication, err := createNewRelicApplication(cfg)
 	if err != nil {
-		log.Fatalf("newrelic app could not be created, error: %s", err.Error())
+		// degraded mode, telemetry is still written by the local driver
+		handleError(fmt.Errorf("%s%s falls back to the local driver: %w", telemetry.TelemetryDriverError, zerologDriver, err))
+		registerDriver(zerologDriver, configuredLocalDriver(cfg))
+		return
 	}
 
-	configLogLevel := cfg.GetString("telemetry.logLevel")
-	switch configLogLevel {
-	case logLevelDebug:
-		logLevel = logLevelDebug
-		break
-	case logLevelError:
-		logLevel = logLevelError
-		break
-	case logLevelInfo:
-		logLevel = logLevelInfo
-		break
-	default:
-		log.Println("Got unknown log level from config. Fallback to error level")
-		logLevel = logLevelError
-	}
+	logLevel = configuredLogLevel(cfg)
 
-	driver := ZeroLogDriver{
-		NewRelicApp: newRelicApplication,
-	}
+	driver := newConfiguredZeroLogDriver(cfg, newRelicApplication)
+
+	registerDriver(zerologDriver, driver)
+	watchNewRelicConnection(cfg, zerologDriver, newRelicApplication, driver)
+}
 
-	telemetry.RegisterDriver(zerologDriver, driver)
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+// newConfiguredZeroLogDriver builds the driver of the telemetry.drivers.nrZerolog settings, its transactions share one logger
+func newConfiguredZeroLogDriver(cfg Config, app *newrelic.Application) ZeroLogDriver {
+	return ZeroLogDriver{
+		NewRelicApp:  app,
+		fieldMapping: newFieldMapping(zerologDriver, resolveDriverConfigKey(cfg, zerologConfigName, "fieldProfile")),
+		LargeMessage: DriverConfig(cfg, zerologConfigName).GetBool("largeMessage"),
+		Console:      DriverConfig(cfg, zerologConfigName).GetBool("console"),
+		ErrorOutput:  consoleOutput(DriverConfig(cfg, zerologConfigName).GetString("errorOutput")),
+		shared:       &zerologSharedLogger{},
+	}
 }
 
 // ZeroLogDriver holds all information the driver needs for telemetry
 type ZeroLogDriver struct {
 	NewRelicApp *newrelic.Application
+	// LargeMessage logs complete messages instead of truncating them at telemetry.ErrorBytesSize or telemetry.DebugByteSize
+	LargeMessage bool
+	// Console prints the records with the colored zerolog.ConsoleWriter instead of JSON, they are still forwarded to
+	// New Relic. It is meant for local development.
+	Console bool
+	// ErrorOutput receives the error records instead of stdout, e.g. stderr. They are still forwarded to New Relic.
+	ErrorOutput  io.Writer
+	fieldMapping *fieldMapping
+	// shared holds the logger of all transactions, drivers without it create a logger per transaction
+	shared *zerologSharedLogger
+}
+
+// zerologSharedLogger is created on the first transaction, the writers are stateless and safe for concurrent use
+type zerologSharedLogger struct {
+	logger zerolog.Logger
+	once   sync.Once
 }
 
-// InitializeTransaction starts a transaction
+// InitializeTransaction starts a transaction with a context logger derived from the logger of the driver
 func (d ZeroLogDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
-	writer := zerologWriter.New(os.Stdout, d.NewRelicApp)
-	logger := zerolog.New(writer).With().Timestamp().Logger()
+	var logger zerolog.Logger
+	if d.shared != nil {
+		d.shared.once.Do(func() {
+			d.shared.logger = d.newLogger()
+		})
+		logger = d.shared.logger.With().Logger()
+	} else {
+		logger = d.newLogger()
+	}
 
 	transaction := newZeroLogTransaction(logger)
+	transaction.largeMessage = d.LargeMessage
 
 	return transaction, nil
 }
 
+// newLogger creates the logger writing to stdout and New Relic
+func (d ZeroLogDriver) newLogger() zerolog.Logger {
+	writer := d.newWriter(os.Stdout)
+	if d.ErrorOutput != nil {
+		writer = zerologLevelWriter{
+			Writer: writer,
+			errors: d.newWriter(d.ErrorOutput),
+		}
+	}
+	// the hook reads the time from the clock of the drivers, without configured timestamp it writes the zerolog default
+	// the logger has no level, info and debug messages are filtered per segment by messageEnabled, so the transaction
+	// start, end and metric records are always written. The global zerolog level of the process is not changed.
+	return zerolog.New(writer).Hook(timestampHook{timestamp: configuredTimestamp()})
+}
+
+// newWriter creates the writer of the records printed to the output and forwarded to New Relic
+func (d ZeroLogDriver) newWriter(output io.Writer) io.Writer {
+	if d.Console {
+		return zerologConsoleWriter{
+			forward: zerologWriter.New(io.Discard, d.NewRelicApp),
+			console: zerolog.ConsoleWriter{Out: output, TimeFormat: "15:04:05.000"},
+		}
+	}
+
+	// the mapping is only applied to the output, new relic still receives the original field names
+	if d.fieldMapping != nil {
+		output = d.fieldMapping.writer(output)
+	}
+
+	return zerologWriter.New(output, d.NewRelicApp)
+}
+
+// zerologLevelWriter writes the error records to a separate writer, zerolog passes the level of every record
+type zerologLevelWriter struct {
+	io.Writer
+	errors io.Writer
+}
+
+func (w zerologLevelWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level >= zerolog.ErrorLevel && level != zerolog.NoLevel {
+		return w.errors.Write(p)
+	}
+
+	return w.Writer.Write(p)
+}
+
+// timestampHook adds the configured timestamp to every zerolog event instead of the global zerolog timestamp settings
+type timestampHook struct {
+	timestamp timestamp
+}
+
+func (h timestampHook) Run(event *zerolog.Event, _ zerolog.Level, _ string) {
+	now := clockNow()
+	if h.timestamp.format == timestampFormatUnixMillis {
+		event.Int64(h.timestamp.field, now.UnixMilli())
+		return
+	}
+
+	event.Str(h.timestamp.field, h.timestamp.formatTime(now, time.RFC3339))
+}
+
+// zerologConsoleWriter forwards the JSON records to New Relic and prints them with the console writer
+type zerologConsoleWriter struct {
+	forward io.Writer
+	console io.Writer
+}
+
+func (w zerologConsoleWriter) Write(p []byte) (int, error) {
+	_, forwardErr := w.forward.Write(p)
+	_, consoleErr := w.console.Write(p)
+
+	return len(p), errors.Join(forwardErr, consoleErr)
+}
+
 func (t *ZeroLogTransaction) logTrace(msg string) {
 	preparedLog := t.transaction.Info()
 	if t.trace != "" {
 		preparedLog.Str("traceID", t.trace)
 	}
 	preparedLog.Str("processID", t.processID)
+	span, _ := newRelicSpanOf(t.processID, "")
+	addNewRelicSpan(preparedLog, span)
 
-	for key, value := range t.attributes {
-		preparedLog.Any(key, value)
+	for _, key := range sortedAttributeKeys(t.attributes) {
+		preparedLog.Any(key, t.attributes[key])
 	}
 
 	preparedLog.Msg(msg)
 }
 
-// ZeroLogSegmentContainer used for segment handling
+// addNewRelicSpan adds the fields of New Relic logs in context, so the record is linked to the span of newrelicAPM
+func addNewRelicSpan(preparedLog *zerolog.Event, span newRelicSpan) {
+	if len(span.spanID) == 0 {
+		return
+	}
+
+	preparedLog.
+		Str(newRelicTraceIDField, span.traceID).
+		Str(newRelicSpanIDField, span.spanID)
+}
+
+// ZeroLogSegmentContainer used for segment handling, the segments are sharded by their ID
 type ZeroLogSegmentContainer struct {
-	segments               map[string]string         // key = segment ID | value = name of the segment
-	attributes             map[string]map[string]any // {"segmentID":  {"attributeName": "attribute value"}}
-	mutex                  sync.RWMutex
-	segmentsStartWasLogged map[string]struct{}
+	shards [segmentShardCount]zeroLogSegmentShard
+}
+
+// zeroLogSegmentShard holds the segments whose ID belongs to the shard, the map is created on first use
+type zeroLogSegmentShard struct {
+	segments map[string]*zeroLogSegment // key = segment ID
+	mutex    sync.RWMutex
+}
+
+// zeroLogSegment is the state of an open segment, a message needs a single lookup for all of it
+type zeroLogSegment struct {
+	name        string
+	attributes  map[string]any
+	startLogged bool
+	// span is the span of the segment in newrelicAPM, it is kept so the end record is linked after the span ended
+	span newRelicSpan
+}
+
+// segmentName returns the name of the segment, an empty name for messages outside of a segment
+func (s *zeroLogSegment) segmentName() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.name
+}
+
+// attributeMap returns the attributes of the segment, nil for messages outside of a segment
+func (s *zeroLogSegment) attributeMap() map[string]any {
+	if s == nil {
+		return nil
+	}
+
+	return s.attributes
+}
+
+// linkedSpan returns the span of the segment in newrelicAPM, messages outside of a segment get the span of the transaction
+// - Expects the shard of the segment to be locked -
+func (s *zeroLogSegment) linkedSpan(processID string, segmentID string) newRelicSpan {
+	if s != nil && len(s.span.spanID) > 0 {
+		return s.span
+	}
+
+	span, ok := newRelicSpanOf(processID, segmentID)
+	if ok && s != nil {
+		s.span = span
+	}
+
+	return span
+}
+
+// shard returns the shard of the segment
+func (c *ZeroLogSegmentContainer) shard(segmentID string) *zeroLogSegmentShard {
+	return &c.shards[segmentShardIndex(segmentID)]
 }
 
 // ZeroLogTransaction used for local transactions
@@ -114,16 +288,21 @@ type ZeroLogTransaction struct {
 	attributes       map[string]any
 	trace            string
 	processID        string
+	startTime        time.Time
+	segmentCount     atomic.Int64
+	errorCount       atomic.Int64
+	infoCount        atomic.Int64
+	debugCount       atomic.Int64
+	outcome          string
+	largeMessage     bool
 }
 
 func newZeroLogTransaction(logger zerolog.Logger) *ZeroLogTransaction {
 	t := ZeroLogTransaction{
+		startTime:   clockNow(),
 		transaction: logger,
 		attributes:  make(map[string]any),
 	}
-	t.segmentContainer.segments = make(map[string]string)
-	t.segmentContainer.attributes = make(map[string]map[string]any)
-	t.segmentContainer.segmentsStartWasLogged = make(map[string]struct{})
 	return &t
 }
 
@@ -149,47 +328,51 @@ func (t *ZeroLogTransaction) AddTransactionAttribute(key string, value any) erro
 
 // SegmentStart starts a local segment and keeps track of all opened segments
 func (t *ZeroLogTransaction) SegmentStart(segmentID string, name string) error {
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
-	if t.segmentContainer.segments == nil {
-		t.segmentContainer.segments = make(map[string]string)
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.segments == nil {
+		shard.segments = make(map[string]*zeroLogSegment)
 	}
-	t.segmentContainer.segments[segmentID] = name
-	if logLevel == logLevelDebug {
-		return t.segmentWriteStart(segmentID)
+	segment, ok := shard.segments[segmentID]
+	if !ok {
+		segment = &zeroLogSegment{}
+		shard.segments[segmentID] = segment
+	}
+	segment.name = name
+	t.segmentCount.Add(1)
+	if messageEnabled(logLevelDebug, name) {
+		return t.segmentWriteStart(segmentID, segment)
 	}
 
 	return nil
 }
 
-func (t *ZeroLogTransaction) segmentWriteStart(segmentID string) error {
-	if _, ok := t.segmentContainer.segmentsStartWasLogged[segmentID]; ok {
-		return nil
-	}
-	var name string
-	ok := false
-	if name, ok = t.segmentContainer.segments[segmentID]; !ok {
+func (t *ZeroLogTransaction) segmentWriteStart(segmentID string, segment *zeroLogSegment) error {
+	if segment == nil {
 		return fmt.Errorf("segment name not found for segmentID: %s", segmentID)
 	}
+	if segment.startLogged {
+		return nil
+	}
 
-	msg := fmt.Sprintf("Segment start: %s", name)
-	readCloser := io.NopCloser(strings.NewReader(msg))
-
-	err := t.infoWithAlreadyLockedMutex(segmentID, readCloser)
+	err := t.infoWithAlreadyLockedMutex(segmentID, segment, fmt.Sprintf("Segment start: %s", segment.name))
 	if err != nil {
 		return err
 	}
 
-	t.segmentContainer.segmentsStartWasLogged[segmentID] = struct{}{}
+	segment.startLogged = true
 
 	return nil
 }
 
-func (t *ZeroLogTransaction) infoWithAlreadyLockedMutex(segmentID string, readCloser io.ReadCloser) error {
-	return t.logMessageWithAlreadyLockedMutex(newRelicZerologInfo, segmentID, readCloser)
+func (t *ZeroLogTransaction) infoWithAlreadyLockedMutex(segmentID string, segment *zeroLogSegment, logMsg string) error {
+	return t.logMessageWithAlreadyLockedMutex(newRelicZerologInfo, segmentID, segment, logMsg)
 }
 
-func (t *ZeroLogTransaction) logMessageWithAlreadyLockedMutex(level string, segmentID string, readCloser io.ReadCloser) error {
+// readMessage reads the message up to the limit of the level and closes the reader, it is called before the shard is
+// locked, so a slow reader does not block the other segments of the shard
+func (t *ZeroLogTransaction) readMessage(level string, readCloser io.ReadCloser) (string, error) {
 	defer func() {
 		closeErr := readCloser.Close()
 		if closeErr != nil {
@@ -204,14 +387,22 @@ func (t *ZeroLogTransaction) logMessageWithAlreadyLockedMutex(level string, segm
 		msgByteSize = telemetry.DebugByteSize
 	}
 
-	msg := make([]byte, msgByteSize)
+	// large messages like stack traces are kept complete as a single log event
+	if t.largeMessage {
+		msgByteSize = 0
+	}
 
-	bytesRead, err := readCloser.Read(msg)
+	msg, err := readLogMessage(readCloser, msgByteSize)
 	if err != nil {
-		return errors.New("error while reading message")
+		return "", errors.New("error while reading message")
 	}
 
-	logMsg := string(msg[:bytesRead])
+	return string(msg), nil
+}
+
+// logMessageWithAlreadyLockedMutex logs the message, segment is nil for messages outside of a segment
+// - Expects the shard of the segment to be locked -
+func (t *ZeroLogTransaction) logMessageWithAlreadyLockedMutex(level string, segmentID string, segment *zeroLogSegment, logMsg string) error {
 	var preparedLog *zerolog.Event
 
 	switch level {
@@ -228,14 +419,29 @@ func (t *ZeroLogTransaction) logMessageWithAlreadyLockedMutex(level string, segm
 		return errors.New("unknown log level")
 	}
 
+	attributes := segment.attributeMap()
 	preparedLog.
 		Str("processID", t.processID).
 		Str("traceID", t.trace).
 		Str("segmentID", segmentID).
-		Str("action", t.segmentContainer.segments[segmentID])
+		Str("action", segment.segmentName())
+	addNewRelicSpan(preparedLog, segment.linkedSpan(t.processID, segmentID))
+
+	if callerEnabled {
+		preparedLog.
+			Str("caller", callerInfo()).
+			Uint64("goroutine", goroutineID())
+	}
+
+	for _, key := range sortedAttributeKeys(attributes) {
+		preparedLog.Any(key, attributes[key])
+	}
 
-	for key, value := range t.segmentContainer.attributes[segmentID] {
-		preparedLog.Any(key, value)
+	if level == newRelicZerologError {
+		group := errorGroup(t.name, logMsg, attributes, t.attributes)
+		if len(group) > 0 {
+			preparedLog.Str(ErrorGroupAttribute, group)
+		}
 	}
 
 	preparedLog.Msg(logMsg)
@@ -246,74 +452,56 @@ func (t *ZeroLogTransaction) logMessageWithAlreadyLockedMutex(level string, segm
 // AddSegmentAttribute adds an attribute to the currently open segment
 // - Thread safe -
 func (t *ZeroLogTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	segmentName, segmentExist := t.segmentContainer.segments[segmentID]
+	segment, segmentExist := shard.segments[segmentID]
 	if !segmentExist {
 		return fmt.Errorf("can not add attribute to not existing segment. SegmentID: %s | Key: %s | Value: %s", segmentID, key, value)
 	}
 
-	if t.segmentContainer.attributes == nil {
-		t.segmentContainer.attributes = make(map[string]map[string]any)
-	}
-
-	if t.segmentContainer.attributes[segmentID] == nil {
-		t.segmentContainer.attributes[segmentID] = make(map[string]any)
+	if segment.attributes == nil {
+		segment.attributes = make(map[string]any)
 	}
 
-	attribute, attributeExist := t.segmentContainer.attributes[segmentID][key]
+	attribute, attributeExist := segment.attributes[key]
 	if attributeExist {
-		return fmt.Errorf("segment attribute already exist. Segment: %s | SegmentID: %s | Key: %s | Already set value: %v", segmentName, segmentID, key, attribute)
+		return fmt.Errorf("segment attribute already exist. Segment: %s | SegmentID: %s | Key: %s | Already set value: %v", segment.name, segmentID, key, attribute)
 	}
 
-	t.segmentContainer.attributes[segmentID][key] = value
+	segment.attributes[key] = value
 
 	return nil
 }
 
 // SegmentEnd ends the current open segment (LIFO) and keeps track of all opened segments
 func (t *ZeroLogTransaction) SegmentEnd(segmentID string) error {
-	t.segmentContainer.mutex.Lock()
-	defer t.segmentContainer.mutex.Unlock()
-	_, ok := t.segmentContainer.segments[segmentID]
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	segment, ok := shard.segments[segmentID]
 	if !ok {
 		return fmt.Errorf("Error trying to end segment. Segment is not open. SegmentID: %s", segmentID)
 	}
 
-	err := t.segmentWriteEnd(segmentID)
+	err := t.segmentWriteEnd(segmentID, segment)
 	if err != nil {
 		return err
 	}
 
+	delete(shard.segments, segmentID)
+
 	return nil
 }
 
-func (t *ZeroLogTransaction) segmentWriteEnd(segmentID string) error {
-	if _, ok := t.segmentContainer.segmentsStartWasLogged[segmentID]; !ok {
-		delete(t.segmentContainer.segments, segmentID)
-		delete(t.segmentContainer.attributes, segmentID)
+func (t *ZeroLogTransaction) segmentWriteEnd(segmentID string, segment *zeroLogSegment) error {
+	if !segment.startLogged {
 		return nil
 	}
 
-	name, ok := t.segmentContainer.segments[segmentID]
-	if !ok {
-		return fmt.Errorf("Error trying to end segment. Segment is not open.\nSegmentID: %s", segmentID)
-	}
-
-	msg := fmt.Sprintf("Segment end: %s", name)
-	readCloser := io.NopCloser(strings.NewReader(msg))
 	// implement using our info method
-	err := t.infoWithAlreadyLockedMutex(segmentID, readCloser)
-
-	if err != nil {
-		return err
-	}
-
-	delete(t.segmentContainer.segments, segmentID)
-	delete(t.segmentContainer.attributes, segmentID)
-	delete(t.segmentContainer.segmentsStartWasLogged, segmentID)
-	return nil
+	return t.infoWithAlreadyLockedMutex(segmentID, segment, fmt.Sprintf("Segment end: %s", segment.name))
 }
 
 // Error logs errors in the transaction
@@ -321,66 +509,35 @@ func (t *ZeroLogTransaction) Error(segmentID string, readCloser io.ReadCloser) e
 	return t.logMessage(newRelicZerologError, segmentID, readCloser)
 }
 
+// logMessage reads the message and logs it, only the logging holds the lock of the shard
 func (t *ZeroLogTransaction) logMessage(level string, segmentID string, readCloser io.ReadCloser) error {
-	t.segmentContainer.mutex.Lock()
-	defer func() {
-		t.segmentContainer.mutex.Unlock()
-		closeErr := readCloser.Close()
-		if closeErr != nil {
-			log.Printf("Telemetry driver newRelicZerolog could not close reader while logging Info. Potential resource leak!")
-		}
-	}()
-	t.segmentWriteStart(segmentID)
-
-	// max bytes available for the info message
-	msgByteSize := telemetry.ErrorBytesSize
-
-	if level != newRelicZerologError {
-		msgByteSize = telemetry.DebugByteSize
-	}
-
-	msg := make([]byte, msgByteSize)
-
-	bytesRead, err := readCloser.Read(msg)
+	logMsg, err := t.readMessage(level, readCloser)
 	if err != nil {
-		return errors.New("error while reading message")
+		return err
 	}
 
-	logMsg := string(msg[:bytesRead])
-	var preparedLog *zerolog.Event
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	segment := shard.segments[segmentID]
+	t.segmentWriteStart(segmentID, segment)
 
 	switch level {
-	case newRelicZerologInfo:
-		preparedLog = t.transaction.Info()
-		break
 	case newRelicZerologError:
-		preparedLog = t.transaction.Error()
-		break
+		t.errorCount.Add(1)
+	case newRelicZerologInfo:
+		t.infoCount.Add(1)
 	case newRelicZerologDebug:
-		preparedLog = t.transaction.Debug()
-		break
-	default:
-		return errors.New("unknown log level")
+		t.debugCount.Add(1)
 	}
 
-	preparedLog.
-		Str("processID", t.processID).
-		Str("traceID", t.trace).
-		Str("segmentID", segmentID).
-		Str("action", t.segmentContainer.segments[segmentID])
-
-	for key, value := range t.segmentContainer.attributes[segmentID] {
-		preparedLog.Any(key, value)
-	}
-
-	preparedLog.Msg(logMsg)
-
-	return nil
+	return t.logMessageWithAlreadyLockedMutex(level, segmentID, segment, logMsg)
 }
 
 // Info logs errors in the transaction
 func (t *ZeroLogTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
-	if logLevel == logLevelError {
+	if !t.messageEnabled(logLevelInfo, segmentID) {
 		return nil
 	}
 	return t.logMessage(newRelicZerologInfo, segmentID, readCloser)
@@ -388,16 +545,82 @@ func (t *ZeroLogTransaction) Info(segmentID string, readCloser io.ReadCloser) er
 
 // Debug logs errors in the transaction
 func (t *ZeroLogTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
-	if logLevel != logLevelDebug {
+	if !t.messageEnabled(logLevelDebug, segmentID) {
 		return nil
 	}
 	return t.logMessage(newRelicZerologDebug, segmentID, readCloser)
 }
 
-// Done ends the transaction
+// messageEnabled reports whether a message of the level is logged in the segment, see telemetry.levelOverrides
+func (t *ZeroLogTransaction) messageEnabled(level string, segmentID string) bool {
+	shard := t.segmentContainer.shard(segmentID)
+	shard.mutex.RLock()
+	name := shard.segments[segmentID].segmentName()
+	shard.mutex.RUnlock()
+
+	return messageEnabled(level, name)
+}
+
+// IsLevelEnabled reports whether a message of the level is logged in the segment
+func (t *ZeroLogTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	return t.messageEnabled(level, segmentID)
+}
+
+// RecordMetric writes a metric typed record
+func (t *ZeroLogTransaction) RecordMetric(name string, value float64) error {
+	t.transaction.Info().
+		Str("type", "metric").
+		Str("processID", t.processID).
+		Str("traceID", t.trace).
+		Str("metric.name", name).
+		Float64("metric.value", value).
+		Msg(name)
+
+	return nil
+}
+
+// SetOutcome sets the outcome reported at Done, without one it is derived from the logged errors
+// - Not thread safe -
+func (t *ZeroLogTransaction) SetOutcome(outcome string) error {
+	err := validateOutcome(outcome)
+	if err != nil {
+		return err
+	}
+
+	t.outcome = outcome
+
+	return nil
+}
+
+// Done ends the transaction and logs the duration, the number of segments and the number of messages per level
 func (t *ZeroLogTransaction) Done() error {
-	msg := fmt.Sprintf("Transaction end: %s", t.name)
-	t.logTrace(msg)
+	// the transaction messages are logged in the shard without segment
+	shard := t.segmentContainer.shard("")
+	shard.mutex.Lock()
+	errorCount := int(t.errorCount.Load())
+	preparedLog := t.transaction.Info()
+	if t.trace != "" {
+		preparedLog.Str("traceID", t.trace)
+	}
+	preparedLog.
+		Str("processID", t.processID).
+		Dur("duration", clockSince(t.startTime)).
+		Int64("segmentCount", t.segmentCount.Load()).
+		Int("errorCount", errorCount).
+		Int64("infoCount", t.infoCount.Load()).
+		Int64("debugCount", t.debugCount.Load()).
+		Int(ErrorCountAttribute, errorCount).
+		Str("outcome", deriveOutcome(t.outcome, errorCount))
+	span, _ := newRelicSpanOf(t.processID, "")
+	addNewRelicSpan(preparedLog, span)
+
+	for _, key := range sortedAttributeKeys(t.attributes) {
+		preparedLog.Any(key, t.attributes[key])
+	}
+
+	preparedLog.Msg(fmt.Sprintf("Transaction end: %s", t.name))
+	shard.mutex.Unlock()
+
 	t.Erase()
 
 	return nil
@@ -405,12 +628,7 @@ func (t *ZeroLogTransaction) Done() error {
 
 // CreateTrace creates a trace for the transaction
 func (t *ZeroLogTransaction) CreateTrace() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetTrace sets a trace for the transaction
@@ -438,12 +656,7 @@ func (t *ZeroLogTransaction) SetTraceID(traceID string) error {
 
 // CreateProcessID creates a ProcessID for the transaction
 func (t *ZeroLogTransaction) CreateProcessID() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetProcessID sets a ProcessID for the transaction
@@ -461,8 +674,12 @@ func (t *ZeroLogTransaction) ProcessID() (string, error) {
 // Erase any memory the transaction allocated
 func (t *ZeroLogTransaction) Erase() {
 	t.attributes = nil
-	t.segmentContainer.segments = nil
-	t.segmentContainer.attributes = nil
+	for i := range t.segmentContainer.shards {
+		shard := &t.segmentContainer.shards[i]
+		shard.mutex.Lock()
+		shard.segments = nil
+		shard.mutex.Unlock()
+	}
 
 	// we need to collect the garbage manually here because maps in go do have some problems with the garbage collection
 	// the runtime.GC method is used to manually free the memory
@@ -0,0 +1,63 @@
+//go:build !nonewrelic
+
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/spf13/viper"
+)
+
+// BenchmarkInitializeTransaction compares a driver constructed by hand, which creates a logger per transaction, with the
+// driver built by init, whose transactions derive a context logger from the shared one
+func BenchmarkInitializeTransaction(b *testing.B) {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("teldrvr benchmark"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, benchmark := range []struct {
+		name   string
+		driver ZeroLogDriver
+	}{
+		{name: "handConstructed", driver: ZeroLogDriver{NewRelicApp: app}},
+		{name: "init", driver: newConfiguredZeroLogDriver(viper.GetViper(), app)},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := benchmark.driver.InitializeTransaction("benchmark")
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestZeroLogDriverWritesErrorLevelRecords(t *testing.T) {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("teldrvr test"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errors := bytes.Buffer{}
+	transaction, err := ZeroLogDriver{NewRelicApp: app, ErrorOutput: &errors}.InitializeTransaction("severity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = transaction.Error("", messageReader("failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]any
+	if err = json.Unmarshal(errors.Bytes(), &record); err != nil {
+		t.Fatalf("error output %q is no single record: %v", errors.String(), err)
+	}
+	if record["level"] != "error" {
+		t.Errorf("record level = %v, want error", record["level"])
+	}
+}
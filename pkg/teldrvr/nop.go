@@ -3,7 +3,6 @@ package teldrvr
 import (
 	"io"
 
-	"github.com/google/uuid"
 	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
 )
 
@@ -13,7 +12,7 @@ const nopDriver = "nopDriver"
 func init() {
 	driver := NopDriver{}
 
-	telemetry.RegisterDriver(nopDriver, driver)
+	registerDriver(nopDriver, driver)
 }
 
 // nopDriver holds all information the driver needs for telemetry
@@ -78,6 +77,11 @@ func (t *NopTransaction) Info(segmentID string, readCloser io.ReadCloser) error
 	return nil
 }
 
+// RecordMetric no operation
+func (t *NopTransaction) RecordMetric(name string, value float64) error {
+	return nil
+}
+
 // Done ends the transaction
 func (t *NopTransaction) Done() error {
 	return nil
@@ -88,14 +92,14 @@ func (t *NopTransaction) Debug(segmentID string, readCloser io.ReadCloser) error
 	return nil
 }
 
+// IsLevelEnabled reports false, no message is logged
+func (t *NopTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	return false
+}
+
 // CreateTrace creates a trace for the transaction
 func (t *NopTransaction) CreateTrace() (string, error) {
-	newUUID, err := uuid.NewUUID()
-	if err != nil {
-		return "", err
-	}
-
-	return newUUID.String(), nil
+	return newID()
 }
 
 // SetTrace sets a trace for the transaction
@@ -0,0 +1,220 @@
+// Package otelbridge forwards OpenTelemetry spans into the teldrvr drivers, so libraries instrumented with OpenTelemetry
+// appear in the same telemetry stream. Local root spans become transactions, their child spans become segments:
+//
+//	provider := otelbridge.NewTracerProvider()
+//	otel.SetTracerProvider(provider)
+//	defer provider.Shutdown(context.Background())
+package otelbridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exceptionEvent is the name of the span events recorded by span.RecordError
+const exceptionEvent = "exception"
+
+// NewTracerProvider returns a tracer provider whose spans are forwarded to all active drivers. The options, e.g. a
+// sampler or additional span processors, are passed to the provider. Use NewSpanProcessor to select the drivers.
+func NewTracerProvider(options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	options = append(options, sdktrace.WithSpanProcessor(NewSpanProcessor()))
+
+	return sdktrace.NewTracerProvider(options...)
+}
+
+// bridgedTrace holds the transactions started for a local root span
+type bridgedTrace struct {
+	transactions []telemetry.Transaction
+	rootSpanID   trace.SpanID
+	done         bool
+}
+
+// SpanProcessor starts a transaction per driver for every local root span and a segment for every child span.
+// The attributes, events and the error status are passed when a span ends. Spans whose parent is remote or was not seen
+// by the processor are treated as root spans.
+type SpanProcessor struct {
+	drivers []string
+	spans   map[trace.SpanID]*bridgedTrace
+	mutex   sync.Mutex
+}
+
+// NewSpanProcessor returns a span processor forwarding the spans to the given drivers, all active drivers if none are given
+func NewSpanProcessor(drivers ...string) *SpanProcessor {
+	return &SpanProcessor{
+		drivers: drivers,
+		spans:   make(map[trace.SpanID]*bridgedTrace),
+	}
+}
+
+// OnStart starts a transaction for a root span or a segment for a child span
+func (p *SpanProcessor) OnStart(_ context.Context, span sdktrace.ReadWriteSpan) {
+	spanContext := span.SpanContext()
+	parent := span.Parent()
+
+	p.mutex.Lock()
+	bridged, ok := p.spans[parent.SpanID()]
+	if ok && parent.IsValid() && !parent.IsRemote() && !bridged.done {
+		p.spans[spanContext.SpanID()] = bridged
+		p.mutex.Unlock()
+
+		for _, transaction := range bridged.transactions {
+			handleError(transaction.SegmentStart(spanContext.SpanID().String(), span.Name()))
+		}
+		return
+	}
+	p.mutex.Unlock()
+
+	bridged = &bridgedTrace{rootSpanID: spanContext.SpanID()}
+	for _, driver := range p.targetDrivers() {
+		transaction, err := teldrvr.StartTransaction(driver, span.Name())
+		if err != nil {
+			handleError(fmt.Errorf("%stransaction of span »%s« could not be started by driver %s: %w", telemetry.TelemetryDriverError, span.Name(), driver, err))
+			continue
+		}
+
+		handleError(transaction.SetTrace(spanContext.TraceID().String()))
+		bridged.transactions = append(bridged.transactions, transaction)
+	}
+
+	p.mutex.Lock()
+	p.spans[spanContext.SpanID()] = bridged
+	p.mutex.Unlock()
+}
+
+// OnEnd passes the attributes, events and the error status of the span and ends its transaction or segment.
+// Child spans that end after their root span are dropped, because the transaction is already done.
+func (p *SpanProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	spanID := span.SpanContext().SpanID()
+
+	p.mutex.Lock()
+	bridged, ok := p.spans[spanID]
+	delete(p.spans, spanID)
+	root := ok && bridged.rootSpanID == spanID
+	if root {
+		bridged.done = true
+	}
+	p.mutex.Unlock()
+
+	if !ok || (!root && bridged.done) {
+		return
+	}
+
+	segmentID := ""
+	if !root {
+		segmentID = spanID.String()
+	}
+
+	for _, transaction := range bridged.transactions {
+		forwardSpan(transaction, segmentID, span)
+
+		if root {
+			handleError(transaction.Done())
+			continue
+		}
+		handleError(transaction.SegmentEnd(segmentID))
+	}
+}
+
+// Shutdown drops the open spans, their transactions are not ended
+func (p *SpanProcessor) Shutdown(context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.spans = make(map[trace.SpanID]*bridgedTrace)
+
+	return nil
+}
+
+// ForceFlush has nothing to flush, the spans are forwarded when they end
+func (p *SpanProcessor) ForceFlush(context.Context) error {
+	return nil
+}
+
+// targetDrivers returns the configured drivers or all active drivers
+func (p *SpanProcessor) targetDrivers() []string {
+	if len(p.drivers) > 0 {
+		return p.drivers
+	}
+
+	var drivers []string
+	for _, driver := range teldrvr.RegisteredDrivers() {
+		if teldrvr.IsDriverActive(driver) {
+			drivers = append(drivers, driver)
+		}
+	}
+
+	return drivers
+}
+
+// forwardSpan passes the attributes, links, events and the error status of the span to the transaction or segment
+func forwardSpan(transaction telemetry.Transaction, segmentID string, span sdktrace.ReadOnlySpan) {
+	addAttribute := func(key string, value any) error {
+		if len(segmentID) == 0 {
+			return transaction.AddTransactionAttribute(key, value)
+		}
+		return transaction.AddSegmentAttribute(segmentID, key, value)
+	}
+
+	handleError(addAttribute("otel.spanKind", span.SpanKind().String()))
+	for _, kv := range span.Attributes() {
+		handleError(addAttribute(string(kv.Key), kv.Value.AsInterface()))
+	}
+
+	links := span.Links()
+	if len(links) > 0 {
+		traceIDs := make([]string, 0, len(links))
+		for _, link := range links {
+			traceIDs = append(traceIDs, link.SpanContext.TraceID().String())
+		}
+		handleError(teldrvr.LinkTraces(transaction, segmentID, traceIDs...))
+	}
+
+	for _, event := range span.Events() {
+		message := eventMessage(event.Name, event.Attributes)
+		if event.Name == exceptionEvent {
+			handleError(teldrvr.Errorf(transaction, segmentID, "%s", message))
+			continue
+		}
+		handleError(teldrvr.InfoMsg(transaction, segmentID, message))
+	}
+
+	status := span.Status()
+	if status.Code == codes.Error {
+		message := "span »" + span.Name() + "« failed"
+		if len(status.Description) > 0 {
+			message += ": " + status.Description
+		}
+		handleError(teldrvr.Errorf(transaction, segmentID, "%s", message))
+	}
+}
+
+// eventMessage formats the span event as message, e.g. retry attempt=2
+func eventMessage(name string, attributes []attribute.KeyValue) string {
+	builder := strings.Builder{}
+	builder.WriteString(name)
+	for _, kv := range attributes {
+		builder.WriteString(" ")
+		builder.WriteString(string(kv.Key))
+		builder.WriteString("=")
+		builder.WriteString(kv.Value.Emit())
+	}
+
+	return builder.String()
+}
+
+// handleError passes errors of the drivers to the OpenTelemetry error handler, the processor can not return them
+func handleError(err error) {
+	if err != nil {
+		otel.Handle(err)
+	}
+}
@@ -0,0 +1,61 @@
+package teldrvr
+
+import (
+	"fmt"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// outcomes of a transaction, named like the event.outcome field of the Elastic Common Schema
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeUnknown = "unknown"
+)
+
+// OutcomeAttribute holds the outcome of transactions that do not support outcomes
+const OutcomeAttribute = "outcome"
+
+// OutcomeSetter is implemented by all transactions that report an outcome at Done
+type OutcomeSetter interface {
+	SetOutcome(outcome string) error
+}
+
+// SetOutcome sets the outcome of the transaction, it overrides the derived outcome.
+// Transactions that do not support outcomes get the outcome attribute.
+func SetOutcome(transaction telemetry.Transaction, outcome string) error {
+	err := validateOutcome(outcome)
+	if err != nil {
+		return err
+	}
+
+	setter, ok := findTransaction[OutcomeSetter](transaction)
+	if !ok {
+		return innermostTransaction(transaction).AddTransactionAttribute(OutcomeAttribute, outcome)
+	}
+
+	return setter.SetOutcome(outcome)
+}
+
+// validateOutcome checks the outcome is success, failure or unknown
+func validateOutcome(outcome string) error {
+	switch outcome {
+	case OutcomeSuccess, OutcomeFailure, OutcomeUnknown:
+		return nil
+	default:
+		return fmt.Errorf("outcome »%s« has to be one of %s, %s, %s", outcome, OutcomeSuccess, OutcomeFailure, OutcomeUnknown)
+	}
+}
+
+// deriveOutcome returns the outcome set by SetOutcome, without one the transaction failed if it logged an error
+func deriveOutcome(outcome string, errorCount int) string {
+	if len(outcome) > 0 {
+		return outcome
+	}
+
+	if errorCount > 0 {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
@@ -0,0 +1,218 @@
+package teldrvr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const pagerdutyDriver = "pagerduty"
+
+const pagerdutyDefaultURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerduty limits the summary to 1024 characters
+const pagerdutySummaryLimit = 1024
+
+// CriticalAttribute marks an error as critical if set to true as transaction or segment attribute
+const CriticalAttribute = "critical"
+
+// pagerdutySeverities are the default severities of the alerts, PagerDuty knows critical, error, warning and info
+var pagerdutySeverities = SeverityMapping{
+	logLevelError:    "error",
+	severityCritical: "critical",
+}
+
+// pagerdutyConfigKeys are the settings below telemetry.drivers.pagerduty
+var pagerdutyConfigKeys = []ConfigKey{
+	{Name: "routingKey", Required: true, Secret: true},
+	{Name: "url", Default: pagerdutyDefaultURL},
+	{Name: "criticalOnly", Kind: ConfigKindBool, Default: true},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(pagerdutyDriver, pagerdutyConfigKeys...)
+	RegisterDriverConfig(pagerdutyDriver, TLSConfigKeys...)
+	RegisterDriverConfig(pagerdutyDriver, EmitPolicyConfigKeys...)
+	RegisterDriverConfig(pagerdutyDriver, SeverityConfigKeys...)
+
+	if !driverEnabled(cfg, pagerdutyDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, pagerdutyDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no alerts will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(pagerdutyDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, pagerdutyDriver)
+
+	policy := LoadEmitPolicy(cfg, pagerdutyDriver, DefaultEmitPolicy)
+	client, err := newHTTPClient(cfg, pagerdutyDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no alerts will be sent: %w", telemetry.TelemetryDriverError, pagerdutyDriver, err))
+		registerDriver(pagerdutyDriver, NopDriver{})
+		return
+	}
+
+	source, _ := os.Hostname()
+
+	sink := &PagerDutySink{
+		URL:          driverCfg.GetString("url"),
+		RoutingKey:   driverCfg.GetString("routingKey"),
+		Source:       source,
+		CriticalOnly: driverCfg.GetBool("criticalOnly"),
+		Policy:       policy,
+		Client:       client,
+		Severities:   LoadSeverityMapping(cfg, pagerdutyDriver, pagerdutySeverities),
+	}
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(pagerdutyDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(pagerdutyDriver, driver)
+}
+
+// PagerDutySink triggers PagerDuty alerts for error events using the Events API v2
+type PagerDutySink struct {
+	URL          string
+	RoutingKey   string
+	Source       string
+	CriticalOnly bool
+	Policy       EmitPolicy
+	Client       *http.Client
+	// Severities maps the errors to the severities of the alerts, nil uses error and critical
+	Severities SeverityMapping
+}
+
+type pagerdutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerdutyPayload `json:"payload"`
+}
+
+type pagerdutyPayload struct {
+	Summary       string         `json:"summary"`
+	Source        string         `json:"source"`
+	Severity      string         `json:"severity"`
+	Timestamp     string         `json:"timestamp"`
+	Component     string         `json:"component,omitempty"`
+	Group         string         `json:"group,omitempty"`
+	Class         string         `json:"class,omitempty"`
+	CustomDetails map[string]any `json:"custom_details,omitempty"`
+}
+
+// SelfTest is skipped, a synthetic event would notify people
+func (s *PagerDutySink) SelfTest(ctx context.Context) error {
+	return ErrSelfTestSkipped
+}
+
+// Emit triggers an alert for error events, all other events are ignored
+func (s *PagerDutySink) Emit(event Event) error {
+	if event.Type != eventTypeLog || event.Level != logLevelError {
+		return nil
+	}
+
+	critical, _ := event.Attributes[CriticalAttribute].(bool)
+	if s.CriticalOnly && !critical {
+		return nil
+	}
+
+	severities := s.Severities
+	if severities == nil {
+		severities = pagerdutySeverities
+	}
+
+	summary := fmt.Sprintf("%s: %s", event.Transaction, event.Message)
+	if len(summary) > pagerdutySummaryLimit {
+		summary = summary[:pagerdutySummaryLimit]
+	}
+
+	customDetails := make(map[string]any, len(event.Attributes)+2)
+	for key, value := range event.Attributes {
+		customDetails[key] = value
+	}
+	customDetails["traceID"] = event.TraceID
+	customDetails["processID"] = event.ProcessID
+
+	body, err := json.Marshal(pagerdutyEvent{
+		RoutingKey:  s.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    pagerdutyDedupKey(event),
+		Payload: pagerdutyPayload{
+			Summary:       summary,
+			Source:        s.Source,
+			Severity:      severities.EventSeverity(event),
+			Timestamp:     event.Time.Format(time.RFC3339),
+			Component:     event.Transaction,
+			Group:         event.Segment,
+			Class:         event.ErrorGroup,
+			CustomDetails: customDetails,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := s.URL
+	if len(url) == 0 {
+		url = pagerdutyDefaultURL
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return s.Policy.Do(func() (bool, error) {
+		return s.post(client, url, body)
+	})
+}
+
+// post sends the event once and reports whether a failed request should be retried
+func (s *PagerDutySink) post(client *http.Client, url string, body []byte) (bool, error) {
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("pagerduty responded with status %d: %s", response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
+
+// pagerdutyDedupKey uses the error group as fingerprint, so repeated errors are merged into one alert
+func pagerdutyDedupKey(event Event) string {
+	fingerprint := event.ErrorGroup
+	if len(fingerprint) == 0 {
+		fingerprint = event.Message
+	}
+
+	hash := sha1.Sum([]byte(event.Transaction + "|" + fingerprint))
+
+	return hex.EncodeToString(hash[:])
+}
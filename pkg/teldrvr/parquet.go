@@ -0,0 +1,372 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+const parquetMagic = "PAR1"
+const parquetCreatedBy = "mc-telemetry-driver"
+const parquetContentType = "application/vnd.apache.parquet"
+const parquetExtension = ".parquet"
+
+// physical types, repetition types and converted types of the Parquet format
+const (
+	parquetTypeInt32     = 1
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetConvertedNone            = -1
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+)
+
+// encodings, codecs and page types of the Parquet format
+const (
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetCodecGzip         = 2
+	parquetCodecZstd         = 6
+
+	parquetPageData = 0
+)
+
+// parquetColumn is a column of the event table. value returns false for null values of optional columns.
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	repetition    int32
+	value         func(event Event) (any, bool)
+}
+
+// parquetColumns are the columns of the Parquet files, named like the columns of the ClickHouse table
+var parquetColumns = []parquetColumn{
+	{name: "time", physicalType: parquetTypeInt64, convertedType: parquetConvertedTimestampMicros, repetition: parquetRequired,
+		value: func(event Event) (any, bool) { return event.Time.UnixMicro(), true }},
+	parquetStringColumn("type", parquetRequired, func(event Event) string { return event.Type }),
+	parquetStringColumn("level", parquetOptional, func(event Event) string { return event.Level }),
+	parquetStringColumn("transaction", parquetRequired, func(event Event) string { return event.Transaction }),
+	parquetStringColumn("trace_id", parquetOptional, func(event Event) string { return event.TraceID }),
+	parquetStringColumn("process_id", parquetOptional, func(event Event) string { return event.ProcessID }),
+	parquetStringColumn("segment_id", parquetOptional, func(event Event) string { return event.SegmentID }),
+	parquetStringColumn("segment", parquetOptional, func(event Event) string { return event.Segment }),
+	parquetStringColumn("message", parquetOptional, func(event Event) string { return event.Message }),
+	parquetStringColumn("error_group", parquetOptional, func(event Event) string { return event.ErrorGroup }),
+	parquetStringColumn("metric_name", parquetOptional, func(event Event) string { return event.MetricName }),
+	{name: "metric_value", physicalType: parquetTypeDouble, convertedType: parquetConvertedNone, repetition: parquetOptional,
+		value: func(event Event) (any, bool) { return event.MetricValue, event.Type == eventTypeMetric }},
+	{name: "duration_ms", physicalType: parquetTypeInt64, convertedType: parquetConvertedNone, repetition: parquetOptional,
+		value: func(event Event) (any, bool) {
+			return event.Duration.Milliseconds(), event.Type == eventTypeTransactionEnd
+		}},
+	{name: "segment_count", physicalType: parquetTypeInt32, convertedType: parquetConvertedNone, repetition: parquetOptional,
+		value: func(event Event) (any, bool) {
+			return int32(event.SegmentCount), event.Type == eventTypeTransactionEnd
+		}},
+	{name: "error_count", physicalType: parquetTypeInt32, convertedType: parquetConvertedNone, repetition: parquetOptional,
+		value: func(event Event) (any, bool) {
+			return int32(event.ErrorCount), event.Type == eventTypeTransactionEnd
+		}},
+	parquetStringColumn("outcome", parquetOptional, func(event Event) string { return event.Outcome }),
+	// attributes are a JSON object, annotated as UTF8 because not all engines support the JSON annotation
+	{name: "attributes", physicalType: parquetTypeByteArray, convertedType: parquetConvertedUTF8, repetition: parquetOptional,
+		value: func(event Event) (any, bool) {
+			if len(event.Attributes) == 0 {
+				return nil, false
+			}
+
+			attributes, err := json.Marshal(event.Attributes)
+			if err != nil {
+				return fmt.Sprintf(`{"error":%q}`, err.Error()), true
+			}
+
+			return string(attributes), true
+		}},
+}
+
+// parquetStringColumn creates a UTF8 column, empty strings of optional columns are null
+func parquetStringColumn(name string, repetition int32, value func(event Event) string) parquetColumn {
+	return parquetColumn{
+		name:          name,
+		physicalType:  parquetTypeByteArray,
+		convertedType: parquetConvertedUTF8,
+		repetition:    repetition,
+		value: func(event Event) (any, bool) {
+			v := value(event)
+			return v, repetition == parquetRequired || len(v) > 0
+		},
+	}
+}
+
+// parquetCodec returns the Parquet codec of the compression, the pages are compressed instead of the whole file
+func parquetCodec(compression string) (int32, error) {
+	switch compression {
+	case "", compressionNone:
+		return parquetCodecUncompressed, nil
+	case compressionGzip:
+		return parquetCodecGzip, nil
+	case compressionZstd:
+		return parquetCodecZstd, nil
+	default:
+		return 0, validateCompression(compression)
+	}
+}
+
+// encodeParquet converts the events into a Parquet file with one row group and one plain encoded page per column.
+// The file can be queried directly, e.g. with Athena or DuckDB.
+func encodeParquet(events []Event, compression string) ([]byte, error) {
+	codec, err := parquetCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	file := bytes.Buffer{}
+	file.WriteString(parquetMagic)
+
+	var totalSize int64
+	chunks := make([]func(w *thriftWriter), 0, len(parquetColumns))
+	for _, column := range parquetColumns {
+		page, uncompressedSize, err := encodeParquetPage(column, events, compression)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.name, err)
+		}
+
+		header := thriftWriter{}
+		header.structBegin()
+		header.i32Field(1, parquetPageData)
+		header.i32Field(2, int32(uncompressedSize))
+		header.i32Field(3, int32(len(page)))
+		header.structField(5)
+		header.i32Field(1, int32(len(events)))
+		header.i32Field(2, parquetEncodingPlain)
+		header.i32Field(3, parquetEncodingRLE)
+		header.i32Field(4, parquetEncodingRLE)
+		header.structEnd()
+		header.structEnd()
+
+		offset := int64(file.Len())
+		file.Write(header.Bytes())
+		file.Write(page)
+
+		compressedSize := int64(header.Len() + len(page))
+		uncompressed := int64(header.Len() + uncompressedSize)
+		totalSize += uncompressed
+
+		column := column
+		chunks = append(chunks, func(w *thriftWriter) {
+			w.structBegin()
+			w.i64Field(2, offset)
+			w.structField(3)
+			w.i32Field(1, column.physicalType)
+			w.listField(2, thriftTypeI32, 2)
+			w.i32(parquetEncodingPlain)
+			w.i32(parquetEncodingRLE)
+			w.listField(3, thriftTypeBinary, 1)
+			w.binary(column.name)
+			w.i32Field(4, codec)
+			w.i64Field(5, int64(len(events)))
+			w.i64Field(6, uncompressed)
+			w.i64Field(7, compressedSize)
+			w.i64Field(9, offset)
+			w.structEnd()
+			w.structEnd()
+		})
+	}
+
+	metadata := thriftWriter{}
+	metadata.structBegin()
+	metadata.i32Field(1, 1)
+	metadata.listField(2, thriftTypeStruct, len(parquetColumns)+1)
+	metadata.structBegin()
+	metadata.binaryField(4, "schema")
+	metadata.i32Field(5, int32(len(parquetColumns)))
+	metadata.structEnd()
+	for _, column := range parquetColumns {
+		metadata.structBegin()
+		metadata.i32Field(1, column.physicalType)
+		metadata.i32Field(3, column.repetition)
+		metadata.binaryField(4, column.name)
+		if column.convertedType != parquetConvertedNone {
+			metadata.i32Field(6, column.convertedType)
+		}
+		metadata.structEnd()
+	}
+	metadata.i64Field(3, int64(len(events)))
+	metadata.listField(4, thriftTypeStruct, 1)
+	metadata.structBegin()
+	metadata.listField(1, thriftTypeStruct, len(chunks))
+	for _, chunk := range chunks {
+		chunk(&metadata)
+	}
+	metadata.i64Field(2, totalSize)
+	metadata.i64Field(3, int64(len(events)))
+	metadata.structEnd()
+	metadata.binaryField(6, parquetCreatedBy)
+	metadata.structEnd()
+
+	file.Write(metadata.Bytes())
+	_ = binary.Write(&file, binary.LittleEndian, uint32(metadata.Len()))
+	file.WriteString(parquetMagic)
+
+	return file.Bytes(), nil
+}
+
+// encodeParquetPage returns the compressed data page of the column and its uncompressed size.
+// Optional columns start with the definition levels, null values are not written.
+func encodeParquetPage(column parquetColumn, events []Event, compression string) ([]byte, int, error) {
+	values := bytes.Buffer{}
+	levels := make([]bool, 0, len(events))
+
+	for _, event := range events {
+		value, ok := column.value(event)
+		levels = append(levels, ok)
+		if !ok {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			_ = binary.Write(&values, binary.LittleEndian, uint32(len(v)))
+			values.WriteString(v)
+		case int32:
+			_ = binary.Write(&values, binary.LittleEndian, v)
+		case int64:
+			_ = binary.Write(&values, binary.LittleEndian, v)
+		case float64:
+			_ = binary.Write(&values, binary.LittleEndian, math.Float64bits(v))
+		default:
+			return nil, 0, fmt.Errorf("unsupported value %T", value)
+		}
+	}
+
+	page := bytes.Buffer{}
+	if column.repetition == parquetOptional {
+		definitionLevels := encodeParquetLevels(levels)
+		_ = binary.Write(&page, binary.LittleEndian, uint32(len(definitionLevels)))
+		page.Write(definitionLevels)
+	}
+	page.Write(values.Bytes())
+
+	compressed, err := compress(compression, page.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return compressed, page.Len(), nil
+}
+
+// encodeParquetLevels encodes the definition levels with bit width 1 as RLE runs of the hybrid encoding
+func encodeParquetLevels(levels []bool) []byte {
+	encoded := make([]byte, 0, 8)
+	for start := 0; start < len(levels); {
+		end := start + 1
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+
+		encoded = binary.AppendUvarint(encoded, uint64(end-start)<<1)
+		if levels[start] {
+			encoded = append(encoded, 1)
+		} else {
+			encoded = append(encoded, 0)
+		}
+
+		start = end
+	}
+
+	return encoded
+}
+
+// element types of the Thrift compact protocol
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+// thriftWriter writes the Thrift compact protocol the Parquet metadata is serialized with
+type thriftWriter struct {
+	bytes.Buffer
+	lastField  int16
+	fieldStack []int16
+}
+
+func (w *thriftWriter) structBegin() {
+	w.fieldStack = append(w.fieldStack, w.lastField)
+	w.lastField = 0
+}
+
+func (w *thriftWriter) structEnd() {
+	w.WriteByte(0)
+	w.lastField = w.fieldStack[len(w.fieldStack)-1]
+	w.fieldStack = w.fieldStack[:len(w.fieldStack)-1]
+}
+
+func (w *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	delta := id - w.lastField
+	if delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.WriteByte(fieldType)
+		w.varint(int64(id))
+	}
+	w.lastField = id
+}
+
+// varint writes the zigzag encoded value
+func (w *thriftWriter) varint(value int64) {
+	w.Write(binary.AppendUvarint(nil, uint64((value<<1)^(value>>63))))
+}
+
+func (w *thriftWriter) i32(value int32) {
+	w.varint(int64(value))
+}
+
+func (w *thriftWriter) binary(value string) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	w.WriteString(value)
+}
+
+func (w *thriftWriter) i32Field(id int16, value int32) {
+	w.fieldHeader(id, thriftTypeI32)
+	w.i32(value)
+}
+
+func (w *thriftWriter) i64Field(id int16, value int64) {
+	w.fieldHeader(id, thriftTypeI64)
+	w.varint(value)
+}
+
+func (w *thriftWriter) binaryField(id int16, value string) {
+	w.fieldHeader(id, thriftTypeBinary)
+	w.binary(value)
+}
+
+// structField starts a nested struct, it is finished with structEnd
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, thriftTypeStruct)
+	w.structBegin()
+}
+
+// listField starts a list, the elements are written afterwards without field headers
+func (w *thriftWriter) listField(id int16, elementType byte, size int) {
+	w.fieldHeader(id, thriftTypeList)
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+	w.WriteByte(0xF0 | elementType)
+	w.Write(binary.AppendUvarint(nil, uint64(size)))
+}
@@ -0,0 +1,198 @@
+package teldrvr
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const parquetDriver = "parquet"
+
+const parquetDefaultMaxEvents = 10000
+const parquetDefaultFlushInterval = 5 * time.Minute
+
+// parquetConfigKeys are the settings below telemetry.drivers.parquet
+var parquetConfigKeys = []ConfigKey{
+	{Name: "dir", Required: true},
+	{Name: "compression", Default: compressionGzip, Values: compressionValues},
+	{Name: "maxEvents", Kind: ConfigKindInt, Default: parquetDefaultMaxEvents, Min: 1},
+	{Name: "flushInterval", Kind: ConfigKindDuration, Default: parquetDefaultFlushInterval, Min: time.Millisecond},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(parquetDriver, parquetConfigKeys...)
+
+	if !driverEnabled(cfg, parquetDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, parquetDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no events will be archived", telemetry.TelemetryDriverError, err))
+		registerDriver(parquetDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, parquetDriver)
+
+	sink := NewParquetFileSink(driverCfg.GetString("dir"), driverCfg.GetInt("maxEvents"), driverCfg.GetDuration("flushInterval"))
+	sink.Compression = driverCfg.GetString("compression")
+	sink.Start()
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(parquetDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(parquetDriver, driver)
+}
+
+// ParquetFileSink accumulates the events and periodically writes them as Parquet files into a local directory, e.g. a
+// volume synced to an object storage. The files are partitioned by the hour of the write like the objects of the
+// S3Sink: <dir>/YYYY/MM/DD/HH/<time>-<uuid>.parquet
+// Files are written under a temporary name and renamed afterwards, so readers never see incomplete files.
+type ParquetFileSink struct {
+	Dir string
+	// Compression of the pages, "gzip" (default), "zstd" or "none"
+	Compression string
+	// MaxEvents is the maximum number of events per file
+	MaxEvents int
+	// FlushInterval is the maximum time an event is kept in memory
+	FlushInterval time.Duration
+
+	mutex  sync.Mutex
+	events []Event
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewParquetFileSink creates a ParquetFileSink, Start has to be called to enable the periodic flush
+func NewParquetFileSink(dir string, maxEvents int, flushInterval time.Duration) *ParquetFileSink {
+	if maxEvents < 1 {
+		maxEvents = parquetDefaultMaxEvents
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = parquetDefaultFlushInterval
+	}
+
+	return &ParquetFileSink{
+		Dir:           dir,
+		Compression:   compressionGzip,
+		MaxEvents:     maxEvents,
+		FlushInterval: flushInterval,
+		events:        make([]Event, 0, maxEvents),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush in a background goroutine, the flush timer is taken from the clock of the drivers
+func (s *ParquetFileSink) Start() {
+	ticker := CurrentClock().NewTicker(s.FlushInterval)
+
+	s.wg.Add(1)
+	go s.run(ticker)
+}
+
+func (s *ParquetFileSink) run(ticker Ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			err := s.Flush()
+			if err != nil {
+				handleError(fmt.Errorf("%s%s could not flush events: %w", telemetry.TelemetryDriverError, parquetDriver, err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Emit buffers the event and flushes the buffer if the file is full
+func (s *ParquetFileSink) Emit(event Event) error {
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= s.MaxEvents
+	s.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return s.Flush()
+}
+
+// Flush writes the buffered events into a new file, the events of a failed write are dropped
+func (s *ParquetFileSink) Flush() error {
+	s.mutex.Lock()
+	events := s.events
+	s.events = make([]Event, 0, s.MaxEvents)
+	s.mutex.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	body, err := encodeParquet(events, s.Compression)
+	if err != nil {
+		return fmt.Errorf("%d events dropped: %w", len(events), err)
+	}
+
+	err = s.write(body, clockNow().UTC())
+	if err != nil {
+		return fmt.Errorf("%d events dropped: %w", len(events), err)
+	}
+
+	return nil
+}
+
+// write stores the file in the partition of the hour
+func (s *ParquetFileSink) write(body []byte, now time.Time) error {
+	dir := filepath.Join(s.Dir, filepath.FromSlash(now.Format("2006/01/02/15")))
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s%s", now.UnixNano(), uuid.NewString(), parquetExtension))
+	temporary := path + ".tmp"
+
+	err = os.WriteFile(temporary, body, 0o640)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(temporary, path)
+	if err != nil {
+		_ = os.Remove(temporary)
+		return err
+	}
+
+	return nil
+}
+
+// Close stops the background goroutine and writes the buffered events
+func (s *ParquetFileSink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	return s.Flush()
+}
@@ -0,0 +1,392 @@
+// Package pgdrvr provides a telemetry driver storing transactions, segments and messages in PostgreSQL tables.
+// Import it for its side effect and add "postgres" to telemetry.driver or telemetry.external:
+//
+//	import _ "github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr/pgdrvr"
+package pgdrvr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const postgresDriver = "postgres"
+
+const defaultTablePrefix = "telemetry_"
+const defaultBatchSize = 100
+const defaultFlushInterval = 5 * time.Second
+const retentionInterval = time.Hour
+
+const eventTypeTransactionEnd = "transaction.end"
+const eventTypeSegmentEnd = "segment.end"
+const eventTypeLog = "log"
+
+var tablePrefixPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// configKeys are the settings below telemetry.drivers.postgres
+var configKeys = []teldrvr.ConfigKey{
+	{Name: "dsn", Required: true, Secret: true},
+	{Name: "tablePrefix", Default: defaultTablePrefix},
+	{Name: "createTables", Kind: teldrvr.ConfigKindBool},
+	{Name: "batchSize", Kind: teldrvr.ConfigKindInt, Default: defaultBatchSize, Min: 1},
+	{Name: "flushInterval", Kind: teldrvr.ConfigKindDuration, Default: defaultFlushInterval, Min: time.Millisecond},
+	{Name: "retention", Kind: teldrvr.ConfigKindDuration, Min: 0},
+	{Name: "queueSize", Kind: teldrvr.ConfigKindInt, Min: 1},
+}
+
+func init() {
+	teldrvr.RegisterDriverConfig(postgresDriver, configKeys...)
+	teldrvr.RegisterDriverConfig(postgresDriver, teldrvr.EmitPolicyConfigKeys...)
+
+	err := teldrvr.RegisterExternal(postgresDriver, newDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newDriver(cfg teldrvr.Config) (telemetry.Driver, error) {
+	driverCfg := teldrvr.DriverConfig(cfg, postgresDriver)
+
+	tablePrefix := driverCfg.GetString("tablePrefix")
+
+	if len(tablePrefix) > 0 && !tablePrefixPattern.MatchString(tablePrefix) {
+		return nil, fmt.Errorf("invalid table prefix %q", tablePrefix)
+	}
+
+	db, err := sql.Open("postgres", driverCfg.GetString("dsn"))
+	if err != nil {
+		return nil, err
+	}
+
+	sink := NewSink(db, tablePrefix, driverCfg.GetInt("batchSize"), driverCfg.GetDuration("flushInterval"))
+	sink.Retention = driverCfg.GetDuration("retention")
+	sink.Policy = teldrvr.LoadEmitPolicy(cfg, postgresDriver, teldrvr.DefaultEmitPolicy)
+
+	if driverCfg.GetBool("createTables") {
+		err = sink.CreateTables()
+		if err != nil {
+			// the tables are created again on the first flush, so a database that is not reachable yet does not block the start
+			log.Printf("%s%s could not create tables: %v", telemetry.TelemetryDriverError, postgresDriver, err)
+		}
+	}
+
+	sink.Start()
+
+	return teldrvr.EventDriver{
+		Sink: teldrvr.NewAsyncSink(postgresDriver, sink, driverCfg.GetInt("queueSize")),
+	}, nil
+}
+
+// Sink buffers the events and inserts them batch wise with prepared statements.
+// Only finished transactions and segments as well as log messages are stored.
+type Sink struct {
+	DB          *sql.DB
+	TablePrefix string
+	BatchSize   int
+	// FlushInterval is the maximum time an event is buffered
+	FlushInterval time.Duration
+	// Retention deletes older rows once per hour, 0 keeps all rows
+	Retention time.Duration
+	// Policy limits every insert by the RequestTimeout, errors of the server are not retried
+	Policy teldrvr.EmitPolicy
+
+	mutex         sync.Mutex
+	events        []teldrvr.Event
+	tablesCreated bool
+	stop          chan struct{}
+	wg            sync.WaitGroup
+	once          sync.Once
+}
+
+// NewSink creates a Sink, Start has to be called to enable the periodic flush and the retention job
+func NewSink(db *sql.DB, tablePrefix string, batchSize int, flushInterval time.Duration) *Sink {
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	return &Sink{
+		DB:            db,
+		TablePrefix:   tablePrefix,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		Policy:        teldrvr.DefaultEmitPolicy,
+		events:        make([]teldrvr.Event, 0, batchSize),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush and the retention job in a background goroutine
+func (s *Sink) Start() {
+	clock := teldrvr.CurrentClock()
+	flushTicker := clock.NewTicker(s.FlushInterval)
+	retentionTicker := clock.NewTicker(retentionInterval)
+
+	s.wg.Add(1)
+	go s.run(flushTicker, retentionTicker)
+}
+
+func (s *Sink) run(flushTicker teldrvr.Ticker, retentionTicker teldrvr.Ticker) {
+	defer s.wg.Done()
+	defer flushTicker.Stop()
+	defer retentionTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C():
+			err := s.Flush()
+			if err != nil {
+				log.Printf("%s%s could not flush events: %v", telemetry.TelemetryDriverError, postgresDriver, err)
+			}
+		case <-retentionTicker.C():
+			err := s.DeleteExpired()
+			if err != nil {
+				log.Printf("%s%s could not delete expired rows: %v", telemetry.TelemetryDriverError, postgresDriver, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Emit buffers the event and flushes the buffer if the batch is full
+func (s *Sink) Emit(event teldrvr.Event) error {
+	if event.Type != eventTypeTransactionEnd && event.Type != eventTypeSegmentEnd && event.Type != eventTypeLog {
+		return nil
+	}
+
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= s.BatchSize
+	s.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return s.Flush()
+}
+
+// Flush inserts all buffered events in one database transaction.
+// If the insert fails, the events are dropped, so a broken database can not exhaust the memory.
+func (s *Sink) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.events) == 0 {
+		return nil
+	}
+
+	events := s.events
+	s.events = make([]teldrvr.Event, 0, s.BatchSize)
+
+	if !s.tablesCreated {
+		err := s.createTables()
+		if err != nil {
+			return fmt.Errorf("%d events dropped: %w", len(events), err)
+		}
+	}
+
+	err := s.insert(events)
+	if err != nil {
+		return fmt.Errorf("%d events dropped: %w", len(events), err)
+	}
+
+	return nil
+}
+
+// insert writes the events and retries according to the policy
+// - Expects the mutex to be locked -
+func (s *Sink) insert(events []teldrvr.Event) error {
+	return s.Policy.Do(func() (bool, error) {
+		err := s.insertOnce(events)
+
+		// connection problems are retried, errors reported by the server would fail again
+		var serverErr *pq.Error
+		return !errors.As(err, &serverErr), err
+	})
+}
+
+// insertOnce writes the events in one transaction with one prepared statement per table
+func (s *Sink) insertOnce(events []teldrvr.Event) (err error) {
+	ctx := context.Background()
+	if s.Policy.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Policy.RequestTimeout)
+		defer cancel()
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	transactionStmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %stransactions
+		(name, trace_id, process_id, started_at, ended_at, duration_ms, segment_count, error_count, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, s.TablePrefix))
+	if err != nil {
+		return err
+	}
+	defer transactionStmt.Close()
+
+	segmentStmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %ssegments
+		(transaction, trace_id, process_id, segment_id, name, ended_at, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, s.TablePrefix))
+	if err != nil {
+		return err
+	}
+	defer segmentStmt.Close()
+
+	messageStmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %smessages
+		(time, level, transaction, trace_id, process_id, segment_id, segment, message, error_group, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, s.TablePrefix))
+	if err != nil {
+		return err
+	}
+	defer messageStmt.Close()
+
+	for _, event := range events {
+		var attributes []byte
+		attributes, err = json.Marshal(event.Attributes)
+		if err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case eventTypeTransactionEnd:
+			_, err = transactionStmt.ExecContext(ctx, event.Transaction, event.TraceID, event.ProcessID, event.Time.Add(-event.Duration),
+				event.Time, event.Duration.Milliseconds(), event.SegmentCount, event.ErrorCount, attributes)
+		case eventTypeSegmentEnd:
+			_, err = segmentStmt.ExecContext(ctx, event.Transaction, event.TraceID, event.ProcessID, event.SegmentID, event.Segment,
+				event.Time, attributes)
+		case eventTypeLog:
+			_, err = messageStmt.ExecContext(ctx, event.Time, event.Level, event.Transaction, event.TraceID, event.ProcessID,
+				event.SegmentID, event.Segment, event.Message, event.ErrorGroup, attributes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateTables creates the tables and indexes if they do not exist
+func (s *Sink) CreateTables() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.createTables()
+}
+
+// createTables creates the tables and indexes if they do not exist
+// - Expects the mutex to be locked -
+func (s *Sink) createTables() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS %[1]stransactions (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			trace_id TEXT NOT NULL,
+			process_id TEXT NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL,
+			ended_at TIMESTAMPTZ NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			segment_count INTEGER NOT NULL,
+			error_count INTEGER NOT NULL,
+			attributes JSONB
+		)`,
+		`CREATE INDEX IF NOT EXISTS %[1]stransactions_ended_at_idx ON %[1]stransactions (ended_at)`,
+		`CREATE TABLE IF NOT EXISTS %[1]ssegments (
+			id BIGSERIAL PRIMARY KEY,
+			transaction TEXT NOT NULL,
+			trace_id TEXT NOT NULL,
+			process_id TEXT NOT NULL,
+			segment_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			ended_at TIMESTAMPTZ NOT NULL,
+			attributes JSONB
+		)`,
+		`CREATE INDEX IF NOT EXISTS %[1]ssegments_ended_at_idx ON %[1]ssegments (ended_at)`,
+		`CREATE TABLE IF NOT EXISTS %[1]smessages (
+			id BIGSERIAL PRIMARY KEY,
+			time TIMESTAMPTZ NOT NULL,
+			level TEXT NOT NULL,
+			transaction TEXT NOT NULL,
+			trace_id TEXT NOT NULL,
+			process_id TEXT NOT NULL,
+			segment_id TEXT NOT NULL,
+			segment TEXT NOT NULL,
+			message TEXT NOT NULL,
+			error_group TEXT NOT NULL,
+			attributes JSONB
+		)`,
+		`CREATE INDEX IF NOT EXISTS %[1]smessages_time_idx ON %[1]smessages (time)`,
+		`CREATE INDEX IF NOT EXISTS %[1]smessages_trace_id_idx ON %[1]smessages (trace_id)`,
+	}
+
+	for _, statement := range statements {
+		_, err := s.DB.Exec(fmt.Sprintf(statement, s.TablePrefix))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.tablesCreated = true
+
+	return nil
+}
+
+// DeleteExpired deletes all rows older than the retention
+func (s *Sink) DeleteExpired() error {
+	if s.Retention <= 0 {
+		return nil
+	}
+
+	threshold := teldrvr.CurrentClock().Now().Add(-s.Retention)
+
+	tables := map[string]string{
+		"transactions": "ended_at",
+		"segments":     "ended_at",
+		"messages":     "time",
+	}
+
+	for table, column := range tables {
+		_, err := s.DB.Exec(fmt.Sprintf("DELETE FROM %s%s WHERE %s < $1", s.TablePrefix, table, column), threshold)
+		if err != nil {
+			return fmt.Errorf("table %s%s: %w", s.TablePrefix, table, err)
+		}
+	}
+
+	return nil
+}
+
+// Close stops the background goroutine, flushes the buffered events and closes the database
+func (s *Sink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	err := s.Flush()
+
+	return errors.Join(err, s.DB.Close())
+}
@@ -0,0 +1,111 @@
+package teldrvr
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// EmitPolicy defines how a remote driver connects to its backend and how often a failed emit is retried
+type EmitPolicy struct {
+	// ConnectTimeout limits establishing the connection including the TLS handshake
+	ConnectTimeout time.Duration
+	// RequestTimeout limits a single request, e.g. an HTTP request or a publish confirmation
+	RequestTimeout time.Duration
+	// MaxRetries is the number of retries after the first failed attempt, 0 disables retries
+	MaxRetries int
+	// InitialBackoff is the wait time before the first retry, it is doubled for every further retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait time between two retries, 0 means uncapped
+	MaxBackoff time.Duration
+}
+
+// DefaultEmitPolicy is used for every setting that is neither configured for the driver nor in telemetry.policy
+var DefaultEmitPolicy = EmitPolicy{
+	ConnectTimeout: 5 * time.Second,
+	RequestTimeout: 10 * time.Second,
+	MaxRetries:     3,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// EmitPolicyConfigKeys are the settings read by LoadEmitPolicy, remote drivers register them with their own settings
+var EmitPolicyConfigKeys = []ConfigKey{
+	{Name: "policy.connectTimeout", Kind: ConfigKindDuration, Min: 0},
+	{Name: "policy.requestTimeout", Kind: ConfigKindDuration, Min: 0},
+	{Name: "policy.maxRetries", Kind: ConfigKindInt, Min: 0},
+	{Name: "policy.initialBackoff", Kind: ConfigKindDuration, Min: 0},
+	{Name: "policy.maxBackoff", Kind: ConfigKindDuration, Min: 0},
+}
+
+// LoadEmitPolicy reads the policy of a driver from telemetry.drivers.<driver>.policy.*
+// Missing settings fall back to telemetry.policy.* and after that to the provided defaults.
+func LoadEmitPolicy(cfg Config, driver string, defaults EmitPolicy) EmitPolicy {
+	policy := defaults
+	policy.apply(cfg, "telemetry.policy.")
+	policy.apply(DriverConfig(cfg, driver), "policy.")
+
+	return policy
+}
+
+// apply overwrites the settings that are configured below the prefix
+func (p *EmitPolicy) apply(cfg Config, prefix string) {
+	if cfg.IsSet(prefix + "connectTimeout") {
+		p.ConnectTimeout = cfg.GetDuration(prefix + "connectTimeout")
+	}
+	if cfg.IsSet(prefix + "requestTimeout") {
+		p.RequestTimeout = cfg.GetDuration(prefix + "requestTimeout")
+	}
+	if cfg.IsSet(prefix + "maxRetries") {
+		p.MaxRetries = cfg.GetInt(prefix + "maxRetries")
+	}
+	if cfg.IsSet(prefix + "initialBackoff") {
+		p.InitialBackoff = cfg.GetDuration(prefix + "initialBackoff")
+	}
+	if cfg.IsSet(prefix + "maxBackoff") {
+		p.MaxBackoff = cfg.GetDuration(prefix + "maxBackoff")
+	}
+}
+
+// Backoff returns the wait time before the given retry, starting with 1
+func (p EmitPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
+
+// Do calls the attempt until it succeeds, reports that a retry is pointless or MaxRetries is reached.
+// The error of the last attempt is returned.
+func (p EmitPolicy) Do(attempt func() (retry bool, err error)) error {
+	for retries := 0; ; retries++ {
+		retry, err := attempt()
+		if err == nil || !retry || retries >= p.MaxRetries {
+			return err
+		}
+
+		time.Sleep(p.Backoff(retries + 1))
+	}
+}
+
+// Dialer returns a dialer honoring the ConnectTimeout
+func (p EmitPolicy) Dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   p.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// retryableStatus reports whether a request with the HTTP status code should be retried
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -0,0 +1,96 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// QueueDurationAttribute holds the milliseconds the request or message waited before the transaction started
+const QueueDurationAttribute = "queue.duration"
+
+// queue start headers set by load balancers and proxies, X-Queue-Start takes precedence like in the New Relic agent
+const (
+	RequestStartHeader = "X-Request-Start"
+	QueueStartHeader   = "X-Queue-Start"
+)
+
+// queue start timestamps before 2000 or after 2050 are rejected as invalid
+var (
+	earliestQueueStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	latestQueueStart   = time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// QueueStartRecorder is implemented by all transactions that report the queue time natively
+type QueueStartRecorder interface {
+	SetQueueStart(start time.Time) error
+}
+
+// SetQueueStart records when the request or message was enqueued, e.g. the timestamp of a RabbitMQ message. It has to
+// be called right after the transaction started. Transactions without native queue time get the queue.duration
+// attribute in milliseconds.
+func SetQueueStart(transaction telemetry.Transaction, start time.Time) error {
+	if start.IsZero() {
+		return errors.New("queue start is not set")
+	}
+
+	recorder, ok := findTransaction[QueueStartRecorder](transaction)
+	if ok {
+		return recorder.SetQueueStart(start)
+	}
+
+	return innermostTransaction(transaction).AddTransactionAttribute(QueueDurationAttribute, queueDurationMs(start))
+}
+
+// SetQueueStartFromHeader records the queue start of the X-Queue-Start or X-Request-Start header.
+// A header without queue start is ignored.
+func SetQueueStartFromHeader(transaction telemetry.Transaction, header http.Header) error {
+	value := header.Get(QueueStartHeader)
+	if len(value) == 0 {
+		value = header.Get(RequestStartHeader)
+	}
+	if len(value) == 0 {
+		return nil
+	}
+
+	start, err := ParseQueueStart(value)
+	if err != nil {
+		return err
+	}
+
+	return SetQueueStart(transaction, start)
+}
+
+// ParseQueueStart parses the value of a queue start header, e.g. t=1700000000123456. The timestamp is accepted in
+// microseconds, milliseconds or seconds with fraction.
+func ParseQueueStart(value string) (time.Time, error) {
+	timestamp, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(value), "t="), 64)
+	if err != nil || timestamp <= 0 {
+		return time.Time{}, fmt.Errorf("invalid queue start »%s«", value)
+	}
+
+	for _, divisor := range []float64{1e6, 1e3, 1} {
+		seconds := timestamp / divisor
+		start := time.Unix(0, int64(seconds*float64(time.Second)))
+		if start.After(earliestQueueStart) && start.Before(latestQueueStart) {
+			return start, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("queue start »%s« is out of range", value)
+}
+
+// queueDurationMs returns the milliseconds since the queue start, a queue start in the future counts as no queue time
+func queueDurationMs(start time.Time) float64 {
+	duration := clockSince(start)
+	if duration < 0 {
+		return 0
+	}
+
+	return float64(duration) / float64(time.Millisecond)
+}
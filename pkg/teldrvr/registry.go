@@ -0,0 +1,260 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// driverRegistry keeps track of all drivers registered by this package
+// The telemetry package only knows a registryDriver per name, which resolves the real driver on every
+// InitializeTransaction call. This allows replacing or deregistering drivers at runtime without
+// touching the (unlocked) map of the telemetry package again.
+type driverRegistry struct {
+	drivers map[string]telemetry.Driver
+	mutex   sync.RWMutex
+}
+
+var registry = driverRegistry{
+	drivers: make(map[string]telemetry.Driver),
+}
+
+// registryDriver is registered in the telemetry package and forwards to the currently registered driver
+type registryDriver struct {
+	name string
+}
+
+// InitializeTransaction starts a transaction with the currently registered driver.
+// If the driver was deregistered in the meantime, a nop transaction is returned.
+// The transaction and segment names are normalized by telemetry.nameNormalization before they reach the driver,
+// placeholders in segment names are expanded with telemetry.segmentTemplates.enabled and segments that are never ended
+// are reported at Done with telemetry.segmentLeaks.detect, see also telemetry.segmentLifecycle.
+// The hooks registered with OnTransactionStart, OnSegmentEnd and OnError are called and the error rates of
+// telemetry.errorBudget are evaluated by the transactions of the first configured driver.
+func (d registryDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	logDiagnostics()
+
+	registry.mutex.RLock()
+	driver, ok := registry.drivers[d.name]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return NopDriver{}.InitializeTransaction(name)
+	}
+
+	normalization := configuredNameNormalization()
+	name = normalization.normalize(name)
+	transaction, err := driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	transaction = withErrorBudget(d.name, name, withHooks(d.name, name, transaction))
+	transaction = withNameNormalization(normalization, transaction)
+
+	return withSegmentLifecycle(withSegmentTemplates(transaction)), nil
+}
+
+// registerDriver adds the driver to the registry and makes it available in the telemetry package.
+// The driver is wrapped in the middlewares of telemetry.middlewares, by default in an AttributeLimitDriver,
+// TailSamplingDriver, ShadowDriver or CanaryDriver if attribute limits, tail sampling, a shadow or a canary is configured.
+func registerDriver(name string, driver telemetry.Driver) {
+	driver = withMiddlewares(name, driver)
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, ok := registry.drivers[name]; !ok {
+		telemetry.RegisterDriver(name, registryDriver{name: name})
+	}
+
+	registry.drivers[name] = driver
+}
+
+// RegisteredDrivers returns the sorted names of all currently registered drivers
+func RegisteredDrivers() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	names := make([]string, 0, len(registry.drivers))
+	for name := range registry.drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// IsDriverRegistered reports whether a driver with the given name is registered
+func IsDriverRegistered(name string) bool {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	_, ok := registry.drivers[name]
+
+	return ok
+}
+
+// IsDriverActive reports whether the driver is registered and selected by the telemetry.driver config
+func IsDriverActive(name string) bool {
+	if !IsDriverRegistered(name) {
+		return false
+	}
+
+	return containsString(SelectedDrivers(viper.GetViper()), name)
+}
+
+// ReplaceDriver swaps the driver registered under the given name.
+// Transactions that were already started keep using the old driver.
+func ReplaceDriver(name string, driver telemetry.Driver) error {
+	if driver == nil {
+		return fmt.Errorf("can not replace driver '%s' with nil driver", name)
+	}
+
+	if !IsDriverRegistered(name) {
+		return fmt.Errorf("can not replace not registered driver '%s'", name)
+	}
+
+	registerDriver(name, driver)
+
+	return nil
+}
+
+// DeregisterDriver removes the driver from the registry.
+// New transactions for this driver name will use the nop driver.
+func DeregisterDriver(name string) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, ok := registry.drivers[name]; !ok {
+		return fmt.Errorf("can not deregister not registered driver '%s'", name)
+	}
+
+	delete(registry.drivers, name)
+
+	return nil
+}
+
+// RegisteredDriver returns the currently registered driver
+func RegisteredDriver(name string) (telemetry.Driver, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	driver, ok := registry.drivers[name]
+
+	return driver, ok
+}
+
+// StartTransaction starts a transaction with the registered driver the same way the telemetry package does,
+// including the name normalization. It is meant for bridges that feed telemetry from other sources into the drivers.
+func StartTransaction(driver string, name string) (telemetry.Transaction, error) {
+	if !IsDriverRegistered(driver) {
+		return nil, fmt.Errorf("can not start transaction with not registered driver '%s'", driver)
+	}
+
+	return registryDriver{name: driver}.InitializeTransaction(name)
+}
+
+// CloseDrivers emits all queued events of the registered drivers and closes their sinks.
+// It is meant to be called before the application exits, events emitted afterwards are dropped.
+func CloseDrivers() error {
+	registry.mutex.RLock()
+	drivers := make([]telemetry.Driver, 0, len(registry.drivers))
+	for _, driver := range registry.drivers {
+		drivers = append(drivers, driver)
+	}
+	registry.mutex.RUnlock()
+
+	var errs []error
+	for _, driver := range drivers {
+		err := closeDriver(driver)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// FlushDrivers emits all queued events of the registered drivers and flushes their batches.
+// Unlike CloseDrivers the drivers can be used afterwards, e.g. at the end of a job.
+func FlushDrivers() error {
+	registry.mutex.RLock()
+	drivers := make([]telemetry.Driver, 0, len(registry.drivers))
+	for _, driver := range registry.drivers {
+		drivers = append(drivers, driver)
+	}
+	registry.mutex.RUnlock()
+
+	var errs []error
+	for _, driver := range drivers {
+		err := flushDriver(driver)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// flushDriver flushes the sink of an event driver, wrapping drivers are resolved to the wrapped driver
+func flushDriver(driver telemetry.Driver) error {
+	switch d := driver.(type) {
+	case ShadowDriver:
+		return flushDriver(d.Primary)
+	case CanaryDriver:
+		return flushDriver(d.Stable)
+	case TailSamplingDriver:
+		return flushDriver(d.Driver)
+	case AttributeLimitDriver:
+		return flushDriver(d.Driver)
+	case EventDriver:
+		flusher, ok := d.Sink.(FlushingSink)
+		if !ok {
+			return nil
+		}
+
+		return flusher.Flush()
+	case DriverUnwrapper:
+		return flushDriver(d.Unwrap())
+	}
+
+	return nil
+}
+
+// closeDriver closes the sink of an event driver, wrapping drivers are resolved to the wrapped driver
+func closeDriver(driver telemetry.Driver) error {
+	switch d := driver.(type) {
+	case ShadowDriver:
+		return closeDriver(d.Primary)
+	case CanaryDriver:
+		return closeDriver(d.Stable)
+	case TailSamplingDriver:
+		return closeDriver(d.Driver)
+	case AttributeLimitDriver:
+		return closeDriver(d.Driver)
+	case EventDriver:
+		closer, ok := d.Sink.(io.Closer)
+		if !ok {
+			return nil
+		}
+
+		return closer.Close()
+	case DriverUnwrapper:
+		return closeDriver(d.Unwrap())
+	case driverShutdowner:
+		return d.shutdown()
+	}
+
+	return nil
+}
+
+// driverShutdowner is implemented by drivers that are not based on an EventSink, e.g. the New Relic drivers
+type driverShutdowner interface {
+	shutdown() error
+}
@@ -0,0 +1,87 @@
+package teldrvr
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// RoundTripper opens a segment External/<host>/<method> for every outgoing request of the transaction. The segment
+// carries the URL without query, the method, the status code and the duration. Transport failures and responses
+// outside of 2xx are logged as errors of the segment. The trace of the transaction is passed in the request headers.
+// Telemetry errors are passed to the error handler, they never fail the request.
+type RoundTripper struct {
+	Transaction telemetry.Transaction
+	// Next sends the requests, http.DefaultTransport if nil
+	Next http.RoundTripper
+}
+
+// NewRoundTripper returns a RoundTripper for the transaction, e.g. &http.Client{Transport: NewRoundTripper(t, nil)}
+func NewRoundTripper(transaction telemetry.Transaction, next http.RoundTripper) *RoundTripper {
+	return &RoundTripper{
+		Transaction: transaction,
+		Next:        next,
+	}
+}
+
+// RoundTrip sends the request with the trace headers and records it as segment of the transaction
+func (r *RoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	next := r.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	// a RoundTripper must not modify the request of the caller
+	request = request.Clone(request.Context())
+	r.report(InjectTraceHeaders(r.Transaction, request.Header))
+
+	segmentID := newIDString()
+	requestURL := redactedURL(request.URL)
+	r.report(r.Transaction.SegmentStart(segmentID, "External/"+request.URL.Host+"/"+request.Method))
+	r.report(r.Transaction.AddSegmentAttribute(segmentID, "http.url", requestURL))
+	r.report(r.Transaction.AddSegmentAttribute(segmentID, "http.method", request.Method))
+
+	start := clockNow()
+	response, err := next.RoundTrip(request)
+	r.report(r.Transaction.AddSegmentAttribute(segmentID, "http.duration.ms", float64(clockSince(start))/float64(time.Millisecond)))
+
+	switch {
+	case err != nil:
+		r.logError(segmentID, fmt.Sprintf("%s %s failed: %s", request.Method, requestURL, err.Error()))
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		r.report(r.Transaction.AddSegmentAttribute(segmentID, "http.statusCode", response.StatusCode))
+		r.logError(segmentID, fmt.Sprintf("%s %s responded %s", request.Method, requestURL, response.Status))
+	default:
+		r.report(r.Transaction.AddSegmentAttribute(segmentID, "http.statusCode", response.StatusCode))
+	}
+
+	r.report(r.Transaction.SegmentEnd(segmentID))
+
+	return response, err
+}
+
+// logError logs the message as error of the segment
+func (r *RoundTripper) logError(segmentID string, message string) {
+	r.report(r.Transaction.Error(segmentID, messageReader(message)))
+}
+
+// report passes telemetry errors to the error handler, so they do not fail the request
+func (r *RoundTripper) report(err error) {
+	if err != nil {
+		handleError(fmt.Errorf("%sround tripper: %w", telemetry.TelemetryDriverError, err))
+	}
+}
+
+// redactedURL returns the URL without user info, query and fragment, they may contain credentials or personal data
+func redactedURL(requestURL *url.URL) string {
+	redacted := *requestURL
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.Fragment = ""
+	redacted.RawFragment = ""
+
+	return redacted.String()
+}
@@ -0,0 +1,146 @@
+package teldrvr
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// runtimeMetricsTransaction is the name of the transactions that carry the runtime metrics
+const runtimeMetricsTransaction = "telemetry.runtimeMetrics"
+
+// runtimeMetricsIntervalConfigKey is the interval of the runtime metrics, the default is runtimeMetricsDefaultInterval
+const runtimeMetricsIntervalConfigKey = "telemetry.runtimeMetrics.interval"
+
+const runtimeMetricsDefaultInterval = 30 * time.Second
+
+var runtimeMetrics = struct {
+	stop  chan struct{}
+	mutex sync.Mutex
+}{}
+
+// EnableRuntimeMetrics starts a background collector that records the goroutine count, the heap stats and the GC pauses
+// as metrics of a transaction in every active driver. The interval is read from telemetry.runtimeMetrics.interval.
+// Calling it again while the collector runs has no effect.
+func EnableRuntimeMetrics() {
+	runtimeMetrics.mutex.Lock()
+	defer runtimeMetrics.mutex.Unlock()
+
+	if runtimeMetrics.stop != nil {
+		return
+	}
+
+	interval := runtimeMetricsDefaultInterval
+	if viper.IsSet(runtimeMetricsIntervalConfigKey) {
+		interval = viper.GetDuration(runtimeMetricsIntervalConfigKey)
+	}
+
+	if interval <= 0 {
+		handleError(fmt.Errorf("%s%s »%s« has to be positive, using %s", telemetry.TelemetryDriverError,
+			runtimeMetricsIntervalConfigKey, interval, runtimeMetricsDefaultInterval))
+		interval = runtimeMetricsDefaultInterval
+	}
+
+	runtimeMetrics.stop = make(chan struct{})
+	go collectRuntimeMetrics(CurrentClock().NewTicker(interval), runtimeMetrics.stop)
+}
+
+// DisableRuntimeMetrics stops the collector started by EnableRuntimeMetrics
+func DisableRuntimeMetrics() {
+	runtimeMetrics.mutex.Lock()
+	defer runtimeMetrics.mutex.Unlock()
+
+	if runtimeMetrics.stop == nil {
+		return
+	}
+
+	close(runtimeMetrics.stop)
+	runtimeMetrics.stop = nil
+}
+
+func collectRuntimeMetrics(ticker Ticker, stop chan struct{}) {
+	defer ticker.Stop()
+
+	var lastNumGC uint32
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			metrics := readRuntimeMetrics(&lastNumGC)
+			recordRuntimeMetrics(metrics)
+		}
+	}
+}
+
+// readRuntimeMetrics returns the current runtime metrics, the GC pauses are those since the previous call
+func readRuntimeMetrics(lastNumGC *uint32) map[string]float64 {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+
+	// PauseNs is a circular buffer of the last 256 pauses, the pause of GC n is at (n+255)%256
+	gcCount := stats.NumGC - *lastNumGC
+	first := *lastNumGC + 1
+	if gcCount > uint32(len(stats.PauseNs)) {
+		first = stats.NumGC - uint32(len(stats.PauseNs)) + 1
+	}
+
+	var maxPause uint64
+	for n := first; n <= stats.NumGC; n++ {
+		pause := stats.PauseNs[(n+255)%256]
+		if pause > maxPause {
+			maxPause = pause
+		}
+	}
+	*lastNumGC = stats.NumGC
+
+	return map[string]float64{
+		"runtime.goroutines":       float64(runtime.NumGoroutine()),
+		"runtime.heap.alloc":       float64(stats.HeapAlloc),
+		"runtime.heap.inuse":       float64(stats.HeapInuse),
+		"runtime.heap.sys":         float64(stats.HeapSys),
+		"runtime.heap.objects":     float64(stats.HeapObjects),
+		"runtime.gc.count":         float64(gcCount),
+		"runtime.gc.pauseMax.ms":   float64(maxPause) / float64(time.Millisecond),
+		"runtime.gc.pauseTotal.ms": float64(stats.PauseTotalNs) / float64(time.Millisecond),
+	}
+}
+
+// recordRuntimeMetrics records the metrics in a transaction of every active driver
+func recordRuntimeMetrics(metrics map[string]float64) {
+	for _, name := range RegisteredDrivers() {
+		if !IsDriverActive(name) {
+			continue
+		}
+
+		driver, ok := RegisteredDriver(name)
+		if !ok {
+			continue
+		}
+
+		err := recordDriverRuntimeMetrics(driver, metrics)
+		if err != nil {
+			handleError(fmt.Errorf("%sruntime metrics could not be recorded by driver %s: %w", telemetry.TelemetryDriverError, name, err))
+		}
+	}
+}
+
+func recordDriverRuntimeMetrics(driver telemetry.Driver, metrics map[string]float64) error {
+	transaction, err := driver.InitializeTransaction(runtimeMetricsTransaction)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range metrics {
+		err = RecordMetric(transaction, name, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return transaction.Done()
+}
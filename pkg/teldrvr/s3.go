@@ -0,0 +1,412 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const s3Driver = "s3"
+
+const s3DefaultRegion = "us-east-1"
+const s3DefaultMaxEvents = 10000
+const s3DefaultFlushInterval = 5 * time.Minute
+const s3DefaultMaxPending = 10
+const s3ObjectSuffix = ".ndjson"
+
+const s3FormatNDJSON = "ndjson"
+const s3FormatParquet = "parquet"
+
+// uploads of large objects take longer than usual requests
+var s3DefaultPolicy = EmitPolicy{
+	ConnectTimeout: DefaultEmitPolicy.ConnectTimeout,
+	RequestTimeout: 60 * time.Second,
+	MaxRetries:     DefaultEmitPolicy.MaxRetries,
+	InitialBackoff: DefaultEmitPolicy.InitialBackoff,
+	MaxBackoff:     DefaultEmitPolicy.MaxBackoff,
+}
+
+// s3ConfigKeys are the settings below telemetry.drivers.s3
+var s3ConfigKeys = []ConfigKey{
+	{Name: "bucket", Required: true},
+	{Name: "prefix"},
+	{Name: "region", Default: s3DefaultRegion},
+	{Name: "endpoint"},
+	{Name: "accessKeyID", Required: true},
+	{Name: "secretAccessKey", Required: true, Secret: true},
+	{Name: "sessionToken", Secret: true},
+	{Name: "storageClass"},
+	{Name: "compression", Default: compressionGzip, Values: compressionValues},
+	{Name: "format", Default: s3FormatNDJSON, Values: []string{s3FormatNDJSON, s3FormatParquet}},
+	{Name: "maxEvents", Kind: ConfigKindInt, Default: s3DefaultMaxEvents, Min: 1},
+	{Name: "flushInterval", Kind: ConfigKindDuration, Default: s3DefaultFlushInterval, Min: time.Millisecond},
+	{Name: "maxPending", Kind: ConfigKindInt, Default: s3DefaultMaxPending, Min: 1},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(s3Driver, s3ConfigKeys...)
+	RegisterDriverConfig(s3Driver, TLSConfigKeys...)
+	RegisterDriverConfig(s3Driver, EmitPolicyConfigKeys...)
+
+	if !driverEnabled(cfg, s3Driver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, s3Driver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no events will be archived", telemetry.TelemetryDriverError, err))
+		registerDriver(s3Driver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, s3Driver)
+
+	policy := LoadEmitPolicy(cfg, s3Driver, s3DefaultPolicy)
+	client, err := newHTTPClient(cfg, s3Driver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no events will be archived: %w", telemetry.TelemetryDriverError, s3Driver, err))
+		registerDriver(s3Driver, NopDriver{})
+		return
+	}
+
+	sink := NewS3Sink(driverCfg.GetString("bucket"), driverCfg.GetString("prefix"), driverCfg.GetString("region"),
+		driverCfg.GetInt("maxEvents"), driverCfg.GetDuration("flushInterval"))
+	sink.Endpoint = driverCfg.GetString("endpoint")
+	sink.AccessKeyID = driverCfg.GetString("accessKeyID")
+	sink.SecretAccessKey = driverCfg.GetString("secretAccessKey")
+	sink.SessionToken = driverCfg.GetString("sessionToken")
+	sink.StorageClass = driverCfg.GetString("storageClass")
+	sink.Compression = driverCfg.GetString("compression")
+	sink.Format = driverCfg.GetString("format")
+	sink.MaxPending = driverCfg.GetInt("maxPending")
+	sink.Policy = policy
+	sink.Client = client
+	sink.Start()
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(s3Driver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(s3Driver, driver)
+}
+
+// S3Sink accumulates the events and periodically uploads them as compressed NDJSON or Parquet objects to S3.
+// The objects are partitioned by the hour of the upload: <prefix>/YYYY/MM/DD/HH/<time>-<uuid>.ndjson.gz
+// Uploads that failed are kept in memory and retried with the next flush.
+type S3Sink struct {
+	Bucket string
+	Prefix string
+	Region string
+	// Endpoint overrides the AWS endpoint for S3 compatible storages, e.g. "http://127.0.0.1:9000".
+	// Objects are addressed path style if an endpoint is set.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// StorageClass of the objects, e.g. "STANDARD_IA" or "GLACIER_IR", empty uses the bucket default
+	StorageClass string
+	// Compression of the objects, "gzip" (default), "zstd" or "none". Parquet objects compress their pages instead.
+	Compression string
+	// Format of the objects, "ndjson" (default) or "parquet"
+	Format string
+	// MaxEvents is the maximum number of events per object
+	MaxEvents int
+	// FlushInterval is the maximum time an event is kept in memory
+	FlushInterval time.Duration
+	// MaxPending limits the number of failed objects kept for a retry, older objects are dropped first
+	MaxPending int
+	Policy     EmitPolicy
+	Client     *http.Client
+
+	mutex   sync.Mutex
+	events  []Event
+	pending [][]byte
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewS3Sink creates an S3Sink, Start has to be called to enable the periodic flush
+func NewS3Sink(bucket string, prefix string, region string, maxEvents int, flushInterval time.Duration) *S3Sink {
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+
+	if maxEvents < 1 {
+		maxEvents = s3DefaultMaxEvents
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = s3DefaultFlushInterval
+	}
+
+	return &S3Sink{
+		Bucket:        bucket,
+		Prefix:        strings.Trim(prefix, "/"),
+		Region:        region,
+		MaxEvents:     maxEvents,
+		FlushInterval: flushInterval,
+		MaxPending:    s3DefaultMaxPending,
+		Compression:   compressionGzip,
+		Policy:        s3DefaultPolicy,
+		Client:        &http.Client{Timeout: s3DefaultPolicy.RequestTimeout},
+		events:        make([]Event, 0, maxEvents),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start runs the periodic flush in a background goroutine, the flush timer is taken from the clock of the drivers
+func (s *S3Sink) Start() {
+	ticker := CurrentClock().NewTicker(s.FlushInterval)
+
+	s.wg.Add(1)
+	go s.run(ticker)
+}
+
+func (s *S3Sink) run(ticker Ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			err := s.Flush()
+			if err != nil {
+				handleError(fmt.Errorf("%s%s could not flush events: %w", telemetry.TelemetryDriverError, s3Driver, err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Emit buffers the event and flushes the buffer if the object is full
+func (s *S3Sink) Emit(event Event) error {
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= s.MaxEvents
+	s.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return s.Flush()
+}
+
+// Flush uploads the pending objects and the buffered events
+func (s *S3Sink) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.events) > 0 {
+		body, err := s.encode(s.events)
+		s.events = make([]Event, 0, s.MaxEvents)
+		if err != nil {
+			return err
+		}
+
+		s.pending = append(s.pending, body)
+	}
+
+	for len(s.pending) > 0 {
+		err := s.upload(s.pending[0])
+		if err != nil {
+			return s.dropOverflow(err)
+		}
+
+		s.pending = s.pending[1:]
+	}
+
+	s.pending = nil
+
+	return nil
+}
+
+// dropOverflow removes the oldest pending objects exceeding MaxPending
+// - Expects the mutex to be locked -
+func (s *S3Sink) dropOverflow(uploadErr error) error {
+	if s.MaxPending < 0 || len(s.pending) <= s.MaxPending {
+		return fmt.Errorf("upload will be retried: %w", uploadErr)
+	}
+
+	dropped := len(s.pending) - s.MaxPending
+	s.pending = s.pending[dropped:]
+
+	return fmt.Errorf("%d objects dropped: %w", dropped, uploadErr)
+}
+
+// encode converts the events into the configured format
+func (s *S3Sink) encode(events []Event) ([]byte, error) {
+	if s.Format == s3FormatParquet {
+		return encodeParquet(events, s.Compression)
+	}
+
+	return encodeS3Object(events, s.Compression)
+}
+
+// encodeS3Object converts the events into compressed newline delimited JSON
+func encodeS3Object(events []Event, compression string) ([]byte, error) {
+	body := bytes.Buffer{}
+	encoder := json.NewEncoder(&body)
+
+	for _, event := range events {
+		err := encoder.Encode(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return compress(compression, body.Bytes())
+}
+
+// objectKey returns a unique key partitioned by the hour of the upload
+func (s *S3Sink) objectKey(now time.Time) string {
+	suffix := s3ObjectSuffix + compressionExtension(s.Compression)
+	if s.Format == s3FormatParquet {
+		suffix = parquetExtension
+	}
+
+	key := fmt.Sprintf("%s/%d-%s%s", now.Format("2006/01/02/15"), now.UnixNano(), uuid.NewString(), suffix)
+	if len(s.Prefix) == 0 {
+		return key
+	}
+
+	return s.Prefix + "/" + key
+}
+
+// objectURL returns the url of the object, virtual hosted style for AWS and path style for custom endpoints
+func (s *S3Sink) objectURL(key string) (*url.URL, error) {
+	if len(s.Endpoint) == 0 {
+		return &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", s.Bucket, s.Region),
+			Path:   "/" + key,
+		}, nil
+	}
+
+	objectURL, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	objectURL.Path = strings.TrimRight(objectURL.Path, "/") + "/" + s.Bucket + "/" + key
+
+	return objectURL, nil
+}
+
+// upload puts one object into the bucket and retries according to the policy.
+// All attempts use the same key, so a retried upload does not duplicate the object.
+func (s *S3Sink) upload(body []byte) error {
+	objectURL, err := s.objectURL(s.objectKey(clockNow().UTC()))
+	if err != nil {
+		return err
+	}
+	objectURL.RawPath = s3EncodePath(objectURL.Path)
+
+	return s.Policy.Do(func() (bool, error) {
+		return s.uploadOnce(objectURL, body)
+	})
+}
+
+// uploadOnce puts the object once and reports whether a failed upload should be retried
+func (s *S3Sink) uploadOnce(objectURL *url.URL, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPut, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", s3ContentType(s.Format, s.Compression))
+
+	if len(s.StorageClass) > 0 {
+		request.Header.Set("X-Amz-Storage-Class", s.StorageClass)
+	}
+
+	err = s.sign(request, body, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("s3 responded with status %d: %s", response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
+
+// s3ContentType returns the content type of the objects
+func s3ContentType(format string, compression string) string {
+	if format == s3FormatParquet {
+		return parquetContentType
+	}
+
+	switch compression {
+	case compressionGzip:
+		return "application/gzip"
+	case compressionZstd:
+		return "application/zstd"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// sign adds the AWS signature version 4 to the request
+func (s *S3Sink) sign(request *http.Request, body []byte, now time.Time) error {
+	return signAWSRequest(request, body, now, s.Region, "s3", awsCredentials{
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+		SessionToken:    s.SessionToken,
+	})
+}
+
+// s3EncodePath encodes every byte except the unreserved characters and the path separator as required by the signature
+func s3EncodePath(path string) string {
+	encoded := strings.Builder{}
+	for _, b := range []byte(path) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			encoded.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", b)
+	}
+
+	return encoded.String()
+}
+
+// Close stops the background goroutine and uploads the buffered events
+func (s *S3Sink) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	return s.Flush()
+}
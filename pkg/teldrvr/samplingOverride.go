@@ -0,0 +1,47 @@
+package teldrvr
+
+import (
+	"fmt"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// sampling decisions that override the sampling of a transaction
+const (
+	// SamplingForce keeps all messages of the transaction, e.g. of a payment capture
+	SamplingForce = "force"
+	// SamplingNever drops the sampled messages of the transaction, errors are kept
+	SamplingNever = "never"
+)
+
+// SamplingOverrider is implemented by all transactions that sample their messages or wrap a transaction that does
+type SamplingOverrider interface {
+	OverrideSampling(decision string) error
+}
+
+// ForceSample keeps all messages of the transaction regardless of the sampling, e.g. for critical transactions like
+// a payment capture. The messages buffered so far are passed to the drivers.
+func ForceSample(transaction telemetry.Transaction) error {
+	return OverrideSampling(transaction, SamplingForce)
+}
+
+// NeverSample drops the sampled messages of the transaction regardless of the sampling, also if it fails or is slow.
+// Errors are not sampled and are still logged.
+func NeverSample(transaction telemetry.Transaction) error {
+	return OverrideSampling(transaction, SamplingNever)
+}
+
+// OverrideSampling overrides the sampling of the transaction with SamplingForce or SamplingNever, the last call wins.
+// Transactions without sampling log all messages anyway and ignore the decision.
+func OverrideSampling(transaction telemetry.Transaction, decision string) error {
+	if decision != SamplingForce && decision != SamplingNever {
+		return fmt.Errorf("sampling decision »%s« has to be one of %s, %s", decision, SamplingForce, SamplingNever)
+	}
+
+	overrider, ok := findTransaction[SamplingOverrider](transaction)
+	if !ok {
+		return nil
+	}
+
+	return overrider.OverrideSampling(decision)
+}
@@ -0,0 +1,152 @@
+package teldrvr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// SecretsProvider resolves references to secrets that are stored outside of the config, e.g. in HashiCorp Vault
+type SecretsProvider interface {
+	// Secret returns the secret of the reference, the reference is passed without the scheme
+	Secret(reference string) (string, error)
+}
+
+// SecretsProviderFactory creates a secrets provider based on the provided configuration
+type SecretsProviderFactory func(Config) (SecretsProvider, error)
+
+// secretsCacheTTLConfigKey is the time a resolved secret is cached, the default is secretsDefaultCacheTTL
+const secretsCacheTTLConfigKey = "telemetry.secrets.cacheTTL"
+
+const secretsDefaultCacheTTL = 15 * time.Minute
+
+// cachedSecret is a resolved secret with the time it has to be refreshed
+type cachedSecret struct {
+	value   string
+	refresh time.Time
+}
+
+// secretsProviders holds the factories of all secrets providers by their scheme, e.g. vault for vault://path#field.
+// The providers are created with the first reference of their scheme and the secrets are cached.
+// The factories of this package are set here instead of an init function, so they are available in the init
+// functions of all drivers.
+var secretsProviders = struct {
+	factories map[string]SecretsProviderFactory
+	providers map[string]SecretsProvider
+	secrets   map[string]cachedSecret
+	mutex     sync.Mutex
+}{
+	factories: map[string]SecretsProviderFactory{
+		vaultScheme:             newVaultSecretsProvider,
+		awsSecretsManagerScheme: newAWSSecretsManagerProvider,
+		awsParameterStoreScheme: newAWSParameterStoreProvider,
+	},
+	providers: make(map[string]SecretsProvider),
+	secrets:   make(map[string]cachedSecret),
+}
+
+// RegisterSecretsProvider registers a secrets provider for the scheme.
+// Settings of drivers initialized afterwards can reference secrets with <scheme>://<reference>.
+func RegisterSecretsProvider(scheme string, factory SecretsProviderFactory) error {
+	if len(scheme) == 0 {
+		return fmt.Errorf("can not register secrets provider without scheme")
+	}
+
+	if factory == nil {
+		return fmt.Errorf("can not register secrets provider '%s' without factory", scheme)
+	}
+
+	secretsProviders.mutex.Lock()
+	defer secretsProviders.mutex.Unlock()
+
+	if _, ok := secretsProviders.factories[scheme]; ok {
+		return fmt.Errorf("secrets provider '%s' is already registered", scheme)
+	}
+
+	secretsProviders.factories[scheme] = factory
+
+	return nil
+}
+
+// secretReference splits the value into the scheme and the reference, if it references a registered secrets provider
+func secretReference(value string) (string, string, bool) {
+	scheme, reference, ok := strings.Cut(value, "://")
+	if !ok {
+		return "", "", false
+	}
+
+	secretsProviders.mutex.Lock()
+	_, ok = secretsProviders.factories[scheme]
+	secretsProviders.mutex.Unlock()
+
+	return scheme, reference, ok
+}
+
+// ResolveSecret returns the secret if the value references a registered secrets provider, e.g. vault://secret/data/telemetry#licenceKey.
+// All other values are returned unchanged. Resolved secrets are cached for telemetry.secrets.cacheTTL, if the refresh
+// fails afterwards, the cached secret is used further and the error is passed to the error handler.
+func ResolveSecret(value string) (string, error) {
+	scheme, reference, ok := secretReference(value)
+	if !ok {
+		return value, nil
+	}
+
+	secretsProviders.mutex.Lock()
+	defer secretsProviders.mutex.Unlock()
+
+	cached, isCached := secretsProviders.secrets[value]
+	if isCached && time.Now().Before(cached.refresh) {
+		return cached.value, nil
+	}
+
+	secret, err := readSecret(scheme, reference)
+	if err != nil {
+		err = fmt.Errorf("secret »%s« could not be read: %w", value, err)
+		if !isCached {
+			return "", err
+		}
+
+		handleError(fmt.Errorf("%s%w, the cached secret is used", telemetry.TelemetryDriverError, err))
+		return cached.value, nil
+	}
+
+	ttl := secretsDefaultCacheTTL
+	if viper.IsSet(secretsCacheTTLConfigKey) {
+		ttl = viper.GetDuration(secretsCacheTTLConfigKey)
+	}
+	secretsProviders.secrets[value] = cachedSecret{value: secret, refresh: time.Now().Add(ttl)}
+
+	return secret, nil
+}
+
+// readSecret reads the secret from the provider of the scheme
+// - Expects the mutex to be locked -
+func readSecret(scheme string, reference string) (string, error) {
+	provider, err := secretsProvider(scheme)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.Secret(reference)
+}
+
+// secretsProvider returns the provider of the scheme and creates it with the first call
+// - Expects the mutex to be locked -
+func secretsProvider(scheme string) (SecretsProvider, error) {
+	if provider, ok := secretsProviders.providers[scheme]; ok {
+		return provider, nil
+	}
+
+	provider, err := secretsProviders.factories[scheme](viper.GetViper())
+	if err != nil {
+		return nil, fmt.Errorf("secrets provider '%s' could not be created: %w", scheme, err)
+	}
+
+	secretsProviders.providers[scheme] = provider
+
+	return provider, nil
+}
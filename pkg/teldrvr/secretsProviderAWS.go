@@ -0,0 +1,149 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// awsSecretsManagerScheme references secrets in AWS Secrets Manager, e.g. awssm://telemetry#licenceKey
+const awsSecretsManagerScheme = "awssm"
+
+// awsParameterStoreScheme references parameters of the AWS SSM Parameter Store, e.g. ssm:///telemetry/licenceKey
+const awsParameterStoreScheme = "ssm"
+
+// awsSecretsProvider reads secrets with the JSON API of AWS Secrets Manager or the SSM Parameter Store
+type awsSecretsProvider struct {
+	service     string
+	target      string
+	region      string
+	credentials *awsCredentialsProvider
+	client      *http.Client
+}
+
+// newAWSSecretsManagerProvider creates the provider of awssm:// references from telemetry.secrets.aws.*
+func newAWSSecretsManagerProvider(cfg Config) (SecretsProvider, error) {
+	return newAWSSecretsProvider(cfg, "secretsmanager", "secretsmanager.GetSecretValue")
+}
+
+// newAWSParameterStoreProvider creates the provider of ssm:// references from telemetry.secrets.aws.*
+func newAWSParameterStoreProvider(cfg Config) (SecretsProvider, error) {
+	return newAWSSecretsProvider(cfg, "ssm", "AmazonSSM.GetParameter")
+}
+
+func newAWSSecretsProvider(cfg Config, service string, target string) (SecretsProvider, error) {
+	region := cfg.GetString("telemetry.secrets.aws.region")
+	if len(region) == 0 {
+		return nil, errors.New("telemetry.secrets.aws.region is not set")
+	}
+
+	credentials, err := newAWSCredentialsProvider(cfg, "telemetry.secrets.aws.")
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsSecretsProvider{
+		service:     service,
+		target:      target,
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: DefaultEmitPolicy.RequestTimeout},
+	}, nil
+}
+
+// Secret reads the secret. Secrets Manager references may select a field of a JSON secret with <secret-id>#<field>.
+func (p *awsSecretsProvider) Secret(reference string) (string, error) {
+	if p.service == "ssm" {
+		response := struct {
+			Parameter struct {
+				Value string `json:"Value"`
+			} `json:"Parameter"`
+		}{}
+		err := p.request(map[string]any{"Name": reference, "WithDecryption": true}, &response)
+		if err != nil {
+			return "", err
+		}
+
+		return response.Parameter.Value, nil
+	}
+
+	secretID, field, hasField := strings.Cut(reference, "#")
+
+	response := struct {
+		SecretString string `json:"SecretString"`
+	}{}
+	err := p.request(map[string]any{"SecretId": secretID}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if !hasField {
+		return response.SecretString, nil
+	}
+
+	fields := make(map[string]any)
+	err = json.Unmarshal([]byte(response.SecretString), &fields)
+	if err != nil {
+		return "", fmt.Errorf("secret »%s« is no JSON object: %w", secretID, err)
+	}
+
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret »%s« has no string field »%s«", secretID, field)
+	}
+
+	return value, nil
+}
+
+// request sends the signed request to the API of the service and decodes the response
+func (p *awsSecretsProvider) request(payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := p.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s.%s.amazonaws.com/", p.service, p.region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", p.target)
+
+	err = signAWSRequest(request, body, time.Now().UTC(), p.region, p.service, credentials)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		awsError := struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}{}
+		json.Unmarshal(responseBody, &awsError)
+
+		return fmt.Errorf("%s responded with status %d: %s %s", p.service, response.StatusCode, awsError.Type, awsError.Message)
+	}
+
+	return json.Unmarshal(responseBody, result)
+}
@@ -0,0 +1,203 @@
+package teldrvr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// vaultScheme references secrets in HashiCorp Vault, e.g. vault://secret/data/telemetry#licenceKey
+const vaultScheme = "vault"
+
+// vaultRenewRetry is the wait time before a failed token renewal is retried
+const vaultRenewRetry = 30 * time.Second
+
+// vaultSecretsProvider reads secrets from the KV secrets engine (version 1 and 2) of HashiCorp Vault.
+// A renewable token is renewed in the background before it expires. If a token file is configured, e.g. written
+// by the Vault agent, it is read before every request and the renewal is left to the agent.
+type vaultSecretsProvider struct {
+	address   string
+	namespace string
+	tokenFile string
+	token     string
+	client    *http.Client
+}
+
+// vaultResponse contains the parts of the Vault responses used by the provider
+type vaultResponse struct {
+	Data map[string]any `json:"data"`
+	Auth *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// newVaultSecretsProvider creates the provider from telemetry.secrets.vault.*
+func newVaultSecretsProvider(cfg Config) (SecretsProvider, error) {
+	p := &vaultSecretsProvider{
+		address:   strings.TrimSuffix(cfg.GetString("telemetry.secrets.vault.address"), "/"),
+		namespace: cfg.GetString("telemetry.secrets.vault.namespace"),
+		tokenFile: cfg.GetString("telemetry.secrets.vault.tokenFile"),
+		token:     cfg.GetString("telemetry.secrets.vault.token"),
+	}
+
+	if len(p.address) == 0 {
+		return nil, errors.New("telemetry.secrets.vault.address is not set")
+	}
+
+	if len(p.token) == 0 && len(p.tokenFile) == 0 {
+		return nil, errors.New("telemetry.secrets.vault.token or telemetry.secrets.vault.tokenFile has to be set")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	caFile := cfg.GetString("telemetry.secrets.vault.caFile")
+	if len(caFile) > 0 {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry.secrets.vault.caFile could not be read: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("telemetry.secrets.vault.caFile »%s« contains no PEM certificate", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	p.client = &http.Client{Timeout: DefaultEmitPolicy.RequestTimeout, Transport: transport}
+
+	if len(p.tokenFile) > 0 {
+		return p, nil
+	}
+
+	lookup, err := p.request(http.MethodGet, "auth/token/lookup-self")
+	if err != nil {
+		return nil, fmt.Errorf("token could not be looked up: %w", err)
+	}
+
+	ttl, _ := lookup.Data["ttl"].(float64)
+	renewable, _ := lookup.Data["renewable"].(bool)
+	if renewable && ttl > 0 {
+		go p.renew(time.Duration(ttl) * time.Second)
+	}
+
+	return p, nil
+}
+
+// Secret reads the field of the secret, the reference has the format <path>#<field>
+func (p *vaultSecretsProvider) Secret(reference string) (string, error) {
+	path, field, ok := strings.Cut(reference, "#")
+	if !ok || len(path) == 0 || len(field) == 0 {
+		return "", fmt.Errorf("reference »%s« has to be <path>#<field>", reference)
+	}
+
+	response, err := p.request(http.MethodGet, path)
+	if err != nil {
+		return "", err
+	}
+
+	data := response.Data
+	// the KV secrets engine version 2 wraps the secret with its metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret »%s« has no field »%s«", path, field)
+	}
+
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field »%s« of secret »%s« is no string", field, path)
+	}
+
+	return secret, nil
+}
+
+// renew renews the token at half of its lease duration until it is no longer renewable
+func (p *vaultSecretsProvider) renew(ttl time.Duration) {
+	wait := ttl / 2
+	for {
+		time.Sleep(wait)
+
+		response, err := p.request(http.MethodPost, "auth/token/renew-self")
+		if err != nil {
+			handleError(fmt.Errorf("%svault token could not be renewed: %w", telemetry.TelemetryDriverError, err))
+			wait = vaultRenewRetry
+			continue
+		}
+
+		if response.Auth == nil || !response.Auth.Renewable || response.Auth.LeaseDuration <= 0 {
+			return
+		}
+
+		wait = time.Duration(response.Auth.LeaseDuration) * time.Second / 2
+	}
+}
+
+// currentToken returns the token, if a token file is configured it is read again
+func (p *vaultSecretsProvider) currentToken() (string, error) {
+	if len(p.tokenFile) == 0 {
+		return p.token, nil
+	}
+
+	token, err := os.ReadFile(p.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("token file could not be read: %w", err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// request sends the request to the Vault API and decodes the response
+func (p *vaultSecretsProvider) request(method string, path string) (vaultResponse, error) {
+	response := vaultResponse{}
+
+	token, err := p.currentToken()
+	if err != nil {
+		return response, err
+	}
+
+	request, err := http.NewRequest(method, p.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return response, err
+	}
+	request.Header.Set("X-Vault-Token", token)
+	if len(p.namespace) > 0 {
+		request.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	httpResponse, err := p.client.Do(request)
+	if err != nil {
+		return response, err
+	}
+	defer httpResponse.Body.Close()
+
+	body, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return response, err
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil && httpResponse.StatusCode < 300 {
+		return response, fmt.Errorf("response could not be decoded: %w", err)
+	}
+
+	if httpResponse.StatusCode >= 300 {
+		return response, fmt.Errorf("vault responded with status %d: %s", httpResponse.StatusCode, strings.Join(response.Errors, ", "))
+	}
+
+	return response, nil
+}
@@ -0,0 +1,254 @@
+package teldrvr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// segmentLeaksConfigKey configures the detection of segments that are never ended, e.g. telemetry.segmentLeaks.autoClose
+const segmentLeaksConfigKey = "telemetry.segmentLeaks"
+
+// segmentLifecycleConfigKey selects how the drivers treat segment IDs that are not open, see segmentLifecycleStrict
+const segmentLifecycleConfigKey = "telemetry.segmentLifecycle"
+
+// segmentLifecycleStrict passes all calls to the drivers, which fail on ending a segment twice or on unknown segment IDs
+const segmentLifecycleStrict = "strict"
+
+// segmentLifecycleLenient ignores ending a segment that is not open and starts an implicit segment for unknown IDs
+const segmentLifecycleLenient = "lenient"
+
+// segmentLifecycleSettings holds the settings read from the config on the first transaction
+var segmentLifecycleSettings = struct {
+	detect    bool
+	autoClose bool
+	lenient   bool
+	once      sync.Once
+}{}
+
+// configuredSegmentLifecycle reads telemetry.segmentLeaks and telemetry.segmentLifecycle once.
+// The leak detection is disabled and the lifecycle is strict by default, so the transactions are not wrapped.
+func configuredSegmentLifecycle() (detect bool, autoClose bool, lenient bool) {
+	segmentLifecycleSettings.once.Do(func() {
+		cfg := viper.GetViper()
+
+		segmentLifecycleSettings.detect = cfg.GetBool(segmentLeaksConfigKey + ".detect")
+		segmentLifecycleSettings.autoClose = cfg.GetBool(segmentLeaksConfigKey + ".autoClose")
+
+		switch lifecycle := cfg.GetString(segmentLifecycleConfigKey); lifecycle {
+		case "", segmentLifecycleStrict:
+		case segmentLifecycleLenient:
+			segmentLifecycleSettings.lenient = true
+		default:
+			handleError(fmt.Errorf("%s%s »%s« has to be one of %s, %s, falling back to %s", telemetry.TelemetryDriverError,
+				segmentLifecycleConfigKey, lifecycle, segmentLifecycleStrict, segmentLifecycleLenient, segmentLifecycleStrict))
+		}
+	})
+
+	return segmentLifecycleSettings.detect, segmentLifecycleSettings.autoClose, segmentLifecycleSettings.lenient
+}
+
+// withSegmentLifecycle wraps the transaction so its segments are tracked, if the leak detection, the auto close or the
+// lenient lifecycle is enabled
+func withSegmentLifecycle(transaction telemetry.Transaction) telemetry.Transaction {
+	detect, autoClose, lenient := configuredSegmentLifecycle()
+	if !detect && !autoClose && !lenient {
+		return transaction
+	}
+
+	return &SegmentLifecycleTransaction{
+		Transaction: transaction,
+		detect:      detect,
+		autoClose:   autoClose,
+		lenient:     lenient,
+		segments:    make(map[string]openSegment),
+	}
+}
+
+// openSegment is a segment that was started but not ended yet
+type openSegment struct {
+	name  string
+	start time.Time
+	// implicit segments were started by the lenient lifecycle for an unknown ID
+	implicit bool
+}
+
+// SegmentLifecycleTransaction tracks the open segments. The segments that are still open at Done are reported with
+// their name and age, with telemetry.segmentLeaks.autoClose they are ended before the transaction, the latest first.
+// In the lenient lifecycle SegmentEnd is idempotent and unknown segment IDs start an implicit segment named by the ID,
+// which is ended silently at Done.
+type SegmentLifecycleTransaction struct {
+	telemetry.Transaction
+	detect    bool
+	autoClose bool
+	lenient   bool
+	segments  map[string]openSegment
+	mutex     sync.Mutex
+}
+
+// SegmentStart starts the segment and tracks it until it is ended
+func (t *SegmentLifecycleTransaction) SegmentStart(segmentID string, name string) error {
+	err := t.Transaction.SegmentStart(segmentID, name)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.segments[segmentID] = openSegment{name: name, start: clockNow()}
+	t.mutex.Unlock()
+
+	return nil
+}
+
+// ensureSegment starts an implicit segment for an unknown segment ID in the lenient lifecycle
+func (t *SegmentLifecycleTransaction) ensureSegment(segmentID string) error {
+	if !t.lenient || len(segmentID) == 0 {
+		return nil
+	}
+
+	t.mutex.Lock()
+	_, ok := t.segments[segmentID]
+	t.mutex.Unlock()
+	if ok {
+		return nil
+	}
+
+	err := t.Transaction.SegmentStart(segmentID, segmentID)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.segments[segmentID] = openSegment{name: segmentID, start: clockNow(), implicit: true}
+	t.mutex.Unlock()
+
+	return nil
+}
+
+// AddSegmentAttribute adds an attribute to the segment
+func (t *SegmentLifecycleTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	err := t.ensureSegment(segmentID)
+	if err != nil {
+		return err
+	}
+
+	return t.Transaction.AddSegmentAttribute(segmentID, key, value)
+}
+
+// SegmentEnd ends the segment and stops tracking it, in the lenient lifecycle segments that are not open are ignored
+func (t *SegmentLifecycleTransaction) SegmentEnd(segmentID string) error {
+	t.mutex.Lock()
+	_, ok := t.segments[segmentID]
+	delete(t.segments, segmentID)
+	t.mutex.Unlock()
+
+	if !ok && t.lenient {
+		return nil
+	}
+
+	return t.Transaction.SegmentEnd(segmentID)
+}
+
+// Error logs the error in the segment
+func (t *SegmentLifecycleTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	err := t.ensureSegment(segmentID)
+	if err != nil {
+		readCloser.Close()
+		return err
+	}
+
+	return t.Transaction.Error(segmentID, readCloser)
+}
+
+// Info logs the information in the segment
+func (t *SegmentLifecycleTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	err := t.ensureSegment(segmentID)
+	if err != nil {
+		readCloser.Close()
+		return err
+	}
+
+	return t.Transaction.Info(segmentID, readCloser)
+}
+
+// Debug logs the information in the segment
+func (t *SegmentLifecycleTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	err := t.ensureSegment(segmentID)
+	if err != nil {
+		readCloser.Close()
+		return err
+	}
+
+	return t.Transaction.Debug(segmentID, readCloser)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *SegmentLifecycleTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// Done reports the segments that were never ended, ends them if autoClose is enabled and ends the transaction.
+// Implicit segments are always ended and never reported.
+func (t *SegmentLifecycleTransaction) Done() error {
+	t.mutex.Lock()
+	open := make([]string, 0, len(t.segments))
+	for segmentID := range t.segments {
+		open = append(open, segmentID)
+	}
+	segments := t.segments
+	t.segments = make(map[string]openSegment)
+	t.mutex.Unlock()
+
+	// latest first, so nested segments are ended before their parents
+	sort.Slice(open, func(i, j int) bool {
+		return segments[open[i]].start.After(segments[open[j]].start)
+	})
+
+	var leaked []string
+	var descriptions []string
+	for _, segmentID := range open {
+		segment := segments[segmentID]
+		if segment.implicit {
+			continue
+		}
+
+		leaked = append(leaked, segmentID)
+		descriptions = append(descriptions, fmt.Sprintf("%s[%s] open for %s", segment.name, segmentID, clockSince(segment.start).Round(time.Millisecond)))
+	}
+
+	if t.detect && len(leaked) > 0 {
+		action := "they are dropped with the transaction"
+		if t.autoClose {
+			action = "they are ended now"
+		}
+		handleError(fmt.Errorf("%s%d open segments at the end of the transaction, %s: %s", telemetry.TelemetryDriverError, len(leaked), action, strings.Join(descriptions, ", ")))
+	}
+
+	for _, segmentID := range open {
+		if !segments[segmentID].implicit && !t.autoClose {
+			continue
+		}
+
+		err := t.Transaction.SegmentEnd(segmentID)
+		if err != nil {
+			handleError(fmt.Errorf("%ssegment %s could not be ended: %w", telemetry.TelemetryDriverError, segmentID, err))
+		}
+	}
+
+	return t.Transaction.Done()
+}
+
+// Erase any memory the transaction allocated
+func (t *SegmentLifecycleTransaction) Erase() {
+	t.mutex.Lock()
+	t.segments = make(map[string]openSegment)
+	t.mutex.Unlock()
+
+	t.Transaction.Erase()
+}
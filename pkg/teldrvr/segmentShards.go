@@ -0,0 +1,17 @@
+package teldrvr
+
+// segmentShardCount is the number of shards of the segment maps of the local and zerolog drivers. Every shard has its
+// own lock, so concurrent segments of a transaction rarely wait for each other.
+const segmentShardCount = 16
+
+// segmentShardIndex returns the shard of the segment ID, the FNV-1a hash of the ID modulo the shard count.
+// Messages without segment share the shard of the empty ID.
+func segmentShardIndex(segmentID string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(segmentID); i++ {
+		hash ^= uint32(segmentID[i])
+		hash *= 16777619
+	}
+
+	return int(hash % segmentShardCount)
+}
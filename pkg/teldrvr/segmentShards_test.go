@@ -0,0 +1,52 @@
+package teldrvr
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// segmentIDsOfShards returns n segment IDs, spread over all shards or all in the first shard
+func segmentIDsOfShards(n int, spread bool) []string {
+	ids := make([]string, 0, n)
+	for i := 0; len(ids) < n; i++ {
+		id := strconv.Itoa(i)
+		if spread || segmentShardIndex(id) == 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// BenchmarkSegmentShards compares concurrent segments of one transaction spread over the shards with segments that all
+// share one shard, which behave like the single lock before the sharding. The gain grows with the cores, e.g. -cpu 1,4,16.
+func BenchmarkSegmentShards(b *testing.B) {
+	for _, benchmark := range []struct {
+		name   string
+		spread bool
+	}{
+		{name: "spread", spread: true},
+		{name: "oneShard", spread: false},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			transaction, err := LocalDriver{Writer: io.Discard}.InitializeTransaction("benchmark")
+			if err != nil {
+				b.Fatal(err)
+			}
+			ids := segmentIDsOfShards(1024, benchmark.spread)
+			var next atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				segmentID := ids[int(next.Add(1)-1)%len(ids)]
+				for pb.Next() {
+					_ = transaction.SegmentStart(segmentID, "segment")
+					_ = transaction.AddSegmentAttribute(segmentID, "key", "value")
+					_ = transaction.SegmentEnd(segmentID)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// segmentTemplatesConfigKey enables the expansion of placeholders in segment names, e.g. telemetry.segmentTemplates.enabled
+const segmentTemplatesConfigKey = "telemetry.segmentTemplates"
+
+// segmentPlaceholder matches a placeholder like {entity} in a segment name, the name is the attribute key
+var segmentPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// segmentTemplates holds the setting read from the config on the first transaction
+var segmentTemplates = struct {
+	enabled bool
+	once    sync.Once
+}{}
+
+// segmentTemplatesEnabled reads telemetry.segmentTemplates.enabled once, the templates are disabled by default
+func segmentTemplatesEnabled() bool {
+	segmentTemplates.once.Do(func() {
+		segmentTemplates.enabled = viper.GetViper().GetBool(segmentTemplatesConfigKey + ".enabled")
+	})
+
+	return segmentTemplates.enabled
+}
+
+// withSegmentTemplates wraps the transaction so placeholders in its segment names are expanded, if enabled
+func withSegmentTemplates(transaction telemetry.Transaction) telemetry.Transaction {
+	if !segmentTemplatesEnabled() {
+		return transaction
+	}
+
+	return &SegmentTemplateTransaction{
+		Transaction:           transaction,
+		transactionAttributes: make(map[string]any),
+		pending:               make(map[string]*pendingSegment),
+	}
+}
+
+// pendingSegment is a segment with placeholders that has not reached the wrapped transaction yet
+type pendingSegment struct {
+	name       string
+	keys       []string
+	attributes map[string]any
+}
+
+// SegmentTemplateTransaction expands placeholders like {entity} in segment names with the segment attribute of the
+// same key, or else the transaction attribute. Segments with placeholders are started in the wrapped transaction with
+// their first message, their end or the first query of their level, so the attributes added right after the start are
+// available. Placeholders without attribute are kept.
+type SegmentTemplateTransaction struct {
+	telemetry.Transaction
+	transactionAttributes map[string]any
+	pending               map[string]*pendingSegment
+	mutex                 sync.Mutex
+}
+
+// AddTransactionAttribute adds the attribute and keeps it for the placeholders
+func (t *SegmentTemplateTransaction) AddTransactionAttribute(key string, value any) error {
+	t.mutex.Lock()
+	t.transactionAttributes[key] = value
+	t.mutex.Unlock()
+
+	return t.Transaction.AddTransactionAttribute(key, value)
+}
+
+// SegmentStart starts the segment, segments with placeholders are kept until their name can be expanded
+func (t *SegmentTemplateTransaction) SegmentStart(segmentID string, name string) error {
+	if !segmentPlaceholder.MatchString(name) {
+		return t.Transaction.SegmentStart(segmentID, name)
+	}
+
+	t.mutex.Lock()
+	t.pending[segmentID] = &pendingSegment{name: name, attributes: make(map[string]any)}
+	t.mutex.Unlock()
+
+	return nil
+}
+
+// AddSegmentAttribute adds the attribute, the attributes of a pending segment are added once it is started
+func (t *SegmentTemplateTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	t.mutex.Lock()
+	segment, ok := t.pending[segmentID]
+	if ok {
+		if _, exists := segment.attributes[key]; !exists {
+			segment.keys = append(segment.keys, key)
+		}
+		segment.attributes[key] = value
+	}
+	t.mutex.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	return t.Transaction.AddSegmentAttribute(segmentID, key, value)
+}
+
+// startSegment starts the pending segment with the expanded name and adds its attributes
+func (t *SegmentTemplateTransaction) startSegment(segmentID string) error {
+	t.mutex.Lock()
+	segment, ok := t.pending[segmentID]
+	delete(t.pending, segmentID)
+	name := ""
+	if ok {
+		name = t.expand(segment)
+	}
+	t.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	err := t.Transaction.SegmentStart(segmentID, name)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0, len(segment.keys))
+	for _, key := range segment.keys {
+		errs = append(errs, t.Transaction.AddSegmentAttribute(segmentID, key, segment.attributes[key]))
+	}
+
+	return errors.Join(errs...)
+}
+
+// expand replaces the placeholders of the segment name with the attribute values
+func (t *SegmentTemplateTransaction) expand(segment *pendingSegment) string {
+	return segmentPlaceholder.ReplaceAllStringFunc(segment.name, func(placeholder string) string {
+		key := placeholder[1 : len(placeholder)-1]
+		if value, ok := segment.attributes[key]; ok {
+			return fmt.Sprint(value)
+		}
+		if value, ok := t.transactionAttributes[key]; ok {
+			return fmt.Sprint(value)
+		}
+
+		return placeholder
+	})
+}
+
+// Error starts a pending segment and logs the error
+func (t *SegmentTemplateTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	return errors.Join(t.startSegment(segmentID), t.Transaction.Error(segmentID, readCloser))
+}
+
+// Info starts a pending segment and logs the message
+func (t *SegmentTemplateTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	return errors.Join(t.startSegment(segmentID), t.Transaction.Info(segmentID, readCloser))
+}
+
+// Debug starts a pending segment and logs the message
+func (t *SegmentTemplateTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	return errors.Join(t.startSegment(segmentID), t.Transaction.Debug(segmentID, readCloser))
+}
+
+// SegmentEnd starts a pending segment and ends it
+func (t *SegmentTemplateTransaction) SegmentEnd(segmentID string) error {
+	err := t.startSegment(segmentID)
+	if err != nil {
+		return err
+	}
+
+	return t.Transaction.SegmentEnd(segmentID)
+}
+
+// Done starts the pending segments, so segments that are never ended are reported by the drivers, and ends the
+// transaction
+func (t *SegmentTemplateTransaction) Done() error {
+	t.mutex.Lock()
+	segmentIDs := make([]string, 0, len(t.pending))
+	for segmentID := range t.pending {
+		segmentIDs = append(segmentIDs, segmentID)
+	}
+	t.mutex.Unlock()
+
+	errs := make([]error, 0, len(segmentIDs)+1)
+	for _, segmentID := range segmentIDs {
+		errs = append(errs, t.startSegment(segmentID))
+	}
+
+	return errors.Join(append(errs, t.Transaction.Done())...)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *SegmentTemplateTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// IsLevelEnabled starts a pending segment, its level can depend on the expanded name, and reports whether the wrapped
+// transaction logs messages of the level in the segment
+func (t *SegmentTemplateTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	err := t.startSegment(segmentID)
+	if err != nil {
+		handleError(fmt.Errorf("%ssegment %s could not be started: %w", telemetry.TelemetryDriverError, segmentID, err))
+	}
+
+	return IsLevelEnabled(t.Transaction, segmentID, level)
+}
@@ -0,0 +1,104 @@
+package teldrvr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// LevelError, LevelInfo and LevelDebug select whether the messages of a SegmentWriter are logged as error, info or debug,
+// IsLevelEnabled takes them as well
+const (
+	LevelError = logLevelError
+	LevelInfo  = logLevelInfo
+	LevelDebug = logLevelDebug
+)
+
+// segmentWriter logs every line written to it as message of the segment
+type segmentWriter struct {
+	transaction telemetry.Transaction
+	segmentID   string
+	level       string
+	// pending holds the last line until its line break is written
+	pending []byte
+	mutex   sync.Mutex
+}
+
+// SegmentWriter returns a writer that logs every written line as message of the segment, or of the transaction if the
+// segment ID is empty. It is meant for libraries that only accept a writer, e.g. the output of an exec.Cmd.
+// Lines longer than the message limit of the level are split. Close logs the last line if it misses its line break.
+// An unknown level is reported and replaced by LevelInfo.
+func SegmentWriter(transaction telemetry.Transaction, segmentID string, level string) io.WriteCloser {
+	switch level {
+	case LevelError, LevelInfo, LevelDebug:
+	default:
+		handleError(fmt.Errorf("%ssegment writer level »%s« has to be one of %s, %s, %s, falling back to %s",
+			telemetry.TelemetryDriverError, level, LevelDebug, LevelInfo, LevelError, LevelInfo))
+		level = LevelInfo
+	}
+
+	return &segmentWriter{
+		transaction: transaction,
+		segmentID:   segmentID,
+		level:       level,
+	}
+}
+
+// Write logs all complete lines of p and keeps the rest until the next line break
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	limit := telemetry.DebugByteSize
+	if w.level == LevelError {
+		limit = telemetry.ErrorBytesSize
+	}
+
+	w.mutex.Lock()
+	w.pending = append(w.pending, p...)
+	var lines []string
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			break
+		}
+		lines = append(lines, string(w.pending[:end]))
+		w.pending = w.pending[end+1:]
+	}
+	for len(w.pending) >= limit {
+		lines = append(lines, string(w.pending[:limit]))
+		w.pending = w.pending[limit:]
+	}
+	w.pending = append([]byte(nil), w.pending...)
+	w.mutex.Unlock()
+
+	for _, line := range lines {
+		err := w.log(line)
+		if err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close logs the pending line, the writer can still be used afterwards
+func (w *segmentWriter) Close() error {
+	w.mutex.Lock()
+	line := string(w.pending)
+	w.pending = nil
+	w.mutex.Unlock()
+
+	return w.log(line)
+}
+
+// log passes the line to the transaction, empty lines are skipped
+func (w *segmentWriter) log(line string) error {
+	line = strings.TrimSuffix(line, "\r")
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	return logLevelMessage(w.transaction, w.segmentID, w.level, line)
+}
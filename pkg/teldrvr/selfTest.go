@@ -0,0 +1,177 @@
+package teldrvr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// selfTestTransaction is the name of the synthetic transaction sent by SelfTest
+const selfTestTransaction = "telemetry.selfTest"
+
+// SelfTestAttribute marks the synthetic events of SelfTest, so they can be filtered in the backends
+const SelfTestAttribute = "telemetry.selfTest"
+
+// ErrSelfTestSkipped is returned by sinks that can not be tested without side effects, e.g. paging someone
+var ErrSelfTestSkipped = errors.New("self-test skipped, the driver only sends alerts")
+
+// SelfTester is implemented by sinks that verify their backend in a different way than emitting a synthetic event
+type SelfTester interface {
+	SelfTest(ctx context.Context) error
+}
+
+// driverSelfTester is implemented by drivers that are not based on an EventSink, e.g. the New Relic drivers
+type driverSelfTester interface {
+	selfTest(ctx context.Context) error
+}
+
+// SelfTestResult is the result of the self-test of a single driver
+type SelfTestResult struct {
+	Driver   string
+	Duration time.Duration
+	// Err is nil if the backend accepted the synthetic transaction
+	Err     error
+	Skipped bool
+}
+
+// SelfTest sends a synthetic transaction through every active driver and reports the result per driver.
+// Batching sinks are flushed, so the result reflects the connectivity to the backend. Drivers that do not
+// finish before the context is done are reported with the error of the context.
+func SelfTest(ctx context.Context) []SelfTestResult {
+	var names []string
+	for _, name := range RegisteredDrivers() {
+		if IsDriverActive(name) {
+			names = append(names, name)
+		}
+	}
+
+	results := make([]SelfTestResult, len(names))
+	done := make(chan int, len(names))
+
+	for i, name := range names {
+		results[i].Driver = name
+
+		registry.mutex.RLock()
+		driver := registry.drivers[name]
+		registry.mutex.RUnlock()
+
+		go func(i int, driver telemetry.Driver) {
+			start := time.Now()
+			err := selfTestDriver(ctx, driver)
+
+			results[i].Duration = time.Since(start)
+			results[i].Err = err
+			results[i].Skipped = errors.Is(err, ErrSelfTestSkipped)
+			done <- i
+		}(i, driver)
+	}
+
+	finished := make([]bool, len(names))
+	for range names {
+		select {
+		case i := <-done:
+			finished[i] = true
+		case <-ctx.Done():
+			// the results of the unfinished drivers are still written by their goroutines
+			reported := make([]SelfTestResult, len(names))
+			for i, name := range names {
+				reported[i] = SelfTestResult{Driver: name, Err: ctx.Err()}
+			}
+			drainSelfTest(done, finished, results, reported)
+
+			return reported
+		}
+	}
+
+	return results
+}
+
+// drainSelfTest copies the results of all drivers that finished before the context was done
+func drainSelfTest(done chan int, finished []bool, results []SelfTestResult, reported []SelfTestResult) {
+	for {
+		select {
+		case i := <-done:
+			finished[i] = true
+		default:
+			for i := range finished {
+				if finished[i] {
+					reported[i] = results[i]
+				}
+			}
+			return
+		}
+	}
+}
+
+// selfTestDriver tests the driver, wrapping drivers are resolved to the wrapped driver
+func selfTestDriver(ctx context.Context, driver telemetry.Driver) error {
+	switch d := driver.(type) {
+	case ShadowDriver:
+		return selfTestDriver(ctx, d.Primary)
+	case CanaryDriver:
+		return selfTestDriver(ctx, d.Stable)
+	case TailSamplingDriver:
+		return selfTestDriver(ctx, d.Driver)
+	case AttributeLimitDriver:
+		return selfTestDriver(ctx, d.Driver)
+	case EventDriver:
+		return selfTestSink(ctx, d.Sink)
+	case DriverUnwrapper:
+		return selfTestDriver(ctx, d.Unwrap())
+	case driverSelfTester:
+		return d.selfTest(ctx)
+	}
+
+	transaction, err := driver.InitializeTransaction(selfTestTransaction)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.AddTransactionAttribute(SelfTestAttribute, true)
+	if err != nil {
+		return err
+	}
+
+	err = InfoMsg(transaction, "", "telemetry self-test")
+	if err != nil {
+		return err
+	}
+
+	return transaction.Done()
+}
+
+// selfTestSink emits a synthetic event directly to the sink, so errors are not swallowed by the AsyncSink
+func selfTestSink(ctx context.Context, sink EventSink) error {
+	if sink == nil {
+		return errors.New("event driver has no sink")
+	}
+
+	if asyncSink, ok := sink.(*AsyncSink); ok {
+		sink = asyncSink.next
+	}
+
+	if tester, ok := sink.(SelfTester); ok {
+		return tester.SelfTest(ctx)
+	}
+
+	err := sink.Emit(Event{
+		Time:        clockNow(),
+		Type:        eventTypeLog,
+		Level:       logLevelInfo,
+		Transaction: selfTestTransaction,
+		Message:     "telemetry self-test",
+		Attributes:  map[string]any{SelfTestAttribute: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := sink.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+
+	return flusher.Flush()
+}
@@ -0,0 +1,318 @@
+package teldrvr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const sentryDriver = "sentry"
+
+// sentryDefaultMaxBreadcrumbs is the default of the Sentry SDKs
+const sentryDefaultMaxBreadcrumbs = 100
+
+const sentryClient = "mc-telemetry-driver/1.0"
+
+// sentryConfigKeys are the settings below telemetry.drivers.sentry
+var sentryConfigKeys = []ConfigKey{
+	{Name: "dsn", Required: true, Secret: true, Validate: validateSentryDSN},
+	{Name: "environment"},
+	{Name: "release"},
+	{Name: "maxBreadcrumbs", Kind: ConfigKindInt, Default: sentryDefaultMaxBreadcrumbs, Min: 0},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(sentryDriver, sentryConfigKeys...)
+	RegisterDriverConfig(sentryDriver, TLSConfigKeys...)
+	RegisterDriverConfig(sentryDriver, EmitPolicyConfigKeys...)
+	RegisterDriverConfig(sentryDriver, SeverityConfigKeys...)
+
+	if !driverEnabled(cfg, sentryDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, sentryDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no errors will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(sentryDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, sentryDriver)
+
+	policy := LoadEmitPolicy(cfg, sentryDriver, DefaultEmitPolicy)
+	client, err := newHTTPClient(cfg, sentryDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no errors will be sent: %w", telemetry.TelemetryDriverError, sentryDriver, err))
+		registerDriver(sentryDriver, NopDriver{})
+		return
+	}
+
+	sink, err := NewSentrySink(driverCfg.GetString("dsn"))
+	if err != nil {
+		handleError(fmt.Errorf("%s%s: %w, no errors will be sent", telemetry.TelemetryDriverError, sentryDriver, err))
+		registerDriver(sentryDriver, NopDriver{})
+		return
+	}
+	sink.Environment = driverCfg.GetString("environment")
+	sink.Release = driverCfg.GetString("release")
+	sink.Policy = policy
+	sink.Client = client
+	sink.Severities = LoadSeverityMapping(cfg, sentryDriver, nil)
+
+	driver := EventDriver{
+		Sink:        NewAsyncSink(sentryDriver, sink, driverCfg.GetInt("queueSize")),
+		Breadcrumbs: driverCfg.GetInt("maxBreadcrumbs"),
+	}
+
+	registerDriver(sentryDriver, driver)
+}
+
+// validateSentryDSN checks the DSN has the format https://<key>@<host>/<project>
+func validateSentryDSN(value string) error {
+	_, _, err := parseSentryDSN(value)
+
+	return err
+}
+
+// parseSentryDSN returns the envelope endpoint and the public key of the DSN
+func parseSentryDSN(dsn string) (string, string, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", errors.New("dsn is no valid url")
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", "", fmt.Errorf("dsn scheme »%s« has to be http or https", parsed.Scheme)
+	}
+
+	if parsed.User == nil || len(parsed.User.Username()) == 0 {
+		return "", "", errors.New("dsn has no public key")
+	}
+
+	path := strings.TrimSuffix(parsed.Path, "/")
+	index := strings.LastIndex(path, "/")
+	if index < 0 || index == len(path)-1 {
+		return "", "", errors.New("dsn has no project ID")
+	}
+
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/envelope/", parsed.Scheme, parsed.Host, path[:index], path[index+1:])
+
+	return endpoint, parsed.User.Username(), nil
+}
+
+// SentrySink sends the error events with their breadcrumbs to Sentry, all other events are ignored.
+// The breadcrumbs are collected by the transactions, see EventDriver.Breadcrumbs.
+type SentrySink struct {
+	// Endpoint is the envelope endpoint of the project
+	Endpoint string
+	// Key is the public key of the DSN
+	Key         string
+	Environment string
+	Release     string
+	Policy      EmitPolicy
+	Client      *http.Client
+	// Severities maps the levels of the events and breadcrumbs to the Sentry levels, e.g. critical to fatal.
+	// Levels without entry keep their name.
+	Severities SeverityMapping
+}
+
+// sentryEvent is the part of the Sentry event payload filled by the sink
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Transaction string            `json:"transaction,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     sentryMessage     `json:"message"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+	Breadcrumbs sentryBreadcrumbs `json:"breadcrumbs"`
+}
+
+type sentryMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type sentryBreadcrumbs struct {
+	Values []sentryBreadcrumb `json:"values"`
+}
+
+type sentryBreadcrumb struct {
+	Timestamp string            `json:"timestamp"`
+	Type      string            `json:"type"`
+	Category  string            `json:"category,omitempty"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// NewSentrySink creates a SentrySink for the DSN
+func NewSentrySink(dsn string) (*SentrySink, error) {
+	endpoint, key, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SentrySink{
+		Endpoint: endpoint,
+		Key:      key,
+		Policy:   DefaultEmitPolicy,
+		Client:   &http.Client{Timeout: DefaultEmitPolicy.RequestTimeout},
+	}, nil
+}
+
+// SelfTest is skipped, a synthetic event would open an issue
+func (s *SentrySink) SelfTest(ctx context.Context) error {
+	return ErrSelfTestSkipped
+}
+
+// Emit sends error events, all other events are ignored
+func (s *SentrySink) Emit(event Event) error {
+	if event.Type != eventTypeLog || event.Level != logLevelError {
+		return nil
+	}
+
+	body, err := s.envelope(s.sentryEvent(event))
+	if err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return s.Policy.Do(func() (bool, error) {
+		return s.post(client, body)
+	})
+}
+
+// sentryEvent converts the error event, the breadcrumbs are ordered the oldest first like Sentry expects them
+func (s *SentrySink) sentryEvent(event Event) sentryEvent {
+	payload := sentryEvent{
+		EventID:     strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Timestamp:   event.Time.UTC().Format(time.RFC3339Nano),
+		Level:       s.Severities.EventSeverity(event),
+		Platform:    "go",
+		Logger:      sentryDriver,
+		Transaction: event.Transaction,
+		Environment: s.Environment,
+		Release:     s.Release,
+		Message:     sentryMessage{Formatted: event.Message},
+		Tags:        make(map[string]string),
+		Extra:       event.Attributes,
+		Breadcrumbs: sentryBreadcrumbs{Values: make([]sentryBreadcrumb, 0, len(event.Breadcrumbs))},
+	}
+
+	if len(event.ErrorGroup) > 0 {
+		payload.Fingerprint = []string{event.ErrorGroup}
+	}
+
+	if len(event.TraceID) > 0 {
+		payload.Tags["traceID"] = event.TraceID
+	}
+	if len(event.ProcessID) > 0 {
+		payload.Tags["processID"] = event.ProcessID
+	}
+	if len(event.Segment) > 0 {
+		payload.Tags["segment"] = event.Segment
+	}
+
+	for _, breadcrumb := range event.Breadcrumbs {
+		var data map[string]string
+		if len(breadcrumb.SegmentID) > 0 {
+			data = map[string]string{"segmentID": breadcrumb.SegmentID}
+		}
+
+		payload.Breadcrumbs.Values = append(payload.Breadcrumbs.Values, sentryBreadcrumb{
+			Timestamp: breadcrumb.Time.UTC().Format(time.RFC3339Nano),
+			Type:      "default",
+			Category:  breadcrumb.Segment,
+			Level:     s.Severities.Severity(logLevelInfo, false),
+			Message:   breadcrumb.Message,
+			Data:      data,
+		})
+	}
+
+	return payload
+}
+
+// envelope wraps the event into an envelope with one item
+func (s *SentrySink) envelope(payload sentryEvent) ([]byte, error) {
+	item, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"event_id": payload.EventID,
+		"sent_at":  clockNow().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	itemHeader, err := json.Marshal(map[string]any{
+		"type":   "event",
+		"length": len(item),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := bytes.Buffer{}
+	body.Write(header)
+	body.WriteString("\n")
+	body.Write(itemHeader)
+	body.WriteString("\n")
+	body.Write(item)
+	body.WriteString("\n")
+
+	return body.Bytes(), nil
+}
+
+// post sends the envelope once and reports whether a failed request should be retried
+func (s *SentrySink) post(client *http.Client, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-sentry-envelope")
+	request.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s", sentryClient, s.Key))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return retryableStatus(response.StatusCode), fmt.Errorf("sentry responded with status %d: %s", response.StatusCode, responseBody)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,74 @@
+package teldrvr
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// SeverityConfigKeys are the settings read by LoadSeverityMapping, the drivers that send a severity to their backend
+// register them with their own settings, e.g. telemetry.drivers.sentry.severities: {error: "warning", critical: "fatal"}
+var SeverityConfigKeys = []ConfigKey{
+	{Name: "severities", Kind: ConfigKindStringMap},
+}
+
+// severityCritical is the entry of the mapping for errors marked with CriticalAttribute
+const severityCritical = "critical"
+
+// SeverityMapping maps the log levels error, info and debug to the severities of a backend. The entry critical is
+// used for errors marked with CriticalAttribute, without it they get the severity of error.
+type SeverityMapping map[string]string
+
+// LoadSeverityMapping reads the mapping of a driver from telemetry.drivers.<driver>.severities, the configured
+// entries overwrite the defaults. Entries of unknown levels and empty severities are reported and ignored.
+func LoadSeverityMapping(cfg Config, driver string, defaults SeverityMapping) SeverityMapping {
+	mapping := make(SeverityMapping, len(defaults))
+	for level, severity := range defaults {
+		mapping[level] = severity
+	}
+
+	key := resolveDriverConfigKey(cfg, driver, "severities")
+	configured := DriverConfig(cfg, driver).GetStringMapString("severities")
+	levels := make([]string, 0, len(configured))
+	for level := range configured {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+
+	for _, level := range levels {
+		switch {
+		case level != logLevelError && level != logLevelInfo && level != logLevelDebug && level != severityCritical:
+			handleError(fmt.Errorf("%s%s.%s is ignored, the level has to be one of %s, %s, %s or %s",
+				telemetry.TelemetryDriverError, key, level, logLevelError, logLevelInfo, logLevelDebug, severityCritical))
+		case len(configured[level]) == 0:
+			handleError(fmt.Errorf("%s%s.%s is ignored, the severity is empty", telemetry.TelemetryDriverError, key, level))
+		default:
+			mapping[level] = configured[level]
+		}
+	}
+
+	return mapping
+}
+
+// Severity returns the severity of the level, levels without entry keep their name
+func (m SeverityMapping) Severity(level string, critical bool) string {
+	if critical && level == logLevelError {
+		if severity, ok := m[severityCritical]; ok {
+			return severity
+		}
+	}
+
+	if severity, ok := m[level]; ok {
+		return severity
+	}
+
+	return level
+}
+
+// EventSeverity returns the severity of the level of the event, see Severity
+func (m SeverityMapping) EventSeverity(event Event) string {
+	critical, _ := event.Attributes[CriticalAttribute].(bool)
+
+	return m.Severity(event.Level, critical)
+}
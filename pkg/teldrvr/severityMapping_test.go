@@ -0,0 +1,66 @@
+package teldrvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// logErrorAndInfo logs an error and an info message in a transaction of the driver
+func logErrorAndInfo(t *testing.T, driver telemetry.Driver) {
+	t.Helper()
+
+	transaction, err := driver.InitializeTransaction("severity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = transaction.Error("", messageReader("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if err = transaction.Info("", messageReader("done")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalDriverWritesErrorRecords(t *testing.T) {
+	for _, format := range []string{localFormatPlain, localFormatPretty} {
+		t.Run(format, func(t *testing.T) {
+			output, errors := bytes.Buffer{}, bytes.Buffer{}
+			logErrorAndInfo(t, LocalDriver{Format: format, Writer: &output, ErrorWriter: &errors})
+
+			want := "- ERROR START -"
+			if format == localFormatPretty {
+				want = "ERROR"
+			}
+			if !strings.Contains(errors.String(), want) || !strings.Contains(errors.String(), "failed") {
+				t.Errorf("error output = %q, want an error record with %q", errors.String(), want)
+			}
+			if strings.Contains(output.String(), "failed") {
+				t.Errorf("output = %q, want the error only in the error output", output.String())
+			}
+		})
+	}
+}
+
+func TestJSONLinesSinkWritesErrorSeverity(t *testing.T) {
+	output, errors := bytes.Buffer{}, bytes.Buffer{}
+	logErrorAndInfo(t, EventDriver{Sink: &JSONLinesSink{
+		Writer:      &output,
+		ErrorWriter: &errors,
+		Severities:  SeverityMapping{logLevelError: "ERROR"},
+	}})
+
+	var event Event
+	if err := json.Unmarshal(errors.Bytes(), &event); err != nil {
+		t.Fatalf("error output %q is no single event: %v", errors.String(), err)
+	}
+	if event.Level != "ERROR" || event.Message != "failed" {
+		t.Errorf("error event has level %q and message %q, want ERROR and failed", event.Level, event.Message)
+	}
+	if strings.Contains(output.String(), "failed") {
+		t.Errorf("output = %q, want the error only in the error output", output.String())
+	}
+}
@@ -0,0 +1,416 @@
+package teldrvr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// shadowConfigKey maps a driver to its shadow driver, e.g. telemetry.shadow.newrelicAPM: webhook
+const shadowConfigKey = "telemetry.shadow"
+
+// ShadowLatencyCallback is called after every call of a shadow driver with its latency and error.
+// The error of a shadow driver never reaches the application, the callback is the only place to observe it.
+type ShadowLatencyCallback func(shadow string, operation string, latency time.Duration, err error)
+
+var shadowLatencyCallback = struct {
+	callback ShadowLatencyCallback
+	mutex    sync.RWMutex
+}{}
+
+// SetShadowLatencyCallback sets the callback receiving the latency of all shadow driver calls
+func SetShadowLatencyCallback(callback ShadowLatencyCallback) {
+	shadowLatencyCallback.mutex.Lock()
+	defer shadowLatencyCallback.mutex.Unlock()
+
+	shadowLatencyCallback.callback = callback
+}
+
+// shadowFor returns the name of the shadow driver configured for the driver, an empty name means no shadow
+func shadowFor(cfg Config, name string) string {
+	// viper lowercases the keys of maps
+	for primary, shadow := range cfg.GetStringMapString(shadowConfigKey) {
+		if strings.EqualFold(primary, name) && shadow != name {
+			return strings.TrimSpace(shadow)
+		}
+	}
+
+	return ""
+}
+
+// isShadowDriver reports whether the driver is configured as shadow of another driver
+func isShadowDriver(cfg Config, name string) bool {
+	for _, shadow := range cfg.GetStringMapString(shadowConfigKey) {
+		if strings.TrimSpace(shadow) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// driverEnabled reports whether the driver has to be initialized, which is the case if it is selected
+// by telemetry.driver or is the shadow or canary of another driver
+func driverEnabled(cfg Config, name string) bool {
+	return containsString(SelectedDrivers(cfg), name) || isShadowDriver(cfg, name) || isCanaryDriver(cfg, name)
+}
+
+// withShadow wraps the driver in a ShadowDriver if a shadow is configured for it
+func withShadow(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(ShadowDriver); ok {
+		return driver
+	}
+
+	shadow := shadowFor(viper.GetViper(), name)
+	if len(shadow) == 0 {
+		return driver
+	}
+
+	return ShadowDriver{
+		Primary: driver,
+		Shadow:  shadow,
+	}
+}
+
+// ShadowDriver duplicates all calls of the primary driver to the shadow driver to trial a new backend in production.
+// The application only sees the results of the primary driver, errors and panics of the shadow driver are ignored.
+type ShadowDriver struct {
+	Primary telemetry.Driver
+	// Shadow is the name of a registered driver. It is resolved on every InitializeTransaction call,
+	// so it may be registered after the primary driver, e.g. by an external driver package.
+	Shadow string
+}
+
+// InitializeTransaction starts a transaction with the primary and the shadow driver
+func (d ShadowDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Primary.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	registry.mutex.RLock()
+	shadowDriver, ok := registry.drivers[d.Shadow]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return transaction, nil
+	}
+
+	// a shadow of the shadow is not called, this prevents cycles
+	if shadowed, ok := shadowDriver.(ShadowDriver); ok {
+		shadowDriver = shadowed.Primary
+	}
+
+	t := &ShadowTransaction{
+		primary:    transaction,
+		shadowName: d.Shadow,
+	}
+
+	t.call("InitializeTransaction", func() error {
+		shadow, err := shadowDriver.InitializeTransaction(name)
+		t.shadow = shadow
+		return err
+	})
+
+	return t, nil
+}
+
+// ShadowTransaction forwards all calls to the primary and the shadow transaction
+type ShadowTransaction struct {
+	primary    telemetry.Transaction
+	shadow     telemetry.Transaction
+	shadowName string
+}
+
+// call executes the call of the shadow transaction, measures its latency and recovers its panics
+func (t *ShadowTransaction) call(operation string, shadowCall func() error) {
+	start := clockNow()
+
+	var err error
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("shadow driver %s panicked: %v", t.shadowName, recovered)
+			}
+		}()
+
+		err = shadowCall()
+	}()
+
+	latency := clockSince(start)
+
+	shadowLatencyCallback.mutex.RLock()
+	callback := shadowLatencyCallback.callback
+	shadowLatencyCallback.mutex.RUnlock()
+
+	if callback != nil {
+		callback(t.shadowName, operation, latency, err)
+	}
+}
+
+// callShadow executes the call if the shadow transaction could be started
+func (t *ShadowTransaction) callShadow(operation string, shadowCall func(shadow telemetry.Transaction) error) {
+	if t.shadow == nil {
+		return
+	}
+
+	t.call(operation, func() error {
+		return shadowCall(t.shadow)
+	})
+}
+
+// readMessage reads the message once, so it can be passed to both transactions
+func readMessage(readCloser io.ReadCloser, byteSize int) ([]byte, error) {
+	defer readCloser.Close()
+
+	return io.ReadAll(io.LimitReader(readCloser, int64(byteSize)))
+}
+
+// Start starts the transaction
+func (t *ShadowTransaction) Start(name string) {
+	t.primary.Start(name)
+	t.callShadow("Start", func(shadow telemetry.Transaction) error {
+		shadow.Start(name)
+		return nil
+	})
+}
+
+// AddTransactionAttribute adds an attribute to the transaction
+// - Not thread safe -
+func (t *ShadowTransaction) AddTransactionAttribute(key string, value any) error {
+	err := t.primary.AddTransactionAttribute(key, value)
+	t.callShadow("AddTransactionAttribute", func(shadow telemetry.Transaction) error {
+		return shadow.AddTransactionAttribute(key, value)
+	})
+
+	return err
+}
+
+// SegmentStart starts a segment
+func (t *ShadowTransaction) SegmentStart(segmentID string, name string) error {
+	err := t.primary.SegmentStart(segmentID, name)
+	t.callShadow("SegmentStart", func(shadow telemetry.Transaction) error {
+		return shadow.SegmentStart(segmentID, name)
+	})
+
+	return err
+}
+
+// AddSegmentAttribute adds an attribute to the currently open segment
+// - Thread safe if the transactions of both drivers are -
+func (t *ShadowTransaction) AddSegmentAttribute(segmentID string, key string, value any) error {
+	err := t.primary.AddSegmentAttribute(segmentID, key, value)
+	t.callShadow("AddSegmentAttribute", func(shadow telemetry.Transaction) error {
+		return shadow.AddSegmentAttribute(segmentID, key, value)
+	})
+
+	return err
+}
+
+// SegmentEnd ends the segment
+func (t *ShadowTransaction) SegmentEnd(segmentID string) error {
+	err := t.primary.SegmentEnd(segmentID)
+	t.callShadow("SegmentEnd", func(shadow telemetry.Transaction) error {
+		return shadow.SegmentEnd(segmentID)
+	})
+
+	return err
+}
+
+// Error logs errors in the transaction/segment
+func (t *ShadowTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.ErrorBytesSize)
+	if err != nil {
+		return err
+	}
+
+	err = t.primary.Error(segmentID, io.NopCloser(bytes.NewReader(message)))
+	t.callShadow("Error", func(shadow telemetry.Transaction) error {
+		return shadow.Error(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+
+	return err
+}
+
+// Info logs information in the transaction
+func (t *ShadowTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	err = t.primary.Info(segmentID, io.NopCloser(bytes.NewReader(message)))
+	t.callShadow("Info", func(shadow telemetry.Transaction) error {
+		return shadow.Info(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+
+	return err
+}
+
+// Debug logs information in the transaction
+func (t *ShadowTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	err = t.primary.Debug(segmentID, io.NopCloser(bytes.NewReader(message)))
+	t.callShadow("Debug", func(shadow telemetry.Transaction) error {
+		return shadow.Debug(segmentID, io.NopCloser(bytes.NewReader(message)))
+	})
+
+	return err
+}
+
+// RecordMetric records a custom metric in both transactions, if they support metrics
+func (t *ShadowTransaction) RecordMetric(name string, value float64) error {
+	err := RecordMetric(t.primary, name, value)
+	t.callShadow("RecordMetric", func(shadow telemetry.Transaction) error {
+		return RecordMetric(shadow, name, value)
+	})
+
+	return err
+}
+
+// InjectTraceHeaders adds the trace headers of the primary transaction, the shadow transaction shares its trace
+func (t *ShadowTransaction) InjectTraceHeaders(header http.Header) error {
+	return InjectTraceHeaders(t.primary, header)
+}
+
+// SetOutcome sets the outcome of both transactions
+func (t *ShadowTransaction) SetOutcome(outcome string) error {
+	err := SetOutcome(t.primary, outcome)
+	t.callShadow("SetOutcome", func(shadow telemetry.Transaction) error {
+		return SetOutcome(shadow, outcome)
+	})
+
+	return err
+}
+
+// SetQueueStart records the queue start in both transactions
+func (t *ShadowTransaction) SetQueueStart(start time.Time) error {
+	err := SetQueueStart(t.primary, start)
+	t.callShadow("SetQueueStart", func(shadow telemetry.Transaction) error {
+		return SetQueueStart(shadow, start)
+	})
+
+	return err
+}
+
+// OverrideSampling overrides the sampling of both transactions
+func (t *ShadowTransaction) OverrideSampling(decision string) error {
+	err := OverrideSampling(t.primary, decision)
+	t.callShadow("OverrideSampling", func(shadow telemetry.Transaction) error {
+		return OverrideSampling(shadow, decision)
+	})
+
+	return err
+}
+
+// IsLevelEnabled reports whether the primary or the shadow transaction logs messages of the level in the segment
+func (t *ShadowTransaction) IsLevelEnabled(segmentID string, level string) bool {
+	if IsLevelEnabled(t.primary, segmentID, level) {
+		return true
+	}
+
+	return t.shadow != nil && IsLevelEnabled(t.shadow, segmentID, level)
+}
+
+// Done ends the transaction
+func (t *ShadowTransaction) Done() error {
+	err := t.primary.Done()
+	t.callShadow("Done", func(shadow telemetry.Transaction) error {
+		return shadow.Done()
+	})
+
+	return err
+}
+
+// CreateTrace creates a trace with the primary transaction and passes it to the shadow transaction
+func (t *ShadowTransaction) CreateTrace() (string, error) {
+	trace, err := t.primary.CreateTrace()
+	if err != nil {
+		return trace, err
+	}
+
+	t.callShadow("SetTrace", func(shadow telemetry.Transaction) error {
+		return shadow.SetTrace(trace)
+	})
+
+	return trace, nil
+}
+
+// SetTrace sets a trace for the transaction
+func (t *ShadowTransaction) SetTrace(trace string) error {
+	err := t.primary.SetTrace(trace)
+	t.callShadow("SetTrace", func(shadow telemetry.Transaction) error {
+		return shadow.SetTrace(trace)
+	})
+
+	return err
+}
+
+// Trace returns the trace of the primary transaction
+func (t *ShadowTransaction) Trace() (string, error) {
+	return t.primary.Trace()
+}
+
+// TraceID returns the trace ID of the primary transaction
+func (t *ShadowTransaction) TraceID() (string, error) {
+	return t.primary.TraceID()
+}
+
+// SetTraceID sets a trace ID for the transaction
+func (t *ShadowTransaction) SetTraceID(traceID string) error {
+	err := t.primary.SetTraceID(traceID)
+	t.callShadow("SetTraceID", func(shadow telemetry.Transaction) error {
+		return shadow.SetTraceID(traceID)
+	})
+
+	return err
+}
+
+// CreateProcessID creates a ProcessID with the primary transaction and passes it to the shadow transaction
+func (t *ShadowTransaction) CreateProcessID() (string, error) {
+	processID, err := t.primary.CreateProcessID()
+	if err != nil {
+		return processID, err
+	}
+
+	t.callShadow("SetProcessID", func(shadow telemetry.Transaction) error {
+		return shadow.SetProcessID(processID)
+	})
+
+	return processID, nil
+}
+
+// SetProcessID sets a ProcessID for the transaction
+func (t *ShadowTransaction) SetProcessID(processID string) error {
+	err := t.primary.SetProcessID(processID)
+	t.callShadow("SetProcessID", func(shadow telemetry.Transaction) error {
+		return shadow.SetProcessID(processID)
+	})
+
+	return err
+}
+
+// ProcessID returns the ProcessID of the primary transaction
+func (t *ShadowTransaction) ProcessID() (string, error) {
+	return t.primary.ProcessID()
+}
+
+// Erase any memory both transactions allocated
+func (t *ShadowTransaction) Erase() {
+	t.primary.Erase()
+	t.callShadow("Erase", func(shadow telemetry.Transaction) error {
+		shadow.Erase()
+		return nil
+	})
+}
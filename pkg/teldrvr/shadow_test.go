@@ -0,0 +1,24 @@
+package teldrvr
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDriverEnabledMatchesWholeNames(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("telemetry.driver", "newrelicAPM, stdoutJSON")
+
+	for name, want := range map[string]bool{
+		"newrelicAPM":    true,
+		stdoutJSONDriver: true,
+		"newrelic":       false,
+		"JSON":           false,
+		localDriver:      false,
+	} {
+		if got := driverEnabled(cfg, name); got != want {
+			t.Errorf("driverEnabled(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
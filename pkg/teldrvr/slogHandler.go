@@ -0,0 +1,104 @@
+package teldrvr
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// slogHandler logs the slog records as messages of a transaction, the attributes are appended as key=value pairs
+type slogHandler struct {
+	transaction telemetry.Transaction
+	segmentID   string
+	text        slog.Handler
+	// buffer receives the attributes formatted by the text handler, it is shared by all derived handlers
+	buffer *bytes.Buffer
+	mutex  *sync.Mutex
+}
+
+// SlogHandler returns a slog.Handler that logs the records in the segment of the transaction, or in the transaction
+// if the segment ID is empty. Error records are logged as errors, debug records as debug messages and all others as
+// info messages, so the driver adds the trace and the segment to them:
+//
+//	logger := slog.New(teldrvr.SlogHandler(transaction, segmentID))
+//	logger.Info("order imported", "orderID", 4711)
+func SlogHandler(transaction telemetry.Transaction, segmentID string) slog.Handler {
+	buffer := &bytes.Buffer{}
+
+	return &slogHandler{
+		transaction: transaction,
+		segmentID:   segmentID,
+		text: slog.NewTextHandler(buffer, &slog.HandlerOptions{
+			Level:       slog.LevelDebug,
+			ReplaceAttr: dropSlogBuiltinAttrs,
+		}),
+		buffer: buffer,
+		mutex:  &sync.Mutex{},
+	}
+}
+
+// dropSlogBuiltinAttrs removes the time, level and message of the text output, the driver logs them on its own
+func dropSlogBuiltinAttrs(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return attr
+	}
+
+	switch attr.Key {
+	case slog.TimeKey, slog.LevelKey, slog.MessageKey:
+		return slog.Attr{}
+	}
+
+	return attr
+}
+
+// Enabled reports true for all levels, the driver drops the messages below the configured log level
+func (h *slogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle logs the record with its attributes as message of the transaction
+func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	h.buffer.Reset()
+	err := h.text.Handle(ctx, record)
+	attributes := strings.TrimSuffix(h.buffer.String(), "\n")
+	h.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	message := record.Message
+	if len(attributes) > 0 {
+		message += " " + attributes
+	}
+
+	switch {
+	case record.Level >= slog.LevelError:
+		return h.transaction.Error(h.segmentID, messageReader(message))
+	case record.Level < slog.LevelInfo:
+		return DebugMsg(h.transaction, h.segmentID, message)
+	}
+
+	return InfoMsg(h.transaction, h.segmentID, message)
+}
+
+// WithAttrs returns a handler that appends the attributes to all records
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.text = h.text.WithAttrs(attrs)
+
+	return &derived
+}
+
+// WithGroup returns a handler that qualifies the following attributes with the group name
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	derived := *h
+	derived.text = h.text.WithGroup(name)
+
+	return &derived
+}
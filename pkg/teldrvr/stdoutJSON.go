@@ -0,0 +1,102 @@
+package teldrvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const stdoutJSONDriver = "stdoutJSON"
+
+// stdoutJSONConfigKeys are the settings below telemetry.drivers.stdoutJSON
+var stdoutJSONConfigKeys = []ConfigKey{
+	{Name: "output", Default: localOutputStdout, Values: []string{localOutputStdout, localOutputStderr}},
+	{Name: "errorOutput", Values: []string{localOutputStdout, localOutputStderr}},
+	{Name: "fieldProfile"},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(stdoutJSONDriver, stdoutJSONConfigKeys...)
+	RegisterDriverConfig(stdoutJSONDriver, SeverityConfigKeys...)
+
+	if !driverEnabled(cfg, stdoutJSONDriver) {
+		return
+	}
+
+	// stdout is always available, so invalid settings are only reported
+	err = ValidateDriverConfig(cfg, stdoutJSONDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w", telemetry.TelemetryDriverError, err))
+	}
+
+	driverCfg := DriverConfig(cfg, stdoutJSONDriver)
+
+	var output io.Writer = os.Stdout
+	if driverCfg.GetString("output") == localOutputStderr {
+		output = os.Stderr
+	}
+
+	mapping := newFieldMapping(stdoutJSONDriver, resolveDriverConfigKey(cfg, stdoutJSONDriver, "fieldProfile"))
+
+	sink := &JSONLinesSink{
+		Writer:     mapping.writer(output),
+		Severities: LoadSeverityMapping(cfg, stdoutJSONDriver, nil),
+	}
+
+	errorOutput := consoleOutput(driverCfg.GetString("errorOutput"))
+	if errorOutput != nil {
+		sink.ErrorWriter = mapping.writer(errorOutput)
+	}
+
+	driver := EventDriver{
+		Sink: sink,
+	}
+
+	registerDriver(stdoutJSONDriver, driver)
+}
+
+// JSONLinesSink writes every event as one JSON object per line, e.g. for a log collector of a container platform.
+// The field names are the JSON names of Event. The events are written synchronously, so none is lost at exit.
+type JSONLinesSink struct {
+	Writer io.Writer
+	// ErrorWriter receives the error events instead of Writer, e.g. stderr while the other events go to stdout
+	ErrorWriter io.Writer
+	// Severities replaces the level of the events, levels without entry keep their name
+	Severities SeverityMapping
+	mutex      sync.Mutex
+}
+
+// Emit writes the event as a single line
+func (s *JSONLinesSink) Emit(event Event) error {
+	writer := s.Writer
+	if s.ErrorWriter != nil && event.Type == eventTypeLog && event.Level == logLevelError {
+		writer = s.ErrorWriter
+	}
+
+	if s.Severities != nil {
+		event.Level = s.Severities.EventSeverity(event)
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = writer.Write(append(line, '\n'))
+
+	return err
+}
@@ -0,0 +1,244 @@
+package teldrvr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// tailSamplingConfigKey configures the tail sampling, e.g. telemetry.tailSampling.drivers: newrelicAPM
+const tailSamplingConfigKey = "telemetry.tailSampling"
+
+// tailSamplingDefaultMaxMessages limits the messages buffered per transaction if telemetry.tailSampling.maxMessages is not set
+const tailSamplingDefaultMaxMessages = 1000
+
+// withTailSampling wraps the driver in a TailSamplingDriver if it is listed in telemetry.tailSampling.drivers
+func withTailSampling(name string, driver telemetry.Driver) telemetry.Driver {
+	if _, ok := driver.(TailSamplingDriver); ok {
+		return driver
+	}
+
+	cfg := viper.GetViper()
+	if !containsString(splitDriverList(cfg.GetString(tailSamplingConfigKey+".drivers")), name) {
+		return driver
+	}
+
+	maxMessages := tailSamplingDefaultMaxMessages
+	if cfg.IsSet(tailSamplingConfigKey + ".maxMessages") {
+		maxMessages = cfg.GetInt(tailSamplingConfigKey + ".maxMessages")
+	}
+
+	return TailSamplingDriver{
+		Driver:      driver,
+		Threshold:   cfg.GetDuration(tailSamplingConfigKey + ".threshold"),
+		MaxMessages: maxMessages,
+	}
+}
+
+// TailSamplingDriver buffers the info and debug messages of a transaction and only passes them to the wrapped driver
+// if the transaction records an error or takes longer than the threshold. Otherwise they are dropped at Done.
+// ForceSample and NeverSample override the decision per transaction. All other calls are passed through immediately.
+type TailSamplingDriver struct {
+	Driver telemetry.Driver
+	// Threshold keeps the messages of transactions that take at least this long, 0 keeps only failed transactions
+	Threshold time.Duration
+	// MaxMessages limits the buffered messages per transaction, the oldest messages are dropped first
+	MaxMessages int
+}
+
+// InitializeTransaction starts a transaction of the wrapped driver that buffers its info and debug messages
+func (d TailSamplingDriver) InitializeTransaction(name string) (telemetry.Transaction, error) {
+	transaction, err := d.Driver.InitializeTransaction(name)
+	if err != nil {
+		return transaction, err
+	}
+
+	return &TailSamplingTransaction{
+		Transaction: transaction,
+		driver:      d,
+		start:       clockNow(),
+	}, nil
+}
+
+// sampledMessage is an info or debug message buffered by the TailSamplingTransaction
+type sampledMessage struct {
+	level     string
+	segmentID string
+	message   []byte
+}
+
+// TailSamplingTransaction buffers the info and debug messages until it is known whether the transaction failed.
+// Messages of segments that ended in the meantime are passed with their segment ID.
+type TailSamplingTransaction struct {
+	telemetry.Transaction
+	driver  TailSamplingDriver
+	start   time.Time
+	failed  bool
+	never   bool
+	dropped int
+	buffer  []sampledMessage
+	mutex   sync.Mutex
+}
+
+// bufferMessage keeps the message until the transaction fails or is done, after a failure it is passed through
+func (t *TailSamplingTransaction) bufferMessage(level string, segmentID string, readCloser io.ReadCloser) error {
+	message, err := readMessage(readCloser, telemetry.DebugByteSize)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	if t.never {
+		t.mutex.Unlock()
+
+		return nil
+	}
+
+	if !t.failed {
+		if t.driver.MaxMessages > 0 && len(t.buffer) >= t.driver.MaxMessages {
+			t.buffer = t.buffer[1:]
+			t.dropped++
+		}
+		t.buffer = append(t.buffer, sampledMessage{level: level, segmentID: segmentID, message: message})
+		t.mutex.Unlock()
+
+		return nil
+	}
+	t.mutex.Unlock()
+
+	return t.send(sampledMessage{level: level, segmentID: segmentID, message: message})
+}
+
+// send passes the message to the wrapped transaction
+func (t *TailSamplingTransaction) send(message sampledMessage) error {
+	readCloser := io.NopCloser(bytes.NewReader(message.message))
+	if message.level == logLevelDebug {
+		return t.Transaction.Debug(message.segmentID, readCloser)
+	}
+
+	return t.Transaction.Info(message.segmentID, readCloser)
+}
+
+// flush passes all buffered messages to the wrapped transaction, later messages are passed through immediately
+func (t *TailSamplingTransaction) flush() error {
+	t.mutex.Lock()
+	buffer := t.buffer
+	dropped := t.dropped
+	t.buffer = nil
+	t.dropped = 0
+	t.failed = true
+	t.never = false
+	t.mutex.Unlock()
+
+	var errs []string
+	if dropped > 0 {
+		err := t.send(sampledMessage{
+			level:   logLevelInfo,
+			message: []byte(fmt.Sprintf("tail sampling dropped the %d oldest messages of the transaction", dropped)),
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, message := range buffer {
+		err := t.send(message)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d buffered messages could not be sent: %s", len(errs), strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Error passes the buffered messages and the error to the wrapped transaction, after NeverSample only the error
+func (t *TailSamplingTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	t.mutex.Lock()
+	never := t.never
+	t.mutex.Unlock()
+
+	if !never {
+		flushErr := t.flush()
+		if flushErr != nil {
+			handleError(fmt.Errorf("%s%w", telemetry.TelemetryDriverError, flushErr))
+		}
+	}
+
+	return t.Transaction.Error(segmentID, readCloser)
+}
+
+// Info buffers the message until the transaction fails or is done
+func (t *TailSamplingTransaction) Info(segmentID string, readCloser io.ReadCloser) error {
+	return t.bufferMessage(logLevelInfo, segmentID, readCloser)
+}
+
+// Debug buffers the message until the transaction fails or is done
+func (t *TailSamplingTransaction) Debug(segmentID string, readCloser io.ReadCloser) error {
+	return t.bufferMessage(logLevelDebug, segmentID, readCloser)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *TailSamplingTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// OverrideSampling passes the buffered and all later messages to the wrapped transaction with SamplingForce, with
+// SamplingNever they are dropped
+func (t *TailSamplingTransaction) OverrideSampling(decision string) error {
+	if decision == SamplingForce {
+		return t.flush()
+	}
+
+	t.mutex.Lock()
+	t.never = true
+	t.failed = false
+	t.buffer = nil
+	t.dropped = 0
+	t.mutex.Unlock()
+
+	return nil
+}
+
+// Done passes the buffered messages to the wrapped transaction if the transaction failed or was slow and ends it
+func (t *TailSamplingTransaction) Done() error {
+	t.mutex.Lock()
+	never := t.never
+	t.mutex.Unlock()
+
+	keep := !never && t.driver.Threshold > 0 && clockSince(t.start) >= t.driver.Threshold
+
+	var flushErr error
+	if keep {
+		flushErr = t.flush()
+	} else {
+		t.mutex.Lock()
+		t.buffer = nil
+		t.mutex.Unlock()
+	}
+
+	err := t.Transaction.Done()
+	if err != nil {
+		return err
+	}
+
+	return flushErr
+}
+
+// Erase any memory the transaction allocated
+func (t *TailSamplingTransaction) Erase() {
+	t.mutex.Lock()
+	t.buffer = nil
+	t.mutex.Unlock()
+
+	t.Transaction.Erase()
+}
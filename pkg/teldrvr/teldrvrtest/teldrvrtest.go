@@ -0,0 +1,103 @@
+// Package teldrvrtest locks down the output of the drivers with golden files. The test mode of teldrvr makes the
+// output deterministic, AssertGolden compares it with the file below testdata:
+//
+//	func TestImportOutput(t *testing.T) {
+//		teldrvrtest.TestMode(t)
+//		output := bytes.Buffer{}
+//		driver := teldrvr.EventDriver{Sink: &teldrvr.JSONLinesSink{Writer: &output}}
+//		...
+//		teldrvrtest.AssertGolden(t, "import", output.Bytes())
+//	}
+//
+// TELDRVR_UPDATE_GOLDEN=1 go test ./... writes the golden files with the current output.
+package teldrvrtest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+)
+
+// UpdateEnv is the environment variable that writes the golden files instead of comparing them
+const UpdateEnv = "TELDRVR_UPDATE_GOLDEN"
+
+// goldenDir is relative to the package directory, the working directory of go test
+const goldenDir = "testdata"
+
+// TestMode enables the test mode of teldrvr and disables it when the test and its subtests are finished.
+// Tests using it must not run in parallel, the clock and the IDs are global.
+func TestMode(t testing.TB) *teldrvr.FakeClock {
+	t.Helper()
+
+	clock := teldrvr.EnableTestMode()
+	t.Cleanup(teldrvr.DisableTestMode)
+
+	return clock
+}
+
+// GoldenPath returns the path of the golden file with the given name
+func GoldenPath(name string) string {
+	return filepath.Join(goldenDir, name+".golden")
+}
+
+// AssertGolden fails the test if the output differs from the golden file, the first different line is reported.
+// With TELDRVR_UPDATE_GOLDEN=1 the golden file is written instead.
+func AssertGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+
+	path := GoldenPath(name)
+
+	if len(os.Getenv(UpdateEnv)) > 0 {
+		err := os.MkdirAll(filepath.Dir(path), 0o750)
+		if err != nil {
+			t.Fatalf("golden file »%s« could not be written: %v", path, err)
+		}
+
+		err = os.WriteFile(path, got, 0o640)
+		if err != nil {
+			t.Fatalf("golden file »%s« could not be written: %v", path, err)
+		}
+
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("golden file »%s« does not exist, run the test with %s=1 to create it", path, UpdateEnv)
+	}
+	if err != nil {
+		t.Fatalf("golden file »%s« could not be read: %v", path, err)
+	}
+
+	if bytes.Equal(got, want) {
+		return
+	}
+
+	line, gotLine, wantLine := firstDifference(got, want)
+	t.Errorf("output differs from golden file »%s« in line %d, run the test with %s=1 to update it\ngot:  %s\nwant: %s",
+		path, line, UpdateEnv, gotLine, wantLine)
+}
+
+// firstDifference returns the number of the first different line and both lines, a missing line is empty
+func firstDifference(got []byte, want []byte) (int, string, string) {
+	gotLines := bytes.Split(got, []byte("\n"))
+	wantLines := bytes.Split(want, []byte("\n"))
+
+	for i := 0; ; i++ {
+		var gotLine, wantLine []byte
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+
+		if !bytes.Equal(gotLine, wantLine) || i >= len(gotLines) || i >= len(wantLines) {
+			return i + 1, string(gotLine), string(wantLine)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package teldrvrtest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// logTransaction writes a started transaction with an attribute, a segment with an attribute and an error in the segment
+func logTransaction(t *testing.T, clock *teldrvr.FakeClock, driver telemetry.Driver) {
+	t.Helper()
+
+	transaction, err := driver.InitializeTransaction("import")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []func() error{
+		func() error { return transaction.SetTrace("trace-1") },
+		func() error { return transaction.SetProcessID("process-1") },
+		func() error {
+			transaction.Start("import")
+			return nil
+		},
+		func() error { return transaction.AddTransactionAttribute("shop", 42) },
+		func() error { return transaction.SegmentStart("segment-1", "read") },
+		func() error { return transaction.AddSegmentAttribute("segment-1", "file", "orders.csv") },
+		func() error {
+			return transaction.Error("segment-1", io.NopCloser(bytes.NewBufferString("line 3 is invalid")))
+		},
+		func() error { return transaction.SegmentEnd("segment-1") },
+		func() error { return transaction.Done() },
+	}
+	for _, call := range calls {
+		clock.Advance(10 * time.Millisecond)
+		if err = call(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocalDriverGolden(t *testing.T) {
+	for _, format := range []string{"plain", "pretty"} {
+		t.Run(format, func(t *testing.T) {
+			clock := TestMode(t)
+			output := bytes.Buffer{}
+			logTransaction(t, clock, teldrvr.LocalDriver{Format: format, Writer: &output})
+
+			AssertGolden(t, "local_"+format, output.Bytes())
+		})
+	}
+}
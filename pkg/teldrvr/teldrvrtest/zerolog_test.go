@@ -0,0 +1,52 @@
+//go:build !nonewrelic
+
+package teldrvrtest
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/plentymarkets/mc-telemetry-driver/pkg/teldrvr"
+)
+
+// captureStdout returns what the function writes to os.Stdout
+func captureStdout(t *testing.T, write func()) []byte {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	done := make(chan []byte)
+	go func() {
+		output, _ := io.ReadAll(reader)
+		done <- output
+	}()
+
+	write()
+	_ = writer.Close()
+
+	return <-done
+}
+
+func TestZeroLogDriverGolden(t *testing.T) {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("teldrvrtest"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := TestMode(t)
+	output := captureStdout(t, func() {
+		logTransaction(t, clock, teldrvr.ZeroLogDriver{NewRelicApp: app})
+	})
+
+	AssertGolden(t, "nrZerolog", output)
+}
@@ -0,0 +1,149 @@
+package teldrvr
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// tenant attributes stamped on the transaction and every segment
+const (
+	TenantAttributePlentySystemID = "plentySystemId"
+	TenantAttributeClusterID      = "clusterId"
+)
+
+// Tenant identifies the customer a transaction works for. Empty identifiers are not stamped.
+type Tenant struct {
+	PlentySystemID string
+	ClusterID      string
+}
+
+// attributes returns the non-empty identifiers by attribute name
+func (t Tenant) attributes() map[string]string {
+	attributes := make(map[string]string, 2)
+	if len(t.PlentySystemID) > 0 {
+		attributes[TenantAttributePlentySystemID] = t.PlentySystemID
+	}
+	if len(t.ClusterID) > 0 {
+		attributes[TenantAttributeClusterID] = t.ClusterID
+	}
+
+	return attributes
+}
+
+// TenantExtractor returns the tenant of the context, false if the context belongs to no tenant
+type TenantExtractor func(ctx context.Context) (Tenant, bool)
+
+// tenantContextKey is the context key of the tenant set by ContextWithTenant
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a context carrying the tenant for the default extractor TenantFromContext
+func ContextWithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by ContextWithTenant, it is the default extractor
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(Tenant)
+
+	return tenant, ok
+}
+
+var tenantExtractor = struct {
+	extractor TenantExtractor
+	mutex     sync.RWMutex
+}{
+	extractor: TenantFromContext,
+}
+
+// SetTenantExtractor sets the function which reads the tenant from a context, e.g. from the claims of a request.
+// nil restores the default extractor TenantFromContext.
+func SetTenantExtractor(extractor TenantExtractor) {
+	if extractor == nil {
+		extractor = TenantFromContext
+	}
+
+	tenantExtractor.mutex.Lock()
+	tenantExtractor.extractor = extractor
+	tenantExtractor.mutex.Unlock()
+}
+
+// extractTenant reads the tenant from the context with the configured extractor
+func extractTenant(ctx context.Context) (Tenant, bool) {
+	tenantExtractor.mutex.RLock()
+	extractor := tenantExtractor.extractor
+	tenantExtractor.mutex.RUnlock()
+
+	if ctx == nil {
+		return Tenant{}, false
+	}
+
+	return extractor(ctx)
+}
+
+// WithTenant stamps the tenant of the context on the transaction and on every segment started afterwards, so the log
+// lines of all drivers carry it. If the context belongs to no tenant, the transaction is returned unchanged.
+func WithTenant(ctx context.Context, transaction telemetry.Transaction) (telemetry.Transaction, error) {
+	tenant, ok := extractTenant(ctx)
+	if !ok {
+		return transaction, nil
+	}
+
+	attributes := tenant.attributes()
+	if len(attributes) == 0 {
+		return transaction, nil
+	}
+
+	var errs []error
+	for key, value := range attributes {
+		err := transaction.AddTransactionAttribute(key, value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return &TenantTransaction{
+		Transaction: transaction,
+		attributes:  attributes,
+	}, errors.Join(errs...)
+}
+
+// StartTenantTransaction starts a transaction of all active drivers stamped with the tenant of the context
+func StartTenantTransaction(ctx context.Context, name string) (telemetry.Transaction, error) {
+	transaction, err := StartActiveTransaction(name)
+
+	transaction, tenantErr := WithTenant(ctx, transaction)
+
+	return transaction, errors.Join(err, tenantErr)
+}
+
+// TenantTransaction adds the tenant attributes to every segment started in the transaction
+type TenantTransaction struct {
+	telemetry.Transaction
+	attributes map[string]string
+}
+
+// SegmentStart starts the segment and stamps the tenant on it
+func (t *TenantTransaction) SegmentStart(segmentID string, name string) error {
+	err := t.Transaction.SegmentStart(segmentID, name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for key, value := range t.attributes {
+		err = t.Transaction.AddSegmentAttribute(segmentID, key, value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *TenantTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
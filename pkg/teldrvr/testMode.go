@@ -0,0 +1,24 @@
+package teldrvr
+
+import "time"
+
+// TestModeStart is the time of the clock in the test mode
+var TestModeStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// EnableTestMode makes the output of the drivers deterministic, e.g. for golden files. The clock is a FakeClock
+// starting at TestModeStart and the IDs are a sequence starting at 1, the drivers write attributes sorted by key in
+// every mode. Sinks have to be created afterwards, see SetClock. DisableTestMode restores the system clock and the IDs
+// of telemetry.idFormat.
+func EnableTestMode() *FakeClock {
+	fakeClock := NewFakeClock(TestModeStart)
+	SetClock(fakeClock)
+	SetIDGenerator(NewSequenceIDGenerator())
+
+	return fakeClock
+}
+
+// DisableTestMode ends the test mode started by EnableTestMode
+func DisableTestMode() {
+	SetClock(nil)
+	SetIDGenerator(nil)
+}
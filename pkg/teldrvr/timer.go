@@ -0,0 +1,38 @@
+package teldrvr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// StopTimer records the elapsed time of the timer and returns it
+type StopTimer func() (time.Duration, error)
+
+// StartTimer measures an operation without opening a segment for it. The returned function records the elapsed
+// milliseconds as attribute <name> of the segment, or as metric <name> of the transaction if the segment ID is empty.
+// Only the first call of the returned function records the time, later calls return the same result.
+func StartTimer(transaction telemetry.Transaction, segmentID string, name string) StopTimer {
+	start := clockNow()
+
+	var once sync.Once
+	var elapsed time.Duration
+	var err error
+
+	return func() (time.Duration, error) {
+		once.Do(func() {
+			elapsed = clockSince(start)
+			milliseconds := float64(elapsed) / float64(time.Millisecond)
+
+			if len(segmentID) == 0 {
+				err = RecordMetric(transaction, name, milliseconds)
+				return
+			}
+
+			err = transaction.AddSegmentAttribute(segmentID, name, milliseconds)
+		})
+
+		return elapsed, err
+	}
+}
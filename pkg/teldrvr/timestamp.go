@@ -0,0 +1,127 @@
+package teldrvr
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// timestampConfigKey configures the timestamps of the log drivers, e.g. telemetry.timestamp.format: unixMillis
+const timestampConfigKey = "telemetry.timestamp"
+
+// timestamp formats of telemetry.timestamp.format
+const (
+	timestampFormatRFC3339     = "rfc3339"
+	timestampFormatRFC3339Nano = "rfc3339Nano"
+	timestampFormatUnixMillis  = "unixMillis"
+)
+
+var timestampFormats = []string{timestampFormatRFC3339, timestampFormatRFC3339Nano, timestampFormatUnixMillis}
+
+// defaultTimestampField is the field name zerolog uses for timestamps
+const defaultTimestampField = "time"
+
+// timestamp formats the times of the log drivers. Without a configured format the drivers keep their own format,
+// the timezone applies to it nevertheless.
+type timestamp struct {
+	field    string
+	format   string
+	location *time.Location
+}
+
+// timestampSettings holds the timestamp read from the config on the first transaction
+var timestampSettings = struct {
+	timestamp timestamp
+	once      sync.Once
+}{}
+
+// configuredTimestamp reads telemetry.timestamp once. Invalid values were reported by the config validation, they
+// fall back to the defaults.
+func configuredTimestamp() timestamp {
+	timestampSettings.once.Do(func() {
+		timestampSettings.timestamp = readTimestamp(viper.GetViper())
+	})
+
+	return timestampSettings.timestamp
+}
+
+// readTimestamp reads the timestamp from the config
+func readTimestamp(cfg Config) timestamp {
+	stamp := timestamp{
+		field:  cfg.GetString(timestampConfigKey + ".field"),
+		format: cfg.GetString(timestampConfigKey + ".format"),
+	}
+
+	if len(stamp.field) == 0 {
+		stamp.field = defaultTimestampField
+	}
+
+	if !containsString(timestampFormats, stamp.format) {
+		stamp.format = ""
+	}
+
+	timezone := cfg.GetString(timestampConfigKey + ".timezone")
+	if len(timezone) > 0 {
+		location, err := time.LoadLocation(timezone)
+		if err == nil {
+			stamp.location = location
+		}
+	}
+
+	return stamp
+}
+
+// validateTimezone checks the timezone is known, e.g. UTC, Local or Europe/Berlin
+func validateTimezone(value string) error {
+	_, err := time.LoadLocation(value)
+
+	return err
+}
+
+// in converts the time into the configured timezone
+func (s timestamp) in(now time.Time) time.Time {
+	if s.location == nil {
+		return now
+	}
+
+	return now.In(s.location)
+}
+
+// formatTime formats the time in the configured format, the layout is used if no format is configured
+func (s timestamp) formatTime(now time.Time, layout string) string {
+	now = s.in(now)
+
+	switch s.format {
+	case timestampFormatRFC3339:
+		return now.Format(time.RFC3339)
+	case timestampFormatRFC3339Nano:
+		return now.Format(time.RFC3339Nano)
+	case timestampFormatUnixMillis:
+		return strconv.FormatInt(now.UnixMilli(), 10)
+	default:
+		return now.Format(layout)
+	}
+}
+
+// timestampWriter prefixes every line of the std logger with the configured timestamp, it replaces the log.LstdFlags
+type timestampWriter struct {
+	writer    io.Writer
+	timestamp timestamp
+}
+
+func (w timestampWriter) Write(p []byte) (int, error) {
+	line := make([]byte, 0, len(p)+32)
+	line = append(line, w.timestamp.formatTime(clockNow(), "2006/01/02 15:04:05")...)
+	line = append(line, ' ')
+	line = append(line, p...)
+
+	_, err := w.writer.Write(line)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
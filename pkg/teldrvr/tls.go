@@ -0,0 +1,104 @@
+package teldrvr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSConfigKeys are the settings read by TLSConfig, network drivers register them with their own settings
+var TLSConfigKeys = []ConfigKey{
+	{Name: "tls.caFile"},
+	{Name: "tls.certFile"},
+	{Name: "tls.keyFile"},
+	{Name: "tls.serverName"},
+	{Name: "tls.minVersion", Values: []string{"1.2", "1.3"}},
+	{Name: "tls.insecureSkipVerify", Kind: ConfigKindBool},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig creates the TLS configuration of a network driver from telemetry.drivers.<driver>.tls.*
+// If no TLS setting is configured, nil is returned and the driver uses its defaults.
+// The certificates are loaded immediately, so invalid files are reported at startup.
+func TLSConfig(cfg Config, driver string) (*tls.Config, error) {
+	prefix := driverConfigPrefix + driver + ".tls."
+	driverCfg := DriverConfig(cfg, driver)
+
+	caFile := driverCfg.GetString("tls.caFile")
+	certFile := driverCfg.GetString("tls.certFile")
+	keyFile := driverCfg.GetString("tls.keyFile")
+	serverName := driverCfg.GetString("tls.serverName")
+	minVersion := driverCfg.GetString("tls.minVersion")
+	insecureSkipVerify := driverCfg.GetBool("tls.insecureSkipVerify")
+
+	if len(caFile) == 0 && len(certFile) == 0 && len(keyFile) == 0 && len(serverName) == 0 && len(minVersion) == 0 && !insecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if len(minVersion) > 0 {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("%sminVersion »%s« is not supported, use 1.2 or 1.3", prefix, minVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(caFile) > 0 {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("%scaFile could not be read: %w", prefix, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%scaFile »%s« contains no PEM certificate", prefix, caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			return nil, errors.New(prefix + "certFile and " + prefix + "keyFile have to be set together")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%scertFile and keyFile could not be loaded: %w", prefix, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// newHTTPClient creates the client of an HTTP based driver with its TLS configuration and the timeouts of the policy
+func newHTTPClient(cfg Config, driver string, policy EmitPolicy) (*http.Client, error) {
+	tlsConfig, err := TLSConfig(cfg, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = policy.Dialer().DialContext
+	if policy.ConnectTimeout > 0 {
+		transport.TLSHandshakeTimeout = policy.ConnectTimeout
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Timeout: policy.RequestTimeout, Transport: transport}, nil
+}
@@ -0,0 +1,40 @@
+package teldrvr
+
+import (
+	"net/http"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// TraceHeader carries the trace of transactions whose driver has no own propagation headers
+const TraceHeader = "X-Telemetry-Trace"
+
+// newRelicTraceHeader is the distributed tracing header of New Relic, newrelic.DistributedTraceNewRelicHeader is not
+// available with the nonewrelic tag
+const newRelicTraceHeader = "Newrelic"
+
+// TraceHeaderInjector is implemented by all transactions that propagate their trace in driver specific headers,
+// e.g. the distributed tracing headers of New Relic
+type TraceHeaderInjector interface {
+	InjectTraceHeaders(header http.Header) error
+}
+
+// InjectTraceHeaders adds the trace of the transaction to the header of an outgoing request.
+// Transactions without driver specific headers pass their trace in the TraceHeader.
+func InjectTraceHeaders(transaction telemetry.Transaction, header http.Header) error {
+	injector, ok := findTransaction[TraceHeaderInjector](transaction)
+	if ok {
+		return injector.InjectTraceHeaders(header)
+	}
+
+	trace, err := innermostTransaction(transaction).Trace()
+	if err != nil {
+		return err
+	}
+
+	if len(trace) > 0 {
+		header.Set(TraceHeader, trace)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package teldrvr
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// TraceLinkAttribute holds the ID of a related trace, further links get the attribute link.traceID.<n>
+const TraceLinkAttribute = "link.traceID"
+
+// LinkTraces references other traces from the transaction or, with a segment ID, from the segment, e.g. the traces of
+// the webhooks a batch job processes. None of the drivers supports span links natively, the New Relic agent neither, so
+// the trace IDs are added as attributes link.traceID, link.traceID.1, link.traceID.2 and so on. Empty trace IDs are
+// skipped. The links have to be added with a single call, attributes can not be overwritten.
+func LinkTraces(transaction telemetry.Transaction, segmentID string, traceIDs ...string) error {
+	var errs []error
+	links := 0
+	for _, traceID := range traceIDs {
+		if len(traceID) == 0 {
+			continue
+		}
+
+		key := TraceLinkAttribute
+		if links > 0 {
+			key += "." + strconv.Itoa(links)
+		}
+		links++
+
+		var err error
+		if len(segmentID) == 0 {
+			err = transaction.AddTransactionAttribute(key, traceID)
+		} else {
+			err = transaction.AddSegmentAttribute(segmentID, key, traceID)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,193 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/viper"
+)
+
+// Hook is called synchronously by the transactions, e.g. to count business KPIs. It receives the call as event: the
+// transaction start, the segment end with its duration or the error with its message. A panic of a hook is recovered
+// and passed to the error handler.
+type Hook func(event Event)
+
+// transactionHooks holds the registered hooks by event type
+var transactionHooks = struct {
+	transactionStart []Hook
+	segmentEnd       []Hook
+	errors           []Hook
+	mutex            sync.RWMutex
+}{}
+
+// OnTransactionStart registers a hook called when a transaction starts, the event carries the transaction name
+func OnTransactionStart(hook Hook) {
+	transactionHooks.mutex.Lock()
+	defer transactionHooks.mutex.Unlock()
+
+	transactionHooks.transactionStart = append(transactionHooks.transactionStart, hook)
+}
+
+// OnSegmentEnd registers a hook called when a segment ends, the event carries the segment and its duration
+func OnSegmentEnd(hook Hook) {
+	transactionHooks.mutex.Lock()
+	defer transactionHooks.mutex.Unlock()
+
+	transactionHooks.segmentEnd = append(transactionHooks.segmentEnd, hook)
+}
+
+// OnError registers a hook called when an error is logged, the event carries the segment and the message
+func OnError(hook Hook) {
+	transactionHooks.mutex.Lock()
+	defer transactionHooks.mutex.Unlock()
+
+	transactionHooks.errors = append(transactionHooks.errors, hook)
+}
+
+// registeredHooks returns the hooks of the event type
+func registeredHooks(eventType string) []Hook {
+	transactionHooks.mutex.RLock()
+	defer transactionHooks.mutex.RUnlock()
+
+	switch eventType {
+	case eventTypeTransactionStart:
+		return transactionHooks.transactionStart
+	case eventTypeSegmentEnd:
+		return transactionHooks.segmentEnd
+	default:
+		return transactionHooks.errors
+	}
+}
+
+// hasHooks reports whether any hook is registered
+func hasHooks() bool {
+	transactionHooks.mutex.RLock()
+	defer transactionHooks.mutex.RUnlock()
+
+	return len(transactionHooks.transactionStart) > 0 || len(transactionHooks.segmentEnd) > 0 ||
+		len(transactionHooks.errors) > 0
+}
+
+// callHooks calls the hooks of the event type
+func callHooks(event Event) {
+	for _, hook := range registeredHooks(event.Type) {
+		callHook(hook, event)
+	}
+}
+
+// callHook calls the hook, a panic is reported and does not reach the application
+func callHook(hook Hook, event Event) {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			handleError(fmt.Errorf("%shook of the %s event panicked: %v", telemetry.TelemetryDriverError, event.Type, recovered))
+		}
+	}()
+
+	hook(event)
+}
+
+// firstConfiguredDriver returns the first registered driver of telemetry.driver. Only its transactions call the hooks,
+// so they are called once per transaction, also if it is started in several drivers.
+func firstConfiguredDriver() string {
+	for _, name := range SelectedDrivers(viper.GetViper()) {
+		if IsDriverRegistered(name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// withHooks wraps the transaction of the driver in a HookTransaction if hooks are registered and the driver calls them
+func withHooks(driver string, name string, transaction telemetry.Transaction) telemetry.Transaction {
+	if !hasHooks() || driver != firstConfiguredDriver() {
+		return transaction
+	}
+
+	callHooks(Event{
+		Time:        clockNow(),
+		Type:        eventTypeTransactionStart,
+		Transaction: name,
+	})
+
+	return &HookTransaction{
+		Transaction: transaction,
+		name:        name,
+		segments:    make(map[string]openSegment),
+	}
+}
+
+// HookTransaction calls the hooks registered with OnSegmentEnd and OnError
+type HookTransaction struct {
+	telemetry.Transaction
+	name     string
+	segments map[string]openSegment
+	mutex    sync.Mutex
+}
+
+// newEvent returns the event of the hooks for the segment
+func (t *HookTransaction) newEvent(eventType string, segmentID string, segment string) Event {
+	processID, _ := t.Transaction.ProcessID()
+
+	return Event{
+		Time:        clockNow(),
+		Type:        eventType,
+		Transaction: t.name,
+		ProcessID:   processID,
+		SegmentID:   segmentID,
+		Segment:     segment,
+	}
+}
+
+// SegmentStart starts the segment and keeps its name and start for the hooks
+func (t *HookTransaction) SegmentStart(segmentID string, name string) error {
+	t.mutex.Lock()
+	t.segments[segmentID] = openSegment{name: name, start: clockNow()}
+	t.mutex.Unlock()
+
+	return t.Transaction.SegmentStart(segmentID, name)
+}
+
+// SegmentEnd ends the segment and calls the hooks with its duration
+func (t *HookTransaction) SegmentEnd(segmentID string) error {
+	err := t.Transaction.SegmentEnd(segmentID)
+
+	t.mutex.Lock()
+	segment, ok := t.segments[segmentID]
+	delete(t.segments, segmentID)
+	t.mutex.Unlock()
+
+	if ok {
+		event := t.newEvent(eventTypeSegmentEnd, segmentID, segment.name)
+		event.Duration = clockSince(segment.start)
+		callHooks(event)
+	}
+
+	return err
+}
+
+// Error logs the error and calls the hooks with its message
+func (t *HookTransaction) Error(segmentID string, readCloser io.ReadCloser) error {
+	content, readErr := readMessage(readCloser, telemetry.ErrorBytesSize)
+	message := string(content)
+
+	t.mutex.Lock()
+	segment := t.segments[segmentID]
+	t.mutex.Unlock()
+
+	event := t.newEvent(eventTypeLog, segmentID, segment.name)
+	event.Level = logLevelError
+	event.Message = message
+	callHooks(event)
+
+	return errors.Join(readErr, t.Transaction.Error(segmentID, messageReader(message)))
+}
+
+// Unwrap returns the wrapped transaction
+func (t *HookTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
@@ -0,0 +1,240 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+	"github.com/spf13/cast"
+)
+
+// names of the built in transaction templates
+const (
+	TemplateBackgroundJob = "background-job"
+	TemplateHTTPRequest   = "http-request"
+	TemplateWebhook       = "webhook"
+)
+
+// TemplateAttribute holds the name of the template a transaction was started with
+const TemplateAttribute = "transaction.template"
+
+// TransactionTemplate describes a kind of transaction, so all teams start them with the same names, attributes and
+// outcomes instead of repeating the boilerplate
+type TransactionTemplate struct {
+	// NamePrefix is prepended to the transaction names, names that already carry it are kept, e.g. "job."
+	NamePrefix string
+	// Attributes are added to every transaction of the template
+	Attributes map[string]any
+	// Segments are started with the transaction and ended at Done if they are still open. The segment ID is the name,
+	// so the code logs into them by their name.
+	Segments []string
+	// OutcomeRules derive the outcome at Done from the transaction attributes, the first matching rule wins. Without a
+	// matching rule or with an outcome set by the code the outcome is derived as usual.
+	OutcomeRules []OutcomeRule
+}
+
+// OutcomeRule returns the outcome of a transaction with the attributes, false if the rule does not apply
+type OutcomeRule func(attributes map[string]any) (string, bool)
+
+// StatusCodeOutcome returns a rule that fails transactions whose status code attribute is at least failureFrom, other
+// status codes succeed. Transactions without the attribute are left to the next rule.
+func StatusCodeOutcome(attribute string, failureFrom int) OutcomeRule {
+	return func(attributes map[string]any) (string, bool) {
+		value, ok := attributes[attribute]
+		if !ok {
+			return "", false
+		}
+
+		statusCode, err := cast.ToIntE(value)
+		if err != nil {
+			return "", false
+		}
+
+		if statusCode >= failureFrom {
+			return OutcomeFailure, true
+		}
+
+		return OutcomeSuccess, true
+	}
+}
+
+// transactionTemplates holds the built in and registered templates by name
+var transactionTemplates = struct {
+	templates map[string]TransactionTemplate
+	mutex     sync.RWMutex
+}{
+	templates: map[string]TransactionTemplate{
+		TemplateBackgroundJob: {
+			NamePrefix: "job.",
+		},
+		// client errors are the fault of the client, the request was handled successfully
+		TemplateHTTPRequest: {
+			NamePrefix:   "http.",
+			OutcomeRules: []OutcomeRule{StatusCodeOutcome("http.statusCode", http.StatusInternalServerError)},
+		},
+		// senders retry all deliveries not answered with 2xx, so they fail the delivery
+		TemplateWebhook: {
+			NamePrefix:   "webhook.",
+			OutcomeRules: []OutcomeRule{StatusCodeOutcome("http.statusCode", http.StatusBadRequest)},
+		},
+	},
+}
+
+// RegisterTransactionTemplate makes a template available to StartTemplateTransaction under the given name.
+// It is meant to be called from an init function.
+func RegisterTransactionTemplate(name string, template TransactionTemplate) error {
+	if len(name) == 0 {
+		return fmt.Errorf("can not register transaction template without name")
+	}
+
+	transactionTemplates.mutex.Lock()
+	defer transactionTemplates.mutex.Unlock()
+
+	if _, ok := transactionTemplates.templates[name]; ok {
+		return fmt.Errorf("transaction template '%s' is already registered", name)
+	}
+
+	transactionTemplates.templates[name] = template
+
+	return nil
+}
+
+// RegisteredTransactionTemplates returns the sorted names of the built in and registered templates
+func RegisteredTransactionTemplates() []string {
+	transactionTemplates.mutex.RLock()
+	defer transactionTemplates.mutex.RUnlock()
+
+	names := make([]string, 0, len(transactionTemplates.templates))
+	for name := range transactionTemplates.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// StartTemplateTransaction starts a transaction of all active drivers from the template, see TransactionTemplate.
+// An unknown template is reported in the error, the returned transaction is started without template nevertheless.
+func StartTemplateTransaction(templateName string, name string) (telemetry.Transaction, error) {
+	transactionTemplates.mutex.RLock()
+	template, ok := transactionTemplates.templates[templateName]
+	transactionTemplates.mutex.RUnlock()
+
+	if !ok {
+		transaction, err := StartActiveTransaction(name)
+		return transaction, errors.Join(fmt.Errorf("transaction template »%s« is not registered", templateName), err)
+	}
+
+	if !strings.HasPrefix(name, template.NamePrefix) {
+		name = template.NamePrefix + name
+	}
+
+	transaction, err := StartActiveTransaction(name)
+	errs := []error{err}
+
+	templateTransaction := &TemplateTransaction{
+		Transaction: transaction,
+		rules:       template.OutcomeRules,
+		attributes:  make(map[string]any, len(template.Attributes)+1),
+	}
+
+	errs = append(errs, templateTransaction.AddTransactionAttribute(TemplateAttribute, templateName))
+	for key, value := range template.Attributes {
+		errs = append(errs, templateTransaction.AddTransactionAttribute(key, value))
+	}
+
+	for _, segment := range template.Segments {
+		err = transaction.SegmentStart(segment, segment)
+		if err == nil {
+			templateTransaction.segments = append(templateTransaction.segments, segment)
+		}
+		errs = append(errs, err)
+	}
+
+	return templateTransaction, errors.Join(errs...)
+}
+
+// TemplateTransaction ends the segments of its template and derives the outcome from its rules at Done
+type TemplateTransaction struct {
+	telemetry.Transaction
+	rules []OutcomeRule
+	// segments are the open segments of the template
+	segments   []string
+	attributes map[string]any
+	outcomeSet bool
+	mutex      sync.Mutex
+}
+
+// AddTransactionAttribute adds the attribute and keeps it for the outcome rules
+func (t *TemplateTransaction) AddTransactionAttribute(key string, value any) error {
+	t.mutex.Lock()
+	t.attributes[key] = value
+	t.mutex.Unlock()
+
+	return t.Transaction.AddTransactionAttribute(key, value)
+}
+
+// SegmentEnd ends the segment, segments of the template are not ended again at Done
+func (t *TemplateTransaction) SegmentEnd(segmentID string) error {
+	t.mutex.Lock()
+	for i, segment := range t.segments {
+		if segment == segmentID {
+			t.segments = append(t.segments[:i], t.segments[i+1:]...)
+			break
+		}
+	}
+	t.mutex.Unlock()
+
+	return t.Transaction.SegmentEnd(segmentID)
+}
+
+// Done ends the open segments of the template in reverse order, sets the outcome of the first matching rule and ends
+// the transaction
+func (t *TemplateTransaction) Done() error {
+	t.mutex.Lock()
+	segments := t.segments
+	t.segments = nil
+	outcome := ""
+	if !t.outcomeSet {
+		for _, rule := range t.rules {
+			var ok bool
+			outcome, ok = rule(t.attributes)
+			if ok {
+				break
+			}
+		}
+	}
+	t.mutex.Unlock()
+
+	var errs []error
+	for i := len(segments) - 1; i >= 0; i-- {
+		errs = append(errs, t.Transaction.SegmentEnd(segments[i]))
+	}
+
+	if len(outcome) > 0 {
+		errs = append(errs, SetOutcome(t.Transaction, outcome))
+	}
+
+	return errors.Join(append(errs, t.Transaction.Done())...)
+}
+
+// Unwrap returns the wrapped transaction
+func (t *TemplateTransaction) Unwrap() telemetry.Transaction {
+	return t.Transaction
+}
+
+// SetOutcome sets the outcome of the wrapped transaction, the outcome rules are skipped afterwards
+func (t *TemplateTransaction) SetOutcome(outcome string) error {
+	err := SetOutcome(t.Transaction, outcome)
+	if err == nil {
+		t.mutex.Lock()
+		t.outcomeSet = true
+		t.mutex.Unlock()
+	}
+
+	return err
+}
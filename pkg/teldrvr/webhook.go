@@ -0,0 +1,180 @@
+package teldrvr
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+/** DRIVER NAME **/
+const webhookDriver = "webhook"
+
+// WebhookSignatureHeader contains the hex encoded HMAC-SHA256 of the body, if a secret is configured
+const WebhookSignatureHeader = "X-Telemetry-Signature-256"
+
+// webhookConfigKeys are the settings below telemetry.drivers.webhook
+var webhookConfigKeys = []ConfigKey{
+	{Name: "url", Required: true},
+	{Name: "headers", Kind: ConfigKindStringMap, Secret: true},
+	{Name: "secret", Secret: true},
+	{Name: "compression", Default: compressionNone, Values: compressionValues},
+	{Name: "queueSize", Kind: ConfigKindInt, Default: defaultEventQueueSize, Min: 1},
+}
+
+func init() {
+	cfg, err := GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	RegisterDriverConfig(webhookDriver, webhookConfigKeys...)
+	RegisterDriverConfig(webhookDriver, TLSConfigKeys...)
+	RegisterDriverConfig(webhookDriver, EmitPolicyConfigKeys...)
+
+	if !driverEnabled(cfg, webhookDriver) {
+		return
+	}
+
+	err = ValidateDriverConfig(cfg, webhookDriver)
+	if err != nil {
+		handleError(fmt.Errorf("%s%w, no events will be sent", telemetry.TelemetryDriverError, err))
+		registerDriver(webhookDriver, NopDriver{})
+		return
+	}
+
+	driverCfg := DriverConfig(cfg, webhookDriver)
+
+	headers, err := parseHeaderTemplates(driverCfg.GetStringMapString("headers"))
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has invalid headers, no events will be sent: %w", telemetry.TelemetryDriverError, webhookDriver, err))
+		registerDriver(webhookDriver, NopDriver{})
+		return
+	}
+
+	policy := LoadEmitPolicy(cfg, webhookDriver, DefaultEmitPolicy)
+	client, err := newHTTPClient(cfg, webhookDriver, policy)
+	if err != nil {
+		handleError(fmt.Errorf("%s%s has an invalid tls config, no events will be sent: %w", telemetry.TelemetryDriverError, webhookDriver, err))
+		registerDriver(webhookDriver, NopDriver{})
+		return
+	}
+
+	sink := &WebhookSink{
+		URL:         driverCfg.GetString("url"),
+		Headers:     headers,
+		Secret:      driverCfg.GetString("secret"),
+		Policy:      policy,
+		Compression: driverCfg.GetString("compression"),
+		Client:      client,
+	}
+
+	driver := EventDriver{
+		Sink: NewAsyncSink(webhookDriver, sink, driverCfg.GetInt("queueSize")),
+	}
+
+	registerDriver(webhookDriver, driver)
+}
+
+// WebhookSink posts every event as JSON to the configured url
+type WebhookSink struct {
+	URL string
+	// Headers are templates executed with the event, e.g. "{{.Transaction}}"
+	Headers map[string]*template.Template
+	Secret  string
+	Policy  EmitPolicy
+	// Compression of the body, "gzip" or "zstd" are sent with the matching Content-Encoding
+	Compression string
+	Client      *http.Client
+}
+
+// parseHeaderTemplates parses the header values as text/template
+func parseHeaderTemplates(headers map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(headers))
+	for name, value := range headers {
+		headerTemplate, err := template.New(name).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+
+		templates[name] = headerTemplate
+	}
+
+	return templates, nil
+}
+
+// Emit posts the event and retries according to the policy on transport errors, 429 and 5xx responses
+func (s *WebhookSink) Emit(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	body, err = compress(s.Compression, body)
+	if err != nil {
+		return err
+	}
+
+	headers := make(http.Header, len(s.Headers)+3)
+	headers.Set("Content-Type", "application/json")
+	if compressionEnabled(s.Compression) {
+		headers.Set("Content-Encoding", s.Compression)
+	}
+	for name, headerTemplate := range s.Headers {
+		value := strings.Builder{}
+		err = headerTemplate.Execute(&value, event)
+		if err != nil {
+			return fmt.Errorf("could not execute header template %s: %w", name, err)
+		}
+		headers.Set(name, value.String())
+	}
+
+	// the signature covers the body as sent, i.e. after the compression
+	if len(s.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(body)
+		headers.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return s.Policy.Do(func() (bool, error) {
+		return s.post(client, headers, body)
+	})
+}
+
+// post sends the request once and reports whether a failed request should be retried
+func (s *WebhookSink) post(client *http.Client, headers http.Header, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header = headers.Clone()
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return false, nil
+	}
+
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	err = fmt.Errorf("webhook responded with status %d: %s", response.StatusCode, responseBody)
+
+	return retryableStatus(response.StatusCode), err
+}
@@ -0,0 +1,107 @@
+package teldrvr
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/plentymarkets/mc-telemetry/pkg/telemetry"
+)
+
+// WorkerFunc is the work of one worker. The transaction belongs to the worker alone, the work is logged in its segment.
+type WorkerFunc func(worker int, transaction telemetry.Transaction, segmentID string) error
+
+// WorkerSummary counts the outcome of the workers started by RunWorkers
+type WorkerSummary struct {
+	Workers   int
+	Succeeded int
+	Failed    int
+}
+
+// RunWorkers runs the work in the given number of goroutines and waits for them. Every worker gets its own transaction
+// of all active drivers with the trace and process ID of the parent transaction and a segment <name>.worker with the
+// attribute worker. A panic of a worker is recovered and counted as failure. At the end the parent transaction gets the
+// attributes <name>.workers, <name>.succeeded and <name>.failed. The returned error joins the errors of all workers.
+func RunWorkers(parent telemetry.Transaction, name string, workers int, work WorkerFunc) (WorkerSummary, error) {
+	summary := WorkerSummary{Workers: workers}
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			errs[worker] = runWorker(parent, name, worker, work)
+		}(worker)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			summary.Failed++
+			continue
+		}
+		summary.Succeeded++
+	}
+
+	reportWorkerError(name, parent.AddTransactionAttribute(name+".workers", summary.Workers))
+	reportWorkerError(name, parent.AddTransactionAttribute(name+".succeeded", summary.Succeeded))
+	reportWorkerError(name, parent.AddTransactionAttribute(name+".failed", summary.Failed))
+
+	return summary, errors.Join(errs...)
+}
+
+// CloneTransaction starts a transaction of all active drivers that continues the trace of the parent, e.g. for a
+// goroutine. Transactions are not safe for concurrent use, so every goroutine needs its own clone.
+func CloneTransaction(parent telemetry.Transaction, name string) (telemetry.Transaction, error) {
+	clone, err := StartActiveTransaction(name)
+
+	trace, traceErr := parent.Trace()
+	if traceErr == nil && len(trace) > 0 {
+		traceErr = clone.SetTrace(trace)
+	}
+
+	processID, processErr := parent.ProcessID()
+	if processErr == nil && len(processID) > 0 {
+		processErr = clone.SetProcessID(processID)
+	}
+
+	return clone, errors.Join(err, traceErr, processErr)
+}
+
+// runWorker runs the work of one worker in its own transaction and segment
+func runWorker(parent telemetry.Transaction, name string, worker int, work WorkerFunc) (err error) {
+	transaction, cloneErr := CloneTransaction(parent, name)
+	reportWorkerError(name, cloneErr)
+
+	segmentID := newIDString()
+	reportWorkerError(name, transaction.SegmentStart(segmentID, name+".worker"))
+	reportWorkerError(name, transaction.AddSegmentAttribute(segmentID, "worker", worker))
+
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			err = fmt.Errorf("worker %d of %s panicked: %v", worker, name, recovered)
+			reportWorkerError(name, Errorf(transaction, segmentID, "%s\n%s", err, debug.Stack()))
+		}
+
+		reportWorkerError(name, transaction.SegmentEnd(segmentID))
+		reportWorkerError(name, transaction.Done())
+		transaction.Erase()
+	}()
+
+	err = work(worker, transaction, segmentID)
+	if err != nil {
+		reportWorkerError(name, ErrorMsg(transaction, segmentID, err))
+	}
+
+	return err
+}
+
+// reportWorkerError passes telemetry errors of the workers to the error handler, they do not fail the work
+func reportWorkerError(name string, err error) {
+	if err != nil {
+		handleError(fmt.Errorf("%sworkers %s: %w", telemetry.TelemetryDriverError, name, err))
+	}
+}